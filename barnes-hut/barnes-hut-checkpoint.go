@@ -0,0 +1,431 @@
+package barneshut
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/thomaspeugeot/tkv/bods"
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+const (
+	CountryCheckpointNamePattern = "conf-%s-%08d-%05d.chkp"
+)
+
+// version of the checkpoint content
+// version 2 stores the neighbours by their ID (version 1 checkpoints can still be read)
+// version 3 stores the integrator, the kernel, the boundary, the stop criteria and the LBFGS history
+// (a version 2 checkpoint keeps the ones of the run that reads it)
+// version 4 stores the parameters of the quadtree, the reordering, the solver and wether a domain is used
+const checkpointVersion = 4
+
+// the stop criteria are stored as they are, their concrete types have to be known by gob
+func init() {
+	gob.Register(EnergyDecrease{})
+	gob.Register(MaxSteps{})
+	gob.Register(WallClock{})
+	gob.Register(DensitySpread{})
+	gob.Register(Stirring{})
+	gob.Register(AnyOf{})
+	gob.Register(AllOf{})
+}
+
+// a NeighbourRecord is the serializable form of a Neighbour
+type NeighbourRecord struct {
+	ID       uint32 // ID of the neighbour body (0 if there is no neighbour)
+	Distance float64
+
+	Index int // version 1 only, index of the neighbour in the bodies slice (-1 if there is no neighbour)
+}
+
+// a IntegratorRecord is the serializable form of an Integrator
+// (gob cannot store integrators without parameters, such as Euler)
+type IntegratorRecord struct {
+	Name  string // see IntegratorFromName
+	FIRE  FIRE   // parameters, if Name is fire
+	LBFGS LBFGS  // parameters, if Name is lbfgs
+}
+
+// a KernelRecord is the serializable form of a Kernel
+type KernelRecord struct {
+	Name  string  // see KernelFromName
+	Param float64 // parameter of the kernel, if any
+}
+
+// a LBFGSRecord is the serializable form of the history of the LBFGS minimiser
+type LBFGSRecord struct {
+	S, Y            [][]float64
+	Rho             []float64
+	Gradient        []float64
+	Displacement    []float64
+	MaxDisplacement float64
+}
+
+// a Checkpoint stores the full state of a run, that is
+// everything that is needed for a restarted run to continue exactly
+// as the run that was checkpointed
+//
+// a .bods file stores only the bodies positions, therefore
+// velocities, neighbours and energy history are lost when a run is restarted from it
+type Checkpoint struct {
+	Version int
+	Country string
+	Step    int
+
+	Bodies       []quadtree.Body
+	BodiesOrig   []quadtree.Body
+	BodiesVel    []Vel
+	BodiesAccel  []Acc
+	BodiesEnergy []float64
+	BodiesIndex  []int // index of each body in the input order (nil if the bodies have not been reordered)
+
+	BodiesAttributes []bods.Attributes // optional attributes of the bodies, in the input order
+
+	Neighbours     [][]NeighbourRecord // stirring measure, current neighbours
+	NeighboursOrig [][]NeighbourRecord // stirring measure, baseline neighbours
+
+	Dt               float64
+	DtRequest        float64
+	DtAdjustMode     DtAdjustModeType
+	BN_THETA         float64
+	BN_THETA_Request float64
+
+	Energy                  float64
+	EnergyDecreaseRatio     float64
+	Energies                []float64
+	GiniOverTime            [][]float64
+	MinInterBodyDistance    float64
+	MaxMinInterBodyDistance float64
+	DensityTenciles         [10]float64
+	Stirring                float64
+
+	FireAlpha      float64 // state of the FIRE integrator
+	FireNbDownhill int
+
+	LBFGS            *LBFGSRecord // state of the LBFGS minimiser (nil if it has not started)
+	ForcesAreCurrent bool         // the forces have been computed at the positions of the bodies (see LBFGS)
+
+	// parameters of the steps (the domain is not stored, it comes with the coord file of the country)
+	Integrator       IntegratorRecord
+	Kernel           KernelRecord
+	BoundaryMode     BoundaryModeType
+	CutoffDistance   float64
+	SpeedDragFactor  float64
+	ShutdownCriteria float64
+	StopCriteria     AnyOf
+
+	// parameters of the force computation. The domain and the solver cannot be restored (the domain
+	// comes with the coord file, the solver is UseDualTree), a run that does not match them refuses the checkpoint
+	QuadtreeMaxLevel     int
+	QuadtreeLeafCapacity int
+	ReorderStep          int
+	UseDualTree          bool
+	WithDomain           bool
+}
+
+// convert an integrator into its serializable form
+func integratorRecord(i Integrator) IntegratorRecord {
+	record := IntegratorRecord{Name: i.Name()}
+	switch i := i.(type) {
+	case FIRE:
+		record.FIRE = i
+	case LBFGS:
+		record.LBFGS = i
+	}
+	return record
+}
+
+// restore an integrator from its serializable form
+func (record IntegratorRecord) integrator() (Integrator, error) {
+	switch record.Name {
+	case "fire":
+		return record.FIRE, nil
+	case "lbfgs":
+		return record.LBFGS, nil
+	}
+	return IntegratorFromName(record.Name)
+}
+
+// convert a kernel into its serializable form
+func kernelRecord(k Kernel) (KernelRecord, error) {
+	switch k := k.(type) {
+	case InverseSquare:
+		return KernelRecord{Name: "inverseSquare"}, nil
+	case Inverse:
+		return KernelRecord{Name: "inverse"}, nil
+	case Yukawa:
+		return KernelRecord{Name: "yukawa", Param: k.Lambda}, nil
+	case Plummer:
+		return KernelRecord{Name: "plummer", Param: k.Epsilon}, nil
+	case SmoothCutoff:
+		return KernelRecord{Name: "smoothCutoff", Param: k.Radius}, nil
+	}
+	return KernelRecord{}, fmt.Errorf("kernel %s cannot be checkpointed", k.Name())
+}
+
+// convert the state of the LBFGS minimiser into its serializable form
+func (st *lbfgsState) record() *LBFGSRecord {
+	if st == nil {
+		return nil
+	}
+	return &LBFGSRecord{
+		S:               st.s,
+		Y:               st.y,
+		Rho:             st.rho,
+		Gradient:        st.gradient,
+		Displacement:    st.displacement,
+		MaxDisplacement: st.maxDisplacement,
+	}
+}
+
+// restore the state of the LBFGS minimiser from its serializable form
+func (record *LBFGSRecord) state() *lbfgsState {
+	if record == nil {
+		return nil
+	}
+	return &lbfgsState{
+		s:               record.S,
+		y:               record.Y,
+		rho:             record.Rho,
+		gradient:        record.Gradient,
+		displacement:    record.Displacement,
+		maxDisplacement: record.MaxDisplacement,
+	}
+}
+
+// convert a neighbour dico into its serializable form
+func (dico *NeighbourDico) records() [][]NeighbourRecord {
+
+	records := make([][]NeighbourRecord, len(*dico))
+	for idx := range *dico {
+		records[idx] = make([]NeighbourRecord, len((*dico)[idx]))
+		for rank, n := range (*dico)[idx] {
+			records[idx][rank].ID = n.id
+			records[idx][rank].Distance = n.Distance
+		}
+	}
+	return records
+}
+
+// restore a neighbour dico from its serializable form
+// the neighbours of a version 1 checkpoint are converted from their index to the ID of the body
+func (dico *NeighbourDico) restore(records [][]NeighbourRecord, version int, bodies *[]quadtree.Body) {
+
+	for idx := range records {
+		for rank, record := range records[idx] {
+			(*dico)[idx][rank].Distance = record.Distance
+			(*dico)[idx][rank].id = record.ID
+			if version == 1 {
+				(*dico)[idx][rank].id = 0
+				if record.Index >= 0 {
+					(*dico)[idx][rank].id = (*bodies)[record.Index].ID
+				}
+			}
+		}
+	}
+}
+
+// WriteCheckpoint serializes the full state of the run into out
+func (r *Run) WriteCheckpoint(out io.Writer) error {
+
+	var kernel KernelRecord
+	if r.config.Kernel != nil {
+		var err error
+		if kernel, err = kernelRecord(r.config.Kernel); err != nil {
+			return err
+		}
+	}
+
+	c := Checkpoint{
+		Version:                 checkpointVersion,
+		Country:                 r.country,
+		Step:                    r.step,
+		Bodies:                  *r.bodies,
+		BodiesOrig:              *r.bodiesOrig,
+		BodiesVel:               *r.bodiesVel,
+		BodiesAccel:             *r.bodiesAccel,
+		BodiesEnergy:            *r.bodiesEnergy,
+		BodiesIndex:             r.bodiesIndex,
+		BodiesAttributes:        r.bodiesAttributes,
+		Neighbours:              r.bodiesNeighbours.records(),
+		NeighboursOrig:          r.bodiesNeighboursOrig.records(),
+		Dt:                      r.config.Dt,
+		DtRequest:               r.config.DtRequest,
+		DtAdjustMode:            r.config.DtAdjustMode,
+		BN_THETA:                r.config.BN_THETA,
+		BN_THETA_Request:        r.config.BN_THETA_Request,
+		Energy:                  r.energy,
+		EnergyDecreaseRatio:     r.energyDecreaseRatio,
+		Energies:                r.energies,
+		GiniOverTime:            r.giniOverTime,
+		MinInterBodyDistance:    r.minInterBodyDistance,
+		MaxMinInterBodyDistance: r.maxMinInterBodyDistance,
+		DensityTenciles:         r.densityTenciles,
+		Stirring:                r.stirring,
+		FireAlpha:               r.fireAlpha,
+		FireNbDownhill:          r.fireNbDownhill,
+		LBFGS:                   r.lbfgs.record(),
+		ForcesAreCurrent:        r.forcesAreCurrent,
+		Integrator:              integratorRecord(r.config.integrator()),
+		Kernel:                  kernel,
+		BoundaryMode:            r.config.BoundaryMode,
+		CutoffDistance:          r.config.CutoffDistance,
+		SpeedDragFactor:         r.config.SpeedDragFactor,
+		ShutdownCriteria:        r.config.ShutdownCriteria,
+		StopCriteria:            r.config.StopCriteria,
+		QuadtreeMaxLevel:        r.config.QuadtreeMaxLevel,
+		QuadtreeLeafCapacity:    r.config.QuadtreeLeafCapacity,
+		ReorderStep:             r.config.ReorderStep,
+		UseDualTree:             UseDualTree,
+		WithDomain:              r.config.Domain != nil,
+	}
+	return gob.NewEncoder(out).Encode(&c)
+}
+
+// ReadCheckpoint restores the full state of the run from in
+func (r *Run) ReadCheckpoint(in io.Reader) error {
+
+	var c Checkpoint
+	if err := gob.NewDecoder(in).Decode(&c); err != nil {
+		return err
+	}
+	if c.Version < 1 || c.Version > checkpointVersion {
+		return fmt.Errorf("unsupported checkpoint version %d", c.Version)
+	}
+	nbBodies := len(c.Bodies)
+	if len(c.BodiesOrig) != nbBodies || len(c.BodiesVel) != nbBodies ||
+		len(c.BodiesAccel) != nbBodies || len(c.BodiesEnergy) != nbBodies ||
+		len(c.Neighbours) != nbBodies || len(c.NeighboursOrig) != nbBodies ||
+		(c.BodiesIndex != nil && len(c.BodiesIndex) != nbBodies) ||
+		(c.BodiesAttributes != nil && len(c.BodiesAttributes) != nbBodies) {
+		return fmt.Errorf("checkpoint arrays do not have %d elements", nbBodies)
+	}
+	if c.LBFGS != nil && len(c.LBFGS.Gradient) != 2*nbBodies {
+		return fmt.Errorf("checkpoint LBFGS history does not have %d elements", 2*nbBodies)
+	}
+
+	var integrator Integrator
+	var kernel Kernel
+	if c.Version >= 3 {
+		var err error
+		if integrator, err = c.Integrator.integrator(); err != nil {
+			return err
+		}
+		if c.Kernel.Name != "" {
+			if kernel, err = KernelFromName(c.Kernel.Name, c.Kernel.Param); err != nil {
+				return err
+			}
+		}
+	}
+
+	if r.config == nil {
+		r.config = NewRunConfig()
+	}
+	if c.Version >= 4 {
+		if c.UseDualTree != UseDualTree {
+			return fmt.Errorf("checkpoint computed with UseDualTree %t, the run has %t", c.UseDualTree, UseDualTree)
+		}
+		if c.WithDomain != (r.config.Domain != nil) {
+			return fmt.Errorf("checkpoint computed with a domain %t, the run has a domain %t", c.WithDomain, r.config.Domain != nil)
+		}
+
+		// the quadtree is set up by Init
+		r.config.QuadtreeMaxLevel = c.QuadtreeMaxLevel
+		r.config.QuadtreeLeafCapacity = c.QuadtreeLeafCapacity
+		r.config.ReorderStep = c.ReorderStep
+	}
+
+	bodies := c.Bodies
+	r.Init(&bodies)
+
+	r.country = c.Country
+	r.step = c.Step
+	copy(*r.bodiesOrig, c.BodiesOrig)
+	copy(*r.bodiesVel, c.BodiesVel)
+	copy(*r.bodiesAccel, c.BodiesAccel)
+	copy(*r.bodiesEnergy, c.BodiesEnergy)
+	r.bodiesIndex = c.BodiesIndex
+	r.bodiesAttributes = c.BodiesAttributes
+	r.bodiesNeighbours.restore(c.Neighbours, c.Version, r.bodies)
+	r.bodiesNeighboursOrig.restore(c.NeighboursOrig, c.Version, r.bodies)
+
+	r.config.Dt = c.Dt
+	r.config.DtRequest = c.DtRequest
+	r.config.DtAdjustMode = c.DtAdjustMode
+	r.config.BN_THETA = c.BN_THETA
+	r.config.BN_THETA_Request = c.BN_THETA_Request
+	if c.Version >= 3 {
+		r.config.Integrator = integrator
+		r.config.Kernel = kernel
+		r.config.BoundaryMode = c.BoundaryMode
+		r.config.CutoffDistance = c.CutoffDistance
+		r.config.SpeedDragFactor = c.SpeedDragFactor
+		r.config.ShutdownCriteria = c.ShutdownCriteria
+		r.config.StopCriteria = c.StopCriteria
+	}
+
+	r.energy = c.Energy
+	r.energyDecreaseRatio = c.EnergyDecreaseRatio
+	r.energies = c.Energies
+	r.giniOverTime = c.GiniOverTime
+	r.minInterBodyDistance = c.MinInterBodyDistance
+	r.maxMinInterBodyDistance = c.MaxMinInterBodyDistance
+	r.densityTenciles = c.DensityTenciles
+	r.stirring = c.Stirring
+	r.fireAlpha = c.FireAlpha
+	r.fireNbDownhill = c.FireNbDownhill
+	r.lbfgs = c.LBFGS.state()
+	r.forcesAreCurrent = c.ForcesAreCurrent
+
+	return nil
+}
+
+// serialize the full state of the run into a checkpoint file
+// return true if operation was successful
+func (r *Run) CaptureCheckpoint() bool {
+
+	filename := fmt.Sprintf(r.OutputDir+"/"+CountryCheckpointNamePattern, r.country, len(*r.bodies), r.step)
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+		return false
+	}
+	defer file.Close()
+
+	if err := r.WriteCheckpoint(file); err != nil {
+		Error.Printf("CaptureCheckpoint %s: %s", filename, err.Error())
+		return false
+	}
+	Info.Printf("Checkpoint written in %s", filename)
+	return true
+}
+
+// load the full state of the run from a checkpoint file
+// works only if state is STOPPED
+func (r *Run) LoadCheckpoint(filename string) bool {
+	Info.Printf("LoadCheckpoint file %s", filename)
+
+	if r.state != STOPPED {
+		return false
+	}
+
+	r.renderingMutex.Lock()
+	defer r.renderingMutex.Unlock()
+
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+		return false
+	}
+	defer file.Close()
+
+	if err := r.ReadCheckpoint(file); err != nil {
+		log.Fatal(fmt.Sprintf("parsing checkpoint file %s", err.Error()))
+		return false
+	}
+	Info.Printf("Country is %s, step is %d, nb bodies %d", r.country, r.step, len(*r.bodies))
+	return true
+}
@@ -1,13 +1,15 @@
 package barneshut
 
 import (
-	"bytes"
-	"encoding/binary"
-	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/thomaspeugeot/tkv/bods"
 )
 
 const (
@@ -20,12 +22,39 @@ const (
 	CountryBodiesSVGNamePattern = "conf-%s-%08d-%05d.svg"
 )
 
+// ParseBodiesFilename returns the country, the number of bodies and the step of a body file name
+// conf-<country>-<nb bodies>-<step>.bods (the directory is ignored). The country is an ISO 3166 code
+// or a region key, it may contain dashes.
+func ParseBodiesFilename(filename string) (country string, nbBodies, step int, err error) {
+
+	name := filepath.Base(filename)
+	if !strings.HasPrefix(name, "conf-") || !strings.HasSuffix(name, ".bods") {
+		return "", 0, 0, fmt.Errorf("%s is not a body file name conf-<country>-<nb bodies>-<step>.bods", filename)
+	}
+	fields := strings.Split(strings.TrimSuffix(strings.TrimPrefix(name, "conf-"), ".bods"), "-")
+	if len(fields) < 3 {
+		return "", 0, 0, fmt.Errorf("%s is not a body file name conf-<country>-<nb bodies>-<step>.bods", filename)
+	}
+	country = strings.Join(fields[:len(fields)-2], "-")
+	nbBodies, errNbBodies := strconv.Atoi(fields[len(fields)-2])
+	step, errStep := strconv.Atoi(fields[len(fields)-1])
+	if country == "" || errNbBodies != nil || errStep != nil || nbBodies < 0 || step < 0 {
+		return "", 0, 0, fmt.Errorf("%s is not a body file name conf-<country>-<nb bodies>-<step>.bods", filename)
+	}
+	return country, nbBodies, step, nil
+}
+
+// if true, body files are written in the binary format of the bods package,
+// otherwise they are written in JSON.
+// Loading detects the format, therefore both formats can be read whatever the value
+var UseBinaryBodsFormat bool = true
+
 // serialize bodies's state vector into a file
 // convention is "step-xxxx.bod"
 // return true if operation was successful
-// works only if state is STOPPED
+// works only if state is STOPPED or COMPLETED
 func (r *Run) CaptureConfig() bool {
-	if r.state == STOPPED {
+	if r.state == STOPPED || r.state == COMPLETED {
 
 		filename := fmt.Sprintf(r.OutputDir+"/"+CountryBodiesNamePattern, r.country, len(*r.bodies), r.step)
 		file, err := os.Create(filename)
@@ -33,17 +62,28 @@ func (r *Run) CaptureConfig() bool {
 			log.Fatal(err)
 			return false
 		}
-		jsonBodies, _ := json.MarshalIndent(r.bodies, "", "\t")
-		file.Write(jsonBodies)
+		err = r.WriteConfig(file)
 		file.Close()
-
-		// r.CaptureConfigBase64()
+		if err != nil {
+			Error.Printf("CaptureConfig %s: %s", filename, err.Error())
+			return false
+		}
 		return true
 	} else {
 		return false
 	}
 }
 
+// WriteConfig writes the bodies of the run into out, with the format of the body files
+// bodies are written in the input order, even if they have been reordered (see RunConfig.ReorderStep)
+func (r *Run) WriteConfig(out io.Writer) error {
+	bodies := r.bodiesInInputOrder()
+	if UseBinaryBodsFormat {
+		return bods.NewEncoder(out).EncodeWithAttributes(r.country, r.step, bodies, r.bodiesAttributes)
+	}
+	return bods.WriteJSON(out, bodies)
+}
+
 func (r *Run) CaptureGif() bool {
 	filename := fmt.Sprintf(CountryBodiesGifNamePattern, r.country, len(*r.bodies), r.step)
 	file, err := os.Create(r.OutputDir + "/" + filename)
@@ -68,86 +108,58 @@ func (r *Run) CaptureSVG() bool {
 	return true
 }
 
-func (r *Run) CaptureConfigBase64() bool {
-	if r.state == STOPPED {
-		filename := fmt.Sprintf("conf-base64-TST-%05d.bods", r.step)
-		file, err := os.Create(filename)
-		if err != nil {
-			log.Fatal(err)
-			return false
-		}
-		buf := new(bytes.Buffer)
-
-		// encoder := base64.NewEncoder(base64.StdEncoding, &b)
-		// encoder.Write( *(r.bodies))
-		// encoder.Close()
-
-		for _, v := range *r.bodies {
-			err = binary.Write(buf, binary.LittleEndian, v.X)
-			err = binary.Write(buf, binary.LittleEndian, v.Y)
-		}
-		file.Write(buf.Bytes())
-
-		file.Close()
-		return true
-	} else {
-		return false
+// SetBodiesAttributes sets the attributes of the bodies given to Init (attributes[idx] are the attributes
+// of the body idx), they are written with the bodies (see bods.Attributes)
+func (r *Run) SetBodiesAttributes(attributes []bods.Attributes) error {
+	if attributes != nil && len(attributes) != len(*r.bodies) {
+		return fmt.Errorf("%d attributes for %d bodies", len(attributes), len(*r.bodies))
 	}
+	r.bodiesAttributes = attributes
+	return nil
 }
 
-// load configuration from filename (does not contain path)
+// load configuration from filename (conf-<country>-<nb bodies>-<step>.bods)
 // works only if state is STOPPED
 func (r *Run) LoadConfig(filename string) bool {
 	Info.Printf("LoadConfig file %s", filename)
 
 	if r.state == STOPPED {
 
-		renderingMutex.Lock()
+		r.renderingMutex.Lock()
 		file, err := os.Open(filename)
 		if err != nil {
 			log.Fatal(err)
 			return false
 		}
 
-		// get the number of steps in the file name
-		// var countryName string
-		for index, runeValue := range filename {
-			Trace.Printf("%#U starts at byte position %d\n", runeValue, index)
-		}
-		ctry := filename[5:8]
-		r.country = ctry
-
-		// parse the number of elements
-		nbElementString := filename[9:17]
-		var nbBodies int
-		_, errScanNbItems := fmt.Sscanf(nbElementString, "%08d", &nbBodies)
-		if errScanNbItems != nil {
-			log.Fatal(errScanNbItems)
+		// get the country, the number of bodies and the step in the file name
+		ctry, nbBodies, step, errParse := ParseBodiesFilename(filename)
+		if errParse != nil {
+			log.Fatal(errParse)
 			return false
 		}
+		r.country = ctry
+		r.step = step
 		Info.Printf("Nb bodies in filename %d", nbBodies)
 
-		// parse the step
-		stepString := filename[18:23]
-		nbItems, errScan := fmt.Sscanf(stepString, "%05d", &r.step)
-		if errScan != nil {
-			log.Fatal(errScan)
-			return false
-		}
-		Trace.Printf("nb item parsed in file name %d (should be one)\n", nbItems)
-
-		jsonParser := json.NewDecoder(file)
-		if err = jsonParser.Decode(r.bodies); err != nil {
+		header, bodies, attributes, err := bods.ReadBodiesWithAttributes(file)
+		if err != nil {
 			log.Fatal(fmt.Sprintf("parsing config file %s", err.Error()))
 		}
+		if header.Version > 0 && (header.Country != ctry || header.Step != r.step) {
+			Warning.Printf("file name says country %s step %d, file header says country %s step %d",
+				ctry, r.step, header.Country, header.Step)
+		}
+		*r.bodies = bodies
 		Info.Printf("Country is %s, step is %d", ctry, r.step)
 		Info.Printf("nb item parsed in file %d\n", len(*r.bodies))
 
 		file.Close()
 
 		r.Init(r.bodies)
+		r.bodiesAttributes = attributes
 
-		renderingMutex.Unlock()
+		r.renderingMutex.Unlock()
 		return true
 	} else {
 		return false
@@ -166,22 +178,27 @@ func (r *Run) LoadConfigOrig(filename string) bool {
 			return false
 		}
 
-		ctry := filename[5:8]
-		if r.country != string(ctry) {
-			Error.Printf("original country %s should be the same as current country %s", ctry, r.country)
-		}
-		stepString := filename[9:14]
-
-		_, errScan := fmt.Sscanf(stepString, "%05d", &r.step)
-		if errScan != nil {
-			log.Fatal(errScan)
+		// the original configuration is the reference of the run, the run keeps its step
+		ctry, _, _, errParse := ParseBodiesFilename(filename)
+		if errParse != nil {
+			log.Fatal(errParse)
 			return false
 		}
+		if r.country != ctry {
+			Error.Printf("original country %s should be the same as current country %s", ctry, r.country)
+		}
 
-		jsonParser := json.NewDecoder(file)
-		if err = jsonParser.Decode(r.bodiesOrig); err != nil {
+		_, bodies, err := bods.ReadBodies(file)
+		if err != nil {
 			log.Fatal(fmt.Sprintf("parsing config file %s", err.Error()))
 		}
+		if r.bodiesIndex != nil && len(bodies) == len(r.bodiesIndex) {
+			for idx := range bodies {
+				(*r.bodiesOrig)[idx] = bodies[r.bodiesIndex[idx]]
+			}
+		} else {
+			*r.bodiesOrig = bodies
+		}
 
 		file.Close()
 		return true
@@ -41,6 +41,8 @@ func (r *Run) ComputeDensityTencilePerTerritoryString() [10]string {
 }
 func (r *Run) ComputeDensityTencilePerTerritory() [10]float64 {
 
+	nbVillagePerAxe := r.config.NbVillagePerAxe
+
 	// parse all bodies
 	// prepare the village
 	villages := make([][]int, nbVillagePerAxe)
@@ -92,7 +92,7 @@ func (r *Run) CreateMovieFromGif() {
 // enabling transmission over http
 func (r *Run) RenderGif(out io.Writer, encode64 bool) {
 
-	renderingMutex.Lock()
+	r.renderingMutex.Lock()
 	t0 := time.Now()
 
 	Trace.Printf("RenderGif begin with r.gridFieldNb %d", r.gridFieldNb)
@@ -112,7 +112,8 @@ func (r *Run) RenderGif(out io.Writer, encode64 bool) {
 			r.xMax, r.yMax,
 			r.gridFieldNb,
 			&(r.q),
-			r.minInterBodyDistance/2.0) // quadtree
+			r.minInterBodyDistance/2.0, // quadtree
+			r.config)
 		f.ComputeField()
 
 		// parse the image
@@ -137,6 +138,9 @@ func (r *Run) RenderGif(out io.Writer, encode64 bool) {
 		}
 	}
 
+	nbVillagePerAxe := r.config.NbVillagePerAxe
+	ratioOfBorderVillages := r.config.RatioOfBorderVillages
+
 	Trace.Printf("RenderGif with x min %f x max %f", r.xMin, r.xMax)
 	for idx := range *r.bodies {
 
@@ -233,10 +237,10 @@ func (r *Run) RenderGif(out io.Writer, encode64 bool) {
 	}
 
 	t1 := time.Now()
-	StepDuration = float64((t1.Sub(t0)).Nanoseconds())
+	r.stepDuration = float64((t1.Sub(t0)).Nanoseconds())
 
-	Trace.Printf("RenderGif %d dur %e", r.gridFieldNb, StepDuration/1000000000)
-	renderingMutex.Unlock()
+	Trace.Printf("RenderGif %d dur %e", r.gridFieldNb, r.stepDuration/1000000000)
+	r.renderingMutex.Unlock()
 
 }
 
@@ -248,6 +252,9 @@ func (r *Run) RenderSVG(out io.Writer) {
 	s.Start(size, size)
 	s.Circle(250, 250, 125, "fill:none;stroke:black")
 
+	nbVillagePerAxe := r.config.NbVillagePerAxe
+	ratioOfBorderVillages := r.config.RatioOfBorderVillages
+
 	for idx := range *r.bodies {
 
 		body := (*r.bodies)[idx]
@@ -18,20 +18,25 @@ A drag is introduced to put simulation bodies to rest (see #Run.UpdateVelocity)
 
 In a cosmological simulation, bodies position are not limited. Here,
 bodies are kept within a [0;1]*[0;1] square by having "mirror" bodies that
-forbids a body from crossing the border (see #Run.UpdatePosition)
+forbids a body from crossing the border (see #Run.UpdatePosition). Other boundary modes
+are possible (see BoundaryModeType)
 */
 package barneshut
 
 import (
+	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"os"
+	"runtime"
 	"sync"
-	"testing"
+	"sync/atomic"
 	"time"
 
+	"github.com/thomaspeugeot/tkv/bods"
 	"github.com/thomaspeugeot/tkv/quadtree"
 )
 
@@ -45,10 +50,6 @@ var ETA float64 = 0.0
 //should have no effect on the simulation since Dt is computed according to computed acceleration
 var G float64 = 0.01
 
-//var Dt float64  = 3*1e-8 // difficult to fine tune
-var Dt float64 = 2.3 * 1e-10 // difficult to fine tune
-var DtRequest = Dt           // new value of Dt requested by the UI. The real Dt will be changed at the end of the current step.
-
 // velocity cannot be too high in order to stop bodies from overtaking
 // each others. The variable defines when displacement has to be capped
 var MaxDisplacement float64 = 0.001 // cannot make more that 1/1000 th of the unit square per second
@@ -57,33 +58,98 @@ var MaxDisplacement float64 = 0.001 // cannot make more that 1/1000 th of the un
 // note: 2.0 gives too much stirring
 var MaxRatioDisplacement float64 = 0.5
 
-// BN_THETA is the barnes hut criteria
-// If distance to Center Of Mass (COM) of the box is more than BN_THETA * side of the box
-// then, compute force from the whole box instead computing for each node of the box.
-var BN_THETA float64 = 0.5
+// if true, Barnes-Hut algo is used
+var UseBarnesHut bool = true
 
-// New value of theta requested by the UI. The real BN_THETA will be changed at the end of the current step.
-var BN_THETA_Request = BN_THETA
+// RunConfig stores the parameters of a simulation run.
+//
+// Each Run owns its RunConfig, therefore many runs can be simulated
+// side by side in the same process (for instance France and Haiti, or a parameter sweep)
+type RunConfig struct {
+	//Dt float64  = 3*1e-8 // difficult to fine tune
+	Dt        float64 // difficult to fine tune
+	DtRequest float64 // new value of Dt requested by the UI. The real Dt will be changed at the end of the current step.
 
-// how much drag we put (1.0 is no drag)
-// tHis criteria is important because it favors bodies that moves freely against bodies that are stuck on a border
-// 0.99 makes a very bumpy behavior for the Dt
-var SpeedDragFactor float64 = 0.2
+	DtAdjustMode DtAdjustModeType // decides wether Dt is set manual or automaticaly
 
-// used to compute speed up
-var nbComputationPerStep uint64
+	// BN_THETA is the barnes hut criteria
+	// If distance to Center Of Mass (COM) of the box is more than BN_THETA * side of the box
+	// then, compute force from the whole box instead computing for each node of the box.
+	BN_THETA float64
 
-// if true, Barnes-Hut algo is used
-var UseBarnesHut bool = true
+	// New value of theta requested by the UI. The real BN_THETA will be changed at the end of the current step.
+	BN_THETA_Request float64
+
+	// Cutoff for influence.
+	// According to Bartolo, 1/r2 is very strong on a plane (integration does not convergence on the plane)
+	// therefore a cutoff distance for the computation of the force is wellcome
+	// first try at 1/10 th
+	CutoffDistance float64
+
+	// repulsion between bodies (if nil, the force is in 1/r2)
+	Kernel Kernel
+
+	// how bodies are kept within the square
+	BoundaryMode BoundaryModeType
+
+	// how the bodies are moved at each step (if nil, Euler)
+	Integrator Integrator
 
-// Cutoff for influence.
-// According to Bartolo, 1/r2 is very strong on a plane (integration does not convergence on the plane)
-// therefore a cutoff distance for the computation of the force is wellcome
-// first try at 1/10 th
-var CutoffDistance float64 = 1.0
+	// if not nil, bodies are kept within the domain and bounce on its outline (see grump.Mask)
+	Domain Domain
 
-// At what step do the simulation stop
-var ShutdownCriteria float64 = 0.00001
+	// depth of the quadtree, between 8 and quadtree.MaxDepth. If above 8, nodes holding
+	// more than QuadtreeLeafCapacity bodies are divided (see quadtree.Quadtree)
+	QuadtreeMaxLevel     int
+	QuadtreeLeafCapacity int
+
+	// steps between reorderings of the bodies along the Z-order curve of their node at level 8
+	// (bodies that are close in the square are then close in memory). 0 means never
+	ReorderStep int
+
+	// how much drag we put (1.0 is no drag)
+	// tHis criteria is important because it favors bodies that moves freely against bodies that are stuck on a border
+	// 0.99 makes a very bumpy behavior for the Dt
+	SpeedDragFactor float64
+
+	// At what energy decrease ratio do the simulation stop
+	ShutdownCriteria float64
+
+	// criteria that stop the simulation (the first that fires stops it)
+	// if nil, the simulation stops according to ShutdownCriteria
+	StopCriteria AnyOf
+
+	// set the number of concurrent routine for the physic calculation (workers that take chunks of bodies)
+	// this value can be set interactively during the run
+	ConcurrentRoutines int
+
+	// number of village per X or Y axis. For 10 000 villages, this number is 100
+	// this value can be set interactively during the run
+	NbVillagePerAxe int
+
+	RatioOfBorderVillages float64 // ratio of villages that are eligible for marking a border
+}
+
+// NewRunConfig returns a configuration with the default values
+func NewRunConfig() *RunConfig {
+	var c RunConfig
+	c.Dt = 2.3 * 1e-10
+	c.DtRequest = c.Dt
+	c.DtAdjustMode = AUTO
+	c.BN_THETA = 0.5
+	c.BN_THETA_Request = c.BN_THETA
+	c.CutoffDistance = 1.0
+	c.Kernel = InverseSquare{}
+	c.BoundaryMode = MIRROR
+	c.QuadtreeMaxLevel = 8
+	c.QuadtreeLeafCapacity = quadtree.DefaultLeafCapacity
+	c.SpeedDragFactor = 0.2
+	c.ShutdownCriteria = 0.00001
+	c.ConcurrentRoutines = runtime.NumCPU()
+	c.NbVillagePerAxe = 100
+	c.RatioOfBorderVillages = 0.0
+	return &c
+}
 
 // Bodies's X,Y position coordinates are float64 between 0 & 1
 type Pos struct {
@@ -106,8 +172,6 @@ type Acc struct {
 // decides wether Dt is set manual or automaticaly
 type DtAdjustModeType string
 
-var DtAdjustMode DtAdjustModeType
-
 // Possible values for DtAdjustModeType
 const (
 	AUTO   = "AUTO"
@@ -119,10 +183,26 @@ type State string
 
 // Possible values for simulation State
 const (
-	STOPPED = "STOPPED"
-	RUNNING = "RUNNING"
+	STOPPED   = "STOPPED"
+	RUNNING   = "RUNNING"
+	COMPLETED = "COMPLETED" // the shutdown criteria has been met, the simulation does not move anymore
 )
 
+// ErrZeroDistance is returned when two bodies are at the same position
+// (the repulsion between them cannot be computed)
+var ErrZeroDistance = errors.New("distance is 0.0 between two bodies")
+
+// RunResult is the outcome of RunSimulation
+type RunResult struct {
+	Country             string
+	NbBodies            int
+	Step                int     // last step of the simulation
+	Energy              float64 // total repulsive energy at the last step
+	EnergyDecreaseRatio float64 // energy decrease ratio at the last step
+	Filename            string  // file of the final configuration (empty if it could not be captured)
+	StopCriterion       string  // name of the criterion that stopped the simulation
+}
+
 // decide wether, villages borders are drawn
 type RenderState string
 
@@ -132,8 +212,6 @@ const (
 	WITH_BORDERS    = "WITH_BORDERS"
 )
 
-var ratioOfBorderVillages = 0.0 // ratio of villages that are eligible for marking a border
-
 // decide wether to display the original configuration or the running configruation
 type RenderChoice string
 
@@ -143,23 +221,19 @@ const (
 	RUNNING_CONFIGURATION  = "RUNNING_CONFIGURATION"
 )
 
-// set the number of concurrent routine for the physic calculation
-// this value can be set interactively during the run
-var ConcurrentRoutines int = 100
-
-// number of village per X or Y axis. For 10 000 villages, this number is 100
-// this value can be set interactively during the run
-var nbVillagePerAxe int = 100
-
 // a simulation run
 type Run struct {
-	bodies               *[]quadtree.Body // bodies position in the quatree
-	bodiesOrig           *[]quadtree.Body // original bodies position in the quatree
-	bodiesAccel          *[]Acc           // bodies acceleration
-	bodiesVel            *[]Vel           // bodies velocity
-	bodiesEnergy         *[]float64       // bodies energy
-	bodiesNeighbours     *NeighbourDico   // storage for neighbour of all bodies
-	bodiesNeighboursOrig *NeighbourDico   // storage for neighbour of all bodies at init
+	config *RunConfig // parameters of the run
+
+	bodies               *[]quadtree.Body  // bodies position in the quatree
+	bodiesOrig           *[]quadtree.Body  // original bodies position in the quatree
+	bodiesAccel          *[]Acc            // bodies acceleration
+	bodiesVel            *[]Vel            // bodies velocity
+	bodiesEnergy         *[]float64        // bodies energy
+	bodiesNeighbours     *NeighbourDico    // storage for neighbour of all bodies
+	bodiesNeighboursOrig *NeighbourDico    // storage for neighbour of all bodies at init
+	bodiesIndex          []int             // index of each body in the input order (nil if the bodies have not been reordered)
+	bodiesAttributes     []bods.Attributes // optional attributes of the bodies, in the input order (nil if there is none)
 
 	q       quadtree.Quadtree // the supporting quadtree
 	country string            // the country of interest
@@ -179,9 +253,17 @@ type Run struct {
 	maxRepulsiveForce       MaxRepulsiveForce // computed at each step (to compute optimal DT value)
 	maxVelocity             float64           // max velocity
 	dtOptim                 float64           // optimal dt
+	fireAlpha               float64           // mixing of FIRE (0 before the first step of FIRE)
+	fireNbDownhill          int               // nb of steps since FIRE went uphill
+	lbfgs                   *lbfgsState       // state of the LBFGS minimiser
+	forcesAreCurrent        bool              // true if the forces have been computed at the current positions
 	ratioOfBodiesWithCapVel float64           // ratio of bodies where the speed has been capped
 	energy                  float64           // total repulsive energy
 	energyDecreaseRatio     float64           // energy decrease ratio. Is used as a shutdown criteria
+	energies                []float64         // total repulsive energy of each step of the run
+	densityTenciles         [10]float64       // density tenciles per village, computed at each step
+	stirring                float64           // ratio of original neighbours that are still neighbours, computed at each step
+	startTime               time.Time         // start of RunSimulation
 
 	status string // status of the run
 
@@ -190,30 +272,118 @@ type Run struct {
 	OutputDir     string // output dir for the run
 	StatusFileLog *os.File
 
-	CaptureGifStep int // simulaton steps between gif generation
-}
+	nbComputationPerStep    uint64  // used to compute speed up
+	maxMinInterBodyDistance float64 // max of minInterBodyDistance over the run (in order to solve issue "over accumulation of bodies at border slows dow spreading #5")
+	stepDuration            float64 // duration of the last step (or of the last rendering) in nanoseconds
+	gflops                  float64
+
+	renderingMutex sync.Mutex // rendering the data set can be done only outside the load config xxx function
+
+	stepErrMutex sync.Mutex
+	stepErr      error // first error met by the routines during the current step
+
+	pool *workerPool // workers of the force computation, they are started at the first step
 
-// (in order to solve issue "over accumulation of bodies at border slows dow spreading #5")
-var maxMinInterBodyDistance float64 // this variable store the max of the previous value
+	doneMutex sync.Mutex    // guards done, which is replaced when RunSimulation is called again
+	done      chan struct{} // closed when RunSimulation returns
+	result    RunResult
+	err       error
+
+	CaptureGifStep int // simulaton steps between gif generation (0 means no gif)
+	CheckpointStep int // simulation steps between automatic checkpoints (0 means no automatic checkpoint)
+}
 
 // RunSimulation is the main entry to the simulation
 // It call for one step of simulation until the
 // energy decrease ratio is met
-func (r *Run) RunSimulation() {
+//
+// When the criteria is met, the state is set to COMPLETED and the final configuration is captured.
+// If ctx is cancelled, the simulation stops at the end of the current step and ctx.Err() is returned.
+// In all cases, Done() is closed when RunSimulation returns.
+// RunSimulation can be called again once it has returned, Done() is then a new channel.
+func (r *Run) RunSimulation(ctx context.Context) (RunResult, error) {
+
+	defer close(r.nextDone())
+	defer r.pool.stop()
+
+	r.startTime = time.Now()
+	criterion := r.config.StopCriterion()
+	Info.Printf("Stop criterion %s", criterion.Name())
 
-	Info.Printf("Energy decrease %f, ShutdownCriteria %f crit %t", r.energyDecreaseRatio, ShutdownCriteria, (r.energyDecreaseRatio > ShutdownCriteria))
-	for r.energyDecreaseRatio > ShutdownCriteria {
+	fired := firedCriterion(criterion, r)
+	for fired == nil {
 		// if state is STOPPED, pause
 		for r.state == STOPPED {
-			time.Sleep(100 * time.Millisecond)
+			select {
+			case <-ctx.Done():
+				return r.complete(ctx.Err())
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		select {
+		case <-ctx.Done():
+			r.state = STOPPED
+			return r.complete(ctx.Err())
+		default:
 		}
-		r.OneStep()
+		if err := r.OneStep(); err != nil {
+			r.state = STOPPED
+			return r.complete(err)
+		}
+		fired = firedCriterion(criterion, r)
 	}
+	Info.Printf("Stop criterion %s fired at step %d", fired.Name(), r.step)
+	r.result.StopCriterion = fired.Name()
 
-	r.state = STOPPED
-	r.CaptureConfig()
+	r.state = COMPLETED
 	// r.CreateMovieFromGif()
-	os.Exit(0)
+	return r.complete(nil)
+}
+
+// set the result of the run
+func (r *Run) complete(err error) (RunResult, error) {
+
+	r.result.Country = r.country
+	r.result.NbBodies = len(*r.bodies)
+	r.result.Step = r.step
+	r.result.Energy = r.energy
+	r.result.EnergyDecreaseRatio = r.energyDecreaseRatio
+	if err == nil {
+		if r.CaptureConfig() {
+			r.result.Filename = fmt.Sprintf(r.OutputDir+"/"+CountryBodiesNamePattern, r.country, len(*r.bodies), r.step)
+		}
+		Info.Printf("Simulation completed at step %d, energy decrease ratio %e", r.step, r.energyDecreaseRatio)
+	} else {
+		Error.Printf("Simulation ended at step %d: %s", r.step, err.Error())
+	}
+	r.err = err
+	return r.result, err
+}
+
+// Done returns a channel that is closed when RunSimulation returns
+func (r *Run) Done() <-chan struct{} {
+	r.doneMutex.Lock()
+	defer r.doneMutex.Unlock()
+	return r.done
+}
+
+// return the channel to be closed at the end of RunSimulation
+// a new one is made if the channel has been closed by a previous RunSimulation
+func (r *Run) nextDone() chan struct{} {
+	r.doneMutex.Lock()
+	defer r.doneMutex.Unlock()
+	select {
+	case <-r.done:
+		r.done = make(chan struct{})
+	default:
+	}
+	return r.done
+}
+
+// Result returns the outcome of RunSimulation
+// it is meaningful only after Done() is closed
+func (r *Run) Result() (RunResult, error) {
+	return r.result, r.err
 }
 
 func (r *Run) SetCountry(country string) {
@@ -222,21 +392,39 @@ func (r *Run) SetCountry(country string) {
 
 func (r *Run) SetGridFieldNb(v int) {
 
-	renderingMutex.Lock()
+	r.renderingMutex.Lock()
 	r.gridFieldNb = v
 	// Trace.Printf("r.gridFieldNb %d", r.gridFieldNb)
-	renderingMutex.Unlock()
+	r.renderingMutex.Unlock()
 }
 
 func NewRun() *Run {
+	return NewRunWithConfig(NewRunConfig())
+}
+
+// NewRunWithConfig creates a run that uses a copy of the parameters of config
+// (the run changes its Dt, DtAdjustMode and BN_THETA, config is left unchanged)
+//
+// the output dir of the run is named after the current time
+//
+// the workers of the force computation are started at the first step, they are stopped when
+// RunSimulation returns or when Close is called (the run can step again after Close)
+func NewRunWithConfig(config *RunConfig) *Run {
+	// https://stackoverflow.com/questions/20234104/how-to-format-current-time-using-a-yyyymmddhhmmss-format
+	return NewRunWithConfigInDir(config, time.Now().Local().Format("2006_01_02_150405"))
+}
+
+// NewRunWithConfigInDir creates a run that uses a copy of the parameters of config
+// and writes its files in outputDir (created if needed)
+func NewRunWithConfigInDir(config *RunConfig, outputDir string) *Run {
 	var r Run
+	r.SetConfig(config)
 	r.state = STOPPED
 	r.gridFieldNb = 10
 	bodies := make([]quadtree.Body, 0)
 
-	// create output directory and cwd to it
-	// https://stackoverflow.com/questions/20234104/how-to-format-current-time-using-a-yyyymmddhhmmss-format
-	r.OutputDir = time.Now().Local().Format("2006_01_02_150405")
+	// create output directory
+	r.OutputDir = outputDir
 	Info.Printf("Output dir %s", r.OutputDir)
 	os.Mkdir(r.OutputDir, 0777)
 
@@ -263,13 +451,38 @@ func NewRun() *Run {
 	return &r
 }
 
+// Close stops the workers of the force computation
+//
+// it is needed when the run is stepped without RunSimulation (OneStep, ComputeRepulsiveForce, ...)
+func (r *Run) Close() {
+	if r.pool != nil {
+		r.pool.stop()
+	}
+}
+
 // init the run with an array of quadtree bodies
+// (OutputDir is not changed, it can be set before or after Init)
 func (r *Run) Init(bodies *([]quadtree.Body)) {
 
 	Trace.Printf("Init begin")
 
+	if r.config == nil {
+		r.config = NewRunConfig()
+	}
+	if r.done == nil {
+		r.done = make(chan struct{})
+	}
+	if r.pool == nil {
+		r.pool = new(workerPool)
+	}
+
 	r.bodies = bodies
 
+	// the neighbours are stored by the ID of the bodies
+	if quadtree.SetMissingIDs(*bodies) {
+		Trace.Printf("Init bodies have been numbered")
+	}
+
 	makeBodiesMemory := func(varAddress **[]quadtree.Body) {
 		tmp := make([]quadtree.Body, len(*bodies))
 		*varAddress = &tmp
@@ -279,6 +492,9 @@ func (r *Run) Init(bodies *([]quadtree.Body)) {
 	makeBodiesMemory(&r.bodiesOrig)
 	copy(*r.bodiesOrig, *r.bodies)
 
+	r.bodiesIndex = nil
+	r.bodiesAttributes = nil
+
 	acc := make([]Acc, len(*bodies))
 	vel := make([]Vel, len(*bodies))
 	energy := make([]float64, len(*bodies))
@@ -287,6 +503,9 @@ func (r *Run) Init(bodies *([]quadtree.Body)) {
 	r.bodiesEnergy = &energy
 
 	// init quatrees
+	r.q.MaxLevel = r.config.QuadtreeMaxLevel
+	r.q.LeafCapacity = r.config.QuadtreeLeafCapacity
+	r.q.NbRoutines = r.config.ConcurrentRoutines
 	r.q.Init(bodies)
 
 	// init neighbour array
@@ -300,14 +519,30 @@ func (r *Run) Init(bodies *([]quadtree.Body)) {
 
 	r.energy = math.MaxFloat64 // very high
 	r.energyDecreaseRatio = 1.0
+	r.energies = nil
+	r.maxMinInterBodyDistance = 0.0
+	r.fireAlpha = 0.0
+	r.fireNbDownhill = 0
+	r.lbfgs = nil
+	r.forcesAreCurrent = false
 
-	DtAdjustMode = AUTO
+	r.config.DtAdjustMode = AUTO
 
 	Trace.Printf("Init end")
 }
 
-// rendering the data set can be done only outside the load config xxx function
-var renderingMutex sync.Mutex
+// Config returns the parameters of the run
+func (r *Run) Config() *RunConfig {
+	return r.config
+}
+
+// SetConfig sets the parameters of the run to a copy of config
+//
+// the parameters can then be changed with Config
+func (r *Run) SetConfig(config *RunConfig) {
+	c := *config
+	r.config = &c
+}
 
 func (r *Run) GetMaxRepulsiveForce() MaxRepulsiveForce {
 	return r.maxRepulsiveForce
@@ -342,20 +577,57 @@ func (r *Run) SetRenderingWindow(xMin, xMax, yMin, yMax float64) {
 	r.xMin, r.xMax, r.yMin, r.yMax = xMin, xMax, yMin, yMax
 }
 
-func NbVillagePerAxe() int {
-	return nbVillagePerAxe
+func (r *Run) NbVillagePerAxe() int {
+	return r.config.NbVillagePerAxe
+}
+
+func (r *Run) SetNbVillagePerAxe(nbVillagePerAxe_p int) {
+	r.config.NbVillagePerAxe = nbVillagePerAxe_p
+}
+
+// set the nb of workers of the force computation, the pool is resized at the next step
+func (r *Run) SetNbRoutines(nbRoutines_p int) {
+	r.config.ConcurrentRoutines = nbRoutines_p
 }
 
-func SetNbVillagePerAxe(nbVillagePerAxe_p int) {
-	nbVillagePerAxe = nbVillagePerAxe_p
+// return the pool of workers with nbRoutine workers
+func (r *Run) workers(nbRoutine int) *workerPool {
+	if nbRoutine < 1 {
+		nbRoutine = 1
+	}
+	r.pool.resize(nbRoutine)
+	return r.pool
+}
+
+// WorkerStats returns the load of the workers of the force computation at the last step
+//
+// with an even load, the Busy durations are close to each other
+func (r *Run) WorkerStats() []WorkerStats {
+	return r.pool.workerStats()
+}
+
+func (r *Run) SetRatioBorderBodies(ratioOfBorderVillages_p float64) {
+	r.config.RatioOfBorderVillages = ratioOfBorderVillages_p
+}
+
+// request a new value for Dt, it is applied at the next step (in MANUAL mode)
+func (r *Run) SetDtRequest(dt float64) {
+	r.config.DtRequest = dt
+}
+
+// request a new value for theta, it is applied at the next step
+func (r *Run) SetThetaRequest(theta float64) {
+	r.config.BN_THETA_Request = theta
 }
 
-func SetNbRoutines(nbRoutines_p int) {
-	ConcurrentRoutines = nbRoutines_p
+// density tenciles per village at the last step
+func (r *Run) DensityTenciles() [10]float64 {
+	return r.densityTenciles
 }
 
-func SetRatioBorderBodies(ratioOfBorderVillages_p float64) {
-	ratioOfBorderVillages = ratioOfBorderVillages_p
+// ratio of original neighbours that are still neighbours at the last step
+func (r *Run) Stirring() float64 {
+	return r.stirring
 }
 
 func (r *Run) GetMinInterBodyDistance() float64 {
@@ -389,27 +661,35 @@ func (r *Run) ToggleFieldRendering() {
 }
 
 func (r *Run) ToggleManualAuto() {
-	if DtAdjustMode == MANUAL {
-		DtAdjustMode = AUTO
+	if r.config.DtAdjustMode == MANUAL {
+		r.config.DtAdjustMode = AUTO
 	} else {
-		DtAdjustMode = MANUAL
+		r.config.DtAdjustMode = MANUAL
 	}
 }
 
-func (r *Run) OneStep() {
+func (r *Run) OneStep() error {
 	// renderingMutex.Lock()
-	r.OneStepOptional(true)
+	return r.OneStepOptional(true)
 	// renderingMutex.Unlock()
 }
-func (r *Run) OneStepOptional(updatePosition bool) {
+
+// perform one step of the simulation
+// if the repulsive forces cannot be computed, bodies are not moved and the error is returned
+func (r *Run) OneStepOptional(updatePosition bool) error {
 
 	// serialize into a file the gif
-	if r.step%r.CaptureGifStep == 0 {
+	if r.CaptureGifStep > 0 && r.step%r.CaptureGifStep == 0 {
 		r.CaptureGif()
 	}
 
 	t0 := time.Now()
 
+	// bodies that are close in the square are put close in memory
+	if r.config.ReorderStep > 0 && r.step%r.config.ReorderStep == 0 {
+		r.reorderBodies()
+	}
+
 	// compute gini distribution
 	r.q.ComputeQuadtreeGini()
 
@@ -425,9 +705,9 @@ func (r *Run) OneStepOptional(updatePosition bool) {
 
 	recordStr := make([]string, 10)
 	// get gini distribution at level 8
-	density := r.ComputeDensityTencilePerTerritory()
+	r.densityTenciles = r.ComputeDensityTencilePerTerritory()
 	// for idx, record := range r.q.BodyCountGini[8][:] {
-	for idx, record := range density {
+	for idx, record := range r.densityTenciles {
 		recordStr[idx] = fmt.Sprintf("%f", record)
 
 	}
@@ -436,42 +716,25 @@ func (r *Run) OneStepOptional(updatePosition bool) {
 	}
 	w.Flush()
 
-	nbComputationPerStep = 0
+	r.nbComputationPerStep = 0
 	r.maxVelocity = 0.0
+	r.stepErr = nil
 
-	BN_THETA = BN_THETA_Request
-
-	// compute the quadtree from the bodies
-	r.q.UpdateNodesListsAndCOM()
-
-	// compute repulsive forces & acceleration
-	r.ComputeRepulsiveForceConcurrent(ConcurrentRoutines)
-	r.ComputeMaxRepulsiveForce()
-
-	// Trace.Printf("MaxRepulsiveForce %#v", r.maxRepulsiveForce)
-
-	// compute optimal Dt, where we want the move to be
-	// half of the minimum distance between bodies
-	// with initial speed at 0, the speed will increase to Dt*Acc and
-	// the displacement will be Dx = Dt*Dt*Acc. Thefore Dt
-	r.dtOptim = math.Sqrt(MaxRatioDisplacement * r.minInterBodyDistance / r.maxRepulsiveForce.Norm)
+	r.config.BN_THETA = r.config.BN_THETA_Request
 
-	// update Dt according to request or according to computing optimal Dt
-	if DtAdjustMode == MANUAL {
-		Dt = DtRequest
-	} else {
-		if r.dtOptim > 0.0 {
-			Dt = r.dtOptim
+	// the forces may have been computed at the current positions by the integrator (see LBFGS)
+	if !r.forcesAreCurrent {
+		if err := r.computeForces(); err != nil {
+			return err
 		}
 	}
+	r.forcesAreCurrent = false
+	r.ComputeMaxRepulsiveForce()
 
-	// compute velocity
-	r.UpdateVelocity()
+	// Trace.Printf("MaxRepulsiveForce %#v", r.maxRepulsiveForce)
 
-	// compute new position
-	if updatePosition {
-		r.UpdatePosition()
-	}
+	// compute Dt, velocity and new position
+	r.config.integrator().Step(r, updatePosition)
 
 	// init neighbours original
 	if r.step == 0 {
@@ -487,91 +750,112 @@ func (r *Run) OneStepOptional(updatePosition bool) {
 		r.energy += *e
 	}
 	r.energyDecreaseRatio = (lastEnergy - r.energy) / lastEnergy
+	r.energies = append(r.energies, r.energy)
 
 	// compute stirring
-	stirring := r.bodiesNeighbours.ComputeStirring(r.bodiesNeighboursOrig)
+	r.stirring = r.bodiesNeighbours.ComputeStirring(r.bodiesNeighboursOrig)
 	ratioOfNil := r.bodiesNeighbours.ComputeRatioOfNilNeighbours()
 
 	// update the step
 	r.step++
 
 	t1 := time.Now()
-	StepDuration = float64((t1.Sub(t0)).Nanoseconds())
-	Gflops = float64(nbComputationPerStep) / StepDuration
+	r.stepDuration = float64((t1.Sub(t0)).Nanoseconds())
+	r.gflops = float64(r.nbComputationPerStep) / r.stepDuration
 
 	r.status = fmt.Sprintf("%s step %5d speedup %5.2f Dur %4.2f E %e MinD %e MaxMinD %e MaxV %e Dt Opt %e Dt %e F/A %e stirring %f nils %f e decr ratio %1.10f\n",
 		time.Now().Local().Format("2006 01 02 15 04 05"),
 		r.step,
-		float64(len(*r.bodies)*len(*r.bodies))/float64(nbComputationPerStep), //speedup
-		StepDuration/1000000000, // duration in seconds
+		float64(len(*r.bodies)*len(*r.bodies))/float64(r.nbComputationPerStep), //speedup
+		r.stepDuration/1000000000, // duration in seconds
 		r.energy,                // energy
 		r.minInterBodyDistance,
-		maxMinInterBodyDistance,
+		r.maxMinInterBodyDistance,
 		r.maxVelocity,
 		r.dtOptim,
-		Dt,
+		r.config.Dt,
 		r.ratioOfBodiesWithCapVel,
-		stirring,
+		r.stirring,
 		ratioOfNil,
 		r.energyDecreaseRatio)
 
 	fmt.Fprintf(r.StatusFileLog, r.Status())
 	fmt.Printf(r.Status())
+
+	// serialize the full state of the run
+	if r.CheckpointStep > 0 && r.step%r.CheckpointStep == 0 {
+		r.CaptureCheckpoint()
+	}
+	return nil
 }
 
-var Gflops float64
-var StepDuration float64
+// compute the quadtree from the bodies, then the repulsive forces, the accelerations and the energies
+func (r *Run) computeForces() error {
+
+	r.q.MaxLevel = r.config.QuadtreeMaxLevel
+	r.q.LeafCapacity = r.config.QuadtreeLeafCapacity
+	r.q.NbRoutines = r.config.ConcurrentRoutines
+	r.q.UpdateNodesListsAndCOM()
+
+	r.ComputeRepulsiveForceConcurrent(r.config.ConcurrentRoutines)
+	return r.stepErr
+}
+
+// record an error met during the computation of the current step
+// only the first error is kept
+func (r *Run) setStepError(err error) {
+	r.stepErrMutex.Lock()
+	if r.stepErr == nil {
+		r.stepErr = err
+	}
+	r.stepErrMutex.Unlock()
+}
 
 func (r *Run) Status() string {
 
 	return r.status
 }
 
+// Gflops returns the number of repulsion computations per nanosecond during the last step
+func (r *Run) Gflops() float64 {
+	return r.gflops
+}
+
 // compute repulsive forces by spreading the calculus
 // among nbRoutine go routines
 //
+// the acceleration of a body is computed by one routine only, in the order of the quadtree, and the
+// routines are only reduced with a min: results do not depend on the scheduling nor on nbRoutine
+// (see TestGolden)
+//
 // return minInterbodyDistance
 func (r *Run) ComputeRepulsiveForceConcurrent(nbRoutine int) float64 {
 
 	Trace.Println("ComputeRepulsiveForceConcurrent")
-	sliceLen := len(*r.bodies)
-	minInterbodyDistanceChan := make(chan float64)
-
-	// breakdown slice
-	for i := 0; i < nbRoutine; i++ {
-
-		startIndex := (i * sliceLen) / nbRoutine
-		endIndex := ((i + 1) * sliceLen) / nbRoutine
-
-		// if nbRoutine is above sliceLen
-		if endIndex < startIndex {
-			endIndex = startIndex
-		}
-
-		// log.Printf( "started routine %3d\n", i)
-		go r.ComputeRepulsiveForceSubSetMinDist(startIndex, endIndex, minInterbodyDistanceChan)
-	}
-
-	// wait for return and compute the min distance across all routines
-	r.minInterBodyDistance = 2.0 // cannot be in a 1.0 by 1.0 square
-	for i := 0; i < nbRoutine; i++ {
-		// log.Printf( "waiting routine %3d\n", i)
-
-		minInterbodyDistanceRoutine := <-minInterbodyDistanceChan
-		// log.Printf( "routine %3d minInterbodyDistance by mutex %e, by concurency %e\n", i, r.minInterBodyDistance, minInterbodyDistanceRoutine)
 
-		if minInterbodyDistanceRoutine < r.minInterBodyDistance {
-			r.minInterBodyDistance = minInterbodyDistanceRoutine
-		}
+	if UseDualTree {
+		r.minInterBodyDistance = r.ComputeRepulsiveForceDualTree(nbRoutine)
+	} else {
+		// bodies are handed by chunks to the workers, a worker takes the next chunk when it is done
+		sliceLen := len(*r.bodies)
+		nbChunks := (sliceLen + workerChunkSize - 1) / workerChunkSize
+		r.minInterBodyDistance = r.workers(nbRoutine).run(nbChunks, func(chunk int) float64 {
+			startIndex := chunk * workerChunkSize
+			endIndex := startIndex + workerChunkSize
+			if endIndex > sliceLen {
+				endIndex = sliceLen
+			}
+			return r.ComputeRepulsiveForceSubSet(startIndex, endIndex)
+		})
 	}
 	// log.Printf( "minInterbodyDistance by mutex %e, by concurency %e\n", r.minInterBodyDistance, minInterbodyDistance)
 
 	// update maxMinInterBodyDistance
-	if maxMinInterBodyDistance == 0 {
-		maxMinInterBodyDistance = r.minInterBodyDistance
+	if r.maxMinInterBodyDistance == 0 {
+		r.maxMinInterBodyDistance = r.minInterBodyDistance
 	}
-	if maxMinInterBodyDistance < r.minInterBodyDistance {
-		maxMinInterBodyDistance = r.minInterBodyDistance
+	if r.maxMinInterBodyDistance < r.minInterBodyDistance {
+		r.maxMinInterBodyDistance = r.minInterBodyDistance
 	}
 
 	return r.minInterBodyDistance
@@ -580,20 +864,13 @@ func (r *Run) ComputeRepulsiveForceConcurrent(nbRoutine int) float64 {
 // compute repulsive forces
 func (r *Run) ComputeRepulsiveForce() {
 
+	if UseDualTree {
+		r.ComputeRepulsiveForceDualTree(1)
+		return
+	}
 	r.ComputeRepulsiveForceSubSet(0, len(*r.bodies))
 }
 
-// compute repulsive forces for a sub part of the bodies
-//
-// send the computed min distance through minInterbodyDistanceChan
-func (r *Run) ComputeRepulsiveForceSubSetMinDist(startIndex, endIndex int, minInterbodyDistanceChan chan<- float64) {
-
-	_minInterbodyDistance := r.ComputeRepulsiveForceSubSet(startIndex, endIndex)
-	// log.Printf( "minInterbodyDistance by mutex %e, by concurency %e\n", r.minInterBodyDistance, _minInterbodyDistance)
-
-	minInterbodyDistanceChan <- _minInterbodyDistance
-}
-
 // compute repulsive forces for a sub part of the bodies
 // return the minimal distance between the bodies sub set
 func (r *Run) ComputeRepulsiveForceSubSet(startIndex, endIndex int) float64 {
@@ -637,29 +914,36 @@ func (r *Run) computeAccelerationOnBody(origIndex int) float64 {
 	energy := &((*r.bodiesEnergy)[origIndex])
 	*energy = 0.0
 
-	// parse all other bodies for repulsions
+	// parse all other bodies (and their images) for repulsions
 	// accumulate repulsion on acceleration
-	for idx2 := range *r.bodies {
+	n := r.config.imageRange()
+	for xM := -n; xM <= n; xM++ {
+		for yM := -n; yM <= n; yM++ {
+			for idx2 := range *r.bodies {
 
-		if idx2 != origIndex {
-			body2 := (*r.bodies)[idx2]
+				if idx2 != origIndex {
+					body2 := (*r.bodies)[idx2]
 
-			dist := getDistanceBetweenBodiesWithMirror(&body, &body2, 0, 0)
+					dist := r.config.getDistanceBetweenBodies(&body, &body2, xM, yM)
 
-			if dist == 0.0 {
-				log.Fatal("distance is 0.0 between ", body, " and ", body2)
-			}
+					if dist == 0.0 {
+						r.setStepError(fmt.Errorf("%w: body %d at x %f y %f and body %d", ErrZeroDistance, origIndex, body.X, body.Y, idx2))
+						continue
+					}
 
-			if dist < minInterbodyDistance {
-				minInterbodyDistance = dist
-			}
+					if dist < minInterbodyDistance {
+						minInterbodyDistance = dist
+					}
 
-			x, y, e := getRepulsionVector(&body, &body2, 0, 0)
+					atomic.AddUint64(&r.nbComputationPerStep, 1)
+					x, y, e := r.config.getRepulsionVector(&body, &body2, xM, yM)
 
-			acc.X += x
-			acc.Y += y
-			*energy += e
-			// Trace.Printf("computeAccelerationOnBody idx2 %3d x %9.3f y %9.3f \n", idx2, x, y)
+					acc.X += x
+					acc.Y += y
+					*energy += e
+					// Trace.Printf("computeAccelerationOnBody idx2 %3d x %9.3f y %9.3f \n", idx2, x, y)
+				}
+			}
 		}
 	}
 	return minInterbodyDistance
@@ -683,9 +967,11 @@ func (r *Run) computeAccelerationOnBodyBarnesHut(idx int) float64 {
 	// Coord is initialized at the Root coord
 	var rootCoord quadtree.Coord
 
+	// parse the images of the bodies
+	n := r.config.imageRange()
 	result := 2.0
-	for i := -1; i <= 1; i++ {
-		for j := -1; j <= 1; j++ {
+	for i := -n; i <= n; i++ {
+		for j := -n; j <= n; j++ {
 
 			resultTmp := r.computeAccelationWithNodeRecursive(idx, rootCoord, i, j)
 			if resultTmp < result {
@@ -718,8 +1004,8 @@ func (r *Run) computeAccelationWithNodeRecursive(idx int, coord quadtree.Coord,
 	boxSize := 1.0 / math.Pow(2.0, float64(level)) // if level = 0, this is 1.0
 
 	// fetch node in the quadtree
-	node := &(r.q.Nodes[coord])
-	distToNode := getDistanceBetweenBodiesWithMirror(&body, &(node.Body), xM, yM)
+	node := r.q.Node(coord)
+	distToNode := r.config.getDistanceBetweenBodies(&body, &(node.Body), xM, yM)
 
 	// Info.Printf("computeAccelationWithNodeRecursive distance to quadtree node %f", distToNode)
 
@@ -729,9 +1015,10 @@ func (r *Run) computeAccelationWithNodeRecursive(idx int, coord quadtree.Coord,
 	}
 
 	// check if the COM of the node can be used
-	if (boxSize / distToNode) < BN_THETA {
+	if (boxSize / distToNode) < r.config.BN_THETA {
 
-		x, y, e := getRepulsionVector(&body, &(node.Body), xM, yM)
+		atomic.AddUint64(&r.nbComputationPerStep, 1)
+		x, y, e := r.config.getRepulsionVector(&body, &(node.Body), xM, yM)
 
 		acc.X += x
 		acc.Y += y
@@ -740,7 +1027,7 @@ func (r *Run) computeAccelationWithNodeRecursive(idx int, coord quadtree.Coord,
 		// Info.Printf("computeAccelationWithNodeRecursive at node %#v x %9.3f y %9.3f\n", node.Coord(), x, y)
 
 	} else {
-		if level < 8 {
+		if !node.IsLeaf() {
 			// parse sub nodes
 			// Info.Printf("computeAccelationWithNodeRecursive go down at node %#v\n", node.Coord())
 			coordNW, coordNE, coordSW, coordSE := quadtree.NodesBelow(coord)
@@ -774,24 +1061,27 @@ func (r *Run) computeAccelationWithNodeRecursive(idx int, coord quadtree.Coord,
 				if *b != body {
 
 					// Info.Printf("computeAccelationWithNodeRecursive at leaf %#v rank %d", b.Coord(), rank)
-					dist := getDistanceBetweenBodiesWithMirror(&body, b, xM, yM)
+					dist := r.config.getDistanceBetweenBodies(&body, b, xM, yM)
 
 					r.bodiesNeighbours.Insert(idx, b, dist)
 
 					if dist == 0.0 {
-						var t testing.T
-						r.q.CheckIntegrity(&t)
+						if err := r.q.Check(); err != nil {
+							Error.Printf("%s", err.Error())
+						}
 
 						Error.Printf("Problem body x %f y %f to x %f y %f", body.X, body.Y, b.X, b.Y)
 						Error.Printf("Problem at rank %d for body of rank %d on node %#v ",
 							rank, rankOfBody, *node)
-						os.Exit(3)
+						r.setStepError(fmt.Errorf("%w: body %d at x %f y %f", ErrZeroDistance, idx, body.X, body.Y))
+						return minInterbodyDistance
 					}
 					if dist < minInterbodyDistance {
 						minInterbodyDistance = dist
 					}
 
-					x, y, e := getRepulsionVector(&body, b, xM, yM)
+					atomic.AddUint64(&r.nbComputationPerStep, 1)
+					x, y, e := r.config.getRepulsionVector(&body, b, xM, yM)
 					// Info.Printf("computeAccelationWithNodeRecursive at leaf %#v rank %d x %9.3f y %9.3f\n", b.Coord(), rank, x, y)
 
 					acc.X += x
@@ -812,37 +1102,14 @@ func (r *Run) UpdateVelocity() {
 
 	Trace.Println("UpdateVelocity")
 
-	var nbVelCapping int64
-	// parse all bodies
-	for idx := range *r.bodies {
+	// put some drag on initial speed
+	r.dampVelocity(r.config.SpeedDragFactor)
 
-		// put some drag on initial speed
-		vel := r.getVel(idx)
-		vel.X *= SpeedDragFactor
-		vel.Y *= SpeedDragFactor
-
-		// update velocity (to be completed with Dt)
-		acc := r.getAcc(idx)
-		vel.X += acc.X * Dt
-		vel.Y += acc.Y * Dt
-
-		// if velocity is above
-		velocity := math.Sqrt(vel.X*vel.X + vel.Y*vel.Y)
-
-		if velocity > r.maxVelocity {
-			var m sync.Mutex
-			m.Lock()
-			r.maxVelocity = velocity
-			m.Unlock()
-		}
+	// update velocity (to be completed with Dt)
+	r.kick(r.config.Dt)
 
-		if velocity*Dt > MaxDisplacement {
-			vel.X *= MaxDisplacement / (velocity * Dt)
-			vel.Y *= MaxDisplacement / (velocity * Dt)
-			nbVelCapping += 1
-		}
-	}
-	r.ratioOfBodiesWithCapVel = float64(nbVelCapping) / float64(len(*r.bodies))
+	// if velocity is above
+	r.capVelocity()
 }
 
 func (r *Run) UpdatePosition() {
@@ -857,27 +1124,14 @@ func (r *Run) UpdatePosition() {
 		// updatePos
 		vel := r.getVel(idx)
 
-		body.X += vel.X * Dt
-		body.Y += vel.Y * Dt
+		oldX, oldY := body.X, body.Y
+		body.X += vel.X * r.config.Dt
+		body.Y += vel.Y * r.config.Dt
 
-		if body.X >= 1.0 {
-			body.X = 1.0 - (body.X - 1.0)
-			vel.X = -vel.X
-			r.borderHasBeenMet = true
-		}
-		if body.X <= 0.0 {
-			body.X = -body.X
-			vel.X = -vel.X
-			r.borderHasBeenMet = true
-		}
-		if body.Y >= 1.0 {
-			body.Y = 1.0 - (body.Y - 1.0)
-			vel.Y = -vel.Y
+		if r.config.applyBoundary(body, vel) {
 			r.borderHasBeenMet = true
 		}
-		if body.Y <= 0.0 {
-			body.Y = -body.Y
-			vel.Y = -vel.Y
+		if r.config.applyDomain(body, vel, oldX, oldY) {
 			r.borderHasBeenMet = true
 		}
 	}
@@ -1,10 +1,12 @@
 package barneshut
 
 import (
+	"archive/zip"
 	"fmt"
 	"math/rand"
 	"testing"
 
+	"github.com/thomaspeugeot/tkv/bods"
 	"github.com/thomaspeugeot/tkv/quadtree"
 )
 
@@ -24,6 +26,7 @@ func BenchmarkComputeRepulsiveForces_1K(b *testing.B) {
 	bodies := make([]quadtree.Body, 1000)
 	SpreadOnCircle(&bodies)
 	var r Run
+	defer r.Close()
 	r.Init(&bodies)
 	for i := 0; i < b.N; i++ {
 		r.ComputeRepulsiveForce()
@@ -34,17 +37,31 @@ func BenchmarkComputeRepulsiveForces_10K(b *testing.B) {
 	bodies := make([]quadtree.Body, 10000)
 	SpreadOnCircle(&bodies)
 	var r Run
+	defer r.Close()
 	r.Init(&bodies)
 	for i := 0; i < b.N; i++ {
 		r.ComputeRepulsiveForce()
 	}
 }
 
+func BenchmarkComputeRepulsiveForcesDualTree_10K(b *testing.B) {
+
+	bodies := make([]quadtree.Body, 10000)
+	SpreadOnCircle(&bodies)
+	var r Run
+	defer r.Close()
+	r.Init(&bodies)
+	for i := 0; i < b.N; i++ {
+		r.ComputeRepulsiveForceDualTree(1)
+	}
+}
+
 func BenchmarkComputeRepulsiveForcesOnHalfSet_1K(b *testing.B) {
 
 	bodies := make([]quadtree.Body, 1000)
 	SpreadOnCircle(&bodies)
 	var r Run
+	defer r.Close()
 	r.Init(&bodies)
 	endIndex := len(bodies) / 2
 	for i := 0; i < b.N; i++ {
@@ -57,12 +74,25 @@ func BenchmarkComputeRepulsiveForcesConcurrent20_30K(b *testing.B) {
 	bodies := make([]quadtree.Body, 30000)
 	SpreadOnCircle(&bodies)
 	var r Run
+	defer r.Close()
 	r.Init(&bodies)
 	for i := 0; i < b.N; i++ {
 		r.ComputeRepulsiveForceConcurrent(20)
 	}
 }
 
+func BenchmarkComputeRepulsiveForcesDualTreeConcurrent20_30K(b *testing.B) {
+
+	bodies := make([]quadtree.Body, 30000)
+	SpreadOnCircle(&bodies)
+	var r Run
+	defer r.Close()
+	r.Init(&bodies)
+	for i := 0; i < b.N; i++ {
+		r.ComputeRepulsiveForceDualTree(20)
+	}
+}
+
 func BenchmarkGetModuleDistance(b *testing.B) {
 
 	x := rand.Float64()
@@ -103,12 +133,17 @@ func BenchmarkGetRepulsionVector(b *testing.B) {
 	bodies[1].X = 0.5
 	bodies[1].Y = 0.5
 
+	c := NewRunConfig()
 	for i := 0; i < b.N; i++ {
-		getRepulsionVector(&(bodies[0]), &(bodies[1]), 0, 0)
+		c.getRepulsionVector(&(bodies[0]), &(bodies[1]), 0, 0)
 	}
 }
 
-// benchmark init
+// benchmark init, with the bodies in the order of SpreadOnCircle (random) and in the Z-order
+// of their node (see RunConfig.ReorderStep)
+//
+// BenchmarkInitRun_1M/inputOrder         	       5	 794799219 ns/op
+// BenchmarkInitRun_1M/mortonOrder        	       5	 571304324 ns/op
 func BenchmarkInitRun_1M(b *testing.B) {
 
 	bodies := make([]quadtree.Body, 1000*1000)
@@ -119,10 +154,23 @@ func BenchmarkInitRun_1M(b *testing.B) {
 
 	SpreadOnCircle(&bodies)
 
-	var r Run
-	for i := 0; i < b.N; i++ {
+	b.Run("inputOrder", func(b *testing.B) {
+		var r Run
+		defer r.Close()
+		for i := 0; i < b.N; i++ {
+			r.Init(&bodies)
+		}
+	})
+	b.Run("mortonOrder", func(b *testing.B) {
+		var r Run
+		defer r.Close()
 		r.Init(&bodies)
-	}
+		r.reorderBodies()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			r.Init(&bodies)
+		}
+	})
 }
 
 // benchmark gif output
@@ -134,6 +182,7 @@ func BenchmarkOutputGif_1MBody_1KSteps(b *testing.B) {
 	SpreadOnCircle(&bodies)
 
 	var r Run
+	defer r.Close()
 	r.Init(&bodies)
 
 	for i := 0; i < b.N; i++ {
@@ -141,3 +190,45 @@ func BenchmarkOutputGif_1MBody_1KSteps(b *testing.B) {
 		r.CaptureGif()
 	}
 }
+
+// compare the integrators on the nb of steps to convergence for haiti
+// (one body out of 20 of the original configuration)
+//
+// BenchmarkIntegratorsHti/euler           	       1	123225478236 ns/op	2361228739385 energy	       539.0 steps
+// BenchmarkIntegratorsHti/leapfrog        	       1	138985528857 ns/op	2361259407188 energy	       541.0 steps
+// BenchmarkIntegratorsHti/velocityVerlet  	       1	158319308429 ns/op	2359118756741 energy	       656.0 steps
+// BenchmarkIntegratorsHti/fire            	       1	 83898203923 ns/op	2362467718277 energy	       390.0 steps
+// BenchmarkIntegratorsHti/lbfgs           	       1	 75351303588 ns/op	2352570537935 energy	       355.0 steps
+func BenchmarkIntegratorsHti(b *testing.B) {
+
+	archive, err := zip.OpenReader("../runtime_server/conf-hti-00190948-00000.bods.zip")
+	if err != nil {
+		b.Skip(err)
+	}
+	defer archive.Close()
+	file, err := archive.File[0].Open()
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, hti, err := bods.ReadBodies(file)
+	file.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var bodies []quadtree.Body
+	for idx := 0; idx < len(hti); idx += 20 {
+		bodies = append(bodies, hti[idx])
+	}
+
+	for _, name := range IntegratorNames {
+		i, _ := IntegratorFromName(name)
+		b.Run(name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				r, steps := runIntegrator(b, i, append([]quadtree.Body(nil), bodies...),
+					AnyOf{EnergyDecrease{Threshold: 1e-4, Steps: 10}, MaxSteps{Steps: 2000}})
+				b.ReportMetric(float64(steps), "steps")
+				b.ReportMetric(r.energy, "energy")
+			}
+		})
+	}
+}
@@ -1,11 +1,14 @@
 package barneshut
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"math"
-	"syscall"
+	"math/rand"
 	"testing"
-	"time"
 
+	"github.com/thomaspeugeot/tkv/bods"
 	"github.com/thomaspeugeot/tkv/quadtree"
 )
 
@@ -16,6 +19,7 @@ func TesOutputGif(t *testing.T) {
 	SpreadOnCircle(&bodies)
 
 	var r Run
+	defer r.Close()
 	r.Init(&bodies)
 	r.SetCountry("fra")
 
@@ -29,18 +33,17 @@ func TestOneStep(t *testing.T) {
 	SpreadOnCircle(&bodies)
 
 	var r Run
-
-	// create output directory and cwd to it
-	r.OutputDir = time.Now().Local().Format("2006_01_02_040506")
-	Info.Printf("Output dir %s", r.OutputDir)
-	syscall.Mkdir(r.OutputDir, 0777)
-
+	defer r.Close()
+	r.OutputDir = t.TempDir()
 	r.Init(&bodies)
 	r.SetCountry("fra")
 
 	// r.q.CheckIntegrity( t)
 	r.OneStep()
 	r.OneStep()
+
+	// the bodies have moved since the last update of the quadtree
+	r.q.UpdateNodesListsAndCOM()
 	r.q.CheckIntegrity(t)
 }
 
@@ -76,7 +79,7 @@ func TesGetRepulsionVector(t *testing.T) {
 	cases[0].wantY = -2.4
 
 	for _, c := range cases {
-		gotX, gotY, _ := getRepulsionVector(&c.A, &c.B, 0, 0)
+		gotX, gotY, _ := NewRunConfig().getRepulsionVector(&c.A, &c.B, 0, 0)
 		if (gotX != c.wantX) && (gotY != c.wantY) {
 			t.Errorf("A %#v B %#v == %f %f, want %f %f", c.A, c.B, gotX, gotY, c.wantX, c.wantY)
 		}
@@ -99,7 +102,8 @@ func TesComputeRepulsiveForces(t *testing.T) {
 	cases[0].want[0] = Acc{-3.2, -2.4}
 	cases[0].want[1] = Acc{3.2, 2.4}
 
-	for _, c := range cases {
+	for idx := range cases {
+		c := &cases[idx]
 		c.r.ComputeRepulsiveForce()
 		if *(c.r.getAcc(0)) != c.want[0] && *(c.r.getAcc(1)) != c.want[1] {
 			t.Errorf("\ngot %#v %#v\nwant %#v %#v", c.r.getAcc(0), c.r.getAcc(1), c.want[0], c.want[1])
@@ -138,10 +142,12 @@ func TestComputeRepulsiveForcesConcurrent(t *testing.T) {
 // forces is close to the classic computation
 func TestComputeAccelerationOnBodyBarnesHut(t *testing.T) {
 
+	// the error depends on the position of body 0, the bodies are seeded so that the test is reproducible
 	bodies := make([]quadtree.Body, 2000000)
-	SpreadOnCircle(&bodies)
+	SpreadOnCircleWithRand(&bodies, rand.New(rand.NewSource(1)))
 	// quadtree.InitBodiesUniform( &bodies, 200)
 	var r Run
+	defer r.Close()
 	r.Init(&bodies)
 
 	r.computeAccelerationOnBody(0)
@@ -177,6 +183,7 @@ func TestConcurrentMinDistanceCompute(t *testing.T) {
 	SpreadOnCircle(&bodies)
 
 	var r Run
+	defer r.Close()
 	r.Init(&bodies)
 
 	// init
@@ -198,7 +205,493 @@ func TestConcurrentMinDistanceCompute(t *testing.T) {
 
 func TestEmptyBodySet(t *testing.T) {
 
-	r := NewRun()
+	r := NewRunWithConfigInDir(NewRunConfig(), t.TempDir())
+	defer r.Close()
+	r.OneStep()
+
+}
+
+// test that a run restarted from a checkpoint continues exactly
+// as the run that kept going
+func TestCheckpointContinuation(t *testing.T) {
+	bodies := make([]quadtree.Body, 500)
+	SpreadOnCircle(&bodies)
+
+	var r Run
+	defer r.Close()
+	r.OutputDir = t.TempDir()
+	r.CaptureGifStep = 1000
+	r.Init(&bodies)
+	r.SetCountry("fra")
+	r.OneStep()
+	r.OneStep()
+
+	var buf bytes.Buffer
+	if err := r.WriteCheckpoint(&buf); err != nil {
+		t.Fatalf("write checkpoint: %v", err)
+	}
+
+	var r2 Run
+	defer r2.Close()
+	r2.OutputDir = r.OutputDir
+	r2.CaptureGifStep = r.CaptureGifStep
+	if err := r2.ReadCheckpoint(&buf); err != nil {
+		t.Fatalf("read checkpoint: %v", err)
+	}
+	if r2.GetStep() != r.GetStep() {
+		t.Errorf("restored step %d, want %d", r2.GetStep(), r.GetStep())
+	}
+
+	r.OneStep()
+	r.OneStep()
+	r2.OneStep()
+	r2.OneStep()
+
+	for idx := range *r.bodies {
+		if (*r.bodies)[idx].BodyXY != (*r2.bodies)[idx].BodyXY {
+			t.Fatalf("body %d differs, got %#v, want %#v", idx, (*r2.bodies)[idx].BodyXY, (*r.bodies)[idx].BodyXY)
+		}
+		if *r.getVel(idx) != *r2.getVel(idx) {
+			t.Fatalf("velocity %d differs, got %#v, want %#v", idx, *r2.getVel(idx), *r.getVel(idx))
+		}
+	}
+	if r.energy != r2.energy {
+		t.Errorf("energy differs, got %e, want %e", r2.energy, r.energy)
+	}
+	if s, s2 := r.bodiesNeighbours.ComputeStirring(r.bodiesNeighboursOrig),
+		r2.bodiesNeighbours.ComputeStirring(r2.bodiesNeighboursOrig); s != s2 {
+		t.Errorf("stirring differs, got %f, want %f", s2, s)
+	}
+}
+
+// test that a run of an integrator with a state (FIRE, LBFGS) restarted from a checkpoint continues exactly
+// as the run that kept going, the restarted run gets the integrator and the kernel from the checkpoint
+func TestCheckpointContinuationIntegrators(t *testing.T) {
+
+	for _, i := range []Integrator{FIRE{NMin: 2}, LBFGS{Memory: 4}, Leapfrog{}} {
+		bodies := make([]quadtree.Body, 300)
+		SpreadOnCircleWithRand(&bodies, rand.New(rand.NewSource(1)))
+
+		var r Run
+		defer r.Close()
+		config := NewRunConfig()
+		config.Integrator = i
+		config.Kernel = Plummer{Epsilon: 0.001}
+		config.StopCriteria = AnyOf{MaxSteps{Steps: 20}, AllOf{Stirring{Threshold: 0.5}}}
+		r.SetConfig(config)
+		r.OutputDir = t.TempDir()
+		r.CaptureGifStep = 1000
+		r.Init(&bodies)
+		r.SetCountry("fra")
+		for step := 0; step < 5; step++ {
+			if err := r.OneStep(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := r.WriteCheckpoint(&buf); err != nil {
+			t.Fatalf("%s: write checkpoint: %v", i.Name(), err)
+		}
+
+		var r2 Run
+		defer r2.Close()
+		r2.OutputDir = r.OutputDir
+		r2.CaptureGifStep = r.CaptureGifStep
+		if err := r2.ReadCheckpoint(&buf); err != nil {
+			t.Fatalf("%s: read checkpoint: %v", i.Name(), err)
+		}
+		if r2.config.integrator() != i || r2.config.Kernel != config.Kernel ||
+			r2.config.StopCriterion().Name() != config.StopCriterion().Name() {
+			t.Fatalf("%s: restored config %#v", i.Name(), r2.config)
+		}
+
+		for step := 0; step < 5; step++ {
+			if err := r.OneStep(); err != nil {
+				t.Fatal(err)
+			}
+			if err := r2.OneStep(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for idx := range *r.bodies {
+			if (*r.bodies)[idx].BodyXY != (*r2.bodies)[idx].BodyXY {
+				t.Fatalf("%s: body %d differs, got %#v, want %#v", i.Name(), idx, (*r2.bodies)[idx].BodyXY, (*r.bodies)[idx].BodyXY)
+			}
+		}
+		if r.energy != r2.energy || r.config.Dt != r2.config.Dt {
+			t.Errorf("%s: energy %e Dt %e, want %e %e", i.Name(), r2.energy, r2.config.Dt, r.energy, r.config.Dt)
+		}
+	}
+}
+
+// test that the parameters of the quadtree and of the reordering are restored from the checkpoint
+// and that a run with another solver or without the domain refuses the checkpoint
+func TestCheckpointForceParameters(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 1000)
+	SpreadOnCircleWithRand(&bodies, rand.New(rand.NewSource(1)))
+
+	var r Run
+	defer r.Close()
+	config := NewRunConfig()
+	config.QuadtreeMaxLevel = 12
+	config.QuadtreeLeafCapacity = 4
+	config.ReorderStep = 2
+	config.Domain = diskDomain{}
+	r.SetConfig(config)
+	r.OutputDir = t.TempDir()
+	r.CaptureGifStep = 1000
+	r.Init(&bodies)
+	r.SetCountry("fra")
+	for step := 0; step < 3; step++ {
+		if err := r.OneStep(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := r.WriteCheckpoint(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := buf.Bytes()
+
+	// without the domain
+	var r2 Run
+	defer r2.Close()
+	r2.OutputDir = r.OutputDir
+	if err := r2.ReadCheckpoint(bytes.NewReader(checkpoint)); err == nil {
+		t.Errorf("checkpoint with a domain read by a run without domain")
+	}
+
+	// with the other solver
+	UseDualTree = true
+	r2.SetConfig(NewRunConfig())
+	r2.Config().Domain = diskDomain{}
+	err := r2.ReadCheckpoint(bytes.NewReader(checkpoint))
+	UseDualTree = false
+	if err == nil {
+		t.Errorf("checkpoint of the barnes-hut solver read by the dual tree solver")
+	}
+
+	// the run has the default parameters of the quadtree, the ones of the checkpoint are restored
+	r2.SetConfig(NewRunConfig())
+	r2.Config().Domain = diskDomain{}
+	r2.CaptureGifStep = r.CaptureGifStep
+	if err := r2.ReadCheckpoint(bytes.NewReader(checkpoint)); err != nil {
+		t.Fatal(err)
+	}
+	if c := r2.Config(); c.QuadtreeMaxLevel != 12 || c.QuadtreeLeafCapacity != 4 || c.ReorderStep != 2 {
+		t.Fatalf("restored config %#v", c)
+	}
+	for step := 0; step < 3; step++ {
+		if err := r.OneStep(); err != nil {
+			t.Fatal(err)
+		}
+		if err := r2.OneStep(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for idx := range *r.bodies {
+		if (*r.bodies)[idx].BodyXY != (*r2.bodies)[idx].BodyXY {
+			t.Fatalf("body %d differs, got %#v, want %#v", idx, (*r2.bodies)[idx].BodyXY, (*r.bodies)[idx].BodyXY)
+		}
+	}
+}
+
+// test that the attributes of the bodies are written with the bodies, whatever their order in memory,
+// and that they are kept by the checkpoints
+func TestBodiesAttributes(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 500)
+	SpreadOnCircle(&bodies)
+	attributes := make([]bods.Attributes, len(bodies))
+	for idx := range attributes {
+		attributes[idx] = bods.Attributes{Row: int32(idx), Col: 3, Population: 1000.0, Urban: idx%2 == 0}
+	}
+
+	var r Run
+	defer r.Close()
+	config := NewRunConfig()
+	config.ReorderStep = 1
+	r.SetConfig(config)
+	r.OutputDir = t.TempDir()
+	r.CaptureGifStep = 0
+	r.Init(&bodies)
+	if err := r.SetBodiesAttributes(attributes[1:]); err == nil {
+		t.Errorf("attributes that do not match the bodies should be refused")
+	}
+	if err := r.SetBodiesAttributes(attributes); err != nil {
+		t.Fatal(err)
+	}
 	r.OneStep()
 
+	var checkpoint bytes.Buffer
+	if err := r.WriteCheckpoint(&checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	var r2 Run
+	defer r2.Close()
+	r2.OutputDir = r.OutputDir
+	if err := r2.ReadCheckpoint(&checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, run := range []*Run{&r, &r2} {
+		var buf bytes.Buffer
+		if err := run.WriteConfig(&buf); err != nil {
+			t.Fatal(err)
+		}
+		_, read, readAttributes, err := bods.ReadBodiesWithAttributes(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for idx := range read {
+			if read[idx].ID != uint32(idx+1) || readAttributes[idx] != attributes[idx] {
+				t.Fatalf("body %d with ID %d got attributes %#v", idx, read[idx].ID, readAttributes[idx])
+			}
+		}
+	}
+}
+
+// test that two runs simulated side by side in the same process
+// do not interfere with each other
+func TestRunsSideBySide(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 300)
+	SpreadOnCircle(&bodies)
+
+	newRun := func(theta float64) *Run {
+		var r Run
+		t.Cleanup(r.Close)
+		config := NewRunConfig()
+		config.BN_THETA_Request = theta
+		r.SetConfig(config)
+		r.OutputDir = t.TempDir()
+		r.CaptureGifStep = 1000
+		bodiesCopy := make([]quadtree.Body, len(bodies))
+		copy(bodiesCopy, bodies)
+		r.Init(&bodiesCopy)
+		return &r
+	}
+
+	// reference run, alone in the process
+	reference := newRun(0.5)
+	for i := 0; i < 3; i++ {
+		reference.OneStep()
+	}
+
+	// the same run, with another run that has different parameters
+	r1 := newRun(0.5)
+	r2 := newRun(0.9)
+	done := make(chan bool)
+	for _, r := range []*Run{r1, r2} {
+		go func(r *Run) {
+			for i := 0; i < 3; i++ {
+				r.OneStep()
+			}
+			done <- true
+		}(r)
+	}
+	<-done
+	<-done
+
+	if r1.Config().BN_THETA != 0.5 || r2.Config().BN_THETA != 0.9 {
+		t.Errorf("theta got %f and %f, want 0.5 and 0.9", r1.Config().BN_THETA, r2.Config().BN_THETA)
+	}
+	for idx := range *reference.bodies {
+		if (*reference.bodies)[idx].BodyXY != (*r1.bodies)[idx].BodyXY {
+			t.Fatalf("body %d differs, got %#v, want %#v", idx, (*r1.bodies)[idx].BodyXY, (*reference.bodies)[idx].BodyXY)
+		}
+	}
+}
+
+// test that a run does not change the config it has been given, therefore
+// the same config can be given to many runs
+func TestRunCopiesConfig(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 300)
+	SpreadOnCircleWithRand(&bodies, rand.New(rand.NewSource(1)))
+
+	config := NewRunConfig()
+	config.DtAdjustMode = MANUAL
+	config.BN_THETA_Request = 0.9
+	want := *config
+
+	var r Run
+	defer r.Close()
+	r.SetConfig(config)
+	r.OutputDir = t.TempDir()
+	r.CaptureGifStep = 1000
+	r.Init(&bodies)
+	for i := 0; i < 3; i++ {
+		if err := r.OneStep(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if config.Dt != want.Dt || config.DtAdjustMode != want.DtAdjustMode || config.BN_THETA != want.BN_THETA {
+		t.Errorf("config changed by the run, Dt %e mode %s theta %f, want %e %s %f",
+			config.Dt, config.DtAdjustMode, config.BN_THETA, want.Dt, want.DtAdjustMode, want.BN_THETA)
+	}
+	if r.Config().BN_THETA != 0.9 || r.Config().Dt == want.Dt {
+		t.Errorf("config of the run not updated, theta %f Dt %e", r.Config().BN_THETA, r.Config().Dt)
+	}
+}
+
+// test that the simulation returns its result once the shutdown criteria is met
+func TestRunSimulationCompletes(t *testing.T) {
+	bodies := make([]quadtree.Body, 200)
+	SpreadOnCircle(&bodies)
+
+	var r Run
+	defer r.Close()
+	config := NewRunConfig()
+	config.ShutdownCriteria = 0.5
+	r.SetConfig(config)
+	r.OutputDir = t.TempDir()
+	r.Init(&bodies)
+	r.SetCountry("fra")
+	r.SetState(RUNNING)
+
+	result, err := r.RunSimulation(context.Background())
+	if err != nil {
+		t.Fatalf("RunSimulation: %v", err)
+	}
+	if r.State() != COMPLETED {
+		t.Errorf("state %s, want %s", r.State(), COMPLETED)
+	}
+	if result.Step == 0 || result.Step != r.GetStep() || result.NbBodies != len(bodies) {
+		t.Errorf("result got %#v", result)
+	}
+	if result.Filename == "" {
+		t.Errorf("final configuration was not captured")
+	}
+	select {
+	case <-r.Done():
+	default:
+		t.Errorf("Done() is not closed")
+	}
+}
+
+// test that a paused simulation can be cancelled
+func TestRunSimulationCancel(t *testing.T) {
+	bodies := make([]quadtree.Body, 200)
+	SpreadOnCircle(&bodies)
+
+	var r Run
+	defer r.Close()
+	r.OutputDir = t.TempDir()
+	r.Init(&bodies)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	_, err := r.RunSimulation(ctx)
+	if err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	<-r.Done()
+
+	// the simulation can be started again
+	ctx, cancel = context.WithCancel(context.Background())
+	go cancel()
+	if _, err := r.RunSimulation(ctx); err != context.Canceled {
+		t.Errorf("second run got %v, want %v", err, context.Canceled)
+	}
+	<-r.Done()
+}
+
+// test that two bodies at the same position make the step fail instead of exiting
+func TestZeroDistance(t *testing.T) {
+	bodies := make([]quadtree.Body, 100)
+	SpreadOnCircle(&bodies)
+	bodies[1].BodyXY = bodies[0].BodyXY
+
+	var r Run
+	defer r.Close()
+	r.OutputDir = t.TempDir()
+	r.Init(&bodies)
+	r.SetState(RUNNING)
+
+	if _, err := r.RunSimulation(context.Background()); !errors.Is(err, ErrZeroDistance) {
+		t.Errorf("got %v, want %v", err, ErrZeroDistance)
+	}
+}
+
+// test that with an adaptive quadtree, the barnes hut computation
+// is still close to the exact computation, with fewer computations when bodies are crowded
+//
+// the exact computation is the barnes hut computation with a null theta (that takes mirror bodies into account)
+func TestComputeAccelerationAdaptiveQuadtree(t *testing.T) {
+
+	// half of the bodies are crowded in a small area
+	bodies := make([]quadtree.Body, 20000)
+	SpreadOnCircleWithRand(&bodies, rand.New(rand.NewSource(1)))
+	for idx := 0; idx < len(bodies); idx += 2 {
+		bodies[idx].X = 0.2 + (bodies[idx].X-0.2)*0.01
+		bodies[idx].Y = 0.7 + (bodies[idx].Y-0.7)*0.01
+	}
+
+	nbComputations := make(map[int]uint64)
+	for _, maxLevel := range []int{8, 12} {
+		var r Run
+		defer r.Close()
+		config := NewRunConfig()
+		config.QuadtreeMaxLevel = maxLevel
+		r.SetConfig(config)
+		bodiesCopy := make([]quadtree.Body, len(bodies))
+		copy(bodiesCopy, bodies)
+		r.Init(&bodiesCopy)
+		r.q.CheckIntegrity(t)
+
+		for _, idx := range []int{0, 1, 1000, 1001} {
+			r.config.BN_THETA = 0.0
+			r.computeAccelerationOnBodyBarnesHut(idx)
+			accReference := (*r.bodiesAccel)[idx]
+			r.config.BN_THETA = 0.5
+
+			r.nbComputationPerStep = 0
+			r.computeAccelerationOnBodyBarnesHut(idx)
+			accBH := (*r.bodiesAccel)[idx]
+			nbComputations[maxLevel] += r.nbComputationPerStep
+
+			relativeError := math.Hypot(accReference.X-accBH.X, accReference.Y-accBH.Y) / math.Hypot(accReference.X, accReference.Y)
+			if relativeError > 0.02 {
+				t.Errorf("max level %d, body %d, expected less than %f, got %f", maxLevel, idx, 0.02, relativeError)
+			}
+		}
+	}
+	if nbComputations[12] >= nbComputations[8] {
+		t.Errorf("adaptive quadtree does %d computations, more than the quadtree of depth 8 (%d)", nbComputations[12], nbComputations[8])
+	}
+}
+
+func TestParseBodiesFilename(t *testing.T) {
+
+	cases := []struct {
+		filename, country string
+		nbBodies, step    int
+	}{
+		{"conf-fra-00934136-08725.bods", "fra", 934136, 8725},
+		{"../runtime_server/conf-hti-00190948-00000.bods", "hti", 190948, 0},
+		{"conf-ile-de-france-00010000-00012.bods", "ile-de-france", 10000, 12},
+		{"conf-hti_ouest-00001000-123456.bods", "hti_ouest", 1000, 123456},
+	}
+	for _, c := range cases {
+		country, nbBodies, step, err := ParseBodiesFilename(c.filename)
+		if err != nil || country != c.country || nbBodies != c.nbBodies || step != c.step {
+			t.Errorf("%s: %s %d %d %v, want %s %d %d", c.filename, country, nbBodies, step, err, c.country, c.nbBodies, c.step)
+		}
+	}
+
+	for _, filename := range []string{
+		"conf-fra.coord",
+		"conf-fra-00934136.bods",
+		"conf--00934136-08725.bods",
+		"conf-fra-0093x136-08725.bods",
+		"fra-00934136-08725.bods",
+	} {
+		if _, _, _, err := ParseBodiesFilename(filename); err == nil {
+			t.Errorf("%s: no error", filename)
+		}
+	}
 }
@@ -0,0 +1,139 @@
+package barneshut
+
+import (
+	"math"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// decides how bodies are kept within the [0;1]*[0;1] square
+//
+// The same mode is used by the computation of the repulsion (which images of the bodies are seen by a body),
+// by the update of the positions (what happens when a body crosses the border) and
+// by the rendering of the repulsion field
+type BoundaryModeType string
+
+// Possible values for BoundaryModeType
+const (
+	// bodies are repulsed by the mirror images of the bodies (relative to the borders)
+	// and bounce on the borders
+	MIRROR = "MIRROR"
+
+	// the square is a torus, bodies are repulsed by the bodies translated by one unit
+	// and a body that crosses a border comes back by the opposite border
+	PERIODIC = "PERIODIC"
+
+	// there is no image of the bodies, bodies bounce on the borders
+	HARD_WALL = "HARD_WALL"
+
+	// there is no image of the bodies, a body that reaches the border stops
+	// on it (the quadtree does not cover the outside of the square)
+	OPEN = "OPEN"
+)
+
+// BoundaryModes lists the possible boundary modes
+var BoundaryModes = []BoundaryModeType{MIRROR, PERIODIC, HARD_WALL, OPEN}
+
+// return the range of the images of the bodies that repulse a body
+// the images are from -imageRange to imageRange on both axis (0, 0 being the body itself)
+func (c *RunConfig) imageRange() int {
+	switch c.BoundaryMode {
+	case HARD_WALL, OPEN:
+		return 0
+	}
+	return 1
+}
+
+// compute vector between body A and the image xM, yM of body B
+// according to the boundary mode
+func (c *RunConfig) getVectorBetweenBodies(A, B *quadtree.Body, xM, yM int) (vX, vY float64) {
+	switch c.BoundaryMode {
+	case PERIODIC:
+		return B.X + float64(xM) - A.X, B.Y + float64(yM) - A.Y
+	case HARD_WALL, OPEN:
+		return B.X - A.X, B.Y - A.Y
+	}
+	return getVectorBetweenBodiesWithMirror(A, B, xM, yM)
+}
+
+// compute distance between A and the image xM, yM of B
+func (c *RunConfig) getDistanceBetweenBodies(A, B *quadtree.Body, xM, yM int) float64 {
+
+	xV, yV := c.getVectorBetweenBodies(A, B, xM, yM)
+	return math.Sqrt(xV*xV + yV*yV)
+}
+
+// keep the body within the square after it has moved
+// return true if the body has crossed the border
+func (c *RunConfig) applyBoundary(body *quadtree.Body, vel *Vel) (borderHasBeenMet bool) {
+
+	applyBoundaryOnAxis := func(x, v *float64) {
+		if *x < 1.0 && *x > 0.0 {
+			return
+		}
+		borderHasBeenMet = true
+
+		switch c.BoundaryMode {
+		case PERIODIC:
+			*x -= math.Floor(*x)
+			if *x >= 1.0 {
+				*x = 0.0
+			}
+		case OPEN:
+			if *x >= 1.0 {
+				*x = math.Nextafter(1.0, 0.0)
+			} else {
+				*x = 0.0
+			}
+			*v = 0.0
+		default:
+			if *x >= 1.0 {
+				*x = 1.0 - (*x - 1.0)
+			} else {
+				*x = -*x
+			}
+			// a body exactly on the border stays on it
+			if *x >= 1.0 {
+				*x = math.Nextafter(1.0, 0.0)
+			}
+			*v = -*v
+		}
+	}
+	applyBoundaryOnAxis(&body.X, &vel.X)
+	applyBoundaryOnAxis(&body.Y, &vel.Y)
+
+	return borderHasBeenMet
+}
+
+// a Domain is the part of the square where the bodies can go,
+// for instance the outline of a country (see grump.Mask)
+type Domain interface {
+	Contains(x, y float64) bool
+}
+
+// keep the body within the domain after it has moved from oldX, oldY
+// return true if the body has met the outline of the domain
+//
+// the body bounces on the outline: the move along the axis that crosses the outline
+// is cancelled and the velocity along this axis is reversed. A body that was not within
+// the domain before the move (for instance, a body of a cell at the edge of the country)
+// moves freely until it enters the domain
+func (c *RunConfig) applyDomain(body *quadtree.Body, vel *Vel, oldX, oldY float64) (outlineHasBeenMet bool) {
+
+	if c.Domain == nil || c.Domain.Contains(body.X, body.Y) || !c.Domain.Contains(oldX, oldY) {
+		return false
+	}
+
+	switch {
+	case c.Domain.Contains(oldX, body.Y):
+		body.X = oldX
+		vel.X = -vel.X
+	case c.Domain.Contains(body.X, oldY):
+		body.Y = oldY
+		vel.Y = -vel.Y
+	default:
+		body.X, body.Y = oldX, oldY
+		vel.X, vel.Y = -vel.X, -vel.Y
+	}
+	return true
+}
@@ -0,0 +1,215 @@
+package barneshut
+
+import (
+	"math"
+	"testing"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// init a run with bodies spread on circles and the boundary mode
+func newBoundaryRun(mode BoundaryModeType, nbBodies int) *Run {
+
+	bodies := make([]quadtree.Body, nbBodies)
+	SpreadOnCircle(&bodies)
+
+	var r Run
+	config := NewRunConfig()
+	config.BoundaryMode = mode
+	config.CutoffDistance = 10.0 // no cutoff
+	r.SetConfig(config)
+	r.Init(&bodies)
+	return &r
+}
+
+// test that the barnes hut computation sees the same images of the bodies
+// as the exact computation, whatever the boundary mode
+func TestBoundaryForceConsistency(t *testing.T) {
+
+	for _, mode := range BoundaryModes {
+		r := newBoundaryRun(mode, 500)
+		defer r.Close()
+
+		for _, idx := range []int{0, 1, 7} {
+			r.computeAccelerationOnBody(idx)
+			accReference := (*r.bodiesAccel)[idx]
+			energyReference := (*r.bodiesEnergy)[idx]
+
+			// with a null theta, barnes hut goes down to the bodies
+			r.config.BN_THETA = 0.0
+			r.computeAccelerationOnBodyBarnesHut(idx)
+			r.config.BN_THETA = 0.5
+			acc := (*r.bodiesAccel)[idx]
+
+			diff := math.Hypot(accReference.X-acc.X, accReference.Y-acc.Y) / math.Hypot(accReference.X, accReference.Y)
+			if diff > 1e-9 {
+				t.Errorf("%s body %d, acceleration got %#v, want %#v", mode, idx, acc, accReference)
+			}
+			if e := (*r.bodiesEnergy)[idx]; math.Abs(e-energyReference) > 1e-9*energyReference {
+				t.Errorf("%s body %d, energy got %e, want %e", mode, idx, e, energyReference)
+			}
+		}
+	}
+}
+
+// test that the rendered field is the potential of the force applied to bodies
+func TestBoundaryFieldConsistency(t *testing.T) {
+
+	for _, mode := range BoundaryModes {
+		r := newBoundaryRun(mode, 200)
+		defer r.Close()
+		r.config.BN_THETA = 0.0
+
+		field := func(x, y float64) float64 {
+			f := NewRepulsionField(x, y, x, y, 1, &r.q, 0.0, r.config)
+			f.ComputeField()
+			return f.values[0][0]
+		}
+
+		// a probe body of unit mass near the border
+		var probe quadtree.Body
+		probe.X, probe.Y, probe.M = 0.05, 0.5, 1.0
+
+		var accX, accY, energy float64
+		n := r.config.imageRange()
+		for xM := -n; xM <= n; xM++ {
+			for yM := -n; yM <= n; yM++ {
+				for idx := range *r.bodies {
+					x, y, e := r.config.getRepulsionVector(&probe, &(*r.bodies)[idx], xM, yM)
+					accX += x
+					accY += y
+					energy += e
+				}
+			}
+		}
+
+		// the field is the energy of the probe
+		if v := field(probe.X, probe.Y); math.Abs(v-energy) > 1e-9*energy {
+			t.Errorf("%s field got %e, want %e", mode, v, energy)
+		}
+
+		// the force is minus the gradient of the field
+		h := 1e-6
+		gradX := (field(probe.X+h, probe.Y) - field(probe.X-h, probe.Y)) / (2 * h)
+		gradY := (field(probe.X, probe.Y+h) - field(probe.X, probe.Y-h)) / (2 * h)
+		if math.Hypot(accX+gradX, accY+gradY) > 1e-4*math.Hypot(accX, accY) {
+			t.Errorf("%s force got %e %e, want %e %e", mode, accX, accY, -gradX, -gradY)
+		}
+	}
+}
+
+// test that the repulsion across the border is consistent with
+// what happens to a body crossing the border
+func TestBoundaryPosition(t *testing.T) {
+
+	cases := []struct {
+		mode        BoundaryModeType
+		wantX, velX float64
+	}{
+		{MIRROR, 0.99, -1.0},
+		{HARD_WALL, 0.99, -1.0},
+		{PERIODIC, 0.01, 1.0},
+		{OPEN, math.Nextafter(1.0, 0.0), 0.0},
+	}
+	for _, c := range cases {
+		config := NewRunConfig()
+		config.BoundaryMode = c.mode
+
+		body := quadtree.Body{BodyXY: quadtree.BodyXY{X: 1.01, Y: 0.5}}
+		vel := Vel{X: 1.0}
+		if !config.applyBoundary(&body, &vel) {
+			t.Errorf("%s border has not been met", c.mode)
+		}
+		if math.Abs(body.X-c.wantX) > 1e-12 || vel.X != c.velX || body.Y != 0.5 {
+			t.Errorf("%s got x %f vel %f, want x %f vel %f", c.mode, body.X, vel.X, c.wantX, c.velX)
+		}
+	}
+
+	// a body that lands exactly on the border is kept within the square
+	for _, mode := range BoundaryModes {
+		config := NewRunConfig()
+		config.BoundaryMode = mode
+		body := quadtree.Body{BodyXY: quadtree.BodyXY{X: 0.5, Y: 1.0}}
+		vel := Vel{Y: 1.0}
+		config.applyBoundary(&body, &vel)
+		if body.Y >= 1.0 || body.Y < 0.0 {
+			t.Errorf("%s got y %f", mode, body.Y)
+		}
+	}
+
+	// with a periodic boundary, a body close to the west border
+	// is repulsed by a body close to the east border
+	A := quadtree.Body{BodyXY: quadtree.BodyXY{X: 0.01, Y: 0.5}, M: 1.0}
+	B := quadtree.Body{BodyXY: quadtree.BodyXY{X: 0.99, Y: 0.5}, M: 1.0}
+	for _, mode := range BoundaryModes {
+		config := NewRunConfig()
+		config.BoundaryMode = mode
+		var accX float64
+		n := config.imageRange()
+		for xM := -n; xM <= n; xM++ {
+			for yM := -n; yM <= n; yM++ {
+				x, _, _ := config.getRepulsionVector(&A, &B, xM, yM)
+				accX += x
+			}
+		}
+		if mode == PERIODIC && accX <= 0.0 {
+			t.Errorf("%s, body is not pushed east (%e)", mode, accX)
+		}
+		if mode != PERIODIC && accX >= 0.0 {
+			t.Errorf("%s, body is not pushed west (%e)", mode, accX)
+		}
+	}
+}
+
+// a disk domain in the middle of the square
+type diskDomain struct{}
+
+func (d diskDomain) Contains(x, y float64) bool {
+	return math.Hypot(x-0.5, y-0.5) < 0.3
+}
+
+// test that bodies bounce on the outline of the domain
+func TestDomain(t *testing.T) {
+
+	config := NewRunConfig()
+	config.Domain = diskDomain{}
+
+	// a body moving east across the outline bounces back
+	body := quadtree.Body{BodyXY: quadtree.BodyXY{X: 0.81, Y: 0.5}}
+	vel := Vel{X: 1.0, Y: 0.5}
+	if !config.applyDomain(&body, &vel, 0.79, 0.49) {
+		t.Errorf("outline has not been met")
+	}
+	if body.X != 0.79 || body.Y != 0.5 || vel.X != -1.0 || vel.Y != 0.5 {
+		t.Errorf("got %#v %#v", body.BodyXY, vel)
+	}
+
+	// a body outside the domain moves freely
+	body = quadtree.Body{BodyXY: quadtree.BodyXY{X: 0.95, Y: 0.5}}
+	if config.applyDomain(&body, &vel, 0.96, 0.5) || body.X != 0.95 {
+		t.Errorf("body outside the domain has been moved to %#v", body.BodyXY)
+	}
+
+	// bodies of a run stay within the domain
+	bodies := make([]quadtree.Body, 300)
+	SpreadOnCircle(&bodies)
+	for idx := range bodies {
+		bodies[idx].X = 0.5 + (bodies[idx].X-0.5)*0.5
+		bodies[idx].Y = 0.5 + (bodies[idx].Y-0.5)*0.5
+	}
+	var r Run
+	defer r.Close()
+	r.SetConfig(config)
+	r.OutputDir = t.TempDir()
+	r.Init(&bodies)
+	for step := 0; step < 20; step++ {
+		if err := r.OneStep(); err != nil {
+			t.Fatalf("step %d: %v", step, err)
+		}
+	}
+	for idx, b := range bodies {
+		if !config.Domain.Contains(b.X, b.Y) {
+			t.Errorf("body %d out of the domain at %f %f", idx, b.X, b.Y)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package barneshut
 import (
 	"math"
 	"math/rand"
-	"sync/atomic"
 
 	"github.com/thomaspeugeot/tkv/quadtree"
 )
@@ -54,12 +53,24 @@ func getModuloDistanceLocal(alpha, beta, left, rigth float64) (dist float64) {
 // function used to spread bodies randomly on
 // the unit square
 func SpreadOnCircle(bodies *[]quadtree.Body) {
+	SpreadOnCircleWithRand(bodies, nil)
+}
+
+// same as SpreadOnCircle with positions drawn from rng
+// (from the global source of math/rand if rng is nil)
+//
+// the same seed gives the same bodies
+func SpreadOnCircleWithRand(bodies *[]quadtree.Body, rng *rand.Rand) {
+	uniform := rand.Float64
+	if rng != nil {
+		uniform = rng.Float64
+	}
 	for idx := range *bodies {
 
 		body := &((*bodies)[idx])
 
-		radius := rand.Float64()
-		angle := 2.0 * math.Pi * rand.Float64()
+		radius := uniform()
+		angle := 2.0 * math.Pi * uniform()
 
 		if idx%2 == 0 {
 			body.X = 0.2
@@ -77,17 +88,6 @@ func SpreadOnCircle(bodies *[]quadtree.Body) {
 	}
 }
 
-// compute modulo distance
-func getModuloDistanceBetweenBodies(A, B *quadtree.Body) float64 {
-
-	x := getModuloDistance(B.X, A.X)
-	y := getModuloDistance(B.Y, A.Y)
-
-	distSquared := (x*x + y*y)
-
-	return math.Sqrt(distSquared)
-}
-
 // compute mirror vector berween bodies A & B
 //
 // x == -1, B's x position is mirrored relative to x=0
@@ -130,36 +130,41 @@ func getDistanceBetweenBodiesWithMirror(A, B *quadtree.Body, xM, yM int) float64
 	return res
 }
 
-// compute repulsion force vector between body A and body B
+// return the kernel of the run
+func (c *RunConfig) kernel() Kernel {
+	if c.Kernel == nil {
+		return InverseSquare{}
+	}
+	return c.Kernel
+}
+
+// compute repulsion force vector between body A and the image xM, yM of body B
 // applied to body A
-// proportional to the inverse of the distance squared
+// the norm of the force is given by the kernel of the run
 // return x, y of repulsion vector and distance between A & B
 // return energy as the repulsion energy
-func getRepulsionVector(A, B *quadtree.Body, xM, yM int) (x, y, energy float64) {
-
-	atomic.AddUint64(&nbComputationPerStep, 1)
+func (c *RunConfig) getRepulsionVector(A, B *quadtree.Body, xM, yM int) (x, y, energy float64) {
 
 	// Trace.Printf("getRepulsionVector A %f %f B %f %f", A.X, A.Y, B.X, B.Y)
 
-	x, y = getVectorBetweenBodiesWithMirror(A, B, xM, yM)
+	x, y = c.getVectorBetweenBodies(A, B, xM, yM)
 
 	distQuared := (x*x + y*y)
 	absDistance := math.Sqrt(distQuared + ETA)
 
-	distPow3 := distQuared * absDistance
+	kernel := c.kernel()
 
 	// repulsion is proportional to mass
 	massCombined := A.M * B.M
-	x *= massCombined
-	y *= massCombined
+	force := massCombined * kernel.Force(absDistance)
 
-	// repulsion is inversly proportional to the square of the distance (1/r2)
-	x = -x / distPow3
-	y = -y / distPow3
+	// repulsion is in the direction opposite to B
+	x = -x * force / absDistance
+	y = -y * force / absDistance
 
-	if absDistance > CutoffDistance {
+	if absDistance > c.CutoffDistance {
 		x = 0.0
 		y = 0.0
 	}
-	return x, y, massCombined / absDistance
+	return x, y, massCombined * kernel.Energy(absDistance)
 }
@@ -0,0 +1,222 @@
+package barneshut
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// if true, the dual tree algorithm is used instead of Barnes-Hut
+//
+// Barnes-Hut walks the quadtree once per body and per image of the bodies. The dual tree
+// algorithm walks the quadtree once per node: when a node T is far enough from a node S (compared to BN_THETA),
+// the repulsion of S is computed once for all the bodies of T. It is accumulated into a first order
+// local expansion (value, gradient and jacobian at the COM of T) that is passed down to the
+// nodes below T and finally evaluated at each body of T. Only the nodes that are too close
+// to each others are walked down to the bodies.
+var UseDualTree bool = false
+
+// level of the quadtree at which the dual tree traversal is split among the concurrent routines
+const dualTreeSplitLevel = 3
+
+// relative step used to compute the derivative of the kernel force
+const kernelDerivativeStep = 1e-5
+
+// a source node of the dual tree traversal, the image xM, yM of the node at coord
+type dualTreeSource struct {
+	coord  quadtree.Coord
+	xM, yM int
+}
+
+// first order local expansion of the repulsion around a point
+//
+// the repulsion is given for a unit mass: energy is the potential, acc is the acceleration (minus the gradient
+// of the potential) and jXX, jXY, jYY is the jacobian of the acceleration
+type localExpansion struct {
+	energy        float64
+	accX, accY    float64
+	jXX, jXY, jYY float64
+}
+
+// add the repulsion of a mass m located at vector dX, dY of the center of the expansion
+func (l *localExpansion) add(c *RunConfig, dX, dY, m float64) {
+
+	r := math.Sqrt(dX*dX + dY*dY + ETA)
+	kernel := c.kernel()
+
+	l.energy += m * kernel.Energy(r)
+	if r > c.CutoffDistance {
+		return
+	}
+
+	f := kernel.Force(r)
+	h := r * kernelDerivativeStep
+	fPrime := (kernel.Force(r+h) - kernel.Force(r-h)) / (2.0 * h)
+
+	// the repulsion is in the direction opposite to the mass
+	uX, uY := dX/r, dY/r
+	l.accX -= m * f * uX
+	l.accY -= m * f * uY
+
+	// jacobian is m * ( f/r I + (f' - f/r) u uT )
+	l.jXX += m * (f/r + (fPrime-f/r)*uX*uX)
+	l.jXY += m * (fPrime - f/r) * uX * uY
+	l.jYY += m * (f/r + (fPrime-f/r)*uY*uY)
+}
+
+// return the expansion around the point at vector dX, dY of the center of the expansion
+func (l localExpansion) shift(dX, dY float64) localExpansion {
+
+	jdX := l.jXX*dX + l.jXY*dY
+	jdY := l.jXY*dX + l.jYY*dY
+
+	l.energy -= l.accX*dX + l.accY*dY + 0.5*(dX*jdX+dY*jdY)
+	l.accX += jdX
+	l.accY += jdY
+	return l
+}
+
+// compute repulsive forces on all bodies with the dual tree algorithm
+//
+// the nodes at dualTreeSplitLevel are shared among the nbRoutine workers of the run
+// return the minimal distance between bodies
+func (r *Run) ComputeRepulsiveForceDualTree(nbRoutine int) float64 {
+
+	Trace.Println("ComputeRepulsiveForceDualTree")
+
+	// the root node and its images are the sources of every node
+	var rootCoord quadtree.Coord
+	var sources []dualTreeSource
+	n := r.config.imageRange()
+	for xM := -n; xM <= n; xM++ {
+		for yM := -n; yM <= n; yM++ {
+			sources = append(sources, dualTreeSource{rootCoord, xM, yM})
+		}
+	}
+
+	// the nodes at dualTreeSplitLevel are handed to the workers
+	nbTargets := 1 << uint(dualTreeSplitLevel)
+	targets := make([]quadtree.Coord, 0, nbTargets*nbTargets)
+	for i := 0; i < nbTargets; i++ {
+		for j := 0; j < nbTargets; j++ {
+			targets = append(targets, quadtree.GetCoord(dualTreeSplitLevel, i, j))
+		}
+	}
+
+	return r.workers(nbRoutine).run(len(targets), func(idx int) float64 {
+		return r.computeAccelerationDualTreeRecursive(targets[idx], sources, localExpansion{})
+	})
+}
+
+// compute the repulsion of the sources on the bodies of the target node at coord
+//
+// local is the expansion of the repulsion of the nodes that are far from the node
+// return the min distance between the bodies of the node and the bodies of the sources
+func (r *Run) computeAccelerationDualTreeRecursive(coord quadtree.Coord, sources []dualTreeSource,
+	local localExpansion) float64 {
+
+	target := r.q.Node(coord)
+
+	// avoid node with zero mass
+	if target.M == 0 {
+		return 2.0
+	}
+	targetSize := math.Ldexp(1.0, -coord.Level()) // 1.0 at level 0
+
+	// sources that are too close to the target are kept for the nodes below
+	var near []dualTreeSource
+	stack := append([]dualTreeSource(nil), sources...)
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		source := r.q.Node(s.coord)
+		if source.M == 0 {
+			continue
+		}
+		sourceSize := math.Ldexp(1.0, -s.coord.Level())
+
+		dX, dY := r.config.getVectorBetweenBodies(&target.Body, &source.Body, s.xM, s.yM)
+		dist := math.Sqrt(dX*dX + dY*dY)
+
+		switch {
+		case targetSize+sourceSize < r.config.BN_THETA*dist:
+			atomic.AddUint64(&r.nbComputationPerStep, 1)
+			local.add(r.config, dX, dY, source.M)
+		case !source.IsLeaf() && (target.IsLeaf() || sourceSize >= targetSize):
+			coordNW, coordNE, coordSW, coordSE := quadtree.NodesBelow(s.coord)
+			stack = append(stack,
+				dualTreeSource{coordNW, s.xM, s.yM},
+				dualTreeSource{coordNE, s.xM, s.yM},
+				dualTreeSource{coordSW, s.xM, s.yM},
+				dualTreeSource{coordSE, s.xM, s.yM})
+		default:
+			near = append(near, s)
+		}
+	}
+
+	minInterbodyDistance := 2.0
+	if !target.IsLeaf() {
+		coordNW, coordNE, coordSW, coordSE := quadtree.NodesBelow(coord)
+		for _, coordBelow := range []quadtree.Coord{coordNW, coordNE, coordSW, coordSE} {
+			below := r.q.Node(coordBelow)
+			localBelow := local.shift(below.X-target.X, below.Y-target.Y)
+			dist := r.computeAccelerationDualTreeRecursive(coordBelow, near, localBelow)
+			if dist < minInterbodyDistance {
+				minInterbodyDistance = dist
+			}
+		}
+		return minInterbodyDistance
+	}
+
+	// the target is a leaf, all near sources are leaves
+	for body := target.First(); body != nil; body = body.Next() {
+		idx := r.bodyIndex(body)
+
+		// far sources
+		l := local.shift(body.X-target.X, body.Y-target.Y)
+		acc := &((*r.bodiesAccel)[idx])
+		acc.X = body.M * l.accX
+		acc.Y = body.M * l.accY
+		energy := &((*r.bodiesEnergy)[idx])
+		*energy = body.M * l.energy
+
+		// near sources
+		for _, s := range near {
+			for b := r.q.Node(s.coord).First(); b != nil; b = b.Next() {
+				if b == body {
+					continue
+				}
+				dist := r.config.getDistanceBetweenBodies(body, b, s.xM, s.yM)
+
+				r.bodiesNeighbours.Insert(idx, b, dist)
+
+				if dist == 0.0 {
+					r.setStepError(fmt.Errorf("%w: body %d at x %f y %f", ErrZeroDistance, idx, body.X, body.Y))
+					continue
+				}
+				if dist < minInterbodyDistance {
+					minInterbodyDistance = dist
+				}
+
+				atomic.AddUint64(&r.nbComputationPerStep, 1)
+				x, y, e := r.config.getRepulsionVector(body, b, s.xM, s.yM)
+				acc.X += x
+				acc.Y += y
+				*energy += e
+			}
+		}
+	}
+	return minInterbodyDistance
+}
+
+// index in the bodies of the run of a body found through the quadtree
+//
+// the quadtree links the bodies in place, the index is the offset of the body in the slice
+func (r *Run) bodyIndex(body *quadtree.Body) int {
+	first := &((*r.bodies)[0])
+	return int((uintptr(unsafe.Pointer(body)) - uintptr(unsafe.Pointer(first))) / unsafe.Sizeof(*first))
+}
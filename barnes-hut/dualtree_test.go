@@ -0,0 +1,107 @@
+package barneshut
+
+import (
+	"math"
+	"testing"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// relative rms error of the accelerations and max relative error of the energies
+// computed by the dual tree algorithm, compared to the brute force computation
+func dualTreeErrors(r *Run) (accError, energyError float64) {
+
+	nbBodies := len(*r.bodies)
+	accReference := make([]Acc, nbBodies)
+	energyReference := make([]float64, nbBodies)
+	for idx := range *r.bodies {
+		r.computeAccelerationOnBody(idx)
+		accReference[idx] = (*r.bodiesAccel)[idx]
+		energyReference[idx] = (*r.bodiesEnergy)[idx]
+	}
+
+	r.ComputeRepulsiveForceDualTree(4)
+
+	var diff2, norm2 float64
+	for idx := range *r.bodies {
+		acc := (*r.bodiesAccel)[idx]
+		diff2 += (acc.X-accReference[idx].X)*(acc.X-accReference[idx].X) + (acc.Y-accReference[idx].Y)*(acc.Y-accReference[idx].Y)
+		norm2 += accReference[idx].X*accReference[idx].X + accReference[idx].Y*accReference[idx].Y
+
+		e := math.Abs((*r.bodiesEnergy)[idx]-energyReference[idx]) / energyReference[idx]
+		if e > energyError {
+			energyError = e
+		}
+	}
+	return math.Sqrt(diff2 / norm2), energyError
+}
+
+// test the dual tree algorithm against the brute force computation
+func TestDualTreeAccuracy(t *testing.T) {
+
+	for _, mode := range BoundaryModes {
+		// with a null theta, all the bodies are computed directly
+		r := newBoundaryRun(mode, 200)
+		defer r.Close()
+		r.config.BN_THETA = 0.0
+		if accError, energyError := dualTreeErrors(r); accError > 1e-9 || energyError > 1e-9 {
+			t.Errorf("%s theta 0, acceleration error %e, energy error %e", mode, accError, energyError)
+		}
+
+		r = newBoundaryRun(mode, 1000)
+		defer r.Close()
+		r.config.BN_THETA = 0.5
+		if accError, energyError := dualTreeErrors(r); accError > 1e-2 || energyError > 1e-2 {
+			t.Errorf("%s theta 0.5, acceleration error %e, energy error %e", mode, accError, energyError)
+		}
+	}
+}
+
+// test the dual tree algorithm with a quadtree deeper than 8 and other kernels
+func TestDualTreeAccuracyAdaptive(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 1000)
+	SpreadOnCircle(&bodies)
+	for idx := 0; idx < len(bodies); idx += 3 {
+		bodies[idx].X = 0.5 + (bodies[idx].X-0.5)*0.001
+		bodies[idx].Y = 0.5 + (bodies[idx].Y-0.5)*0.001
+	}
+
+	for _, k := range []Kernel{InverseSquare{}, Inverse{}, Plummer{Epsilon: 0.01}} {
+		var r Run
+		defer r.Close()
+		config := NewRunConfig()
+		config.Kernel = k
+		config.QuadtreeMaxLevel = 12
+		config.QuadtreeLeafCapacity = 8
+		config.CutoffDistance = 10.0 // no cutoff
+		r.SetConfig(config)
+		r.Init(&bodies)
+
+		if accError, energyError := dualTreeErrors(&r); accError > 1e-2 || energyError > 1e-2 {
+			t.Errorf("%s, acceleration error %e, energy error %e", k.Name(), accError, energyError)
+		}
+	}
+}
+
+// test that the dual tree algorithm is selected by UseDualTree
+func TestUseDualTree(t *testing.T) {
+
+	r := newBoundaryRun(MIRROR, 500)
+	defer r.Close()
+	r.ComputeRepulsiveForceConcurrent(4)
+	minDistanceBarnesHut := r.minInterBodyDistance
+	nbComputationBarnesHut := r.nbComputationPerStep
+
+	UseDualTree = true
+	defer func() { UseDualTree = false }()
+
+	r.nbComputationPerStep = 0
+	r.ComputeRepulsiveForceConcurrent(4)
+	if r.minInterBodyDistance != minDistanceBarnesHut {
+		t.Errorf("min distance got %e, want %e", r.minInterBodyDistance, minDistanceBarnesHut)
+	}
+	if r.nbComputationPerStep == 0 || r.nbComputationPerStep >= nbComputationBarnesHut {
+		t.Errorf("nb of computations got %d, barnes hut %d", r.nbComputationPerStep, nbComputationBarnesHut)
+	}
+}
@@ -0,0 +1,117 @@
+package barneshut
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/thomaspeugeot/tkv/bods"
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// go test -run Golden -update rewrites the golden body files of testdata
+var update = flag.Bool("update", false, "update the golden body files")
+
+// run 10 steps of the integrator on seeded bodies with nbRoutines routines
+// return the encoded final configuration
+func goldenRun(t *testing.T, i Integrator, nbRoutines int) []byte {
+
+	bodies := make([]quadtree.Body, 300)
+	SpreadOnCircleWithRand(&bodies, rand.New(rand.NewSource(42)))
+
+	var r Run
+	defer r.Close()
+	config := NewRunConfig()
+	config.Integrator = i
+	config.ConcurrentRoutines = nbRoutines
+	config.StopCriteria = AnyOf{MaxSteps{Steps: 10}}
+	r.SetConfig(config)
+	r.OutputDir = t.TempDir()
+	r.CaptureGifStep = 0
+	r.Init(&bodies)
+	r.SetState(RUNNING)
+	if _, err := r.RunSimulation(context.Background()); err != nil {
+		t.Fatalf("%s: %v", i.Name(), err)
+	}
+
+	var buf bytes.Buffer
+	if err := bods.NewEncoder(&buf).Encode("tst", r.GetStep(), bodies); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// compare got with the golden file, report the first body that differs
+func compareGolden(t *testing.T, filename string, got []byte) {
+
+	if *update {
+		if err := os.WriteFile(filename, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%v (run go test -run Golden -update to create it)", err)
+	}
+	if bytes.Equal(got, want) {
+		return
+	}
+	_, gotBodies, errGot := bods.ReadBodies(bytes.NewReader(got))
+	_, wantBodies, errWant := bods.ReadBodies(bytes.NewReader(want))
+	if errGot != nil || errWant != nil || len(gotBodies) != len(wantBodies) {
+		t.Fatalf("%s: configurations differ (%v, %v)", filename, errGot, errWant)
+	}
+	for idx := range gotBodies {
+		if gotBodies[idx] != wantBodies[idx] {
+			t.Fatalf("%s: body %d got x %v y %v, want x %v y %v", filename, idx,
+				gotBodies[idx].X, gotBodies[idx].Y, wantBodies[idx].X, wantBodies[idx].Y)
+		}
+	}
+	t.Fatalf("%s: configurations differ", filename)
+}
+
+// test that the simulation is bit for bit reproducible, whatever the nb of routines
+//
+// golden files are produced on amd64, other architectures may fuse multiply and add
+// and give different roundings
+func TestGolden(t *testing.T) {
+
+	for _, name := range IntegratorNames {
+		i, _ := IntegratorFromName(name)
+		got := goldenRun(t, i, 1)
+		if other := goldenRun(t, i, 13); !bytes.Equal(got, other) {
+			t.Errorf("%s: 1 and 13 routines give different configurations", name)
+		}
+		compareGolden(t, fmt.Sprintf("testdata/golden-%s.bods", name), got)
+	}
+}
+
+func TestGoldenDualTree(t *testing.T) {
+
+	UseDualTree = true
+	defer func() { UseDualTree = false }()
+
+	got := goldenRun(t, Euler{}, 1)
+	if other := goldenRun(t, Euler{}, 13); !bytes.Equal(got, other) {
+		t.Errorf("1 and 13 routines give different configurations")
+	}
+	compareGolden(t, "testdata/golden-dualTree.bods", got)
+}
+
+func TestSpreadOnCircleWithRand(t *testing.T) {
+
+	a := make([]quadtree.Body, 100)
+	b := make([]quadtree.Body, 100)
+	SpreadOnCircleWithRand(&a, rand.New(rand.NewSource(7)))
+	SpreadOnCircleWithRand(&b, rand.New(rand.NewSource(7)))
+	for idx := range a {
+		if a[idx] != b[idx] {
+			t.Fatalf("body %d got %v and %v", idx, a[idx].BodyXY, b[idx].BodyXY)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package barneshut
+
+import (
+	"fmt"
+	"math"
+)
+
+// an Integrator moves the bodies according to the repulsion
+//
+// Step is called once per step, after the computation of the accelerations of the bodies
+// at their current positions. It decides Dt (from dtOptim, see computeDtOptim, unless
+// Dt is set manualy), updates the velocities and, if updatePosition is true, the positions.
+//
+// Integrators hold their parameters only, the state of the integration is stored in the run.
+type Integrator interface {
+	Name() string
+	Step(r *Run, updatePosition bool)
+}
+
+// Euler is the historical integrator, an explicit Euler step with drag (SpeedDragFactor)
+// and capping of the displacement (MaxDisplacement)
+type Euler struct{}
+
+func (i Euler) Name() string { return "euler" }
+func (i Euler) Step(r *Run, updatePosition bool) {
+
+	r.adjustDt(r.computeDtOptim())
+	r.UpdateVelocity()
+	if updatePosition {
+		r.UpdatePosition()
+	}
+}
+
+// Leapfrog is the kick-drift leapfrog, velocities are computed at the middle of the steps
+//
+// since Dt changes at each step, the kick is done with the mean of the previous Dt and the current Dt.
+// Velocities are damped by SpeedDragFactor, as with Euler, otherwise the bodies would oscillate forever
+type Leapfrog struct{}
+
+func (i Leapfrog) Name() string { return "leapfrog" }
+func (i Leapfrog) Step(r *Run, updatePosition bool) {
+
+	dtPrevious := r.config.Dt
+	r.adjustDt(r.computeDtOptim())
+
+	// the first kick goes from the start to the middle of the first step
+	kickDt := r.config.Dt / 2.0
+	if r.step > 0 {
+		kickDt = (dtPrevious + r.config.Dt) / 2.0
+	}
+	r.dampVelocity(r.config.SpeedDragFactor)
+	r.kick(kickDt)
+	r.capVelocity()
+
+	if updatePosition {
+		r.UpdatePosition()
+	}
+}
+
+// VelocityVerlet is the kick-drift-kick integrator, velocities are computed at the same time
+// as the positions
+//
+// The second kick of a step needs the accelerations at the new positions, it is therefore done
+// at the beginning of the next step. With a constant Dt, positions are the same as with Leapfrog.
+// Velocities are damped by SpeedDragFactor, as with Euler
+type VelocityVerlet struct{}
+
+func (i VelocityVerlet) Name() string { return "velocityVerlet" }
+func (i VelocityVerlet) Step(r *Run, updatePosition bool) {
+
+	// second half kick of the previous step
+	if r.step > 0 {
+		r.kick(r.config.Dt / 2.0)
+	}
+
+	r.adjustDt(r.computeDtOptim())
+
+	// first half kick of the current step
+	r.dampVelocity(r.config.SpeedDragFactor)
+	r.kick(r.config.Dt / 2.0)
+	r.capVelocity()
+
+	if updatePosition {
+		r.UpdatePosition()
+	}
+}
+
+// FIRE is the Fast Inertial Relaxation Engine (Bitzek et al., 2006), a minimiser of the energy
+//
+// the velocities are mixed with the direction of the forces. As long as the bodies go
+// downhill (the power of the forces is positive), Dt increases and the mixing decreases.
+// When the bodies go uphill, they are stopped and Dt decreases. There is no drag (SpeedDragFactor is not used).
+// Zero values of the parameters are replaced by the values of the paper.
+type FIRE struct {
+	NMin       int     // nb of downhill steps before Dt increases (5)
+	FInc       float64 // increase of Dt (1.1)
+	FDec       float64 // decrease of Dt (0.5)
+	AlphaStart float64 // initial mixing (0.1)
+	FAlpha     float64 // decrease of the mixing (0.99)
+	DtMaxRatio float64 // max Dt, relative to dtOptim (10)
+}
+
+func (i FIRE) Name() string { return "fire" }
+
+// parameters of FIRE with the default values
+func (i FIRE) withDefaults() FIRE {
+	if i.NMin == 0 {
+		i.NMin = 5
+	}
+	if i.FInc == 0.0 {
+		i.FInc = 1.1
+	}
+	if i.FDec == 0.0 {
+		i.FDec = 0.5
+	}
+	if i.AlphaStart == 0.0 {
+		i.AlphaStart = 0.1
+	}
+	if i.FAlpha == 0.0 {
+		i.FAlpha = 0.99
+	}
+	if i.DtMaxRatio == 0.0 {
+		i.DtMaxRatio = 10.0
+	}
+	return i
+}
+
+func (i FIRE) Step(r *Run, updatePosition bool) {
+
+	p := i.withDefaults()
+	dtOptim := r.computeDtOptim()
+
+	// start of the relaxation
+	if r.fireAlpha == 0.0 {
+		r.fireAlpha = p.AlphaStart
+		r.fireNbDownhill = 0
+		r.adjustDt(dtOptim)
+	}
+
+	// power of the forces, norm of the velocities and of the forces
+	var power, velNorm2, accNorm2 float64
+	for idx := range *r.bodies {
+		vel := r.getVel(idx)
+		acc := r.getAcc(idx)
+		power += acc.X*vel.X + acc.Y*vel.Y
+		velNorm2 += vel.X*vel.X + vel.Y*vel.Y
+		accNorm2 += acc.X*acc.X + acc.Y*acc.Y
+	}
+
+	dt := r.config.Dt
+	if power > 0.0 {
+		// mix velocities with the direction of the forces
+		mix := 0.0
+		if accNorm2 > 0.0 {
+			mix = r.fireAlpha * math.Sqrt(velNorm2/accNorm2)
+		}
+		for idx := range *r.bodies {
+			vel := r.getVel(idx)
+			acc := r.getAcc(idx)
+			vel.X = (1.0-r.fireAlpha)*vel.X + mix*acc.X
+			vel.Y = (1.0-r.fireAlpha)*vel.Y + mix*acc.Y
+		}
+		r.fireNbDownhill++
+		if r.fireNbDownhill > p.NMin {
+			dt = math.Min(dt*p.FInc, p.DtMaxRatio*dtOptim)
+			r.fireAlpha *= p.FAlpha
+		}
+	} else {
+		// uphill, stop and restart carefully
+		r.dampVelocity(0.0)
+		dt *= p.FDec
+		r.fireAlpha = p.AlphaStart
+		r.fireNbDownhill = 0
+	}
+	r.adjustDt(dt)
+
+	r.kick(r.config.Dt)
+	r.capVelocity()
+
+	if updatePosition {
+		r.UpdatePosition()
+	}
+}
+
+// compute dtOptim, the Dt where the displacement of the body
+// with the highest acceleration is MaxRatioDisplacement of the minimum distance between bodies
+//
+// with initial speed at 0, the speed will increase to Dt*Acc and
+// the displacement will be Dx = Dt*Dt*Acc.
+func (r *Run) computeDtOptim() float64 {
+	r.dtOptim = math.Sqrt(MaxRatioDisplacement * r.minInterBodyDistance / r.maxRepulsiveForce.Norm)
+	return r.dtOptim
+}
+
+// update Dt according to request or according to dt computed by the integrator
+func (r *Run) adjustDt(dt float64) {
+	if r.config.DtAdjustMode == MANUAL {
+		r.config.Dt = r.config.DtRequest
+		return
+	}
+	if dt > 0.0 {
+		r.config.Dt = dt
+	}
+}
+
+// multiply the velocities by factor
+func (r *Run) dampVelocity(factor float64) {
+	for idx := range *r.bodies {
+		vel := r.getVel(idx)
+		vel.X *= factor
+		vel.Y *= factor
+	}
+}
+
+// update the velocities with the accelerations during dt
+func (r *Run) kick(dt float64) {
+	for idx := range *r.bodies {
+		vel := r.getVel(idx)
+		acc := r.getAcc(idx)
+		vel.X += acc.X * dt
+		vel.Y += acc.Y * dt
+	}
+}
+
+// cap the velocities in order to keep the displacement of a step below MaxDisplacement
+// and compute maxVelocity
+func (r *Run) capVelocity() {
+
+	var nbVelCapping int64
+	for idx := range *r.bodies {
+		vel := r.getVel(idx)
+
+		velocity := math.Sqrt(vel.X*vel.X + vel.Y*vel.Y)
+		if velocity > r.maxVelocity {
+			r.maxVelocity = velocity
+		}
+
+		if velocity*r.config.Dt > MaxDisplacement {
+			vel.X *= MaxDisplacement / (velocity * r.config.Dt)
+			vel.Y *= MaxDisplacement / (velocity * r.config.Dt)
+			nbVelCapping += 1
+		}
+	}
+	r.ratioOfBodiesWithCapVel = float64(nbVelCapping) / float64(len(*r.bodies))
+}
+
+// return the integrator of the run
+func (c *RunConfig) integrator() Integrator {
+	if c.Integrator == nil {
+		return Euler{}
+	}
+	return c.Integrator
+}
+
+// IntegratorNames lists the names accepted by IntegratorFromName
+var IntegratorNames = []string{"euler", "leapfrog", "velocityVerlet", "fire", "lbfgs"}
+
+// IntegratorFromName returns the integrator of name name (with default parameters)
+func IntegratorFromName(name string) (Integrator, error) {
+
+	switch name {
+	case "euler":
+		return Euler{}, nil
+	case "leapfrog":
+		return Leapfrog{}, nil
+	case "velocityVerlet":
+		return VelocityVerlet{}, nil
+	case "fire":
+		return FIRE{}, nil
+	case "lbfgs":
+		return LBFGS{}, nil
+	}
+	return nil, fmt.Errorf("unknown integrator %s, possible integrators are %v", name, IntegratorNames)
+}
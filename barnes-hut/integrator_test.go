@@ -0,0 +1,143 @@
+package barneshut
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+func TestIntegratorFromName(t *testing.T) {
+
+	for _, name := range IntegratorNames {
+		i, err := IntegratorFromName(name)
+		if err != nil || i.Name() != name {
+			t.Errorf("%s: got %v, %v", name, i, err)
+		}
+	}
+	if _, err := IntegratorFromName("rk4"); err == nil {
+		t.Errorf("unknown integrator should be refused")
+	}
+}
+
+// run the integrator on bodies until stop fires
+// return the run and the number of steps
+func runIntegrator(t testing.TB, i Integrator, bodies []quadtree.Body, stop StopCriterion) (*Run, int) {
+
+	var r Run
+	t.Cleanup(r.Close)
+	config := NewRunConfig()
+	config.Integrator = i
+	config.StopCriteria = AnyOf{stop}
+	r.SetConfig(config)
+	r.OutputDir = t.TempDir()
+	r.CaptureGifStep = 0
+	r.Init(&bodies)
+	r.SetState(RUNNING)
+
+	result, err := r.RunSimulation(context.Background())
+	if err != nil {
+		t.Fatalf("%s: %v", i.Name(), err)
+	}
+	return &r, result.Step
+}
+
+// test that every integrator spreads the bodies
+func TestIntegratorsConvergence(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 100)
+	SpreadOnCircleWithRand(&bodies, rand.New(rand.NewSource(1)))
+
+	// energy of the initial configuration
+	var start Run
+	defer start.Close()
+	startBodies := append([]quadtree.Body(nil), bodies...)
+	start.Init(&startBodies)
+	start.OutputDir = t.TempDir()
+	if err := start.OneStepOptional(false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range IntegratorNames {
+		i, _ := IntegratorFromName(name)
+		r, steps := runIntegrator(t, i, append([]quadtree.Body(nil), bodies...), MaxSteps{Steps: 100})
+		// with these bodies, the energy ends between 0.65 (lbfgs) and 0.76 (fire) of the initial energy
+		if steps != 100 || r.energy > 0.8*start.energy {
+			t.Errorf("%s: energy got %e after %d steps, initial energy %e", name, r.energy, steps, start.energy)
+		}
+	}
+}
+
+// test that with a constant Dt and without drag, leapfrog and velocity verlet move the bodies the same way
+func TestLeapfrogVelocityVerlet(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 200)
+	SpreadOnCircle(&bodies)
+
+	positions := make([][]quadtree.Body, 2)
+	for n, i := range []Integrator{Leapfrog{}, VelocityVerlet{}} {
+		var r Run
+		defer r.Close()
+		config := NewRunConfig()
+		config.Integrator = i
+		config.DtAdjustMode = MANUAL
+		config.DtRequest = 1e-6
+		config.SpeedDragFactor = 1.0
+		r.SetConfig(config)
+		r.OutputDir = t.TempDir()
+		positions[n] = append([]quadtree.Body(nil), bodies...)
+		r.Init(&positions[n])
+		for step := 0; step < 10; step++ {
+			if err := r.OneStep(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for idx := range bodies {
+		a, b := positions[0][idx], positions[1][idx]
+		if math.Abs(a.X-b.X) > 1e-12 || math.Abs(a.Y-b.Y) > 1e-12 {
+			t.Fatalf("body %d, leapfrog %#v, velocity verlet %#v", idx, a.BodyXY, b.BodyXY)
+		}
+	}
+}
+
+// test that FIRE stops the bodies and decreases Dt when they go uphill
+func TestFIREUphill(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 100)
+	SpreadOnCircle(&bodies)
+
+	var r Run
+	defer r.Close()
+	config := NewRunConfig()
+	config.Integrator = FIRE{}
+	r.SetConfig(config)
+	r.OutputDir = t.TempDir()
+	r.Init(&bodies)
+	if err := r.OneStep(); err != nil {
+		t.Fatal(err)
+	}
+	dt := r.config.Dt
+
+	// bodies go against the forces
+	r.ComputeRepulsiveForce()
+	r.ComputeMaxRepulsiveForce()
+	for idx := range bodies {
+		acc := r.getAcc(idx)
+		vel := r.getVel(idx)
+		vel.X, vel.Y = -acc.X, -acc.Y
+	}
+	r.config.integrator().Step(&r, false)
+
+	if r.config.Dt != dt*0.5 || r.fireAlpha != 0.1 || r.fireNbDownhill != 0 {
+		t.Errorf("dt got %e (previous %e), alpha %f, nb downhill %d", r.config.Dt, dt, r.fireAlpha, r.fireNbDownhill)
+	}
+	for idx := range bodies {
+		acc, vel := r.getAcc(idx), r.getVel(idx)
+		if vel.X*acc.X+vel.Y*acc.Y < 0.0 {
+			t.Fatalf("body %d still goes uphill", idx)
+		}
+	}
+}
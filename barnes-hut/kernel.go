@@ -0,0 +1,116 @@
+package barneshut
+
+import (
+	"fmt"
+	"math"
+)
+
+// a Kernel defines the repulsion between two bodies of unit mass
+// as a function of the distance r between them
+//
+// Force is the norm of the repulsion force, Energy is the potential energy.
+// Force has to be the opposite of the derivative of Energy and Energy has to stay
+// positive within the unit square (it is summed into the energy of the run, see the shutdown criteria)
+type Kernel interface {
+	Name() string
+	Force(r float64) float64
+	Energy(r float64) float64
+}
+
+// InverseSquare is the historical kernel, the force is in 1/r2
+//
+// According to Bartolo, 1/r2 is very strong on a plane (integration does not convergence on the plane)
+type InverseSquare struct{}
+
+func (k InverseSquare) Name() string             { return "inverseSquare" }
+func (k InverseSquare) Force(r float64) float64  { return 1.0 / (r * r) }
+func (k InverseSquare) Energy(r float64) float64 { return 1.0 / r }
+
+// distance above the max distance between a body and a mirror body
+// it is used to keep the logarithmic energy positive
+const logReferenceDistance = 4.0
+
+// Inverse is the 2D coulomb kernel, the force is in 1/r and the energy is logarithmic
+type Inverse struct{}
+
+func (k Inverse) Name() string             { return "inverse" }
+func (k Inverse) Force(r float64) float64  { return 1.0 / r }
+func (k Inverse) Energy(r float64) float64 { return math.Log(logReferenceDistance / r) }
+
+// Yukawa is the screened coulomb kernel, the energy is exp(-r/Lambda)/r
+//
+// the repulsion fades away exponentially beyond Lambda
+type Yukawa struct {
+	Lambda float64 // screening length
+}
+
+func (k Yukawa) Name() string { return fmt.Sprintf("yukawa(%g)", k.Lambda) }
+func (k Yukawa) Force(r float64) float64 {
+	return math.Exp(-r/k.Lambda) * (1.0/(r*r) + 1.0/(k.Lambda*r))
+}
+func (k Yukawa) Energy(r float64) float64 { return math.Exp(-r/k.Lambda) / r }
+
+// Plummer is the softened inverse square kernel, the energy is 1/sqrt(r2+Epsilon2)
+//
+// the force does not diverge when two bodies are very close
+type Plummer struct {
+	Epsilon float64 // softening length
+}
+
+func (k Plummer) Name() string { return fmt.Sprintf("plummer(%g)", k.Epsilon) }
+func (k Plummer) Force(r float64) float64 {
+	s := r*r + k.Epsilon*k.Epsilon
+	return r / (s * math.Sqrt(s))
+}
+func (k Plummer) Energy(r float64) float64 { return 1.0 / math.Sqrt(r*r+k.Epsilon*k.Epsilon) }
+
+// SmoothCutoff is the inverse square kernel shifted in order to have
+// both the force and the energy going continuously to 0 at Radius
+//
+// unlike CutoffDistance, there is no discontinuity of the force at the cutoff
+type SmoothCutoff struct {
+	Radius float64 // cutoff radius
+}
+
+func (k SmoothCutoff) Name() string { return fmt.Sprintf("smoothCutoff(%g)", k.Radius) }
+func (k SmoothCutoff) Force(r float64) float64 {
+	if r >= k.Radius {
+		return 0.0
+	}
+	return 1.0/(r*r) - 1.0/(k.Radius*k.Radius)
+}
+func (k SmoothCutoff) Energy(r float64) float64 {
+	if r >= k.Radius {
+		return 0.0
+	}
+	return 1.0/r - 1.0/k.Radius + (r-k.Radius)/(k.Radius*k.Radius)
+}
+
+// KernelNames lists the names accepted by KernelFromName
+var KernelNames = []string{"inverseSquare", "inverse", "yukawa", "plummer", "smoothCutoff"}
+
+// KernelFromName returns the kernel of name name
+// param is the screening length of yukawa, the softening length of plummer
+// and the radius of smoothCutoff. It is not used by the other kernels
+func KernelFromName(name string, param float64) (Kernel, error) {
+
+	switch name {
+	case "inverseSquare":
+		return InverseSquare{}, nil
+	case "inverse":
+		return Inverse{}, nil
+	}
+
+	if param <= 0.0 {
+		return nil, fmt.Errorf("kernel %s needs a parameter above 0.0, got %g", name, param)
+	}
+	switch name {
+	case "yukawa":
+		return Yukawa{Lambda: param}, nil
+	case "plummer":
+		return Plummer{Epsilon: param}, nil
+	case "smoothCutoff":
+		return SmoothCutoff{Radius: param}, nil
+	}
+	return nil, fmt.Errorf("unknown kernel %s, possible kernels are %v", name, KernelNames)
+}
@@ -0,0 +1,71 @@
+package barneshut
+
+import (
+	"math"
+	"testing"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// test that the force of each kernel is the opposite of the derivative of its energy
+// and that the energy stays positive
+func TestKernels(t *testing.T) {
+
+	kernels := []Kernel{
+		InverseSquare{},
+		Inverse{},
+		Yukawa{Lambda: 0.1},
+		Plummer{Epsilon: 0.01},
+		SmoothCutoff{Radius: 0.3},
+	}
+	h := 1e-7
+	for _, k := range kernels {
+		for _, r := range []float64{0.001, 0.01, 0.1, 0.25, 1.0, 2.8} {
+			derivative := (k.Energy(r+h) - k.Energy(r-h)) / (2 * h)
+			if math.Abs(k.Force(r)+derivative) > 1e-5*math.Max(1.0, k.Force(r)) {
+				t.Errorf("%s at r %f, force %e, -dE/dr %e", k.Name(), r, k.Force(r), -derivative)
+			}
+			if k.Energy(r) < 0.0 {
+				t.Errorf("%s at r %f, negative energy %e", k.Name(), r, k.Energy(r))
+			}
+		}
+	}
+}
+
+func TestKernelFromName(t *testing.T) {
+
+	for _, name := range KernelNames {
+		k, err := KernelFromName(name, 0.1)
+		if err != nil || k == nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := KernelFromName("yukawa", 0.0); err == nil {
+		t.Errorf("yukawa without screening length should be refused")
+	}
+	if _, err := KernelFromName("gaussian", 0.1); err == nil {
+		t.Errorf("unknown kernel should be refused")
+	}
+}
+
+// test that the repulsion vector follows the kernel
+func TestGetRepulsionVectorKernel(t *testing.T) {
+
+	A := quadtree.Body{BodyXY: quadtree.BodyXY{X: 0.5, Y: 0.5}, M: 2.0}
+	B := quadtree.Body{BodyXY: quadtree.BodyXY{X: 0.6, Y: 0.5}, M: 3.0}
+
+	c := NewRunConfig()
+	for _, k := range []Kernel{InverseSquare{}, Inverse{}, Plummer{Epsilon: 0.05}} {
+		c.Kernel = k
+		x, y, e := c.getRepulsionVector(&A, &B, 0, 0)
+
+		// B is on the right of A, therefore A is pushed to the left
+		wantX := -6.0 * k.Force(0.1)
+		if math.Abs(x-wantX) > 1e-9*math.Abs(wantX) || y != 0.0 {
+			t.Errorf("%s got %e %e, want %e 0", k.Name(), x, y, wantX)
+		}
+		if wantE := 6.0 * k.Energy(0.1); math.Abs(e-wantE) > 1e-9*wantE {
+			t.Errorf("%s energy got %e, want %e", k.Name(), e, wantE)
+		}
+	}
+}
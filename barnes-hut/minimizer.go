@@ -0,0 +1,254 @@
+package barneshut
+
+import (
+	"math"
+)
+
+// LBFGS is not a physical integrator, it minimises the energy of the run (the sum of
+// the energies of the bodies) with the limited memory BFGS quasi-Newton method
+//
+// The gradient of the energy is given by the repulsive forces (computed with Barnes-Hut).
+// At each step, the direction is computed from the last Memory displacements and changes of the gradient,
+// the bodies are moved along this direction and the energy is computed at the new positions. If the energy
+// has not decreased enough (Armijo condition), the displacement is halved and the energy computed again.
+// Therefore, a step can compute the forces more than once. Velocities are not used.
+//
+// Only the end state is meaningful, the trajectory of the bodies is not. Since the energy is approximated
+// by Barnes-Hut, the decrease of the energy is irregular, the run should stop on the mean decrease over some steps
+// (see EnergyDecrease). The history is stored in checkpoints, a resumed run goes on as the run that kept going.
+// Zero values of the parameters are replaced by the default values.
+type LBFGS struct {
+	Memory          int     // nb of displacements that are kept (8)
+	MaxDisplacement float64 // max displacement of a body during a step (0.01)
+	MaxLineSearch   int     // max nb of force computations per step (10)
+}
+
+// Armijo condition, the energy has to decrease by this ratio of the decrease predicted by the gradient
+const armijoRatio = 1e-4
+
+func (i LBFGS) Name() string { return "lbfgs" }
+
+// parameters of LBFGS with the default values
+func (i LBFGS) withDefaults() LBFGS {
+	if i.Memory == 0 {
+		i.Memory = 8
+	}
+	if i.MaxDisplacement == 0.0 {
+		i.MaxDisplacement = 0.01
+	}
+	if i.MaxLineSearch == 0 {
+		i.MaxLineSearch = 10
+	}
+	return i
+}
+
+// state of the minimisation
+//
+// vectors are of size 2 * nb of bodies (X and Y of each body)
+type lbfgsState struct {
+	s, y [][]float64 // last displacements and the changes of the gradient, the most recent is the last
+	rho  []float64   // 1 / (y.s)
+
+	gradient     []float64 // gradient at the start of the last step
+	displacement []float64 // accepted displacement of the last step (nil if the last step failed)
+
+	maxDisplacement float64 // max displacement of a body during the last accepted step
+}
+
+// max displacement of a body along the steepest descent
+func (st *lbfgsState) steepestDescentDisplacement(p LBFGS) float64 {
+	if st.maxDisplacement > 0.0 && st.maxDisplacement < p.MaxDisplacement {
+		return st.maxDisplacement
+	}
+	return p.MaxDisplacement
+}
+
+func (i LBFGS) Step(r *Run, updatePosition bool) {
+
+	p := i.withDefaults()
+	r.computeDtOptim()
+	if !updatePosition {
+		return
+	}
+
+	nbBodies := len(*r.bodies)
+	if r.lbfgs == nil || len(r.lbfgs.gradient) != 2*nbBodies {
+		r.lbfgs = &lbfgsState{}
+	}
+	st := r.lbfgs
+
+	// energy and gradient at the current positions
+	// each pair of bodies is counted twice in the energy, hence the gradient is twice the force
+	energy := r.sumEnergy()
+	gradient := make([]float64, 2*nbBodies)
+	for idx := range *r.bodies {
+		acc := r.getAcc(idx)
+		gradient[2*idx] = -2.0 * acc.X
+		gradient[2*idx+1] = -2.0 * acc.Y
+	}
+
+	// update the history with the last step
+	if st.displacement != nil {
+		y := make([]float64, 2*nbBodies)
+		for k := range y {
+			y[k] = gradient[k] - st.gradient[k]
+		}
+		if sy := dot(st.displacement, y); sy > 0.0 {
+			st.s = append(st.s, st.displacement)
+			st.y = append(st.y, y)
+			st.rho = append(st.rho, 1.0/sy)
+			if len(st.s) > p.Memory {
+				st.s, st.y, st.rho = st.s[1:], st.y[1:], st.rho[1:]
+			}
+		}
+	}
+	st.gradient = gradient
+	st.displacement = nil
+
+	direction := st.direction(gradient)
+	slope := dot(gradient, direction)
+	if slope >= 0.0 {
+		// not a descent direction, restart from the steepest descent
+		st.s, st.y, st.rho = nil, nil, nil
+		direction = st.direction(gradient)
+		slope = dot(gradient, direction)
+	}
+
+	// the quasi newton step is 1.0, the steepest descent has no natural scale
+	// it is scaled in order to move the bodies by the max displacement of the last step
+	alpha := 1.0
+	maxNorm := maxBodyNorm(direction)
+	if maxNorm == 0.0 {
+		return
+	}
+	if len(st.s) == 0 || alpha*maxNorm > p.MaxDisplacement {
+		alpha = st.steepestDescentDisplacement(p) / maxNorm
+	}
+
+	start := make([]float64, 2*nbBodies)
+	for idx, body := range *r.bodies {
+		start[2*idx] = body.X
+		start[2*idx+1] = body.Y
+		vel := r.getVel(idx)
+		vel.X, vel.Y = 0.0, 0.0
+	}
+
+	// backtracking line search
+	for try := 0; try < p.MaxLineSearch; try++ {
+		r.moveBodies(start, direction, alpha)
+		err := r.computeForces()
+		newEnergy := r.sumEnergy()
+		if err == nil && newEnergy <= energy+armijoRatio*alpha*slope {
+			st.displacement = make([]float64, 2*nbBodies)
+			for k := range direction {
+				st.displacement[k] = alpha * direction[k]
+			}
+			st.maxDisplacement = maxBodyNorm(st.displacement)
+			r.forcesAreCurrent = true
+			return
+		}
+		Trace.Printf("LBFGS step %d, energy %e above %e with alpha %e (%v)", r.step, newEnergy, energy, alpha, err)
+		r.stepErrMutex.Lock()
+		r.stepErr = nil
+		r.stepErrMutex.Unlock()
+
+		// the history is probably outdated (the gradient is only approximated by Barnes-Hut),
+		// the search goes on along the steepest descent
+		if len(st.s) > 0 {
+			st.s, st.y, st.rho = nil, nil, nil
+			direction = st.direction(gradient)
+			slope = dot(gradient, direction)
+			alpha = st.steepestDescentDisplacement(p) / maxBodyNorm(direction)
+			continue
+		}
+		alpha *= 0.5
+	}
+
+	// no decrease, bodies stay where they are and the history is forgotten
+	Info.Printf("LBFGS step %d, no decrease of the energy after %d tries", r.step, p.MaxLineSearch)
+	r.moveBodies(start, direction, 0.0)
+	st.s, st.y, st.rho = nil, nil, nil
+	r.forcesAreCurrent = r.computeForces() == nil
+}
+
+// return the direction of the step, -H.gradient where H is the approximation of
+// the inverse of the hessian given by the history (two loop recursion)
+func (st *lbfgsState) direction(gradient []float64) []float64 {
+
+	q := make([]float64, len(gradient))
+	copy(q, gradient)
+
+	m := len(st.s)
+	a := make([]float64, m)
+	for k := m - 1; k >= 0; k-- {
+		a[k] = st.rho[k] * dot(st.s[k], q)
+		axpy(-a[k], st.y[k], q)
+	}
+
+	// initial hessian is gamma I
+	gamma := 1.0
+	if m > 0 {
+		gamma = dot(st.s[m-1], st.y[m-1]) / dot(st.y[m-1], st.y[m-1])
+	}
+	for k := range q {
+		q[k] *= gamma
+	}
+
+	for k := 0; k < m; k++ {
+		b := st.rho[k] * dot(st.y[k], q)
+		axpy(a[k]-b, st.s[k], q)
+	}
+
+	for k := range q {
+		q[k] = -q[k]
+	}
+	return q
+}
+
+// move the bodies at start + alpha * direction and keep them within the square and the domain
+func (r *Run) moveBodies(start, direction []float64, alpha float64) {
+
+	for idx := range *r.bodies {
+		body := &((*r.bodies)[idx])
+		oldX, oldY := start[2*idx], start[2*idx+1]
+		body.X = oldX + alpha*direction[2*idx]
+		body.Y = oldY + alpha*direction[2*idx+1]
+
+		var vel Vel
+		r.config.applyBoundary(body, &vel)
+		r.config.applyDomain(body, &vel, oldX, oldY)
+	}
+}
+
+// sum of the energies of the bodies
+func (r *Run) sumEnergy() float64 {
+	energy := 0.0
+	for _, e := range *r.bodiesEnergy {
+		energy += e
+	}
+	return energy
+}
+
+// max norm of the vector of a body
+func maxBodyNorm(u []float64) float64 {
+	res := 0.0
+	for k := 0; k+1 < len(u); k += 2 {
+		res = math.Max(res, math.Hypot(u[k], u[k+1]))
+	}
+	return res
+}
+
+func dot(u, v []float64) float64 {
+	res := 0.0
+	for k := range u {
+		res += u[k] * v[k]
+	}
+	return res
+}
+
+// v += a * u
+func axpy(a float64, u, v []float64) {
+	for k := range u {
+		v[k] += a * u[k]
+	}
+}
@@ -0,0 +1,56 @@
+package barneshut
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// test that the two loop recursion finds the newton step of a quadratic
+// once the history spans the space
+func TestLBFGSDirection(t *testing.T) {
+
+	// energy is 0.5 * (x*x + 4*y*y), the displacements are along the axis
+	st := lbfgsState{
+		s:   [][]float64{{1.0, 0.0}, {0.0, 1.0}},
+		y:   [][]float64{{1.0, 0.0}, {0.0, 4.0}},
+		rho: []float64{1.0, 0.25},
+	}
+	gradient := []float64{3.0, 8.0} // at x = 3, y = 2
+
+	direction := st.direction(gradient)
+	if math.Abs(direction[0]+3.0) > 1e-12 || math.Abs(direction[1]+2.0) > 1e-12 {
+		t.Errorf("direction got %v, want [-3 -2]", direction)
+	}
+
+	// without history, the direction is the steepest descent
+	var empty lbfgsState
+	direction = empty.direction(gradient)
+	if direction[0] != -3.0 || direction[1] != -8.0 {
+		t.Errorf("direction got %v, want [-3 -8]", direction)
+	}
+}
+
+// test that the energy never increases and that it decreases faster than with euler
+func TestLBFGSDecreasesEnergy(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 200)
+	SpreadOnCircleWithRand(&bodies, rand.New(rand.NewSource(1)))
+
+	r, steps := runIntegrator(t, LBFGS{}, append([]quadtree.Body(nil), bodies...), MaxSteps{Steps: 30})
+	if steps != 30 {
+		t.Fatalf("got %d steps", steps)
+	}
+	for step := 1; step < len(r.energies); step++ {
+		if r.energies[step] > r.energies[step-1] {
+			t.Errorf("step %d, energy increases from %e to %e", step, r.energies[step-1], r.energies[step])
+		}
+	}
+
+	euler, _ := runIntegrator(t, Euler{}, append([]quadtree.Body(nil), bodies...), MaxSteps{Steps: 30})
+	if r.energy >= euler.energy {
+		t.Errorf("energy after 30 steps got %e, euler %e", r.energy, euler.energy)
+	}
+}
@@ -0,0 +1,110 @@
+package barneshut
+
+import (
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// reorder the bodies along the Z-order (Morton) curve of their node at level 8
+//
+// the bodies of a leaf of the quadtree are chained by pointers, the walk of the quadtree
+// therefore jumps around memory if the bodies are in the order of the input file. Once reordered,
+// the bodies of a node are contiguous in memory.
+//
+// the arrays of the run that are indexed by the bodies are reordered the same way and r.bodiesIndex
+// keeps the index of each body in the input order (body files are always written in the input order,
+// see WriteConfig)
+func (r *Run) reorderBodies() {
+
+	bodies := *r.bodies
+	nbBodies := len(bodies)
+
+	// counting sort on the rank of the nodes, bodies of the same node keep their relative order
+	ranks := make([]int, nbBodies)
+	counts := make([]int, 1<<16+1)
+	for idx := range bodies {
+		ranks[idx] = bodies[idx].Coord8().Morton()
+		counts[ranks[idx]+1]++
+	}
+	for rank := 1; rank < len(counts); rank++ {
+		counts[rank] += counts[rank-1]
+	}
+	order := make([]int, nbBodies) // index before the reordering of the body at each index
+	sorted := true
+	for idx, rank := range ranks {
+		order[counts[rank]] = idx
+		sorted = sorted && counts[rank] == idx
+		counts[rank]++
+	}
+	if sorted {
+		return
+	}
+	Trace.Printf("reorderBodies at step %d", r.step)
+
+	// neighbours are stored by their ID, only the rows of the dicos are moved
+	for _, dico := range []*NeighbourDico{r.bodiesNeighbours, r.bodiesNeighboursOrig} {
+		rows := append(NeighbourDico(nil), (*dico)...)
+		for idx, old := range order {
+			(*dico)[idx] = rows[old]
+		}
+	}
+
+	// the links of the bodies are garbage until the quadtree is updated
+	tmpBodies := make([]quadtree.Body, nbBodies)
+	for _, b := range []*[]quadtree.Body{r.bodies, r.bodiesOrig} {
+		copy(tmpBodies, *b)
+		for idx, old := range order {
+			(*b)[idx] = tmpBodies[old]
+		}
+	}
+
+	tmpVel := append([]Vel(nil), (*r.bodiesVel)...)
+	tmpAcc := append([]Acc(nil), (*r.bodiesAccel)...)
+	tmpEnergy := append([]float64(nil), (*r.bodiesEnergy)...)
+	if r.bodiesIndex == nil {
+		r.bodiesIndex = make([]int, nbBodies)
+		for idx := range r.bodiesIndex {
+			r.bodiesIndex[idx] = idx
+		}
+	}
+	tmpIndex := append([]int(nil), r.bodiesIndex...)
+	for idx, old := range order {
+		(*r.bodiesVel)[idx] = tmpVel[old]
+		(*r.bodiesAccel)[idx] = tmpAcc[old]
+		(*r.bodiesEnergy)[idx] = tmpEnergy[old]
+		r.bodiesIndex[idx] = tmpIndex[old]
+	}
+
+	// the LBFGS state stores 2 coordinates per body
+	if st := r.lbfgs; st != nil {
+		for _, v := range append(append([][]float64{st.gradient, st.displacement}, st.s...), st.y...) {
+			reorderPairs(v, order)
+		}
+	}
+
+	r.q.UpdateNodesListsAndCOM()
+}
+
+// reorder the pairs of v, the pair at order[idx] becomes the pair at idx
+func reorderPairs(v []float64, order []int) {
+	if v == nil {
+		return
+	}
+	tmp := append([]float64(nil), v...)
+	for idx, old := range order {
+		v[2*idx] = tmp[2*old]
+		v[2*idx+1] = tmp[2*old+1]
+	}
+}
+
+// return the bodies in the input order (the bodies themselves if they have not been reordered)
+func (r *Run) bodiesInInputOrder() []quadtree.Body {
+
+	if r.bodiesIndex == nil {
+		return *r.bodies
+	}
+	bodies := make([]quadtree.Body, len(*r.bodies))
+	for idx, b := range *r.bodies {
+		bodies[r.bodiesIndex[idx]] = b
+	}
+	return bodies
+}
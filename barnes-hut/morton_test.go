@@ -0,0 +1,118 @@
+package barneshut
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/thomaspeugeot/tkv/bods"
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// ID of the neighbours of each body, by ID of the body
+func neighbourIDs(r *Run, dico *NeighbourDico) map[uint32][]uint32 {
+
+	neighbours := make(map[uint32][]uint32, len(*dico))
+	for idx := range *dico {
+		id := (*r.bodies)[idx].ID
+		for _, n := range (*dico)[idx] {
+			neighbours[id] = append(neighbours[id], n.id)
+		}
+	}
+	return neighbours
+}
+
+// test that the reordering is a permutation of the state of the run
+func TestReorderBodies(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 2000)
+	SpreadOnCircleWithRand(&bodies, rand.New(rand.NewSource(3)))
+	var r Run
+	defer r.Close()
+	r.Init(&bodies)
+	r.OutputDir = t.TempDir()
+	for step := 0; step < 3; step++ {
+		if err := r.OneStep(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var before, after bytes.Buffer
+	r.WriteConfig(&before)
+	neighbours := neighbourIDs(&r, r.bodiesNeighbours)
+	energy := (*r.bodiesEnergy)[42]
+	stirring := r.bodiesNeighbours.ComputeStirring(r.bodiesNeighboursOrig)
+
+	r.reorderBodies()
+
+	for idx := 1; idx < len(bodies); idx++ {
+		if bodies[idx-1].Coord8().Morton() > bodies[idx].Coord8().Morton() {
+			t.Fatalf("body %d is not in the Z-order", idx)
+		}
+	}
+	r.WriteConfig(&after)
+	if !bytes.Equal(before.Bytes(), after.Bytes()) {
+		t.Errorf("the configuration in the input order has changed")
+	}
+	for id, n := range neighbourIDs(&r, r.bodiesNeighbours) {
+		for rank := range n {
+			if n[rank] != neighbours[id][rank] {
+				t.Fatalf("body %d, neighbour %d got %d, want %d", id, rank, n[rank], neighbours[id][rank])
+			}
+		}
+	}
+	for idx, input := range r.bodiesIndex {
+		if input == 42 && (*r.bodiesEnergy)[idx] != energy {
+			t.Errorf("energy of body 42 got %e, want %e", (*r.bodiesEnergy)[idx], energy)
+		}
+	}
+	if got := r.bodiesNeighbours.ComputeStirring(r.bodiesNeighboursOrig); got != stirring {
+		t.Errorf("stirring got %f, want %f", got, stirring)
+	}
+	if err := r.q.Check(); err != nil {
+		t.Error(err)
+	}
+}
+
+// test that a run with reorderings gives the same configuration as a run without
+// (up to the rounding, the bodies of a leaf are summed in another order)
+func TestReorderStep(t *testing.T) {
+
+	var configs [2][]quadtree.Body
+	for n, reorderStep := range []int{0, 4} {
+		bodies := make([]quadtree.Body, 1000)
+		SpreadOnCircleWithRand(&bodies, rand.New(rand.NewSource(5)))
+
+		var r Run
+		defer r.Close()
+		config := NewRunConfig()
+		config.ReorderStep = reorderStep
+		config.StopCriteria = AnyOf{MaxSteps{Steps: 10}}
+		r.SetConfig(config)
+		r.OutputDir = t.TempDir()
+		r.CaptureGifStep = 0
+		r.Init(&bodies)
+		r.SetState(RUNNING)
+		if _, err := r.RunSimulation(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := r.WriteConfig(&buf); err != nil {
+			t.Fatal(err)
+		}
+		_, read, err := bods.ReadBodies(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		configs[n] = read
+	}
+
+	for idx := range configs[0] {
+		if d := math.Hypot(configs[0][idx].X-configs[1][idx].X, configs[0][idx].Y-configs[1][idx].Y); d > 1e-9 {
+			t.Fatalf("body %d got %v and %v", idx, configs[0][idx].BodyXY, configs[1][idx].BodyXY)
+		}
+	}
+}
@@ -6,8 +6,9 @@ import (
 
 // relative to a body of interest, the storage for a neighbour with its distance
 // nota : this is used to measure the stirring of the bodies along the simulation
+// the neighbour is stored by its ID, therefore the dico does not depend on the order of the bodies
 type Neighbour struct {
-	n        *quadtree.Body // rank in the []quadtree.Body
+	id       uint32 // ID of the neighbour body (0 if there is no neighbour)
 	Distance float64
 }
 
@@ -18,20 +19,19 @@ var NbOfNeighboursPerBody int = 10
 
 type NeighbourDico [][]Neighbour
 
-func (r *Run) InitNeighbourDico(bodies *([]quadtree.Body)) {
-	neighbours := make(NeighbourDico, len(*bodies))
-	r.bodiesNeighbours = &neighbours
-	for idx := range *r.bodiesNeighbours {
-		(*r.bodiesNeighbours)[idx] = make([]Neighbour, NbOfNeighboursPerBody)
+// NewNeighbourDico returns a dico of nbBodies bodies without neighbours
+func NewNeighbourDico(nbBodies int) *NeighbourDico {
+	dico := make(NeighbourDico, nbBodies)
+	for idx := range dico {
+		dico[idx] = make([]Neighbour, NbOfNeighboursPerBody)
 	}
-	r.bodiesNeighbours.Reset()
+	dico.Reset()
+	return &dico
+}
 
-	neighboursOrig := make(NeighbourDico, len(*bodies))
-	r.bodiesNeighboursOrig = &neighboursOrig
-	for idx := range *r.bodiesNeighboursOrig {
-		(*r.bodiesNeighboursOrig)[idx] = make([]Neighbour, NbOfNeighboursPerBody)
-	}
-	r.bodiesNeighboursOrig.Reset()
+func (r *Run) InitNeighbourDico(bodies *([]quadtree.Body)) {
+	r.bodiesNeighbours = NewNeighbourDico(len(*bodies))
+	r.bodiesNeighboursOrig = NewNeighbourDico(len(*bodies))
 }
 
 // reset neighbour dico
@@ -39,7 +39,7 @@ func (dico *NeighbourDico) Reset() {
 
 	for idx := range *dico {
 		for n := range (*dico)[idx] {
-			(*dico)[idx][n].n = nil
+			(*dico)[idx][n].id = 0
 			(*dico)[idx][n].Distance = 2.0
 		}
 	}
@@ -50,7 +50,7 @@ func (dicoTarget *NeighbourDico) Copy(dicoSource *NeighbourDico) {
 
 	for idx := range *dicoSource {
 		for n := range (*dicoSource)[idx] {
-			(*dicoTarget)[idx][n].n = (*dicoSource)[idx][n].n
+			(*dicoTarget)[idx][n].id = (*dicoSource)[idx][n].id
 			(*dicoTarget)[idx][n].Distance = (*dicoSource)[idx][n].Distance
 		}
 	}
@@ -66,7 +66,7 @@ func (dico *NeighbourDico) Insert(index int, body *quadtree.Body, distance float
 	//check if body is already present
 	// if yes, update distance
 	for rank := NbOfNeighboursPerBody - 1; rank >= 0; rank-- {
-		if (*dico)[index][rank].n == body {
+		if (*dico)[index][rank].id == body.ID {
 			if (*dico)[index][rank].Distance > distance {
 				(*dico)[index][rank].Distance = distance
 			}
@@ -76,16 +76,16 @@ func (dico *NeighbourDico) Insert(index int, body *quadtree.Body, distance float
 
 	// check if body is eligible to the last rank
 	// replace if last rank is nil or if distance is greater
-	if (*dico)[index][NbOfNeighboursPerBody-1].n == nil ||
+	if (*dico)[index][NbOfNeighboursPerBody-1].id == 0 ||
 		(*dico)[index][NbOfNeighboursPerBody-1].Distance > distance {
 
-		(*dico)[index][NbOfNeighboursPerBody-1].n = body
+		(*dico)[index][NbOfNeighboursPerBody-1].id = body.ID
 		(*dico)[index][NbOfNeighboursPerBody-1].Distance = distance
 	}
 
 	// swap from last rank to rank 0
 	for rank := NbOfNeighboursPerBody - 2; rank >= 0; rank-- {
-		if (*dico)[index][rank].n == nil ||
+		if (*dico)[index][rank].id == 0 ||
 			(*dico)[index][rank].Distance > (*dico)[index][rank+1].Distance {
 
 			tmp := (*dico)[index][rank]
@@ -102,14 +102,14 @@ func (dico *NeighbourDico) Check() {
 	for idx := range *dico {
 		for n := range (*dico)[idx] {
 
-			body := (*dico)[idx][n].n
-			if body == nil {
+			body := (*dico)[idx][n].id
+			if body == 0 {
 				Info.Printf("nil neighbour at index %d rank %d, with distance %f", idx, n, (*dico)[idx][n].Distance)
 			}
 
 			// check to see if neighbor is present twice
 			for nOrig := range (*dico)[idx] {
-				if (*dico)[idx][nOrig].n == body && nOrig != n {
+				if (*dico)[idx][nOrig].id == body && nOrig != n {
 
 					Info.Printf("neighbour found twice at index %d rank %d and rank %d", idx, n, nOrig)
 
@@ -125,7 +125,7 @@ func (dico *NeighbourDico) ComputeRatioOfNilNeighbours() float64 {
 	for idx := range *dico {
 		for n := range (*dico)[idx] {
 
-			if (*dico)[idx][n].n == nil {
+			if (*dico)[idx][n].id == 0 {
 				nbOfNil++
 			}
 		}
@@ -143,15 +143,15 @@ func (dico *NeighbourDico) ComputeStirring(dicoOrig *NeighbourDico) float64 {
 	for idx := range *dico {
 		for nOrig := range (*dicoOrig)[idx] {
 
-			bodyOrig := (*dicoOrig)[idx][nOrig].n
+			bodyOrig := (*dicoOrig)[idx][nOrig].id
 
-			if bodyOrig != nil {
+			if bodyOrig != 0 {
 				numberOfNeighbors++
 
 				// parse dico to check if the orig neighbour's body is present
 				for n := range (*dico)[idx] {
 					found := 0
-					if (*dico)[idx][n].n == bodyOrig {
+					if (*dico)[idx][n].id == bodyOrig {
 						numberOfKeptNeighbors++
 						found++
 						// continue
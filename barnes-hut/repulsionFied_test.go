@@ -8,6 +8,7 @@ import (
 func OldTestRepulsionFieldInit(t *testing.T) {
 
 	r := NewRun()
+	defer r.Close()
 	r.LoadConfig("conf-fra-00000.bods")
 
 	// get pointer on quadtree
@@ -19,7 +20,8 @@ func OldTestRepulsionFieldInit(t *testing.T) {
 		0.4, 0.6,
 		r.gridFieldNb,
 		q, // quadtree
-		0.00001)
+		0.00001,
+		r.Config())
 	f.ComputeField()
 	r.fieldRendering = true
 	Info.Printf("TestRepulsionFieldInit value at 1 1 %e", f.values[1][1])
@@ -2,13 +2,12 @@ package barneshut
 
 import (
 	"math"
-	"testing"
 
 	"github.com/thomaspeugeot/tkv/quadtree"
 )
 
 // a RepulsionField stores the computation
-// of a scalar field with the values of the repulsion field (1/r)
+// of a scalar field with the values of the repulsion field (the energy of the kernel of the run)
 // on a fixed area, at interpolation points ( GridFieldTicks interpolation points per dimension )
 // this structure is transcient
 type RepulsionField struct {
@@ -19,9 +18,10 @@ type RepulsionField struct {
 	maxValue float64
 	q        *quadtree.Quadtree // the quadtree against which the field is computed
 	cutoff   float64            // the distance to the nearest body with void the repulsion field
+	config   *RunConfig         // parameters of the run (for the barnes hut criteria)
 }
 
-func NewRepulsionField(XMin, YMin, XMax, YMax float64, GridFieldTicks int, q *quadtree.Quadtree, cutoff float64) *RepulsionField {
+func NewRepulsionField(XMin, YMin, XMax, YMax float64, GridFieldTicks int, q *quadtree.Quadtree, cutoff float64, config *RunConfig) *RepulsionField {
 	Trace.Println("NewRepulsionField")
 
 	var f RepulsionField
@@ -35,6 +35,7 @@ func NewRepulsionField(XMin, YMin, XMax, YMax float64, GridFieldTicks int, q *qu
 	f.q = q
 
 	f.cutoff = cutoff
+	f.config = config
 
 	f.values = make([][]float64, GridFieldTicks)
 	for i := range f.values {
@@ -52,16 +53,15 @@ func (f *RepulsionField) XY(i, j int) (x, y float64) {
 	return x, y
 }
 
-// compute repulsion at body A coordinates from body B
-// repulsion field is in 1/r * M
-func getRepulsionField(A, B *quadtree.Body) (v float64) {
+// compute repulsion at body A coordinates from the image xM, yM of body B
+// repulsion field is the energy of the kernel * M (1/r * M for the inverse square kernel)
+func (c *RunConfig) getRepulsionField(A, B *quadtree.Body, xM, yM int) (v float64) {
 
-	x := getModuloDistance(B.X, A.X)
-	y := getModuloDistance(B.Y, A.Y)
+	x, y := c.getVectorBetweenBodies(A, B, xM, yM)
 
 	distQuared := (x*x + y*y)
 	absDistance := math.Sqrt(distQuared + ETA)
-	v = B.M / absDistance
+	v = B.M * c.kernel().Energy(absDistance)
 
 	return v
 }
@@ -81,7 +81,20 @@ func (f *RepulsionField) ComputeField() {
 			// go func() {
 			var fv float64 // field value
 			// 	// am i sure that have not been changed by the next call to func ?
-			f.ComputeFieldRecursive(x, y, f.q, rootCoord, &fv)
+
+			// the images of the bodies are the images used for the computation of the repulsion
+			n := f.config.imageRange()
+			onBody := false
+			for xM := -n; xM <= n; xM++ {
+				for yM := -n; yM <= n; yM++ {
+					if f.ComputeFieldRecursive(x, y, f.q, rootCoord, xM, yM, &fv) {
+						onBody = true
+					}
+				}
+			}
+			if onBody {
+				fv = 0.0
+			}
 			// 	done <- fv
 			// }()
 			if fv > f.maxValue {
@@ -104,8 +117,10 @@ func (f *RepulsionField) ComputeField() {
 	Trace.Printf("computeField maxValue %e\n", f.maxValue)
 }
 
-// compute repulsion field at interpolation point x, y and update v
-func (f *RepulsionField) ComputeFieldRecursive(x, y float64, q *quadtree.Quadtree, coord quadtree.Coord, v *float64) {
+// compute repulsion field at interpolation point x, y from the image xM, yM of the bodies and update v
+//
+// return true if the point is closer than the cutoff to a body
+func (f *RepulsionField) ComputeFieldRecursive(x, y float64, q *quadtree.Quadtree, coord quadtree.Coord, xM, yM int, v *float64) (onBody bool) {
 
 	Trace.Printf("ComputeFieldRecursive at %e %e, quadtree %p, coord %s, input v = %e\n", x, y, q, coord.String(), *v)
 
@@ -117,29 +132,30 @@ func (f *RepulsionField) ComputeFieldRecursive(x, y float64, q *quadtree.Quadtre
 	level := coord.Level()
 	boxSize := 1.0 / math.Pow(2.0, float64(level)) // if level = 0, this is 1.0
 
-	node := &(q.Nodes[coord])
-	distToNode := getModuloDistanceBetweenBodies(&body, &(node.Body))
+	node := q.Node(coord)
+	distToNode := f.config.getDistanceBetweenBodies(&body, &(node.Body), xM, yM)
 
 	// avoid node with zero mass
 	if node.M == 0 {
-		return
+		return false
 	}
 
 	// check if the COM of the node can be used
-	if (boxSize / distToNode) < BN_THETA {
+	if (boxSize / distToNode) < f.config.BN_THETA {
 
-		*v += getRepulsionField(&body, &(node.Body))
+		*v += f.config.getRepulsionField(&body, &(node.Body), xM, yM)
 
 	} else {
-		if level < 8 {
+		if !node.IsLeaf() {
 			// parse sub nodes
 			Trace.Printf("ComputeFieldRecursive go down at node %#v\n", node.Coord())
 
 			coordNW, coordNE, coordSW, coordSE := quadtree.NodesBelow(coord)
-			f.ComputeFieldRecursive(x, y, q, coordNW, v)
-			f.ComputeFieldRecursive(x, y, q, coordNE, v)
-			f.ComputeFieldRecursive(x, y, q, coordSW, v)
-			f.ComputeFieldRecursive(x, y, q, coordSE, v)
+			for _, coordBelow := range []quadtree.Coord{coordNW, coordNE, coordSW, coordSE} {
+				if f.ComputeFieldRecursive(x, y, q, coordBelow, xM, yM, v) {
+					return true
+				}
+			}
 		} else {
 
 			// parse bodies of the node
@@ -148,11 +164,12 @@ func (f *RepulsionField) ComputeFieldRecursive(x, y float64, q *quadtree.Quadtre
 			for b := node.First(); b != nil; b = b.Next() {
 				if *b != body {
 
-					dist := getModuloDistanceBetweenBodies(&body, b)
+					dist := f.config.getDistanceBetweenBodies(&body, b, xM, yM)
 
 					if dist == 0.0 {
-						var t testing.T
-						q.CheckIntegrity(&t)
+						if err := q.Check(); err != nil {
+							Error.Printf("%s", err.Error())
+						}
 
 						// c1 := body.Coord()
 						// c2 := b.Coord()
@@ -162,11 +179,10 @@ func (f *RepulsionField) ComputeFieldRecursive(x, y float64, q *quadtree.Quadtre
 
 					// if distance is inferior to cutoff, return
 					if dist < f.cutoff {
-						*v = 0.0
-						return
+						return true
 
 					} else {
-						*v += getRepulsionField(&body, b)
+						*v += f.config.getRepulsionField(&body, b, xM, yM)
 
 					}
 
@@ -178,5 +194,5 @@ func (f *RepulsionField) ComputeFieldRecursive(x, y float64, q *quadtree.Quadtre
 			}
 		}
 	}
-	return
+	return false
 }
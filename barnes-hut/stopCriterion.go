@@ -0,0 +1,173 @@
+package barneshut
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// a StopCriterion decides wether the simulation is over.
+//
+// Criteria are evaluated by RunSimulation before each step.
+// They can be composed with AnyOf and AllOf
+type StopCriterion interface {
+	Name() string // used to log which criterion fired
+	ShouldStop(r *Run) bool
+}
+
+// EnergyDecrease stops the simulation when the energy decrease ratio
+// of the last step is below Threshold (this is the historical criterion, see RunConfig.ShutdownCriteria)
+//
+// If Steps is above 1, the decrease ratio is the mean over the last Steps steps. It is usefull
+// with the LBFGS minimiser, whose steps are irregular.
+type EnergyDecrease struct {
+	Threshold float64
+	Steps     int
+}
+
+func (c EnergyDecrease) Name() string {
+	if c.Steps > 1 {
+		return fmt.Sprintf("energy decrease ratio over %d steps below %g", c.Steps, c.Threshold)
+	}
+	return fmt.Sprintf("energy decrease ratio below %g", c.Threshold)
+}
+
+func (c EnergyDecrease) ShouldStop(r *Run) bool {
+	if c.Steps <= 1 {
+		return r.energyDecreaseRatio <= c.Threshold
+	}
+	n := len(r.energies)
+	if n <= c.Steps {
+		return false
+	}
+	first, last := r.energies[n-1-c.Steps], r.energies[n-1]
+	return (first-last)/first <= c.Threshold*float64(c.Steps)
+}
+
+// MaxSteps stops the simulation when Steps steps have been performed
+type MaxSteps struct {
+	Steps int
+}
+
+func (c MaxSteps) Name() string {
+	return fmt.Sprintf("max steps %d", c.Steps)
+}
+
+func (c MaxSteps) ShouldStop(r *Run) bool {
+	return r.step >= c.Steps
+}
+
+// WallClock stops the simulation when Budget has elapsed since the start of RunSimulation
+type WallClock struct {
+	Budget time.Duration
+}
+
+func (c WallClock) Name() string {
+	return fmt.Sprintf("wall clock budget %s", c.Budget)
+}
+
+func (c WallClock) ShouldStop(r *Run) bool {
+	return !r.startTime.IsZero() && time.Since(r.startTime) >= c.Budget
+}
+
+// DensitySpread stops the simulation when the spread between the highest
+// and the lowest density tencile (see ComputeDensityTencilePerTerritory) is below Target.
+//
+// Tenciles are in percentage of the average density, therefore a Target of 20.0
+// means that the most populated villages are less than 20 points above the least populated ones
+type DensitySpread struct {
+	Target float64
+}
+
+func (c DensitySpread) Name() string {
+	return fmt.Sprintf("density tencile spread below %g", c.Target)
+}
+
+func (c DensitySpread) ShouldStop(r *Run) bool {
+	if r.step == 0 {
+		return false
+	}
+	return r.densityTenciles[9]-r.densityTenciles[0] <= c.Target
+}
+
+// Stirring stops the simulation when the ratio of original neighbours that are
+// still neighbours (see NeighbourDico.ComputeStirring) goes below Threshold.
+//
+// It prevents the spreading from mixing the bodies too much
+type Stirring struct {
+	Threshold float64
+}
+
+func (c Stirring) Name() string {
+	return fmt.Sprintf("stirring below %g", c.Threshold)
+}
+
+func (c Stirring) ShouldStop(r *Run) bool {
+	if r.step == 0 {
+		return false
+	}
+	return r.stirring < c.Threshold
+}
+
+// AnyOf stops the simulation when one of its criteria is met
+type AnyOf []StopCriterion
+
+func (c AnyOf) Name() string {
+	return "any of (" + joinNames(c) + ")"
+}
+
+func (c AnyOf) ShouldStop(r *Run) bool {
+	return firedCriterion(c, r) != nil
+}
+
+// AllOf stops the simulation when all of its criteria are met
+type AllOf []StopCriterion
+
+func (c AllOf) Name() string {
+	return "all of (" + joinNames(c) + ")"
+}
+
+func (c AllOf) ShouldStop(r *Run) bool {
+	for _, criterion := range c {
+		if !criterion.ShouldStop(r) {
+			return false
+		}
+	}
+	return len(c) > 0
+}
+
+func joinNames(criteria []StopCriterion) string {
+	names := make([]string, len(criteria))
+	for idx, criterion := range criteria {
+		names[idx] = criterion.Name()
+	}
+	return strings.Join(names, ", ")
+}
+
+// return the criterion that fired, or nil if the simulation has to go on
+//
+// within an AnyOf, the criterion that fired is returned instead of the AnyOf itself
+func firedCriterion(c StopCriterion, r *Run) StopCriterion {
+	if anyOf, ok := c.(AnyOf); ok {
+		for _, criterion := range anyOf {
+			if fired := firedCriterion(criterion, r); fired != nil {
+				return fired
+			}
+		}
+		return nil
+	}
+	if c.ShouldStop(r) {
+		return c
+	}
+	return nil
+}
+
+// StopCriterion returns the criterion evaluated by RunSimulation
+//
+// if no criterion is configured, the energy decrease ratio is compared to the ShutdownCriteria
+func (c *RunConfig) StopCriterion() StopCriterion {
+	if c.StopCriteria == nil {
+		return EnergyDecrease{Threshold: c.ShutdownCriteria}
+	}
+	return c.StopCriteria
+}
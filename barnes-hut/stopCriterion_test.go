@@ -0,0 +1,86 @@
+package barneshut
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+func TestStopCriteria(t *testing.T) {
+
+	var r Run
+	r.step = 10
+	r.energyDecreaseRatio = 0.001
+	r.energies = []float64{100, 90, 89.99, 89.9, 89.9}
+	r.densityTenciles = [10]float64{80, 85, 90, 95, 100, 100, 105, 110, 115, 120}
+	r.stirring = 0.7
+	r.startTime = time.Now().Add(-time.Hour)
+
+	cases := []struct {
+		c    StopCriterion
+		want bool
+	}{
+		{EnergyDecrease{Threshold: 0.01}, true},
+		{EnergyDecrease{Threshold: 0.0001}, false},
+		{EnergyDecrease{Threshold: 0.001, Steps: 3}, true},
+		{EnergyDecrease{Threshold: 0.001, Steps: 4}, false},
+		{EnergyDecrease{Threshold: 0.1, Steps: 5}, false},
+		{MaxSteps{Steps: 10}, true},
+		{MaxSteps{Steps: 11}, false},
+		{WallClock{Budget: time.Minute}, true},
+		{WallClock{Budget: 2 * time.Hour}, false},
+		{DensitySpread{Target: 40}, true},
+		{DensitySpread{Target: 39}, false},
+		{Stirring{Threshold: 0.8}, true},
+		{Stirring{Threshold: 0.6}, false},
+		{AnyOf{MaxSteps{Steps: 11}, Stirring{Threshold: 0.8}}, true},
+		{AnyOf{MaxSteps{Steps: 11}, Stirring{Threshold: 0.6}}, false},
+		{AllOf{MaxSteps{Steps: 10}, Stirring{Threshold: 0.8}}, true},
+		{AllOf{MaxSteps{Steps: 10}, Stirring{Threshold: 0.6}}, false},
+		{AllOf{}, false},
+	}
+	for _, c := range cases {
+		if got := c.c.ShouldStop(&r); got != c.want {
+			t.Errorf("%s got %t, want %t", c.c.Name(), got, c.want)
+		}
+	}
+}
+
+// test that the criterion that fired within a composition is reported
+func TestFiredCriterion(t *testing.T) {
+
+	var r Run
+	r.step = 10
+	r.energyDecreaseRatio = 1.0
+
+	c := AnyOf{EnergyDecrease{Threshold: 0.01}, AnyOf{WallClock{Budget: time.Hour}, MaxSteps{Steps: 5}}}
+	fired := firedCriterion(c, &r)
+	if fired == nil || fired.Name() != (MaxSteps{Steps: 5}).Name() {
+		t.Errorf("got %v, want %s", fired, MaxSteps{Steps: 5}.Name())
+	}
+}
+
+// test that RunSimulation stops on the configured criteria
+func TestRunSimulationMaxSteps(t *testing.T) {
+	bodies := make([]quadtree.Body, 200)
+	SpreadOnCircle(&bodies)
+
+	var r Run
+	defer r.Close()
+	config := NewRunConfig()
+	config.StopCriteria = AnyOf{EnergyDecrease{Threshold: 0.0}, MaxSteps{Steps: 3}}
+	r.SetConfig(config)
+	r.OutputDir = t.TempDir()
+	r.Init(&bodies)
+	r.SetState(RUNNING)
+
+	result, err := r.RunSimulation(context.Background())
+	if err != nil {
+		t.Fatalf("RunSimulation: %v", err)
+	}
+	if result.Step != 3 || result.StopCriterion != (MaxSteps{Steps: 3}).Name() {
+		t.Errorf("result got %#v", result)
+	}
+}
@@ -0,0 +1,138 @@
+package barneshut
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// nb of bodies handed to a worker at once by ComputeRepulsiveForceConcurrent
+const workerChunkSize = 64
+
+// WorkerStats is the load of a worker of the force computation
+type WorkerStats struct {
+	Worker    int           // rank of the worker
+	Tasks     int           // nb of tasks (chunks of bodies or dual tree nodes) done during the last computation
+	Busy      time.Duration // time spent on the tasks during the last computation
+	TotalBusy time.Duration // time spent on the tasks since the worker started
+}
+
+// a job is split into nbTasks tasks, workers take the next task until there is none left
+// (dynamic scheduling: a worker that meets a dense region takes less tasks than the others)
+type poolJob struct {
+	nbTasks int
+	next    int64 // next task to be taken
+	task    func(idx int) float64
+	mins    []float64 // min returned by the tasks of each worker
+	wg      sync.WaitGroup
+}
+
+// a workerPool keeps its goroutines from one computation to the next
+type workerPool struct {
+	jobs []chan *poolJob // one channel per worker
+
+	mutex sync.Mutex // guards jobs and stats against the readers of the stats
+	stats []WorkerStats
+}
+
+// set the nb of workers, new workers are started and workers above nbWorkers are stopped
+func (p *workerPool) resize(nbWorkers int) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for len(p.jobs) > nbWorkers {
+		close(p.jobs[len(p.jobs)-1])
+		p.jobs = p.jobs[:len(p.jobs)-1]
+	}
+	for len(p.jobs) < nbWorkers {
+		jobs := make(chan *poolJob)
+		go p.work(len(p.jobs), jobs)
+		p.jobs = append(p.jobs, jobs)
+	}
+	if len(p.stats) > nbWorkers {
+		p.stats = p.stats[:nbWorkers]
+	}
+	for len(p.stats) < nbWorkers {
+		p.stats = append(p.stats, WorkerStats{Worker: len(p.stats)})
+	}
+}
+
+// loop of a worker, until its channel is closed
+func (p *workerPool) work(worker int, jobs <-chan *poolJob) {
+	for job := range jobs {
+		p.do(worker, job)
+	}
+}
+
+// take the tasks of job until there is none left
+//
+// the job (and the run it refers to) is not referenced by the worker once it is done
+func (p *workerPool) do(worker int, job *poolJob) {
+
+	start := time.Now()
+	tasks := 0
+	minDistance := 2.0
+	for {
+		idx := int(atomic.AddInt64(&job.next, 1) - 1)
+		if idx >= job.nbTasks {
+			break
+		}
+		if res := job.task(idx); res < minDistance {
+			minDistance = res
+		}
+		tasks++
+	}
+	job.mins[worker] = minDistance
+	busy := time.Since(start)
+
+	p.mutex.Lock()
+	if worker < len(p.stats) { // the pool may have been resized since
+		p.stats[worker].Tasks = tasks
+		p.stats[worker].Busy = busy
+		p.stats[worker].TotalBusy += busy
+	}
+	p.mutex.Unlock()
+	job.wg.Done()
+}
+
+// run task for each index between 0 and nbTasks on the workers
+//
+// run and resize are called by the routine of the simulation only
+// return the min of the values returned by the tasks (2.0 if there is no task)
+func (p *workerPool) run(nbTasks int, task func(idx int) float64) float64 {
+
+	job := poolJob{nbTasks: nbTasks, task: task, mins: make([]float64, len(p.jobs))}
+	job.wg.Add(len(p.jobs))
+	for _, jobs := range p.jobs {
+		jobs <- &job
+	}
+	job.wg.Wait()
+
+	minDistance := 2.0
+	for _, m := range job.mins {
+		if m < minDistance {
+			minDistance = m
+		}
+	}
+	return minDistance
+}
+
+// stop all workers, the stats of the last computation are kept
+func (p *workerPool) stop() {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, jobs := range p.jobs {
+		close(jobs)
+	}
+	p.jobs = nil
+}
+
+// return a copy of the stats of the workers
+func (p *workerPool) workerStats() []WorkerStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]WorkerStats(nil), p.stats...)
+}
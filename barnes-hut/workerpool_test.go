@@ -0,0 +1,81 @@
+package barneshut
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// test that each task is run once, whatever the nb of workers
+func TestWorkerPool(t *testing.T) {
+
+	var p workerPool
+	defer p.stop()
+
+	for _, nbWorkers := range []int{1, 7, 3, 20} {
+		p.resize(nbWorkers)
+
+		counts := make([]int32, 1000)
+		min := p.run(len(counts), func(idx int) float64 {
+			atomic.AddInt32(&counts[idx], 1)
+			return float64(idx+1) / 1000.0
+		})
+		for idx, count := range counts {
+			if count != 1 {
+				t.Fatalf("%d workers, task %d run %d times", nbWorkers, idx, count)
+			}
+		}
+		if min != 0.001 {
+			t.Errorf("%d workers, min got %f", nbWorkers, min)
+		}
+
+		stats := p.workerStats()
+		tasks := 0
+		for _, s := range stats {
+			tasks += s.Tasks
+		}
+		if len(stats) != nbWorkers || tasks != len(counts) {
+			t.Errorf("%d workers, got %d stats and %d tasks", nbWorkers, len(stats), tasks)
+		}
+	}
+
+	if min := p.run(0, nil); min != 2.0 {
+		t.Errorf("min without task got %f", min)
+	}
+}
+
+// test that the stats of the workers follow the nb of routines of the run
+func TestWorkerStats(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 1000)
+	SpreadOnCircle(&bodies)
+	var r Run
+	defer r.Close()
+	r.Init(&bodies)
+	r.OutputDir = t.TempDir()
+
+	for _, nbRoutines := range []int{4, 2} {
+		r.SetNbRoutines(nbRoutines)
+		if err := r.OneStepOptional(false); err != nil {
+			t.Fatal(err)
+		}
+		stats := r.WorkerStats()
+		chunks := 0
+		for _, s := range stats {
+			chunks += s.Tasks
+			if s.Busy > s.TotalBusy {
+				t.Errorf("worker %d busy %v, total %v", s.Worker, s.Busy, s.TotalBusy)
+			}
+		}
+		if len(stats) != nbRoutines || chunks != (len(bodies)+workerChunkSize-1)/workerChunkSize {
+			t.Errorf("%d routines, got %d stats and %d chunks", nbRoutines, len(stats), chunks)
+		}
+	}
+	// the workers are started again at the step after Close
+	r.Close()
+	r.Close()
+	if err := r.OneStepOptional(false); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,434 @@
+/*
+Package bods provides a compact binary format for body files (the ".bods" files) as well as
+a streaming encoder and decoder for it.
+
+The historical format of a body file is the JSON serialization of a []quadtree.Body. For
+countries with more than one million bodies, the JSON file is large and slow to decode.
+The binary format is made of a header followed by one fixed length record per body
+
+	magic     4 bytes, "TKVB"
+	version   uint16
+	country   uint16 length followed by the country name
+	nbBodies  uint64
+	step      uint64
+	checksum  uint32, CRC32 (IEEE) of all body records
+	flags     uint8, 1 if the records carry the attributes of the bodies
+	records   nbBodies * (X float64, Y float64, M float64, ID uint32)
+	          followed, if the flag is set, by (row int32, col int32, population float64, urban uint8)
+
+All numbers are little endian. Records of version 1 files have no ID, the bodies of those
+files (and of JSON files without ID) are numbered from 1 in the order of the file when they are read.
+Files before version 3 have no flags and no attributes.
+
+The attributes (see Attributes) are optional, they record where a body comes from. The JSON format
+has no attributes.
+
+ReadBodies detects wether a body file is in the binary or in the JSON format,
+therefore the loaders do not have to know in which format the file was written.
+*/
+package bods
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// Magic are the first bytes of a binary body file
+var Magic = [4]byte{'T', 'K', 'V', 'B'}
+
+// Version is the version of the binary format written by the Encoder
+const Version uint16 = 3
+
+// size in bytes of one body record without attributes, per version
+var recordSizes = [...]int{1: 3 * 8, 2: 3*8 + 4, 3: 3*8 + 4}
+
+// size in bytes of the attributes of a record
+const attributesSize = 4 + 4 + 8 + 1
+
+// flag of the header if the records carry the attributes
+const flagAttributes uint8 = 1
+
+// headers announcing more bodies than this are refused (a body per inhabitant of the world
+// is below this)
+const maxBodies = 1 << 33
+
+// number of bodies allocated before the records are read, the slices grow with the records
+// so that a header lying on the number of bodies does not allocate much
+const initialBodies = 1 << 16
+
+// ErrChecksum is returned when the checksum of the records does not match the checksum of the header
+var ErrChecksum = errors.New("bods: checksum mismatch")
+
+// Header stores the meta data of a binary body file
+type Header struct {
+	Version  uint16 // version of the format (0 for a JSON file)
+	Country  string // country of the bodies
+	NbBodies int    // number of body records following the header
+	Step     int    // simulation step of the configuration
+	Checksum uint32 // CRC32 of the body records
+
+	Attributes bool // true if the records carry the attributes of the bodies
+}
+
+// Attributes are the optional attributes of a body, they record where the body comes from
+type Attributes struct {
+	Row, Col   int32   // GRUMP cell of the body
+	Population float64 // nb of people represented by the body
+	Urban      bool    // true if the cell of the body is urban
+}
+
+// encode the attributes of a body after the body in record
+func putAttributes(record []byte, a *Attributes) {
+	binary.LittleEndian.PutUint32(record[0:4], uint32(a.Row))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(a.Col))
+	binary.LittleEndian.PutUint64(record[8:16], math.Float64bits(a.Population))
+	record[16] = 0
+	if a.Urban {
+		record[16] = 1
+	}
+}
+
+// decode the attributes of a body
+func getAttributes(record []byte, a *Attributes) {
+	a.Row = int32(binary.LittleEndian.Uint32(record[0:4]))
+	a.Col = int32(binary.LittleEndian.Uint32(record[4:8]))
+	a.Population = math.Float64frombits(binary.LittleEndian.Uint64(record[8:16]))
+	a.Urban = record[16] != 0
+}
+
+// encode a body into a record
+func putRecord(record []byte, b *quadtree.Body) {
+	binary.LittleEndian.PutUint64(record[0:8], math.Float64bits(b.X))
+	binary.LittleEndian.PutUint64(record[8:16], math.Float64bits(b.Y))
+	binary.LittleEndian.PutUint64(record[16:24], math.Float64bits(b.M))
+	binary.LittleEndian.PutUint32(record[24:28], b.ID)
+}
+
+// decode a body from a record
+func getRecord(record []byte, b *quadtree.Body) {
+	b.X = math.Float64frombits(binary.LittleEndian.Uint64(record[0:8]))
+	b.Y = math.Float64frombits(binary.LittleEndian.Uint64(record[8:16]))
+	b.M = math.Float64frombits(binary.LittleEndian.Uint64(record[16:24]))
+	if len(record) > 24 {
+		b.ID = binary.LittleEndian.Uint32(record[24:28])
+	}
+}
+
+// Checksum computes the checksum of the records of bodies
+func Checksum(bodies []quadtree.Body) uint32 {
+	return checksum(bodies, nil)
+}
+
+// checksum of the records of bodies, with their attributes if attributes is not nil
+func checksum(bodies []quadtree.Body, attributes []Attributes) uint32 {
+	crc := crc32.NewIEEE()
+	record := newRecord(attributes != nil)
+	for idx := range bodies {
+		putRecord(record, &bodies[idx])
+		if attributes != nil {
+			putAttributes(record[recordSizes[Version]:], &attributes[idx])
+		}
+		crc.Write(record)
+	}
+	return crc.Sum32()
+}
+
+// a record of the current version
+func newRecord(withAttributes bool) []byte {
+	if withAttributes {
+		return make([]byte, recordSizes[Version]+attributesSize)
+	}
+	return make([]byte, recordSizes[Version])
+}
+
+// An Encoder writes bodies in the binary format to an output stream
+type Encoder struct {
+	w *bufio.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w)}
+}
+
+// Encode writes the header and the records of bodies
+//
+// bodies are streamed record by record, the only pass over the whole slice
+// before writing is the computation of the checksum
+func (e *Encoder) Encode(country string, step int, bodies []quadtree.Body) error {
+	return e.EncodeWithAttributes(country, step, bodies, nil)
+}
+
+// EncodeWithAttributes writes the header and the records of bodies with their attributes
+// (attributes[idx] are the attributes of bodies[idx]). If attributes is nil, the records have no attributes
+func (e *Encoder) EncodeWithAttributes(country string, step int, bodies []quadtree.Body, attributes []Attributes) error {
+
+	if len(country) > math.MaxUint16 {
+		return fmt.Errorf("bods: country name too long (%d bytes)", len(country))
+	}
+	if attributes != nil && len(attributes) != len(bodies) {
+		return fmt.Errorf("bods: %d attributes for %d bodies", len(attributes), len(bodies))
+	}
+
+	h := Header{
+		Version:    Version,
+		Country:    country,
+		NbBodies:   len(bodies),
+		Step:       step,
+		Checksum:   checksum(bodies, attributes),
+		Attributes: attributes != nil,
+	}
+	if err := e.writeHeader(h); err != nil {
+		return err
+	}
+
+	record := newRecord(h.Attributes)
+	for idx := range bodies {
+		putRecord(record, &bodies[idx])
+		if h.Attributes {
+			putAttributes(record[recordSizes[Version]:], &attributes[idx])
+		}
+		if _, err := e.w.Write(record); err != nil {
+			return err
+		}
+	}
+	return e.w.Flush()
+}
+
+func (e *Encoder) writeHeader(h Header) error {
+
+	buf := new(bytes.Buffer)
+	buf.Write(Magic[:])
+	binary.Write(buf, binary.LittleEndian, h.Version)
+	binary.Write(buf, binary.LittleEndian, uint16(len(h.Country)))
+	buf.WriteString(h.Country)
+	binary.Write(buf, binary.LittleEndian, uint64(h.NbBodies))
+	binary.Write(buf, binary.LittleEndian, uint64(h.Step))
+	binary.Write(buf, binary.LittleEndian, h.Checksum)
+	var flags uint8
+	if h.Attributes {
+		flags |= flagAttributes
+	}
+	binary.Write(buf, binary.LittleEndian, flags)
+
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// A Decoder reads bodies in the binary format from an input stream
+type Decoder struct {
+	r      *bufio.Reader
+	header Header
+	record []byte
+	read   int         // number of records read so far
+	crc    hash.Hash32 // checksum of the records read so far
+}
+
+// NewDecoder reads the header from r and returns a decoder
+// positioned on the first body record
+func NewDecoder(r io.Reader) (*Decoder, error) {
+
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	d := Decoder{r: br, crc: crc32.NewIEEE()}
+	if err := d.readHeader(); err != nil {
+		return nil, err
+	}
+	d.record = make([]byte, recordSizes[d.header.Version])
+	if d.header.Attributes {
+		d.record = make([]byte, recordSizes[d.header.Version]+attributesSize)
+	}
+	return &d, nil
+}
+
+func (d *Decoder) readHeader() error {
+
+	var magic [4]byte
+	if _, err := io.ReadFull(d.r, magic[:]); err != nil {
+		return fmt.Errorf("bods: reading magic: %w", err)
+	}
+	if magic != Magic {
+		return fmt.Errorf("bods: not a binary body file (magic %q)", magic[:])
+	}
+
+	if err := binary.Read(d.r, binary.LittleEndian, &d.header.Version); err != nil {
+		return fmt.Errorf("bods: reading version: %w", err)
+	}
+	if d.header.Version == 0 || d.header.Version > Version {
+		return fmt.Errorf("bods: unsupported version %d (max supported %d)", d.header.Version, Version)
+	}
+
+	var countryLen uint16
+	if err := binary.Read(d.r, binary.LittleEndian, &countryLen); err != nil {
+		return fmt.Errorf("bods: reading country: %w", err)
+	}
+	country := make([]byte, countryLen)
+	if _, err := io.ReadFull(d.r, country); err != nil {
+		return fmt.Errorf("bods: reading country: %w", err)
+	}
+	d.header.Country = string(country)
+
+	var nbBodies, step uint64
+	if err := binary.Read(d.r, binary.LittleEndian, &nbBodies); err != nil {
+		return fmt.Errorf("bods: reading nb of bodies: %w", err)
+	}
+	if err := binary.Read(d.r, binary.LittleEndian, &step); err != nil {
+		return fmt.Errorf("bods: reading step: %w", err)
+	}
+	if err := binary.Read(d.r, binary.LittleEndian, &d.header.Checksum); err != nil {
+		return fmt.Errorf("bods: reading checksum: %w", err)
+	}
+	if nbBodies > maxBodies {
+		return fmt.Errorf("bods: impossible nb of bodies %d", nbBodies)
+	}
+	d.header.NbBodies = int(nbBodies)
+	d.header.Step = int(step)
+
+	if d.header.Version >= 3 {
+		var flags uint8
+		if err := binary.Read(d.r, binary.LittleEndian, &flags); err != nil {
+			return fmt.Errorf("bods: reading flags: %w", err)
+		}
+		d.header.Attributes = flags&flagAttributes != 0
+	}
+
+	return nil
+}
+
+// Header returns the header of the body file
+func (d *Decoder) Header() Header { return d.header }
+
+// Next decodes the next body record into b
+// (the body of a version 1 record gets its rank in the file, from 1, as ID)
+//
+// Next returns io.EOF once all records have been read. When the last record
+// is read, the checksum is verified and ErrChecksum is returned on mismatch
+func (d *Decoder) Next(b *quadtree.Body) error {
+	return d.NextWithAttributes(b, nil)
+}
+
+// NextWithAttributes decodes the next body record into b and its attributes into a
+// (a is left unchanged if the records have no attributes or if a is nil)
+func (d *Decoder) NextWithAttributes(b *quadtree.Body, a *Attributes) error {
+
+	if d.read == d.header.NbBodies {
+		return io.EOF
+	}
+	if _, err := io.ReadFull(d.r, d.record); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("bods: reading body %d/%d: %w", d.read, d.header.NbBodies, err)
+	}
+	d.crc.Write(d.record)
+	getRecord(d.record, b)
+	if d.header.Attributes && a != nil {
+		getAttributes(d.record[recordSizes[d.header.Version]:], a)
+	}
+	d.read++
+	if d.header.Version == 1 {
+		b.ID = uint32(d.read)
+	}
+
+	if d.read == d.header.NbBodies && d.crc.Sum32() != d.header.Checksum {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// DecodeAll decodes all remaining bodies
+func (d *Decoder) DecodeAll() ([]quadtree.Body, error) {
+	bodies, _, err := d.DecodeAllWithAttributes()
+	return bodies, err
+}
+
+// DecodeAllWithAttributes decodes all remaining bodies and their attributes
+// (attributes are nil if the records have no attributes)
+func (d *Decoder) DecodeAllWithAttributes() ([]quadtree.Body, []Attributes, error) {
+
+	nb := d.header.NbBodies - d.read
+	capacity := nb
+	if capacity > initialBodies {
+		capacity = initialBodies
+	}
+	bodies := make([]quadtree.Body, 0, capacity)
+	var attributes []Attributes
+	if d.header.Attributes {
+		attributes = make([]Attributes, 0, capacity)
+	}
+	for idx := 0; idx < nb; idx++ {
+		var b quadtree.Body
+		var a Attributes
+		if err := d.NextWithAttributes(&b, &a); err != nil {
+			return nil, nil, err
+		}
+		bodies = append(bodies, b)
+		if attributes != nil {
+			attributes = append(attributes, a)
+		}
+	}
+	return bodies, attributes, nil
+}
+
+// IsBinary returns true if the stream behind br starts with the magic of the binary format
+//
+// br is not advanced
+func IsBinary(br *bufio.Reader) bool {
+	magic, err := br.Peek(len(Magic))
+	return err == nil && bytes.Equal(magic, Magic[:])
+}
+
+// ReadBodies reads a body file, either in the binary or in the JSON format
+//
+// for a JSON file, the returned header has a Version 0 and only NbBodies is set
+func ReadBodies(r io.Reader) (Header, []quadtree.Body, error) {
+	h, bodies, _, err := ReadBodiesWithAttributes(r)
+	return h, bodies, err
+}
+
+// ReadBodiesWithAttributes reads a body file as ReadBodies and returns the attributes of the bodies
+// (nil if the file has no attributes)
+func ReadBodiesWithAttributes(r io.Reader) (Header, []quadtree.Body, []Attributes, error) {
+
+	br := bufio.NewReader(r)
+
+	if IsBinary(br) {
+		d, err := NewDecoder(br)
+		if err != nil {
+			return Header{}, nil, nil, err
+		}
+		bodies, attributes, err := d.DecodeAllWithAttributes()
+		return d.Header(), bodies, attributes, err
+	}
+
+	var bodies []quadtree.Body
+	jsonParser := json.NewDecoder(br)
+	if err := jsonParser.Decode(&bodies); err != nil {
+		return Header{}, nil, nil, fmt.Errorf("bods: parsing JSON body file: %w", err)
+	}
+	quadtree.SetMissingIDs(bodies)
+	return Header{NbBodies: len(bodies)}, bodies, nil, nil
+}
+
+// WriteJSON writes bodies in the JSON format
+func WriteJSON(w io.Writer, bodies []quadtree.Body) error {
+	jsonBodies, err := json.MarshalIndent(bodies, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(jsonBodies)
+	return err
+}
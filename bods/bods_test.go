@@ -0,0 +1,232 @@
+package bods
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// test that bodies written in the binary format are read back unchanged
+func TestEncodeDecode(t *testing.T) {
+
+	var bodies []quadtree.Body
+	quadtree.InitBodiesUniform(&bodies, 1000)
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode("hti", 1334, bodies); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	d, err := NewDecoder(&buf)
+	if err != nil {
+		t.Fatalf("decode header: %v", err)
+	}
+	h := d.Header()
+	if h.Version != Version || h.Country != "hti" || h.NbBodies != len(bodies) || h.Step != 1334 {
+		t.Errorf("header got %#v", h)
+	}
+
+	got, err := d.DecodeAll()
+	if err != nil {
+		t.Fatalf("decode bodies: %v", err)
+	}
+	for idx := range bodies {
+		if got[idx].BodyXY != bodies[idx].BodyXY || got[idx].M != bodies[idx].M || got[idx].ID != bodies[idx].ID {
+			t.Fatalf("body %d got %#v, want %#v", idx, got[idx], bodies[idx])
+		}
+	}
+
+	var b quadtree.Body
+	if err := d.Next(&b); err != io.EOF {
+		t.Errorf("after last body, got %v, want io.EOF", err)
+	}
+}
+
+// test that a corrupted record is detected
+func TestChecksum(t *testing.T) {
+
+	var bodies []quadtree.Body
+	quadtree.InitBodiesUniform(&bodies, 10)
+
+	var buf bytes.Buffer
+	NewEncoder(&buf).Encode("fra", 0, bodies)
+
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xFF
+
+	_, _, err := ReadBodies(bytes.NewReader(data))
+	if err != ErrChecksum {
+		t.Errorf("got %v, want %v", err, ErrChecksum)
+	}
+}
+
+// test that ReadBodies reads both formats
+func TestReadBodiesDetectsFormat(t *testing.T) {
+
+	var bodies []quadtree.Body
+	quadtree.InitBodiesUniform(&bodies, 100)
+
+	var binBuf, jsonBuf bytes.Buffer
+	NewEncoder(&binBuf).Encode("fra", 12, bodies)
+	WriteJSON(&jsonBuf, bodies)
+
+	cases := []struct {
+		name        string
+		in          []byte
+		wantVersion uint16
+	}{
+		{"binary", binBuf.Bytes(), Version},
+		{"json", jsonBuf.Bytes(), 0},
+	}
+	for _, c := range cases {
+		h, got, err := ReadBodies(bytes.NewReader(c.in))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if h.Version != c.wantVersion || h.NbBodies != len(bodies) {
+			t.Errorf("%s: header got %#v", c.name, h)
+		}
+		for idx := range bodies {
+			if got[idx].BodyXY != bodies[idx].BodyXY {
+				t.Errorf("%s: body %d got %#v, want %#v", c.name, idx, got[idx].BodyXY, bodies[idx].BodyXY)
+				break
+			}
+		}
+	}
+}
+
+// test that a truncated file is reported
+func TestTruncated(t *testing.T) {
+
+	var bodies []quadtree.Body
+	quadtree.InitBodiesUniform(&bodies, 10)
+
+	var buf bytes.Buffer
+	NewEncoder(&buf).Encode("fra", 0, bodies)
+
+	data := buf.Bytes()
+	_, _, err := ReadBodies(bytes.NewReader(data[:len(data)-5]))
+	if err == nil {
+		t.Errorf("truncated file should not be decoded")
+	}
+}
+
+// test that a header with an impossible or a lying nb of bodies is reported without allocating all the bodies
+func TestNbBodiesInHeader(t *testing.T) {
+
+	var bodies []quadtree.Body
+	quadtree.InitBodiesUniform(&bodies, 10)
+
+	var buf bytes.Buffer
+	NewEncoder(&buf).EncodeWithAttributes("fra", 0, bodies, make([]Attributes, len(bodies)))
+	offset := len(Magic) + 2 + 2 + len("fra")
+
+	for _, nb := range []uint64{1 << 63, maxBodies + 1, 1 << 32} {
+		data := append([]byte(nil), buf.Bytes()...)
+		binary.LittleEndian.PutUint64(data[offset:], nb)
+		if _, _, _, err := ReadBodiesWithAttributes(bytes.NewReader(data)); err == nil {
+			t.Errorf("file with %d bodies in the header should not be decoded", nb)
+		}
+	}
+}
+
+// test that the bodies of a version 1 file, which has no ID, are numbered in the order of the file
+func TestReadVersion1(t *testing.T) {
+
+	var bodies []quadtree.Body
+	quadtree.InitBodiesUniform(&bodies, 10)
+
+	// records of version 1 are X, Y, M
+	var records bytes.Buffer
+	for _, b := range bodies {
+		for _, v := range []float64{b.X, b.Y, b.M} {
+			binary.Write(&records, binary.LittleEndian, math.Float64bits(v))
+		}
+	}
+	var buf bytes.Buffer
+	buf.Write(Magic[:])
+	binary.Write(&buf, binary.LittleEndian, uint16(1))
+	binary.Write(&buf, binary.LittleEndian, uint16(3))
+	buf.WriteString("fra")
+	binary.Write(&buf, binary.LittleEndian, uint64(len(bodies)))
+	binary.Write(&buf, binary.LittleEndian, uint64(5))
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(records.Bytes()))
+	buf.Write(records.Bytes())
+
+	h, got, err := ReadBodies(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 1 || h.Step != 5 || len(got) != len(bodies) {
+		t.Fatalf("header got %#v", h)
+	}
+	for idx := range bodies {
+		if got[idx].BodyXY != bodies[idx].BodyXY || got[idx].ID != uint32(idx+1) {
+			t.Fatalf("body %d got %#v, want %#v", idx, got[idx], bodies[idx])
+		}
+	}
+}
+
+// test that the bodies of a JSON file without ID are numbered
+func TestReadJSONWithoutID(t *testing.T) {
+
+	h, got, err := ReadBodies(bytes.NewBufferString(`[{"X":0.1,"Y":0.2,"M":1},{"X":0.3,"Y":0.4,"M":1}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.NbBodies != 2 || got[0].ID != 1 || got[1].ID != 2 {
+		t.Errorf("got %#v", got)
+	}
+}
+
+// test that the attributes are written with the bodies and read back unchanged
+func TestAttributes(t *testing.T) {
+
+	var bodies []quadtree.Body
+	quadtree.InitBodiesUniform(&bodies, 100)
+	attributes := make([]Attributes, len(bodies))
+	for idx := range attributes {
+		attributes[idx] = Attributes{Row: int32(idx / 10), Col: int32(idx % 10), Population: 12.5 * float64(idx), Urban: idx%3 == 0}
+	}
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).EncodeWithAttributes("hti", 7, bodies, attributes); err != nil {
+		t.Fatal(err)
+	}
+	h, got, gotAttributes, err := ReadBodiesWithAttributes(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.Attributes || len(got) != len(bodies) || len(gotAttributes) != len(attributes) {
+		t.Fatalf("header got %#v", h)
+	}
+	for idx := range bodies {
+		if got[idx].BodyXY != bodies[idx].BodyXY || got[idx].ID != bodies[idx].ID || gotAttributes[idx] != attributes[idx] {
+			t.Fatalf("body %d got %#v %#v", idx, got[idx], gotAttributes[idx])
+		}
+	}
+
+	// the attributes are covered by the checksum
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0x01
+	if _, _, err := ReadBodies(bytes.NewReader(data)); err != ErrChecksum {
+		t.Errorf("got %v, want %v", err, ErrChecksum)
+	}
+
+	if err := NewEncoder(&buf).EncodeWithAttributes("hti", 7, bodies, attributes[1:]); err == nil {
+		t.Errorf("attributes that do not match the bodies should be refused")
+	}
+
+	// files without attributes
+	buf.Reset()
+	NewEncoder(&buf).Encode("hti", 7, bodies)
+	if h, _, gotAttributes, err := ReadBodiesWithAttributes(&buf); err != nil || h.Attributes || gotAttributes != nil {
+		t.Errorf("got %#v %v %v", h, gotAttributes, err)
+	}
+}
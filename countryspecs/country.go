@@ -3,6 +3,8 @@ Package countryspecs country codes and lat/long coordinates of enclosing square
 */
 package countryspecs
 
+import "strings"
+
 // store country code
 type CountryCodeGrump struct {
 	Name      string
@@ -253,6 +255,9 @@ type CountryBoundary struct {
 }
 
 // store country code
+//
+// the borders are measured on the cells of the global GRUMP grids (cells of 1/120 degree), see
+// BenchmarkReadGrumpNationalities. Index is the GRUMP code of the country (CountryCodeGrump.CodeGrump)
 type CountryBorder struct {
 	Index     int
 	Name      string
@@ -264,235 +269,267 @@ type CountryBorder struct {
 }
 
 var CountryBorders = []CountryBorder{
-	{66, "", -76.688228, -78.937063, -61.333333, -57.700000, 1740750},
-	{114, "", 10.699301, 0.495338, 100.108333, 107.708333, 32265762},
-	{123, "", 43.609557, 42.747669, 5.758333, 6.533333, 573057},
-	{179, "Saudi Arabia", 22.250583, 2.536131, 34.508333, 55.675000, 448372446},
-	{84, "Guatemala", 5.131702, 0.287296, -92.225000, -88.216667, 11051040},
-	{87, "", -5.884615, -14.642191, -61.375000, -56.475000, 21445587},
-	{148, "", -39.280303, -43.044872, 163.575000, 168.141667, 3743068},
-	{4, "", 6.082751, 5.557692, -63.425000, -62.916667, 656},
-	{141, "", 3.952797, 3.774476, -62.233333, -62.133333, 22137},
-	{218, "United States", 68.822261, 6.439394, -179.133333, -66.933333, 3458834216},
-	{74, "", -2.763986, -10.412005, -3.241667, 1.200000, 20401282},
-	{128, "", 41.598485, 38.012238, 26.641667, 30.191667, 7464448},
-	{191, "", 40.003497, 39.537879, -56.408333, -56.141667, 98365},
-	{18, "Benin", -1.287879, -8.638695, 0.791667, 3.858333, 2464902},
-	{81, "Greece", 33.583916, 25.331585, 19.383333, 29.658333, 16834716},
-	{88, "", 10.768648, 10.293124, 113.850000, 114.450000, 154880},
-	{227, "", -31.751166, -33.148019, -178.191667, -176.116667, 65830},
-	{44, "", -0.128788, -21.051865, -81.716667, -66.866667, 58756544},
-	{96, "", 49.821096, 45.085664, -10.650000, -5.983333, 13380960},
-	{118, "", 0.723193, 0.247669, -61.058333, -60.858333, 97114},
-	{198, "", -21.141026, -21.755245, 55.375000, 55.808333, 61974},
-	{12, "", 5.032634, 4.160839, -61.891667, -61.650000, 8316},
-	{15, "", 33.772145, 29.611305, 44.791667, 50.616667, 1966950},
-	{161, "", -4.576923, -7.489510, -83.033333, -77.166667, 14744863},
-	{226, "Wallis and Futuna Islands", -31.582751, -40.112471, 166.533333, 170.250000, 3808100},
-	{3, "", -21.250000, -37.487179, 11.691667, 24.091667, 4487838},
-	{154, "", 47.621795, 44.293124, 3.383333, 7.233333, 10427032},
-	{116, "", -5.884615, -10.867716, -11.475000, -7.358333, 13132708},
-	{140, "", 16.405594, 1.456294, -17.050000, -4.833333, 181719020},
-	{75, "", 26.936480, 26.886946, -5.341667, -5.325000, 1125},
-	{119, "", 40.142191, 39.894522, 9.491667, 9.641667, 32725},
-	{184, "", -25.420746, -60.579837, -14.408333, -5.616667, 109480},
-	{56, "", 7.648019, 4.814685, -71.983333, -68.308333, 3373720},
-	{126, "Morocco", 26.668998, 8.658508, -17.091667, -0.991667, 113746500},
-	{132, "", 1.377040, -10.610140, 160.816667, 172.175000, 219252},
-	{225, "Vietnam", 11.749417, -5.864802, 102.166667, 109.483333, 91146375},
-	{10, "", 33.048951, 30.126457, 43.466667, 46.633333, 433210},
-	{27, "", 22.468531, 22.290210, -64.883333, -64.641667, 4698},
-	{108, "", 35.357226, 30.532634, 69.291667, 80.291667, 31399488},
-	{173, "", -25.351399, -48.919580, -154.675000, -134.866667, 1316184},
-	{187, "", -4.160839, -7.806527, -13.291667, -10.275000, 16122579},
-	{170, "Portugal", 34.059441, 22.745921, -31.441667, -6.183333, 23825160},
-	{35, "Canada", 82.761072, 33.514569, -140.983333, -52.608333, 832800920},
-	{38, "China", 47.621795, 5.547786, 73.566667, 134.783333, 516441052},
-	{151, "Nigeria", 0.465618, -10.956876, 2.691667, 14.691667, 161691102},
-	{183, "", -14.295455, -14.662005, 103.650000, 104.100000, 153903},
-	{20, "", 15.613054, 8.430653, 88.041667, 92.683333, 3541680},
-	{70, "", -13.284965, -20.774476, 8.708333, 14.508333, 21659680},
-	{77, "", 5.508159, 2.773893, -63.075000, -60.991667, 187957},
-	{150, "", -50.524476, -50.682984, 167.908333, 167.991667, 12900},
-	{30, "", -0.198135, -0.544872, -59.633333, -59.408333, 17670},
-	{31, "", -10.045455, -11.273893, 114.250000, 115.366667, 213528},
-	{216, "", 46.234848, 36.724359, 22.158333, 40.241667, 227121192},
-	{92, "", 41.707459, 38.339161, 16.133333, 22.900000, 14522200},
-	{166, "Papua New Guinea", -17.089161, -29.898601, 140.850000, 159.491667, 92310774},
-	{186, "", -22.082168, -30.661422, 155.525000, 168.858333, 7210662},
-	{125, "", 10.372378, 10.243590, 113.541667, 113.600000, 5500},
-	{144, "", -27.184149, -36.397436, 32.691667, 35.925000, 16507296},
-	{207, "", -25.717949, -27.392191, 124.075000, 127.358333, 3750840},
-	{217, "", -51.812354, -57.627622, -58.433333, -53.175000, 52960586},
-	{107, "Kenya", -9.609557, -21.596737, 33.925000, 41.908333, 72620579},
-	{129, "Madagascar", -30.245338, -46.492424, 43.200000, 50.500000, 95174781},
-	{139, "Mozambique", -28.491841, -47.978438, 30.233333, 40.858333, 132616286},
-	{206, "", 34.822261, 25.737762, 52.458333, 66.691667, 150734732},
-	{53, "", -0.921329, -3.071096, 41.791667, 43.433333, 1377470},
-	{79, "", -0.970862, -3.130536, -16.700000, -13.625000, 3323135},
-	{131, "Mexico", 22.844988, 1.238345, -117.283333, -86.691667, 327848198},
-	{17, "", 45.184732, 42.807110, 2.566667, 6.416667, 955247},
-	{156, "", 20.130536, 15.296037, 80.066667, 88.200000, 29073564},
-	{157, "", -16.643357, -16.702797, 166.908333, 166.958333, 5495},
-	{159, "", 15.464452, 3.744755, 51.900000, 59.850000, 62375700},
-	{172, "", 22.646853, 21.081585, 34.250000, 35.583333, 1426912},
-	{190, "Somalia", -1.793124, -18.020396, 41.008333, 51.425000, 142248820},
-	{200, "", 10.075175, 9.124126, -72.475000, -71.066667, 224000},
-	{2, "Afghanistan", 29.700466, 18.882284, 60.500000, 74.883333, 1793518},
-	{76, "", -0.980769, -7.479604, -15.075000, -7.633333, 22181132},
-	{80, "", -11.541375, -17.782634, 5.616667, 11.341667, 2546560},
-	{136, "Myanmar", 17.881702, -4.626457, 92.208333, 101.183333, 114963656},
-	{113, "", 19.734266, 17.871795, 46.575000, 48.441667, 2662167},
-	{137, "Mongolia", 45.947552, 33.375874, 87.766667, 119.933333, 361628625},
-	{16, "", -18.793124, -21.349068, 29.008333, 30.858333, 471488},
-	{45, "", -29.551865, -30.770396, 43.225000, 44.550000, 101430},
-	{93, "Indonesia", -9.025058, -29.115967, 95.075000, 141.016667, 211161243},
-	{112, "", 29.849068, 23.310606, 125.100000, 130.908333, 16688000},
+	{66, "", -51.008333, -52.900000, -61.333333, -57.700000, 1740750},
+	{114, "", 22.500000, 13.916667, 100.108333, 107.708333, 32265762},
+	{123, "", 50.183333, 49.458333, 5.758333, 6.533333, 573057},
+	{179, "Saudi Arabia", 32.216667, 15.633333, 34.508333, 55.675000, 448372446},
+	{84, "Guatemala", 17.816667, 13.741667, -92.225000, -88.216667, 11051040},
+	{87, "", 8.550000, 1.183333, -61.375000, -56.475000, 21445587},
+	{148, "", -19.541667, -22.708333, 163.575000, 168.141667, 3743068},
+	{4, "", 18.616667, 18.175000, -63.425000, -62.916667, 656},
+	{141, "", 16.825000, 16.675000, -62.233333, -62.133333, 22137},
+	{218, "United States", 71.391667, 18.916667, -179.133333, -66.933333, 3458834216},
+	{74, "", 11.175000, 4.741667, -3.241667, 1.200000, 20401282},
+	{128, "", 48.491667, 45.475000, 26.641667, 30.191667, 7464448},
+	{191, "", 47.150000, 46.758333, -56.408333, -56.141667, 98365},
+	{18, "Benin", 12.416667, 6.233333, 0.791667, 3.858333, 2464902},
+	{81, "Greece", 41.750000, 34.808333, 19.383333, 29.658333, 16834716},
+	{88, "", 22.558333, 22.158333, 113.850000, 114.450000, 154880},
+	{227, "", -13.208333, -14.383333, -178.191667, -176.116667, 65830},
+	{44, "", 13.391667, -4.208333, -81.716667, -66.866667, 58756544},
+	{96, "", 55.408333, 51.425000, -10.650000, -5.983333, 13380960},
+	{118, "", 14.108333, 13.708333, -61.058333, -60.858333, 97114},
+	{198, "", -4.283333, -4.800000, 55.375000, 55.808333, 61974},
+	{12, "", 17.733333, 17.000000, -61.891667, -61.650000, 8316},
+	{15, "", 41.908333, 38.408333, 44.791667, 50.616667, 1966950},
+	{161, "", 9.650000, 7.200000, -83.033333, -77.166667, 14744863},
+	{226, "Wallis and Futuna Islands", -13.066667, -20.241667, 166.533333, 170.250000, 3808100},
+	{3, "", -4.375000, -18.033333, 11.691667, 24.091667, 4487838},
+	{154, "", 53.558333, 50.758333, 3.383333, 7.233333, 10427032},
+	{116, "", 8.550000, 4.358333, -11.475000, -7.358333, 13132708},
+	{140, "", 27.300000, 14.725000, -17.050000, -4.833333, 181719020},
+	{75, "", 36.158333, 36.116667, -5.341667, -5.325000, 1125},
+	{119, "", 47.266667, 47.058333, 9.491667, 9.641667, 32725},
+	{184, "", -7.883333, -37.458333, -14.408333, -5.616667, 109480},
+	{56, "", 19.933333, 17.550000, -71.983333, -68.308333, 3373720},
+	{126, "Morocco", 35.933333, 20.783333, -17.091667, -0.991667, 113746500},
+	{132, "", 14.658333, 4.575000, 160.816667, 172.175000, 219252},
+	{225, "Vietnam", 23.383333, 8.566667, 102.166667, 109.483333, 91146375},
+	{10, "", 41.300000, 38.841667, 43.466667, 46.633333, 433210},
+	{27, "", 32.400000, 32.250000, -64.883333, -64.641667, 4698},
+	{108, "", 43.241667, 39.183333, 69.291667, 80.291667, 31399488},
+	{173, "", -7.825000, -27.650000, -154.675000, -134.866667, 1316184},
+	{187, "", 10.000000, 6.933333, -13.291667, -10.275000, 16122579},
+	{170, "Portugal", 42.150000, 32.633333, -31.441667, -6.183333, 23825160},
+	{35, "Canada", 83.116667, 41.691667, -140.983333, -52.608333, 832800920},
+	{38, "China", 53.558333, 18.166667, 73.566667, 134.783333, 516441052},
+	{151, "Nigeria", 13.891667, 4.283333, 2.691667, 14.691667, 161691102},
+	{183, "", 1.475000, 1.166667, 103.650000, 104.100000, 153903},
+	{20, "", 26.633333, 20.591667, 88.041667, 92.683333, 3541680},
+	{70, "", 2.325000, -3.975000, 8.708333, 14.508333, 21659680},
+	{77, "", 18.133333, 15.833333, -63.075000, -60.991667, 187957},
+	{150, "", -29.000000, -29.133333, 167.908333, 167.991667, 12900},
+	{30, "", 13.333333, 13.041667, -59.633333, -59.408333, 17670},
+	{31, "", 5.050000, 4.016667, 114.250000, 115.366667, 213528},
+	{216, "", 52.391667, 44.391667, 22.158333, 40.241667, 227121192},
+	{92, "", 48.583333, 45.750000, 16.133333, 22.900000, 14522200},
+	{166, "Papua New Guinea", -0.875000, -11.650000, 140.850000, 159.491667, 92310774},
+	{186, "", -5.075000, -12.291667, 155.525000, 168.858333, 7210662},
+	{125, "", 22.225000, 22.116667, 113.541667, 113.600000, 5500},
+	{144, "", -9.366667, -17.116667, 32.691667, 35.925000, 16507296},
+	{207, "", -8.133333, -9.541667, 124.075000, 127.358333, 3750840},
+	{217, "", -30.083333, -34.975000, -58.433333, -53.175000, 52960586},
+	{107, "Kenya", 5.416667, -4.666667, 33.925000, 41.908333, 72620579},
+	{129, "Madagascar", -11.941667, -25.608333, 43.200000, 50.500000, 95174781},
+	{139, "Mozambique", -10.466667, -26.858333, 30.233333, 40.858333, 132616286},
+	{206, "", 42.791667, 35.150000, 52.458333, 66.691667, 150734732},
+	{53, "", 12.725000, 10.916667, 41.791667, 43.433333, 1377470},
+	{79, "", 12.683333, 10.866667, -16.700000, -13.625000, 3323135},
+	{131, "Mexico", 32.716667, 14.541667, -117.283333, -86.691667, 327848198},
+	{17, "", 51.508333, 49.508333, 2.566667, 6.416667, 955247},
+	{156, "", 30.433333, 26.366667, 80.066667, 88.200000, 29073564},
+	{157, "", -0.500000, -0.550000, 166.908333, 166.958333, 5495},
+	{159, "", 26.508333, 16.650000, 51.900000, 59.850000, 62375700},
+	{172, "", 32.550000, 31.233333, 34.250000, 35.583333, 1426912},
+	{190, "Somalia", 11.991667, -1.658333, 41.008333, 51.425000, 142248820},
+	{200, "", 21.975000, 21.175000, -72.475000, -71.066667, 224000},
+	{2, "Afghanistan", 38.483333, 29.383333, 60.500000, 74.883333, 1793518},
+	{76, "", 12.675000, 7.208333, -15.075000, -7.633333, 22181132},
+	{80, "", 3.791667, -1.458333, 5.616667, 11.341667, 2546560},
+	{136, "Myanmar", 28.541667, 9.608333, 92.208333, 101.183333, 114963656},
+	{113, "", 30.100000, 28.533333, 46.575000, 48.441667, 2662167},
+	{137, "Mongolia", 52.150000, 41.575000, 87.766667, 119.933333, 361628625},
+	{16, "", -2.308333, -4.458333, 29.008333, 30.858333, 471488},
+	{45, "", -11.358333, -12.383333, 43.225000, 44.550000, 101430},
+	{93, "Indonesia", 5.908333, -10.991667, 95.075000, 141.016667, 211161243},
+	{112, "", 38.608333, 33.108333, 125.100000, 130.908333, 16688000},
 	{0, "Zombieland", -90.000000, 90.000000, 180.000000, -180.000000, 0},
-	{203, "", 8.272145, -9.361888, 97.366667, 105.650000, 126869316},
-	{29, "Brazil", -9.787879, -56.161422, -73.966667, -28.825000, 294977618},
-	{164, "", 9.064685, -10.570513, 116.941667, 126.616667, 60721000},
-	{188, "", 1.119464, -0.416084, -90.108333, -87.666667, 4653376},
-	{210, "", 28.353147, 19.912587, 7.541667, 11.608333, 46082400},
-	{1, "", -1.030303, -1.287879, -70.050000, -69.850000, 286},
-	{101, "Italy", 39.934149, 26.153846, 6.641667, 18.533333, 48590797},
-	{143, "", -39.418998, -40.449301, 57.316667, 63.516667, 387959},
-	{155, "Norway", 68.584499, 52.862471, 4.666667, 31.150000, 138974860},
-	{117, "Libya", 23.389860, 7.152681, 9.408333, 25.166667, 248297166},
-	{138, "", 8.381119, 0.733100, 144.900000, 146.091667, 111780},
-	{204, "", 32.741841, 27.560606, 67.408333, 75.141667, 40672704},
-	{230, "Zimbabwe", -42.351399, -57.449301, 16.475000, 32.900000, 374700820},
-	{149, "Niger", 11.917832, -2.129953, 0.183333, 16.000000, 215927671},
-	{221, "", -0.138695, -1.297786, -61.491667, -61.100000, 155584},
-	{208, "", -34.980769, -42.608974, -176.200000, -173.700000, 294320},
-	{26, "", 5.944056, 2.853147, -89.208333, -87.458333, 723814},
-	{49, "", 7.449883, 6.855478, -81.416667, -79.716667, 23324},
-	{67, "France", 44.689394, 33.098485, -5.125000, 9.575000, 62408222},
-	{181, "Sudan", 11.472028, -11.888112, 21.858333, 38.591667, 545809844},
-	{7, "", 5.448718, -1.812937, -69.141667, -62.933333, 8442},
-	{97, "Iran", 31.236014, 13.750583, 44.066667, 63.325000, 217311622},
-	{171, "", -38.983100, -48.840326, -62.625000, -54.258333, 86178186},
-	{100, "", 23.696970, 19.020979, 34.283333, 35.941667, 3024300},
-	{167, "Poland", 49.147436, 42.212704, 14.141667, 24.150000, 98616506},
-	{194, "", 42.935897, 40.696970, 16.850000, 22.566667, 16724352},
-	{199, "", 28.313520, 22.369464, 35.741667, 42.391667, 52623560},
-	{21, "", 36.516317, 32.989510, 22.375000, 28.625000, 3710469},
-	{43, "", -27.946970, -42.133450, -165.891667, -157.300000, 48332},
-	{47, "", -2.714452, -6.488928, -85.941667, -82.550000, 2895811},
-	{64, "Finland", 67.276807, 55.012238, 19.333333, 31.583333, 56421376},
-	{32, "", 17.624126, 15.712121, 88.808333, 92.133333, 1605216},
-	{61, "Spain", 36.011072, 16.811772, -18.150000, 4.341667, 47312332},
-	{102, "", 5.983683, 5.002914, -78.350000, -76.166667, 1436670},
-	{224, "", 5.894522, 4.973193, -65.075000, -64.550000, 143808},
-	{168, "", 5.973776, 5.220862, -67.941667, -65.208333, 1942080},
-	{19, "", 1.882284, -4.864219, -5.500000, 2.416667, 6243685},
-	{63, "Ethiopia", 1.644522, -11.997086, 33.016667, 47.991667, 83647620},
-	{95, "India", 28.046037, -8.024476, 67.400000, 97.408333, 392747765},
-	{111, "", 4.666084, 4.279720, -62.858333, -62.533333, 46287},
-	{178, "", -17.307110, -19.417249, 28.875000, 30.900000, 5062142},
-	{220, "", 33.772145, 33.772145, 12.466667, 12.466667, 220},
-	{6, "", 34.663753, 34.406177, 1.433333, 1.791667, 4410},
-	{69, "", -4.131119, -9.787879, 138.066667, 163.050000, 82800},
-	{89, "", 3.586247, -0.604312, -89.333333, -83.141667, 12125894},
-	{133, "", 34.307110, 32.533800, 20.483333, 23.041667, 5135130},
-	{52, "", 49.405012, 40.152098, 5.883333, 15.050000, 34470696},
-	{85, "Guyana", -9.173660, -13.463287, -54.516667, -51.600000, 8303650},
-	{135, "", 26.847319, 26.520396, 14.200000, 14.591667, 77085},
-	{209, "", -2.565851, -4.111305, -61.908333, -60.475000, 1369786},
-	{110, "", -10.421911, -33.346154, -176.633333, 176.866667, 282260},
-	{152, "", 1.812937, -3.308858, -87.675000, -82.583333, 21977072},
-	{160, "Pakistan", 27.828089, 12.125874, 60.900000, 75.391667, 170228800},
-	{189, "", 36.248834, 36.139860, 12.425000, 12.525000, 18900},
-	{177, "Russia", 81.265152, 32.930070, -179.991667, 180.000000, 7310478603},
-	{193, "", -8.906177, -13.859557, -58.066667, -53.966667, 32376715},
-	{196, "Sweden", 66.058275, 49.741841, 11.000000, 24.175000, 218839096},
-	{201, "Tanzania", 11.828671, -7.192308, 13.491667, 24.008333, 308843736},
-	{36, "", 40.786131, 38.428322, 5.983333, 10.500000, 2403504},
-	{48, "", 11.640443, 7.529138, -84.941667, -74.116667, 6973008},
-	{57, "", 28.055944, 6.498834, -8.658333, 11.983333, 175224384},
-	{121, "", -50.019231, -52.505828, 27.033333, 29.466667, 4955797},
-	{212, "", -22.755828, -28.848485, 176.075000, 179.875000, 27348},
-	{5, "", 34.673660, 31.087413, 19.291667, 21.066667, 221065},
-	{9, "Argentina", -41.945221, -81.502914, -73.558333, -53.583333, 35811540},
-	{115, "", 25.192890, 23.261072, 35.125000, 36.641667, 1686130},
-	{127, "", 35.961538, 35.931818, 7.425000, 7.450000, 889},
-	{158, "New Zealand", -56.627040, -73.131702, 166.433333, 178.566667, 66877292},
-	{39, "Cote D'Ivoire", -3.289044, -10.867716, -8.583333, -2.483333, 14714310},
-	{46, "", 4.398601, 1.555361, -25.350000, -22.658333, 259026},
-	{68, "", 58.142774, 56.944056, -7.666667, -6.250000, 297636},
-	{98, "Iraq", 28.382867, 18.565268, 38.825000, 48.591667, 58671326},
-	{11, "", -29.215035, -33.405594, -171.041667, -168.116667, 3949},
-	{174, "", 15.078089, 13.076923, 50.758333, 52.441667, 2621832},
-	{222, "Venezuela", -1.565268, -15.157343, -73.366667, -59.775000, 238238190},
-	{229, "Yemen", 6.538462, -1.644522, 41.825000, 54.541667, 116095214},
-	{51, "", 44.649767, 41.687646, 12.116667, 18.866667, 7222518},
-	{86, "", 0.188228, -0.317016, 144.625000, 144.966667, 65790},
-	{99, "Iceland", 63.096154, 59.202797, -24.516667, -13.475000, 25687233},
-	{60, "", 5.349650, -1.347319, 36.458333, 43.141667, 8939940},
-	{169, "", 35.079837, 28.650350, 124.200000, 130.683333, 31891652},
-	{219, "", 38.160839, 28.164918, 56.016667, 73.141667, 142033545},
-	{82, "", -1.396853, -1.783217, -61.783333, -61.558333, 37966},
-	{104, "", 23.617716, 18.654429, 34.975000, 39.325000, 12728144},
-	{109, "", 1.406760, -4.269814, 102.358333, 107.633333, 23429332},
-	{120, "", -4.349068, -9.005245, 79.533333, 81.891667, 9486000},
-	{50, "", 26.401515, 25.044289, 32.283333, 34.608333, 684350},
-	{62, "", 55.071678, 52.327506, 21.775000, 28.216667, 6171046},
-	{73, "", 43.084499, 42.688228, -2.700000, -2.141667, 20148},
-	{130, "", -7.598485, -16.881119, 72.650000, 73.775000, 303290},
-	{182, "", 3.794289, -1.406760, -17.525000, -11.350000, 43193696},
-	{195, "", 39.676573, 37.962704, 13.391667, 16.591667, 6633900},
-	{223, "", 6.251166, 5.755828, -64.766667, -64.258333, 85409},
-	{65, "", -30.859557, -41.053613, -179.991667, 180.000000, 1663805},
-	{71, "United Kingdom", 56.300117, 43.243007, -8.633333, 1.783333, 35849675},
-	{91, "Haiti", 7.826340, 5.369464, -74.466667, -71.625000, 3151512},
-	{176, "", 41.331002, 35.822844, 20.275000, 29.733333, 69756016},
-	{192, "", -14.018065, -16.019231, 6.483333, 7.475000, 251520},
-	{28, "Bolivia", -27.550699, -43.262821, -69.625000, -57.450000, 36830220},
-	{162, "", -44.471445, -45.858392, -130.741667, -124.766667, 16686},
-	{33, "", -37.189977, -48.018065, 20.016667, 29.366667, 24055647},
-	{94, "", 48.652098, 48.206294, -4.816667, -4.300000, 119192},
-	{142, "", 1.644522, 1.069930, -61.216667, -60.800000, 220668},
-	{211, "", 34.009907, 26.540210, 25.683333, 44.841667, 245504830},
-	{42, "", -11.650350, -22.022727, 11.225000, 18.658333, 16769886},
-	{103, "", 42.519814, 42.410839, -2.250000, -2.008333, 27707},
-	{163, "Peru", -16.068765, -37.853730, -81.316667, -68.675000, 248499857},
-	{202, "", -2.803613, -8.787296, -0.133333, 1.808333, 13632980},
-	{23, "", 16.326340, 8.817016, -79.608333, -72.700000, 493120},
-	{147, "Namibia", -36.209207, -50.474942, 11.725000, 25.266667, 153143571},
-	{13, "Australia", -26.916667, -68.049534, 96.833333, 159.116667, 130601627},
-	{78, "", 0.386364, -0.515152, -16.816667, -13.791667, 1031316},
-	{124, "", 53.001166, 50.148019, 20.983333, 28.250000, 16955016},
-	{134, "Mali", 13.671329, -3.962704, -12.225000, 4.250000, 205155206},
-	{205, "", -26.193473, -27.273310, -172.516667, -171.166667, 23780},
-	{90, "", 39.290210, 34.346737, 13.508333, 19.450000, 8649270},
-	{106, "Kazakhstan", 49.850816, 32.187063, 46.508333, 87.316667, 490823460},
-	{175, "", -40.835664, -41.449883, 55.233333, 55.858333, 582750},
-	{228, "", -32.008741, -32.761655, -172.783333, -171.400000, 852036},
-	{24, "", 37.774476, 34.386364, 15.750000, 19.625000, 1997400},
-	{122, "", 51.059441, 48.037879, 20.966667, 26.841667, 16211726},
-	{214, "", -17.227855, -29.997669, 29.600000, 40.458333, 224574168},
-	{215, "", -11.036131, -17.802448, 29.591667, 35.041667, 52487520},
-	{34, "", -2.962121, -13.393939, 14.433333, 27.466667, 24833464},
-	{37, "Chile", -36.872960, -82.335082, -75.700000, -66.983333, 42019864},
-	{54, "", 2.536131, 2.030886, -61.475000, -61.233333, 54648},
-	{55, "", 52.614802, 48.820513, 8.083333, 15.208333, 5180450},
-	{25, "", 50.732517, 44.917249, 23.200000, 32.791667, 10125950},
-	{83, "Greenland", 83.375291, 55.022145, -73.250000, -11.300000, 163158080},
-	{197, "", -46.621212, -48.513403, 30.808333, 32.141667, 4461459},
-	{59, "Egypt", 21.596737, 9.787879, 24.716667, 35.833333, 75450026},
-	{40, "", -0.663753, -14.077506, 8.508333, 16.200000, 21829040},
-	{185, "", 80.056527, 72.339161, 10.500000, 29.708333, 35002925},
-	{165, "", -6.419580, -12.581585, 131.183333, 134.741667, 129690},
-	{213, "", 14.027972, 9.995921, 119.316667, 122.016667, 10026123},
-	{145, "", -7.321096, -15.018648, 99.666667, 119.291667, 56740675},
-	{22, "", 15.196970, 14.622378, 50.400000, 50.700000, 21714},
-	{41, "", -9.649184, -32.038462, 12.225000, 31.291667, 110780401},
-	{58, "", -14.067599, -21.973193, -92.000000, -75.183333, 17476734},
-	{72, "", 35.763403, 32.741841, 40.025000, 46.725000, 7859736},
-	{8, "", 14.959207, 10.709207, 51.133333, 56.391667, 813608},
-	{14, "Austria", 42.222611, 39.092075, 9.550000, 17.166667, 2010190},
-	{105, "Japan", 38.071678, 12.532051, 122.941667, 146.858333, 59144400},
-	{153, "", -38.576923, -38.814685, -169.941667, -169.766667, 56916},
-	{146, "", -31.067599, -31.503497, 45.025000, 45.308333, 94462},
-	{180, "", 38.864219, 33.712704, 18.466667, 23.008333, 29568420},
+	{203, "", 20.458333, 5.625000, 97.366667, 105.650000, 126869316},
+	{29, "Brazil", 5.266667, -33.741667, -73.966667, -28.825000, 294977618},
+	{164, "", 21.125000, 4.608333, 116.941667, 126.616667, 60721000},
+	{188, "", 14.441667, 13.150000, -90.108333, -87.666667, 4653376},
+	{210, "", 37.350000, 30.250000, 7.541667, 11.608333, 46082400},
+	{1, "", 12.633333, 12.416667, -70.050000, -69.850000, 286},
+	{101, "Italy", 47.091667, 35.500000, 6.641667, 18.533333, 48590797},
+	{143, "", -19.658333, -20.525000, 57.316667, 63.516667, 387959},
+	{155, "Norway", 71.191667, 57.966667, 4.666667, 31.150000, 138974860},
+	{117, "Libya", 33.175000, 19.516667, 9.408333, 25.166667, 248297166},
+	{138, "", 20.550000, 14.116667, 144.900000, 146.091667, 111780},
+	{204, "", 41.041667, 36.683333, 67.408333, 75.141667, 40672704},
+	{230, "Zimbabwe", -22.125000, -34.825000, 16.475000, 32.900000, 374700820},
+	{149, "Niger", 23.525000, 11.708333, 0.183333, 16.000000, 215927671},
+	{221, "", 13.383333, 12.408333, -61.491667, -61.100000, 155584},
+	{208, "", -15.925000, -22.341667, -176.200000, -173.700000, 294320},
+	{26, "", 18.500000, 15.900000, -89.208333, -87.458333, 723814},
+	{49, "", 19.766667, 19.266667, -81.416667, -79.716667, 23324},
+	{67, "France", 51.091667, 41.341667, -5.125000, 9.575000, 62408222},
+	{181, "Sudan", 23.150000, 3.500000, 21.858333, 38.591667, 545809844},
+	{7, "", 18.083333, 11.975000, -69.141667, -62.933333, 8442},
+	{97, "Iran", 39.775000, 25.066667, 44.066667, 63.325000, 217311622},
+	{171, "", -19.291667, -27.583333, -62.625000, -54.258333, 86178186},
+	{100, "", 33.433333, 29.500000, 34.283333, 35.941667, 3024300},
+	{167, "Poland", 54.841667, 49.008333, 14.141667, 24.150000, 98616506},
+	{194, "", 49.616667, 47.733333, 16.850000, 22.566667, 16724352},
+	{199, "", 37.316667, 32.316667, 35.741667, 42.391667, 52623560},
+	{21, "", 44.216667, 41.250000, 22.375000, 28.625000, 3710469},
+	{43, "", -10.008333, -21.941667, -165.891667, -157.300000, 48332},
+	{47, "", 11.216667, 8.041667, -85.941667, -82.550000, 2895811},
+	{64, "Finland", 70.091667, 59.775000, 19.333333, 31.583333, 56421376},
+	{32, "", 28.325000, 26.716667, 88.808333, 92.133333, 1605216},
+	{61, "Spain", 43.791667, 27.641667, -18.150000, 4.341667, 47312332},
+	{102, "", 18.533333, 17.708333, -78.350000, -76.166667, 1436670},
+	{224, "", 18.458333, 17.683333, -65.075000, -64.550000, 143808},
+	{168, "", 18.525000, 17.891667, -67.941667, -65.208333, 1942080},
+	{19, "", 15.083333, 9.408333, -5.500000, 2.416667, 6243685},
+	{63, "Ethiopia", 14.883333, 3.408333, 33.016667, 47.991667, 83647620},
+	{95, "India", 37.091667, 6.750000, 67.400000, 97.408333, 392747765},
+	{111, "", 17.425000, 17.100000, -62.858333, -62.533333, 46287},
+	{178, "", -1.058333, -2.833333, 28.875000, 30.900000, 5062142},
+	{220, "", 41.908333, 41.908333, 12.466667, 12.466667, 220},
+	{6, "", 42.658333, 42.441667, 1.433333, 1.791667, 4410},
+	{69, "", 10.025000, 5.266667, 138.066667, 163.050000, 82800},
+	{89, "", 16.516667, 12.991667, -89.333333, -83.141667, 12125894},
+	{133, "", 42.358333, 40.866667, 20.483333, 23.041667, 5135130},
+	{52, "", 55.058333, 47.275000, 5.883333, 15.050000, 34470696},
+	{85, "Guyana", 5.783333, 2.175000, -54.516667, -51.600000, 8303650},
+	{135, "", 36.083333, 35.808333, 14.200000, 14.591667, 77085},
+	{209, "", 11.341667, 10.041667, -61.908333, -60.475000, 1369786},
+	{110, "", 4.733333, -14.550000, -176.633333, 176.866667, 282260},
+	{152, "", 15.025000, 10.716667, -87.675000, -82.583333, 21977072},
+	{160, "Pakistan", 36.908333, 23.700000, 60.900000, 75.391667, 170228800},
+	{189, "", 43.991667, 43.900000, 12.425000, 12.525000, 18900},
+	{177, "Russia", 81.858333, 41.200000, -179.991667, 180.000000, 7310478603},
+	{193, "", 6.008333, 1.841667, -58.066667, -53.966667, 32376715},
+	{196, "Sweden", 69.066667, 55.341667, 11.000000, 24.175000, 218839096},
+	{201, "Tanzania", 23.450000, 7.450000, 13.491667, 24.008333, 308843736},
+	{36, "", 47.808333, 45.825000, 5.983333, 10.500000, 2403504},
+	{48, "", 23.291667, 19.833333, -84.941667, -74.116667, 6973008},
+	{57, "", 37.100000, 18.966667, -8.658333, 11.983333, 175224384},
+	{121, "", -28.575000, -30.666667, 27.033333, 29.466667, 4955797},
+	{212, "", -5.641667, -10.766667, 176.075000, 179.875000, 27348},
+	{5, "", 42.666667, 39.650000, 19.291667, 21.066667, 221065},
+	{9, "Argentina", -21.783333, -55.058333, -73.558333, -53.583333, 35811540},
+	{115, "", 34.691667, 33.066667, 35.125000, 36.641667, 1686130},
+	{127, "", 43.750000, 43.725000, 7.425000, 7.450000, 889},
+	{158, "New Zealand", -34.133333, -48.016667, 166.433333, 178.566667, 66877292},
+	{39, "Cote D'Ivoire", 10.733333, 4.358333, -8.583333, -2.483333, 14714310},
+	{46, "", 17.200000, 14.808333, -25.350000, -22.658333, 259026},
+	{68, "", 62.408333, 61.400000, -7.666667, -6.250000, 297636},
+	{98, "Iraq", 37.375000, 29.116667, 38.825000, 48.591667, 58671326},
+	{11, "", -11.075000, -14.600000, -171.041667, -168.116667, 3949},
+	{174, "", 26.183333, 24.500000, 50.758333, 52.441667, 2621832},
+	{222, "Venezuela", 12.183333, 0.750000, -73.366667, -59.775000, 238238190},
+	{229, "Yemen", 19.000000, 12.116667, 41.825000, 54.541667, 116095214},
+	{51, "", 51.058333, 48.566667, 12.116667, 18.866667, 7222518},
+	{86, "", 13.658333, 13.233333, 144.625000, 144.966667, 65790},
+	{99, "Iceland", 66.575000, 63.300000, -24.516667, -13.475000, 25687233},
+	{60, "", 18.000000, 12.366667, 36.458333, 43.141667, 8939940},
+	{169, "", 43.008333, 37.600000, 124.200000, 130.683333, 31891652},
+	{219, "", 45.600000, 37.191667, 56.016667, 73.141667, 142033545},
+	{82, "", 12.325000, 12.000000, -61.783333, -61.558333, 37966},
+	{104, "", 33.366667, 29.191667, 34.975000, 39.325000, 12728144},
+	{109, "", 14.683333, 9.908333, 102.358333, 107.633333, 23429332},
+	{120, "", 9.841667, 5.925000, 79.533333, 81.891667, 9486000},
+	{50, "", 35.708333, 34.566667, 32.283333, 34.608333, 684350},
+	{62, "", 59.825000, 57.516667, 21.775000, 28.216667, 6171046},
+	{73, "", 49.741667, 49.408333, -2.700000, -2.141667, 20148},
+	{130, "", 7.108333, -0.700000, 72.650000, 73.775000, 303290},
+	{182, "", 16.691667, 12.316667, -17.525000, -11.350000, 43193696},
+	{195, "", 46.875000, 45.433333, 13.391667, 16.591667, 6633900},
+	{223, "", 18.758333, 18.341667, -64.766667, -64.258333, 85409},
+	{65, "", -12.458333, -21.033333, -179.991667, 180.000000, 1663805},
+	{71, "United Kingdom", 60.858333, 49.875000, -8.633333, 1.783333, 35849675},
+	{91, "Haiti", 20.083333, 18.016667, -74.466667, -71.625000, 3151512},
+	{176, "", 48.266667, 43.633333, 20.275000, 29.733333, 69756016},
+	{192, "", 1.708333, 0.025000, 6.483333, 7.475000, 251520},
+	{28, "Bolivia", -9.675000, -22.891667, -69.625000, -57.450000, 36830220},
+	{162, "", -23.908333, -25.075000, -130.741667, -124.766667, 16686},
+	{33, "", -17.783333, -26.891667, 20.016667, 29.366667, 24055647},
+	{94, "", 54.425000, 54.050000, -4.816667, -4.300000, 119192},
+	{142, "", 14.883333, 14.400000, -61.216667, -60.800000, 220668},
+	{211, "", 42.108333, 35.825000, 25.683333, 44.841667, 245504830},
+	{42, "", 3.700000, -5.025000, 11.225000, 18.658333, 16769886},
+	{103, "", 49.266667, 49.175000, -2.250000, -2.008333, 27707},
+	{163, "Peru", -0.016667, -18.341667, -81.316667, -68.675000, 248499857},
+	{202, "", 11.141667, 6.108333, -0.133333, 1.808333, 13632980},
+	{23, "", 27.233333, 20.916667, -79.608333, -72.700000, 493120},
+	{147, "Namibia", -16.958333, -28.958333, 11.725000, 25.266667, 153143571},
+	{13, "Australia", -9.141667, -43.741667, 96.833333, 159.116667, 130601627},
+	{78, "", 13.825000, 13.066667, -16.816667, -13.791667, 1031316},
+	{124, "", 58.083333, 55.683333, 20.983333, 28.250000, 16955016},
+	{134, "Mali", 25.000000, 10.166667, -12.225000, 4.250000, 205155206},
+	{205, "", -8.533333, -9.441667, -172.516667, -171.166667, 23780},
+	{90, "", 46.550000, 42.391667, 13.508333, 19.450000, 8649270},
+	{106, "Kazakhstan", 55.433333, 40.575000, 46.508333, 87.316667, 490823460},
+	{175, "", -20.850000, -21.366667, 55.233333, 55.858333, 582750},
+	{228, "", -13.425000, -14.058333, -172.783333, -171.400000, 852036},
+	{24, "", 45.275000, 42.425000, 15.750000, 19.625000, 1997400},
+	{122, "", 56.450000, 53.908333, 20.966667, 26.841667, 16211726},
+	{214, "", -0.991667, -11.733333, 29.600000, 40.458333, 224574168},
+	{215, "", 4.216667, -1.475000, 29.591667, 35.041667, 52487520},
+	{34, "", 11.008333, 2.233333, 14.433333, 27.466667, 24833464},
+	{37, "Chile", -17.516667, -55.758333, -75.700000, -66.983333, 42019864},
+	{54, "", 15.633333, 15.208333, -61.475000, -61.233333, 54648},
+	{55, "", 57.758333, 54.566667, 8.083333, 15.208333, 5180450},
+	{25, "", 56.175000, 51.283333, 23.200000, 32.791667, 10125950},
+	{83, "Greenland", 83.633333, 59.783333, -73.250000, -11.300000, 163158080},
+	{197, "", -25.716667, -27.308333, 30.808333, 32.141667, 4461459},
+	{59, "Egypt", 31.666667, 21.733333, 24.716667, 35.833333, 75450026},
+	{40, "", 12.941667, 1.658333, 8.508333, 16.200000, 21829040},
+	{185, "", 80.841667, 74.350000, 10.500000, 29.708333, 35002925},
+	{165, "", 8.100000, 2.916667, 131.183333, 134.741667, 129690},
+	{213, "", 25.300000, 21.908333, 119.316667, 122.016667, 10026123},
+	{145, "", 7.341667, 0.866667, 99.666667, 119.291667, 56740675},
+	{22, "", 26.283333, 25.800000, 50.400000, 50.700000, 21714},
+	{41, "", 5.383333, -13.450000, 12.225000, 31.291667, 110780401},
+	{58, "", 1.666667, -4.983333, -92.000000, -75.183333, 17476734},
+	{72, "", 43.583333, 41.041667, 40.025000, 46.725000, 7859736},
+	{8, "", 26.083333, 22.508333, 51.133333, 56.391667, 813608},
+	{14, "Austria", 49.016667, 46.383333, 9.550000, 17.166667, 2010190},
+	{105, "Japan", 45.525000, 24.041667, 122.941667, 146.858333, 59144400},
+	{153, "", -18.950000, -19.150000, -169.941667, -169.766667, 56916},
+	{146, "", -12.633333, -13.000000, 45.025000, 45.308333, 94462},
+	{180, "", 46.191667, 41.858333, 18.466667, 23.008333, 29568420},
+}
+
+// GrumpSpacing is the side length in degrees of the cells the borders are measured on
+const GrumpSpacing = 1.0 / 120.0
+
+// Bounds returns the bounding box of the country, lat of the north and south sides and lng
+// of the west and east sides. The borders are measured on the corners of the cells of the country,
+// the box is enlarged by one cell on each side to contain the cells entirely.
+func (border *CountryBorder) Bounds() (north, south, west, east float64) {
+	return border.TopLat + GrumpSpacing, border.BottomLat - GrumpSpacing, border.WestLng - GrumpSpacing, border.EastLng + GrumpSpacing
+}
+
+// BorderByName returns the border of the country of the given name (case insensitive), either
+// by the name of the border or by the GRUMP code of the country
+func BorderByName(name string) (CountryBorder, bool) {
+
+	for _, border := range CountryBorders {
+		if border.Name != "" && strings.EqualFold(border.Name, name) {
+			return border, true
+		}
+	}
+	for _, code := range CountryCodes {
+		if code.CodeGrump == 0 || !strings.EqualFold(code.Name, name) {
+			continue
+		}
+		for _, border := range CountryBorders {
+			if border.Index == code.CodeGrump {
+				return border, true
+			}
+		}
+	}
+	return CountryBorder{}, false
 }
@@ -0,0 +1,38 @@
+package countryspecs
+
+import "testing"
+
+// the box of a country contains its main cities
+func TestCountryBordersContainCities(t *testing.T) {
+
+	for _, c := range []struct {
+		index    int
+		city     string
+		lat, lng float64
+	}{
+		{67, "Paris", 48.86, 2.35},
+		{67, "Marseille", 43.30, 5.37},
+		{91, "Port-au-Prince", 18.54, -72.34},
+		{91, "Cap-Haitien", 19.76, -72.20},
+		{218, "New York", 40.71, -74.01},
+		{218, "Miami", 25.76, -80.19},
+		{29, "Brasilia", -15.79, -47.88},
+		{38, "Beijing", 39.90, 116.40},
+		{107, "Nairobi", -1.29, 36.82},
+		{129, "Antananarivo", -18.88, 47.51},
+	} {
+		found := false
+		for _, border := range CountryBorders {
+			if border.Index != c.index {
+				continue
+			}
+			found = true
+			if !(border.BottomLat < c.lat && c.lat < border.TopLat && border.WestLng < c.lng && c.lng < border.EastLng) {
+				t.Errorf("%s (%f %f) outside of the box of %d %v", c.city, c.lat, c.lng, c.index, border)
+			}
+		}
+		if !found {
+			t.Errorf("no border for %d", c.index)
+		}
+	}
+}
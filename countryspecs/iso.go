@@ -0,0 +1,248 @@
+package countryspecs
+
+import "strings"
+
+// ISOCodes are the names of the countries of CountryCodes by their ISO 3166 alpha-3 code, in lower
+// case as in the names of the GRUMP files (the codes of the countries that no longer exist are the
+// withdrawn ones)
+var ISOCodes = map[string]string{
+	"afg": "Afghanistan",
+	"alb": "Albania",
+	"dza": "Algeria",
+	"asm": "American Samoa",
+	"and": "Andorra",
+	"ago": "Angola",
+	"aia": "Anguilla",
+	"atg": "Antigua and Barbuda",
+	"arg": "Argentina",
+	"arm": "Armenia",
+	"abw": "Aruba",
+	"aus": "Australia",
+	"aut": "Austria",
+	"aze": "Azerbaijan",
+	"bhs": "Bahamas",
+	"bhr": "Bahrain",
+	"bgd": "Bangladesh",
+	"brb": "Barbados",
+	"blr": "Belarus",
+	"bel": "Belgium",
+	"blz": "Belize",
+	"ben": "Benin",
+	"bmu": "Bermuda",
+	"btn": "Bhutan",
+	"bol": "Bolivia",
+	"bih": "Bosnia and Herzegovina",
+	"bwa": "Botswana",
+	"bra": "Brazil",
+	"vgb": "British Virgin Islands",
+	"brn": "Brunei",
+	"bgr": "Bulgaria",
+	"bfa": "Burkina Faso",
+	"bdi": "Burundi",
+	"khm": "Cambodia",
+	"cmr": "Cameroon",
+	"can": "Canada",
+	"cpv": "Cape Verde",
+	"cym": "Cayman Islands",
+	"caf": "Central African Republic",
+	"tcd": "Chad",
+	"chl": "Chile",
+	"chn": "China",
+	"col": "Colombia",
+	"com": "Comoros",
+	"cok": "Cook Islands",
+	"cri": "Costa Rica",
+	"civ": "Cote D'Ivoire",
+	"hrv": "Croatia",
+	"cub": "Cuba",
+	"cyp": "Cyprus",
+	"cze": "Czech Republic",
+	"cod": "Democratic Republic of the Congo",
+	"dnk": "Denmark",
+	"dji": "Djibouti",
+	"dma": "Dominica",
+	"dom": "Dominican Republic",
+	"ecu": "Ecuador",
+	"egy": "Egypt",
+	"slv": "El Salvador",
+	"gnq": "Equatorial Guinea",
+	"eri": "Eritrea",
+	"est": "Estonia",
+	"eth": "Ethiopia",
+	"fro": "Faeroe Islands",
+	"flk": "Falkland Islands",
+	"fji": "Fiji",
+	"fin": "Finland",
+	"fra": "France",
+	"guf": "French Guiana",
+	"pyf": "French Polynesia",
+	"gab": "Gabon",
+	"gmb": "Gambia",
+	"geo": "Georgia",
+	"deu": "Germany",
+	"gha": "Ghana",
+	"gib": "Gibraltar",
+	"grc": "Greece",
+	"grl": "Greenland",
+	"grd": "Grenada",
+	"glp": "Guadeloupe",
+	"gum": "Guam",
+	"gtm": "Guatemala",
+	"ggy": "Guernsey",
+	"gin": "Guinea",
+	"gnb": "Guinea-Bissau",
+	"guy": "Guyana",
+	"hti": "Haiti",
+	"hnd": "Honduras",
+	"hkg": "Hong Kong",
+	"hun": "Hungary",
+	"isl": "Iceland",
+	"ind": "India",
+	"idn": "Indonesia",
+	"irn": "Iran",
+	"irq": "Iraq",
+	"irl": "Ireland",
+	"imn": "Isle of Man",
+	"isr": "Israel",
+	"ita": "Italy",
+	"jam": "Jamaica",
+	"jpn": "Japan",
+	"jey": "Jersey",
+	"jor": "Jordan",
+	"kaz": "Kazakhstan",
+	"ken": "Kenya",
+	"kir": "Kiribati",
+	"kwt": "Kuwait",
+	"kgz": "Kyrgyzstan",
+	"lao": "Laos",
+	"lva": "Latvia",
+	"lbn": "Lebanon",
+	"lso": "Lesotho",
+	"lbr": "Liberia",
+	"lby": "Libya",
+	"lie": "Liechtenstein",
+	"ltu": "Lithuania",
+	"lux": "Luxembourg",
+	"mac": "Macau",
+	"mkd": "Macedonia",
+	"mdg": "Madagascar",
+	"mwi": "Malawi",
+	"mys": "Malaysia",
+	"mdv": "Maldives",
+	"mli": "Mali",
+	"mlt": "Malta",
+	"mhl": "Marshall Islands",
+	"mtq": "Martinique",
+	"mrt": "Mauritania",
+	"mus": "Mauritius",
+	"myt": "Mayotte",
+	"mex": "Mexico",
+	"fsm": "Micronesia",
+	"mda": "Moldova",
+	"mco": "Monaco",
+	"mng": "Mongolia",
+	"msr": "Montserrat",
+	"mar": "Morocco",
+	"moz": "Mozambique",
+	"mmr": "Myanmar",
+	"nam": "Namibia",
+	"nru": "Nauru",
+	"npl": "Nepal",
+	"nld": "Netherlands",
+	"ant": "Netherlands Antilles",
+	"ncl": "New Caledonia",
+	"nzl": "New Zealand",
+	"nic": "Nicaragua",
+	"ner": "Niger",
+	"nga": "Nigeria",
+	"prk": "North Korea",
+	"mnp": "Northern Mariana Islands",
+	"nor": "Norway",
+	"omn": "Oman",
+	"pak": "Pakistan",
+	"plw": "Palau",
+	"pse": "Palestine",
+	"pan": "Panama",
+	"png": "Papua New Guinea",
+	"pry": "Paraguay",
+	"per": "Peru",
+	"phl": "Philippines",
+	"pcn": "Pitcairn Islands",
+	"pol": "Poland",
+	"prt": "Portugal",
+	"pri": "Puerto Rico",
+	"qat": "Qatar",
+	"cog": "Republic of the Congo",
+	"reu": "Reunion",
+	"rou": "Romania",
+	"rus": "Russia",
+	"rwa": "Rwanda",
+	"shn": "Saint Helena",
+	"kna": "Saint Kitts and Nevis",
+	"lca": "Saint Lucia",
+	"spm": "Saint Pierre and Miquelon",
+	"vct": "Saint Vincent and The Grenadines",
+	"wsm": "Samoa",
+	"smr": "San Marino",
+	"stp": "Sao Tome and Principe",
+	"sau": "Saudi Arabia",
+	"sen": "Senegal",
+	"scg": "Serbia and Montenegro",
+	"syc": "Seychelles",
+	"sle": "Sierra Leone",
+	"sgp": "Singapore",
+	"svk": "Slovakia",
+	"svn": "Slovenia",
+	"slb": "Solomon Islands",
+	"som": "Somalia",
+	"zaf": "South Africa",
+	"kor": "South Korea",
+	"esp": "Spain",
+	"lka": "Sri Lanka",
+	"sdn": "Sudan",
+	"sur": "Suriname",
+	"sjm": "Svalbard",
+	"swz": "Swaziland",
+	"swe": "Sweden",
+	"che": "Switzerland",
+	"syr": "Syria",
+	"twn": "Taiwan",
+	"tjk": "Tajikistan",
+	"tza": "Tanzania",
+	"tls": "Timor",
+	"tgo": "Togo",
+	"tkl": "Tokelau",
+	"ton": "Tonga",
+	"tto": "Trinidad and Tobago",
+	"tun": "Tunisia",
+	"tur": "Turkey",
+	"tkm": "Turkmenistan",
+	"tca": "Turks and Caicos Islands",
+	"tuv": "Tuvalu",
+	"uga": "Uganda",
+	"ukr": "Ukraine",
+	"are": "United Arab Emirates",
+	"gbr": "United Kingdom",
+	"usa": "United States",
+	"ury": "Uruguay",
+	"vir": "US Virgin Islands",
+	"uzb": "Uzbekistan",
+	"vat": "Vatican City",
+	"vut": "Vanuatu",
+	"ven": "Venezuela",
+	"vnm": "Vietnam",
+	"wlf": "Wallis and Futuna Islands",
+	"yem": "Yemen",
+	"yug": "Yugoslavia",
+	"zmb": "Zambia",
+	"zwe": "Zimbabwe",
+}
+
+// BorderByISO returns the border of the country of the ISO 3166 alpha-3 code (case insensitive)
+func BorderByISO(code string) (CountryBorder, bool) {
+	name, ok := ISOCodes[strings.ToLower(code)]
+	if !ok {
+		return CountryBorder{}, false
+	}
+	return BorderByName(name)
+}
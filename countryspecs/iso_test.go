@@ -0,0 +1,36 @@
+package countryspecs
+
+import "testing"
+
+func TestBorderByISO(t *testing.T) {
+
+	// the codes are the ones of the countries of the table
+	names := make(map[string]bool)
+	for _, code := range CountryCodes {
+		names[code.Name] = true
+	}
+	for code, name := range ISOCodes {
+		if !names[name] {
+			t.Errorf("%s: %s is not in CountryCodes", code, name)
+		}
+	}
+
+	for _, c := range []struct {
+		code, name string
+	}{{"fra", "France"}, {"HTI", "Haiti"}, {"usa", "United States"}} {
+		border, ok := BorderByISO(c.code)
+		if !ok || border.Name != c.name {
+			t.Errorf("%s: border %v %t, want %s", c.code, border, ok, c.name)
+		}
+	}
+
+	// Port-au-Prince is within the box of Haiti
+	border, _ := BorderByISO("hti")
+	if north, south, west, east := border.Bounds(); !(south < 18.54 && 18.54 < north && west < -72.34 && -72.34 < east) {
+		t.Errorf("Port-au-Prince outside of the box of Haiti %f %f %f %f", north, south, west, east)
+	}
+
+	if _, ok := BorderByISO("xyz"); ok {
+		t.Errorf("border of xyz")
+	}
+}
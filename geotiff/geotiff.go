@@ -0,0 +1,693 @@
+/*
+Package geotiff reads single band rasters in the GeoTIFF format, the format of the current
+population datasets (GPW v4, WorldPop, GHS-POP in WGS84).
+
+Only what these datasets need is supported
+
+  - classic TIFF and BigTIFF, little or big endian
+  - one sample per pixel, unsigned or signed integers of 8, 16 or 32 bits, floats of 32 or 64 bits
+  - strips or tiles
+  - no compression, LZW or deflate, with the horizontal or the floating point predictor
+  - georeferencing by ModelPixelScale and ModelTiepoint (or by a ModelTransformation
+    without rotation) in a geographic coordinate system (lng/lat in degrees)
+  - the no-data value of GDAL (GDAL_NODATA tag)
+
+Only the first image of the file is read (the next ones are the overviews of cloud optimized files).
+The rows are decoded one block (a strip or a row of tiles) at a time, a global raster is
+therefore never loaded in memory.
+*/
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupported is wrapped by the errors on valid TIFF files that use a feature this package does not support
+var ErrUnsupported = errors.New("geotiff: unsupported")
+
+// tags of the TIFF and GeoTIFF specifications
+const (
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagPredictor       = 317
+	tagTileWidth       = 322
+	tagTileLength      = 323
+	tagTileOffsets     = 324
+	tagTileByteCounts  = 325
+	tagSampleFormat    = 339
+
+	tagModelPixelScale = 33550
+	tagModelTiepoint   = 33922
+	tagModelTransform  = 34264
+	tagGeoKeyDirectory = 34735
+	tagGDALNoData      = 42113
+)
+
+// keys of the GeoKeyDirectory tag and their values
+const (
+	geoKeyModelType       = 1024
+	geoKeyRasterType      = 1025
+	modelTypeGeographic   = 2
+	rasterTypePixelIsArea = 1
+)
+
+// compressions and predictors
+const (
+	compressionNone         = 1
+	compressionLZW          = 5
+	compressionDeflate      = 8
+	compressionDeflateAdobe = 32946
+
+	predictorNone          = 1
+	predictorHorizontal    = 2
+	predictorFloatingPoint = 3
+)
+
+// size in bytes of the values of each field type
+var typeSizes = map[uint16]uint64{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 16: 8, 17: 8, 18: 8,
+}
+
+// fields larger than this are refused (the offsets of a global raster are a few MB)
+const maxFieldSize = 1 << 28
+
+// Raster is the first image of a GeoTIFF file
+type Raster struct {
+	Width, Height int
+
+	// lng of the west side and lat of the north side of the raster
+	West, North float64
+
+	// size of a pixel in degrees
+	PixelWidth, PixelHeight float64
+
+	NoData    float64
+	HasNoData bool
+
+	r              io.ReaderAt
+	order          binary.ByteOrder
+	sample         func(b []byte) float64 // value of the sample stored in b
+	bytesPerSample int
+	compression    int
+	predictor      int
+
+	// a block is a strip of blockHeight rows of Width samples, or a tile
+	tiled                   bool
+	blockWidth, blockHeight int
+	offsets, byteCounts     []uint64
+
+	block int    // index of the row of blocks in cache, -1 if none
+	cache []byte // samples of the row of blocks, row major, blockHeight rows of Width samples
+	row   int    // next row of ReadRow
+}
+
+// a field of an image file directory
+type field struct {
+	typ   uint16
+	count uint64
+	data  []byte
+}
+
+type decoder struct {
+	r      io.ReaderAt
+	order  binary.ByteOrder
+	big    bool // BigTIFF
+	fields map[uint16]field
+}
+
+// read exactly len(p) bytes at offset
+func (d *decoder) readAt(p []byte, offset uint64) error {
+	if offset > math.MaxInt64 {
+		return fmt.Errorf("geotiff: offset %d out of range", offset)
+	}
+	n, err := d.r.ReadAt(p, int64(offset))
+	if n == len(p) {
+		return nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Open reads the header and the first image file directory of a GeoTIFF file
+func Open(r io.ReaderAt) (*Raster, error) {
+
+	d := decoder{r: r}
+	var header [16]byte
+	if err := d.readAt(header[:8], 0); err != nil {
+		return nil, fmt.Errorf("geotiff: reading header: %w", err)
+	}
+	switch string(header[:2]) {
+	case "II":
+		d.order = binary.LittleEndian
+	case "MM":
+		d.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("geotiff: not a TIFF file (byte order %q)", header[:2])
+	}
+
+	var ifdOffset uint64
+	switch d.order.Uint16(header[2:4]) {
+	case 42:
+		ifdOffset = uint64(d.order.Uint32(header[4:8]))
+	case 43:
+		d.big = true
+		if err := d.readAt(header[:16], 0); err != nil {
+			return nil, fmt.Errorf("geotiff: reading BigTIFF header: %w", err)
+		}
+		if d.order.Uint16(header[4:6]) != 8 {
+			return nil, fmt.Errorf("geotiff: BigTIFF offsets of %d bytes", d.order.Uint16(header[4:6]))
+		}
+		ifdOffset = d.order.Uint64(header[8:16])
+	default:
+		return nil, fmt.Errorf("geotiff: not a TIFF file (version %d)", d.order.Uint16(header[2:4]))
+	}
+
+	if err := d.readIFD(ifdOffset); err != nil {
+		return nil, err
+	}
+	return d.raster()
+}
+
+// read the fields of the image file directory at offset
+func (d *decoder) readIFD(offset uint64) error {
+
+	countSize, entrySize, inlineSize := uint64(2), uint64(12), uint64(4)
+	if d.big {
+		countSize, entrySize, inlineSize = 8, 20, 8
+	}
+
+	buf := make([]byte, countSize)
+	if err := d.readAt(buf, offset); err != nil {
+		return fmt.Errorf("geotiff: reading image file directory: %w", err)
+	}
+	var nbEntries uint64
+	if d.big {
+		nbEntries = d.order.Uint64(buf)
+	} else {
+		nbEntries = uint64(d.order.Uint16(buf))
+	}
+	if nbEntries > 1<<16 {
+		return fmt.Errorf("geotiff: %d entries in the image file directory", nbEntries)
+	}
+	entries := make([]byte, nbEntries*entrySize)
+	if err := d.readAt(entries, offset+countSize); err != nil {
+		return fmt.Errorf("geotiff: reading image file directory: %w", err)
+	}
+
+	d.fields = make(map[uint16]field, nbEntries)
+	for e := entries; len(e) > 0; e = e[entrySize:] {
+		tag := d.order.Uint16(e[0:2])
+		f := field{typ: d.order.Uint16(e[2:4])}
+		var value []byte
+		if d.big {
+			f.count, value = d.order.Uint64(e[4:12]), e[12:20]
+		} else {
+			f.count, value = uint64(d.order.Uint32(e[4:8])), e[8:12]
+		}
+
+		// fields of unknown types are skipped, as required by the specification
+		size, ok := typeSizes[f.typ]
+		if !ok {
+			continue
+		}
+		if f.count > maxFieldSize/size {
+			return fmt.Errorf("geotiff: tag %d has %d values", tag, f.count)
+		}
+		if f.count*size <= inlineSize {
+			f.data = value[:f.count*size]
+		} else {
+			var fieldOffset uint64
+			if d.big {
+				fieldOffset = d.order.Uint64(value)
+			} else {
+				fieldOffset = uint64(d.order.Uint32(value))
+			}
+			f.data = make([]byte, f.count*size)
+			if err := d.readAt(f.data, fieldOffset); err != nil {
+				return fmt.Errorf("geotiff: reading tag %d: %w", tag, err)
+			}
+		}
+		d.fields[tag] = f
+	}
+	return nil
+}
+
+// integer values of tag, nil if the tag is absent
+func (d *decoder) uints(tag uint16) ([]uint64, error) {
+
+	f, ok := d.fields[tag]
+	if !ok {
+		return nil, nil
+	}
+	values := make([]uint64, f.count)
+	for idx := range values {
+		switch f.typ {
+		case 1:
+			values[idx] = uint64(f.data[idx])
+		case 3:
+			values[idx] = uint64(d.order.Uint16(f.data[2*idx:]))
+		case 4:
+			values[idx] = uint64(d.order.Uint32(f.data[4*idx:]))
+		case 16:
+			values[idx] = d.order.Uint64(f.data[8*idx:])
+		default:
+			return nil, fmt.Errorf("geotiff: tag %d has type %d, want an unsigned integer", tag, f.typ)
+		}
+	}
+	return values, nil
+}
+
+// single integer value of tag, def if the tag is absent
+func (d *decoder) integer(tag uint16, def int) (int, error) {
+
+	values, err := d.uints(tag)
+	if err != nil {
+		return 0, err
+	}
+	if values == nil {
+		return def, nil
+	}
+	if len(values) == 0 || values[0] > math.MaxInt32 {
+		return 0, fmt.Errorf("geotiff: tag %d has the value %v", tag, values)
+	}
+	// a value per sample, with one sample per pixel the first one is enough
+	return int(values[0]), nil
+}
+
+// float values of tag, nil if the tag is absent
+func (d *decoder) floats(tag uint16) ([]float64, error) {
+
+	f, ok := d.fields[tag]
+	if !ok {
+		return nil, nil
+	}
+	values := make([]float64, f.count)
+	for idx := range values {
+		switch f.typ {
+		case 11:
+			values[idx] = float64(math.Float32frombits(d.order.Uint32(f.data[4*idx:])))
+		case 12:
+			values[idx] = math.Float64frombits(d.order.Uint64(f.data[8*idx:]))
+		default:
+			return nil, fmt.Errorf("geotiff: tag %d has type %d, want a float", tag, f.typ)
+		}
+	}
+	return values, nil
+}
+
+// build the raster from the fields of the image file directory
+func (d *decoder) raster() (*Raster, error) {
+
+	r := Raster{r: d.r, order: d.order, block: -1}
+	var err error
+	var samplesPerPixel, bitsPerSample, sampleFormat int
+	for _, f := range []struct {
+		tag   uint16
+		def   int
+		value *int
+	}{
+		{tagImageWidth, 0, &r.Width},
+		{tagImageLength, 0, &r.Height},
+		{tagSamplesPerPixel, 1, &samplesPerPixel},
+		{tagBitsPerSample, 1, &bitsPerSample},
+		{tagSampleFormat, 1, &sampleFormat},
+		{tagCompression, compressionNone, &r.compression},
+		{tagPredictor, predictorNone, &r.predictor},
+	} {
+		if *f.value, err = d.integer(f.tag, f.def); err != nil {
+			return nil, err
+		}
+	}
+	if r.Width == 0 || r.Height == 0 {
+		return nil, fmt.Errorf("geotiff: image of %d x %d pixels", r.Width, r.Height)
+	}
+	if samplesPerPixel != 1 {
+		return nil, fmt.Errorf("%w: %d samples per pixel, want a single band", ErrUnsupported, samplesPerPixel)
+	}
+
+	if err := r.setSample(sampleFormat, bitsPerSample); err != nil {
+		return nil, err
+	}
+	switch r.compression {
+	case compressionNone, compressionLZW, compressionDeflate, compressionDeflateAdobe:
+	default:
+		return nil, fmt.Errorf("%w: compression %d", ErrUnsupported, r.compression)
+	}
+	switch {
+	case r.predictor == predictorNone:
+	case r.predictor == predictorHorizontal && sampleFormat != 3:
+	case r.predictor == predictorFloatingPoint && sampleFormat == 3:
+	default:
+		return nil, fmt.Errorf("%w: predictor %d for sample format %d", ErrUnsupported, r.predictor, sampleFormat)
+	}
+
+	if err := r.setBlocks(d); err != nil {
+		return nil, err
+	}
+	if err := r.setGeoreferencing(d); err != nil {
+		return nil, err
+	}
+
+	if f, ok := d.fields[tagGDALNoData]; ok && f.typ == 2 {
+		s := strings.TrimSpace(strings.TrimRight(string(f.data), "\x00"))
+		if r.NoData, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("geotiff: no-data value %q: %w", s, err)
+		}
+		if sampleFormat == 3 && bitsPerSample == 32 {
+			// the samples are float32, the text of the no-data value might be rounded differently
+			r.NoData = float64(float32(r.NoData))
+		}
+		r.HasNoData = true
+	}
+	return &r, nil
+}
+
+// set the decoding of the samples
+func (r *Raster) setSample(sampleFormat, bitsPerSample int) error {
+
+	order := r.order
+	switch [2]int{sampleFormat, bitsPerSample} {
+	case [2]int{1, 8}:
+		r.sample = func(b []byte) float64 { return float64(b[0]) }
+	case [2]int{1, 16}:
+		r.sample = func(b []byte) float64 { return float64(order.Uint16(b)) }
+	case [2]int{1, 32}:
+		r.sample = func(b []byte) float64 { return float64(order.Uint32(b)) }
+	case [2]int{2, 8}:
+		r.sample = func(b []byte) float64 { return float64(int8(b[0])) }
+	case [2]int{2, 16}:
+		r.sample = func(b []byte) float64 { return float64(int16(order.Uint16(b))) }
+	case [2]int{2, 32}:
+		r.sample = func(b []byte) float64 { return float64(int32(order.Uint32(b))) }
+	case [2]int{3, 32}:
+		r.sample = func(b []byte) float64 { return float64(math.Float32frombits(order.Uint32(b))) }
+	case [2]int{3, 64}:
+		r.sample = func(b []byte) float64 { return math.Float64frombits(order.Uint64(b)) }
+	default:
+		return fmt.Errorf("%w: sample format %d with %d bits per sample", ErrUnsupported, sampleFormat, bitsPerSample)
+	}
+	r.bytesPerSample = bitsPerSample / 8
+	return nil
+}
+
+// set the layout of the strips or of the tiles
+func (r *Raster) setBlocks(d *decoder) error {
+
+	var err error
+	offsetsTag, byteCountsTag := uint16(tagStripOffsets), uint16(tagStripByteCounts)
+	var nbBlocks int
+	if _, r.tiled = d.fields[tagTileWidth]; r.tiled {
+		offsetsTag, byteCountsTag = tagTileOffsets, tagTileByteCounts
+		if r.blockWidth, err = d.integer(tagTileWidth, 0); err != nil {
+			return err
+		}
+		if r.blockHeight, err = d.integer(tagTileLength, 0); err != nil {
+			return err
+		}
+		if r.blockWidth == 0 || r.blockHeight == 0 {
+			return fmt.Errorf("geotiff: tiles of %d x %d pixels", r.blockWidth, r.blockHeight)
+		}
+		nbBlocks = ((r.Width + r.blockWidth - 1) / r.blockWidth) * ((r.Height + r.blockHeight - 1) / r.blockHeight)
+	} else {
+		r.blockWidth = r.Width
+		if r.blockHeight, err = d.integer(tagRowsPerStrip, r.Height); err != nil {
+			return err
+		}
+		if r.blockHeight == 0 || r.blockHeight > r.Height {
+			r.blockHeight = r.Height
+		}
+		nbBlocks = (r.Height + r.blockHeight - 1) / r.blockHeight
+	}
+
+	if r.offsets, err = d.uints(offsetsTag); err != nil {
+		return err
+	}
+	if r.byteCounts, err = d.uints(byteCountsTag); err != nil {
+		return err
+	}
+	if len(r.offsets) != nbBlocks || len(r.byteCounts) != nbBlocks {
+		return fmt.Errorf("geotiff: %d offsets and %d byte counts, want %d blocks", len(r.offsets), len(r.byteCounts), nbBlocks)
+	}
+	for idx, count := range r.byteCounts {
+		if count > maxFieldSize {
+			return fmt.Errorf("geotiff: block %d of %d bytes", idx, count)
+		}
+	}
+	return nil
+}
+
+// set the corner and the size of the pixels from the GeoTIFF tags
+func (r *Raster) setGeoreferencing(d *decoder) error {
+
+	scale, err := d.floats(tagModelPixelScale)
+	if err != nil {
+		return err
+	}
+	tiepoint, err := d.floats(tagModelTiepoint)
+	if err != nil {
+		return err
+	}
+	transform, err := d.floats(tagModelTransform)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(scale) >= 2 && len(tiepoint) >= 6:
+		// the pixel (i, j) of the raster is at (x, y)
+		r.PixelWidth, r.PixelHeight = scale[0], scale[1]
+		r.West = tiepoint[3] - tiepoint[0]*scale[0]
+		r.North = tiepoint[4] + tiepoint[1]*scale[1]
+	case len(transform) == 16:
+		if transform[1] != 0 || transform[4] != 0 {
+			return fmt.Errorf("%w: rotated raster", ErrUnsupported)
+		}
+		r.PixelWidth, r.PixelHeight = transform[0], -transform[5]
+		r.West, r.North = transform[3], transform[7]
+	default:
+		return fmt.Errorf("geotiff: no georeferencing (ModelPixelScale and ModelTiepoint, or ModelTransformation)")
+	}
+	if !(r.PixelWidth > 0 && r.PixelHeight > 0) {
+		return fmt.Errorf("%w: pixels of %g x %g (the rows have to go from the north to the south)", ErrUnsupported, r.PixelWidth, r.PixelHeight)
+	}
+
+	// geo keys are shorts, a header of 4 shorts (the 4th is the nb of keys)
+	// then 4 shorts per key: id, location (0 if the value is the 4th short), count, value
+	keys, err := d.uints(tagGeoKeyDirectory)
+	if err != nil {
+		return err
+	}
+	for idx := 4; idx+4 <= len(keys); idx += 4 {
+		id, location, value := keys[idx], keys[idx+1], keys[idx+3]
+		if location != 0 {
+			continue
+		}
+		switch {
+		case id == geoKeyModelType && value != modelTypeGeographic:
+			return fmt.Errorf("%w: model type %d, want geographic coordinates (lng/lat)", ErrUnsupported, value)
+		case id == geoKeyRasterType && value != rasterTypePixelIsArea:
+			// the tiepoint is the center of the pixel
+			r.West -= r.PixelWidth / 2.0
+			r.North += r.PixelHeight / 2.0
+		}
+	}
+	return nil
+}
+
+// IsNoData tells wether v is the no-data value of the raster
+func (r *Raster) IsNoData(v float64) bool {
+	if !r.HasNoData {
+		return false
+	}
+	if math.IsNaN(r.NoData) {
+		return math.IsNaN(v)
+	}
+	return v == r.NoData
+}
+
+// ReadRow reads the next row of the raster into values, that has to be of length Width
+//
+// rows are read from the north to the south, it returns io.EOF after the last row
+func (r *Raster) ReadRow(values []float64) error {
+
+	if r.row == r.Height {
+		return io.EOF
+	}
+	if err := r.ReadRowAt(r.row, values); err != nil {
+		return err
+	}
+	r.row++
+	return nil
+}
+
+// ReadRowAt reads the row of the raster (0 is the northest row) into values, that has to be of length Width
+//
+// the rows of the same strip or of the same row of tiles are decoded once, the rows are
+// therefore faster to read in order
+func (r *Raster) ReadRowAt(row int, values []float64) error {
+
+	if row < 0 || row >= r.Height {
+		return fmt.Errorf("geotiff: row %d out of the %d rows", row, r.Height)
+	}
+	if len(values) != r.Width {
+		return fmt.Errorf("geotiff: row of %d values, want %d", len(values), r.Width)
+	}
+	if block := row / r.blockHeight; block != r.block {
+		if err := r.loadBlocks(block); err != nil {
+			r.block = -1
+			return err
+		}
+	}
+	line := r.cache[(row%r.blockHeight)*r.Width*r.bytesPerSample:]
+	for col := range values {
+		values[col] = r.sample(line[col*r.bytesPerSample:])
+	}
+	return nil
+}
+
+// decode the strip or the row of tiles of index block into the cache
+func (r *Raster) loadBlocks(block int) error {
+
+	if r.cache == nil {
+		r.cache = make([]byte, r.blockHeight*r.Width*r.bytesPerSample)
+	}
+	rows := r.blockHeight
+	if (block+1)*r.blockHeight > r.Height {
+		rows = r.Height - block*r.blockHeight
+	}
+
+	if !r.tiled {
+		// the last strip has only the remaining rows
+		data, err := r.decodeBlock(block, r.Width, rows)
+		if err != nil {
+			return err
+		}
+		copy(r.cache, data)
+		r.block = block
+		return nil
+	}
+
+	// tiles are always complete, the pixels beyond the raster are padding
+	across := (r.Width + r.blockWidth - 1) / r.blockWidth
+	for tile := 0; tile < across; tile++ {
+		data, err := r.decodeBlock(block*across+tile, r.blockWidth, r.blockHeight)
+		if err != nil {
+			return err
+		}
+		cols := r.blockWidth
+		if (tile+1)*r.blockWidth > r.Width {
+			cols = r.Width - tile*r.blockWidth
+		}
+		for y := 0; y < rows; y++ {
+			copy(r.cache[(y*r.Width+tile*r.blockWidth)*r.bytesPerSample:(y*r.Width+tile*r.blockWidth+cols)*r.bytesPerSample],
+				data[y*r.blockWidth*r.bytesPerSample:])
+		}
+	}
+	r.block = block
+	return nil
+}
+
+// decompress the block of index idx, of width * rows samples, and undo the predictor
+func (r *Raster) decodeBlock(idx, width, rows int) ([]byte, error) {
+
+	size := width * rows * r.bytesPerSample
+	raw := make([]byte, r.byteCounts[idx])
+	d := decoder{r: r.r}
+	if err := d.readAt(raw, r.offsets[idx]); err != nil {
+		return nil, fmt.Errorf("geotiff: reading block %d: %w", idx, err)
+	}
+
+	var data []byte
+	var err error
+	switch r.compression {
+	case compressionNone:
+		data = raw
+	case compressionLZW:
+		data, err = lzwDecode(raw, size)
+	case compressionDeflate, compressionDeflateAdobe:
+		var zr io.ReadCloser
+		if zr, err = zlib.NewReader(bytes.NewReader(raw)); err == nil {
+			data = make([]byte, size)
+			var n int
+			n, err = io.ReadFull(zr, data)
+			data = data[:n]
+			if err == io.ErrUnexpectedEOF {
+				err = nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("geotiff: decompressing block %d: %w", idx, err)
+	}
+	if len(data) < size {
+		return nil, fmt.Errorf("geotiff: block %d has %d bytes, want %d", idx, len(data), size)
+	}
+	data = data[:size]
+
+	switch r.predictor {
+	case predictorHorizontal:
+		r.undoHorizontal(data, width)
+	case predictorFloatingPoint:
+		data = r.undoFloatingPoint(data, width)
+	}
+	return data, nil
+}
+
+// the horizontal predictor stores the difference of each sample with the previous sample of the row
+func (r *Raster) undoHorizontal(data []byte, width int) {
+
+	bps := r.bytesPerSample
+	for line := data; len(line) > 0; line = line[width*bps:] {
+		for idx := bps; idx < width*bps; idx += bps {
+			switch bps {
+			case 1:
+				line[idx] += line[idx-1]
+			case 2:
+				r.order.PutUint16(line[idx:], r.order.Uint16(line[idx:])+r.order.Uint16(line[idx-2:]))
+			case 4:
+				r.order.PutUint32(line[idx:], r.order.Uint32(line[idx:])+r.order.Uint32(line[idx-4:]))
+			}
+		}
+	}
+}
+
+// the floating point predictor splits the bytes of the samples of a row into planes
+// (the most significant bytes first, whatever the byte order of the file) and stores the
+// difference of each byte with the previous byte. The samples are returned in the byte order of the file.
+func (r *Raster) undoFloatingPoint(data []byte, width int) []byte {
+
+	bps := r.bytesPerSample
+	samples := make([]byte, len(data))
+	for offset := 0; offset < len(data); offset += width * bps {
+		line := data[offset : offset+width*bps]
+		for idx := 1; idx < len(line); idx++ {
+			line[idx] += line[idx-1]
+		}
+		for idx := 0; idx < width; idx++ {
+			for plane := 0; plane < bps; plane++ {
+				b := plane // most significant byte first
+				if r.order == binary.LittleEndian {
+					b = bps - 1 - plane
+				}
+				samples[offset+idx*bps+b] = line[plane*width+idx]
+			}
+		}
+	}
+	return samples
+}
@@ -0,0 +1,496 @@
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// options of a synthetic GeoTIFF
+type tiffOptions struct {
+	order                   binary.ByteOrder
+	big                     bool // BigTIFF
+	sampleFormat, bits      int
+	compression, predictor  int
+	tileWidth, tileHeight   int // strips if 0
+	rowsPerStrip            int
+	noData                  string
+	pixelIsPoint, projected bool
+
+	// fields replacing the fields of the options, a field without data is removed
+	fields map[uint16]field
+}
+
+// encode the sample v in b
+func putSample(o *tiffOptions, b []byte, v float64) {
+	switch o.bits {
+	case 8:
+		b[0] = byte(int64(v))
+	case 16:
+		o.order.PutUint16(b, uint16(int64(v)))
+	case 32:
+		if o.sampleFormat == 3 {
+			o.order.PutUint32(b, math.Float32bits(float32(v)))
+		} else {
+			o.order.PutUint32(b, uint32(int64(v)))
+		}
+	case 64:
+		o.order.PutUint64(b, math.Float64bits(v))
+	}
+}
+
+// TIFF LZW encoder, the inverse of lzwDecode
+func lzwEncode(src []byte) []byte {
+
+	var out []byte
+	var bits uint64
+	var nbBits uint
+	write := func(code int, width uint) {
+		bits = bits<<width | uint64(code)
+		nbBits += width
+		for nbBits >= 8 {
+			out = append(out, byte(bits>>(nbBits-8)))
+			nbBits -= 8
+		}
+	}
+
+	width := uint(9)
+	table := make(map[int]int)
+	next := lzwFirst
+	write(lzwClear, width)
+	prefix := -1
+	// the width grows when the decoder, one code late, reaches 2^width - 1
+	emit := func(code int) {
+		write(code, width)
+		next++
+		if next == 1<<width && width < 12 {
+			width++
+		}
+	}
+	for _, c := range src {
+		if prefix == -1 {
+			prefix = int(c)
+			continue
+		}
+		if code, ok := table[prefix<<8|int(c)]; ok {
+			prefix = code
+			continue
+		}
+		table[prefix<<8|int(c)] = next
+		emit(prefix)
+		prefix = int(c)
+		if next == lzwMaxCode-2 {
+			write(lzwClear, width)
+			width, next, table = 9, lzwFirst, make(map[int]int)
+		}
+	}
+	if prefix != -1 {
+		emit(prefix)
+	}
+	write(lzwEOI, width)
+	if nbBits > 0 {
+		out = append(out, byte(bits<<(8-nbBits)))
+	}
+	return out
+}
+
+// encode a block of width * rows samples with the predictor and the compression of o
+func encodeBlock(o *tiffOptions, samples []float64, width, rows int) []byte {
+
+	bps := o.bits / 8
+	data := make([]byte, width*rows*bps)
+	for idx, v := range samples {
+		putSample(o, data[idx*bps:], v)
+	}
+
+	for offset := 0; offset < len(data); offset += width * bps {
+		line := data[offset : offset+width*bps]
+		switch o.predictor {
+		case predictorHorizontal:
+			for idx := width - 1; idx > 0; idx-- {
+				switch bps {
+				case 1:
+					line[idx] -= line[idx-1]
+				case 2:
+					o.order.PutUint16(line[2*idx:], o.order.Uint16(line[2*idx:])-o.order.Uint16(line[2*idx-2:]))
+				case 4:
+					o.order.PutUint32(line[4*idx:], o.order.Uint32(line[4*idx:])-o.order.Uint32(line[4*idx-4:]))
+				}
+			}
+		case predictorFloatingPoint:
+			planes := make([]byte, len(line))
+			for idx := 0; idx < width; idx++ {
+				for plane := 0; plane < bps; plane++ {
+					b := plane
+					if o.order == binary.LittleEndian {
+						b = bps - 1 - plane
+					}
+					planes[plane*width+idx] = line[idx*bps+b]
+				}
+			}
+			for idx := len(planes) - 1; idx > 0; idx-- {
+				planes[idx] -= planes[idx-1]
+			}
+			copy(line, planes)
+		}
+	}
+
+	switch o.compression {
+	case compressionLZW:
+		return lzwEncode(data)
+	case compressionDeflate, compressionDeflateAdobe:
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		return buf.Bytes()
+	}
+	return data
+}
+
+// a synthetic GeoTIFF of width * height samples, row major from the north, the north west corner
+// of the raster is at lng 2, lat 49 and the pixels are of 0.5 * 0.25 degrees
+func writeTIFF(o tiffOptions, width, height int, samples []float64) []byte {
+
+	header := 8
+	if o.big {
+		header = 16
+	}
+	file := make([]byte, header)
+
+	// blocks
+	var offsets, byteCounts []uint64
+	addBlock := func(block []float64, blockWidth, rows int) {
+		data := encodeBlock(&o, block, blockWidth, rows)
+		offsets = append(offsets, uint64(len(file)))
+		byteCounts = append(byteCounts, uint64(len(data)))
+		file = append(file, data...)
+	}
+	if o.tileWidth == 0 {
+		for row := 0; row < height; row += o.rowsPerStrip {
+			rows := o.rowsPerStrip
+			if row+rows > height {
+				rows = height - row
+			}
+			addBlock(samples[row*width:(row+rows)*width], width, rows)
+		}
+	} else {
+		for row := 0; row < height; row += o.tileHeight {
+			for col := 0; col < width; col += o.tileWidth {
+				tile := make([]float64, o.tileWidth*o.tileHeight) // padded with 0
+				for y := 0; y < o.tileHeight && row+y < height; y++ {
+					for x := 0; x < o.tileWidth && col+x < width; x++ {
+						tile[y*o.tileWidth+x] = samples[(row+y)*width+col+x]
+					}
+				}
+				addBlock(tile, o.tileWidth, o.tileHeight)
+			}
+		}
+	}
+
+	// fields
+	fields := make(map[uint16]field)
+	shorts := func(values ...int) field {
+		f := field{typ: 3, count: uint64(len(values)), data: make([]byte, 2*len(values))}
+		for idx, v := range values {
+			o.order.PutUint16(f.data[2*idx:], uint16(v))
+		}
+		return f
+	}
+	longs := func(values []uint64) field {
+		if o.big {
+			f := field{typ: 16, count: uint64(len(values)), data: make([]byte, 8*len(values))}
+			for idx, v := range values {
+				o.order.PutUint64(f.data[8*idx:], v)
+			}
+			return f
+		}
+		f := field{typ: 4, count: uint64(len(values)), data: make([]byte, 4*len(values))}
+		for idx, v := range values {
+			o.order.PutUint32(f.data[4*idx:], uint32(v))
+		}
+		return f
+	}
+	doubles := func(values ...float64) field {
+		f := field{typ: 12, count: uint64(len(values)), data: make([]byte, 8*len(values))}
+		for idx, v := range values {
+			o.order.PutUint64(f.data[8*idx:], math.Float64bits(v))
+		}
+		return f
+	}
+	fields[tagImageWidth] = shorts(width)
+	fields[tagImageLength] = shorts(height)
+	fields[tagBitsPerSample] = shorts(o.bits)
+	fields[tagSampleFormat] = shorts(o.sampleFormat)
+	fields[tagCompression] = shorts(o.compression)
+	if o.predictor != 0 {
+		fields[tagPredictor] = shorts(o.predictor)
+	}
+	if o.tileWidth == 0 {
+		fields[tagRowsPerStrip] = shorts(o.rowsPerStrip)
+		fields[tagStripOffsets] = longs(offsets)
+		fields[tagStripByteCounts] = longs(byteCounts)
+	} else {
+		fields[tagTileWidth] = shorts(o.tileWidth)
+		fields[tagTileLength] = shorts(o.tileHeight)
+		fields[tagTileOffsets] = longs(offsets)
+		fields[tagTileByteCounts] = longs(byteCounts)
+	}
+	fields[tagModelPixelScale] = doubles(0.5, 0.25, 0)
+	fields[tagModelTiepoint] = doubles(0, 0, 0, 2, 49, 0)
+	modelType, rasterType := modelTypeGeographic, rasterTypePixelIsArea
+	if o.projected {
+		modelType = 1
+	}
+	if o.pixelIsPoint {
+		rasterType = 2
+	}
+	fields[tagGeoKeyDirectory] = shorts(1, 1, 0, 2, geoKeyModelType, 0, 1, modelType, geoKeyRasterType, 0, 1, rasterType)
+	if o.noData != "" {
+		fields[tagGDALNoData] = field{typ: 2, count: uint64(len(o.noData) + 1), data: append([]byte(o.noData), 0)}
+	}
+	for tag, f := range o.fields {
+		if f.data == nil {
+			delete(fields, tag)
+		} else {
+			fields[tag] = f
+		}
+	}
+
+	// image file directory, the data of the fields that are not inline follows it
+	ifd := uint64(len(file))
+	countSize, entrySize, inlineSize := 2, 12, 4
+	if o.big {
+		countSize, entrySize, inlineSize = 8, 20, 8
+	}
+	extra := ifd + uint64(countSize+len(fields)*entrySize)
+	entries := make([]byte, countSize, countSize+len(fields)*entrySize)
+	var extraData []byte
+	if o.big {
+		o.order.PutUint64(entries, uint64(len(fields)))
+	} else {
+		o.order.PutUint16(entries, uint16(len(fields)))
+	}
+	for tag := 0; tag < 1<<16; tag++ {
+		f, ok := fields[uint16(tag)]
+		if !ok {
+			continue
+		}
+		e := make([]byte, entrySize)
+		o.order.PutUint16(e[0:], uint16(tag))
+		o.order.PutUint16(e[2:], f.typ)
+		value := e[8:]
+		if o.big {
+			o.order.PutUint64(e[4:], f.count)
+			value = e[12:]
+		} else {
+			o.order.PutUint32(e[4:], uint32(f.count))
+		}
+		if len(f.data) <= inlineSize {
+			copy(value, f.data)
+		} else {
+			offset := extra + uint64(len(extraData))
+			if o.big {
+				o.order.PutUint64(value, offset)
+			} else {
+				o.order.PutUint32(value, uint32(offset))
+			}
+			extraData = append(extraData, f.data...)
+		}
+		entries = append(entries, e...)
+	}
+	file = append(append(file, entries...), extraData...)
+
+	// header
+	if o.order == binary.LittleEndian {
+		copy(file, "II")
+	} else {
+		copy(file, "MM")
+	}
+	if o.big {
+		o.order.PutUint16(file[2:], 43)
+		o.order.PutUint16(file[4:], 8)
+		o.order.PutUint64(file[8:], ifd)
+	} else {
+		o.order.PutUint16(file[2:], 42)
+		o.order.PutUint32(file[4:], uint32(ifd))
+	}
+	return file
+}
+
+// samples in the range of the sample format, with a few no-data samples
+func randomSamples(o tiffOptions, nb int, noData float64) []float64 {
+
+	rng := rand.New(rand.NewSource(int64(nb)))
+	samples := make([]float64, nb)
+	for idx := range samples {
+		switch {
+		case o.noData != "" && rng.Intn(10) == 0:
+			samples[idx] = noData
+		case o.sampleFormat == 3 && o.bits == 32:
+			samples[idx] = float64(float32(rng.Float64() * 1000.0))
+		case o.sampleFormat == 3:
+			samples[idx] = rng.Float64() * 1000.0
+		case o.sampleFormat == 2:
+			samples[idx] = float64(rng.Intn(1<<uint(o.bits)) - 1<<uint(o.bits-1))
+		default:
+			samples[idx] = float64(rng.Intn(1 << uint(o.bits)))
+		}
+	}
+	return samples
+}
+
+func TestRaster(t *testing.T) {
+
+	le, be := binary.LittleEndian, binary.BigEndian
+	cases := []struct {
+		name          string
+		o             tiffOptions
+		width, height int
+		noData        float64
+	}{
+		{"strips uint8", tiffOptions{order: le, sampleFormat: 1, bits: 8, compression: compressionNone, rowsPerStrip: 2}, 7, 5, 0},
+		{"strips int16 LZW horizontal", tiffOptions{order: be, sampleFormat: 2, bits: 16, compression: compressionLZW, predictor: predictorHorizontal, rowsPerStrip: 3, noData: "-9999"}, 40, 11, -9999},
+		{"strips int32 deflate horizontal", tiffOptions{order: le, sampleFormat: 2, bits: 32, compression: compressionDeflateAdobe, predictor: predictorHorizontal, rowsPerStrip: 1}, 13, 4, 0},
+		{"strips uint32 LZW single strip", tiffOptions{order: le, sampleFormat: 1, bits: 32, compression: compressionLZW, rowsPerStrip: 64}, 300, 30, 0},
+		{"tiles uint16 LZW", tiffOptions{order: le, sampleFormat: 1, bits: 16, compression: compressionLZW, tileWidth: 16, tileHeight: 16}, 37, 21, 0},
+		{"tiles float32 deflate floating point", tiffOptions{order: le, sampleFormat: 3, bits: 32, compression: compressionDeflate, predictor: predictorFloatingPoint, tileWidth: 16, tileHeight: 32, noData: "-3.40282e+38"}, 50, 33, float64(float32(-3.40282e+38))},
+		{"tiles float64 LZW floating point BigTIFF", tiffOptions{order: be, big: true, sampleFormat: 3, bits: 64, compression: compressionLZW, predictor: predictorFloatingPoint, tileWidth: 16, tileHeight: 16, noData: "nan"}, 17, 17, math.NaN()},
+		{"strips float32 BigTIFF pixel is point", tiffOptions{order: le, big: true, sampleFormat: 3, bits: 32, compression: compressionNone, rowsPerStrip: 4, pixelIsPoint: true}, 9, 6, 0},
+		{"strips int8 deflate", tiffOptions{order: be, sampleFormat: 2, bits: 8, compression: compressionDeflate, rowsPerStrip: 2}, 5, 3, 0},
+	}
+	for _, c := range cases {
+		samples := randomSamples(c.o, c.width*c.height, c.noData)
+		r, err := Open(bytes.NewReader(writeTIFF(c.o, c.width, c.height, samples)))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		west, north := 2.0, 49.0
+		if c.o.pixelIsPoint {
+			west, north = 1.75, 49.125
+		}
+		if r.Width != c.width || r.Height != c.height || r.West != west || r.North != north || r.PixelWidth != 0.5 || r.PixelHeight != 0.25 {
+			t.Errorf("%s: got %d x %d pixels of %f x %f at %f %f", c.name, r.Width, r.Height, r.PixelWidth, r.PixelHeight, r.West, r.North)
+		}
+		if r.HasNoData != (c.o.noData != "") || (r.HasNoData && !r.IsNoData(c.noData)) {
+			t.Errorf("%s: no-data got %t %f", c.name, r.HasNoData, r.NoData)
+		}
+
+		same := func(got, want float64) bool {
+			return got == want || (math.IsNaN(got) && math.IsNaN(want))
+		}
+		values := make([]float64, c.width)
+		for row := 0; ; row++ {
+			err := r.ReadRow(values)
+			if err == io.EOF {
+				if row != c.height {
+					t.Errorf("%s: EOF after %d rows", c.name, row)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: row %d: %v", c.name, row, err)
+			}
+			for col, v := range values {
+				if want := samples[row*c.width+col]; !same(v, want) {
+					t.Fatalf("%s: row %d col %d got %f, want %f", c.name, row, col, v, want)
+				}
+			}
+		}
+
+		// rows out of order
+		for _, row := range []int{c.height - 1, 0, c.height / 2} {
+			if err := r.ReadRowAt(row, values); err != nil {
+				t.Fatalf("%s: row %d: %v", c.name, row, err)
+			}
+			if want := samples[row*c.width+c.width-1]; !same(values[c.width-1], want) {
+				t.Errorf("%s: row %d got %f, want %f", c.name, row, values[c.width-1], want)
+			}
+		}
+	}
+}
+
+func TestRasterErrors(t *testing.T) {
+
+	le := binary.LittleEndian
+	o := tiffOptions{order: le, sampleFormat: 1, bits: 16, compression: compressionNone, rowsPerStrip: 2}
+	with := func(tag uint16, f field) tiffOptions {
+		o := o
+		o.fields = map[uint16]field{tag: f}
+		return o
+	}
+	short := func(v uint16) field {
+		f := field{typ: 3, count: 1, data: make([]byte, 2)}
+		le.PutUint16(f.data, v)
+		return f
+	}
+	projected := o
+	projected.projected = true
+
+	cases := []struct {
+		name        string
+		o           tiffOptions
+		unsupported bool
+	}{
+		{"RGB", with(tagSamplesPerPixel, short(3)), true},
+		{"JPEG", with(tagCompression, short(7)), true},
+		{"12 bits", with(tagBitsPerSample, short(12)), true},
+		{"floating point predictor on integers", with(tagPredictor, short(predictorFloatingPoint)), true},
+		{"projected", projected, true},
+		{"no georeferencing", with(tagModelTiepoint, field{}), false},
+		{"missing strips", with(tagStripOffsets, field{}), false},
+		{"no width", with(tagImageWidth, field{}), false},
+	}
+	for _, c := range cases {
+		_, err := Open(bytes.NewReader(writeTIFF(c.o, 4, 4, make([]float64, 16))))
+		if err == nil || errors.Is(err, ErrUnsupported) != c.unsupported {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+	}
+
+	if _, err := Open(bytes.NewReader([]byte("GIF89a\x00\x00"))); err == nil {
+		t.Errorf("a GIF file should be refused")
+	}
+
+	// a truncated block is reported when the row is read
+	file := writeTIFF(o, 4, 4, make([]float64, 16))
+	r, err := Open(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.byteCounts[1] = uint64(len(file))
+	if err := r.ReadRowAt(2, make([]float64, 4)); err == nil {
+		t.Errorf("a block beyond the end of the file should be refused")
+	}
+}
+
+func TestLZW(t *testing.T) {
+
+	// "ABABABA", codes clear A B AB ABA EOI on 9 bits (ABA is the code being defined)
+	got, err := lzwDecode([]byte{0x80, 0x10, 0x48, 0x50, 0x28, 0x24, 0x04}, 100)
+	if err != nil || string(got) != "ABABABA" {
+		t.Errorf("got %q %v", got, err)
+	}
+
+	// long enough for codes of 12 bits and clear codes
+	rng := rand.New(rand.NewSource(1))
+	src := make([]byte, 200000)
+	for idx := range src {
+		src[idx] = byte(rng.Intn(4) + 'a')
+	}
+	got, err = lzwDecode(lzwEncode(src), len(src))
+	if err != nil || !bytes.Equal(got, src) {
+		t.Errorf("round trip got %d bytes, %v", len(got), err)
+	}
+
+	if _, err := lzwDecode([]byte{0x80, 0x10, 0x65, 0x80}, 100); err == nil {
+		t.Errorf("a code beyond the table should be refused")
+	}
+}
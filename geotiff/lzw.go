@@ -0,0 +1,110 @@
+package geotiff
+
+import (
+	"fmt"
+)
+
+// codes of the TIFF variant of LZW
+const (
+	lzwClear   = 256
+	lzwEOI     = 257
+	lzwFirst   = 258 // first code of the strings of the table
+	lzwMaxCode = 4096
+)
+
+// lzwDecode decompresses a TIFF LZW stream into at most size bytes
+//
+// TIFF LZW is not the LZW of the standard library (GIF/PDF): codes are MSB first and
+// the width of the codes grows one code early, when the next code of the table is 2^width - 1
+func lzwDecode(src []byte, size int) ([]byte, error) {
+
+	dst := make([]byte, 0, size)
+
+	// each string of the table is its prefix string and its last byte
+	var prefix [lzwMaxCode]uint16
+	var suffix [lzwMaxCode]byte
+	var length [lzwMaxCode]int
+	for c := 0; c < 256; c++ {
+		suffix[c] = byte(c)
+		length[c] = 1
+	}
+
+	var bits uint32
+	var nbBits uint
+	width := uint(9)
+	next := lzwFirst
+	previous := -1
+	pos := 0
+
+	for len(dst) < size {
+		for nbBits < width {
+			if pos == len(src) {
+				// some encoders omit the EOI code, the caller checks the size
+				return dst, nil
+			}
+			bits = bits<<8 | uint32(src[pos])
+			pos++
+			nbBits += 8
+		}
+		code := int(bits>>(nbBits-width)) & (1<<width - 1)
+		nbBits -= width
+
+		switch {
+		case code == lzwClear:
+			width = 9
+			next = lzwFirst
+			previous = -1
+			continue
+		case code == lzwEOI:
+			return dst, nil
+		case previous == -1:
+			if code > 255 {
+				return nil, fmt.Errorf("geotiff: LZW code %d after a clear code", code)
+			}
+			dst = append(dst, byte(code))
+			previous = code
+			continue
+		case code > next:
+			return nil, fmt.Errorf("geotiff: invalid LZW code %d (next code %d)", code, next)
+		}
+
+		// the string of the code (the previous string and its first byte if the code is not yet in the table)
+		start := len(dst)
+		if code < next {
+			dst = appendString(dst, code, &prefix, &suffix, &length)
+		} else {
+			dst = appendString(dst, previous, &prefix, &suffix, &length)
+			dst = append(dst, dst[start])
+		}
+
+		// new string, the previous string and the first byte of the string of the code
+		// (the table stays full until the next clear code)
+		if next < lzwMaxCode {
+			prefix[next] = uint16(previous)
+			suffix[next] = dst[start]
+			length[next] = length[previous] + 1
+			next++
+			if next == 1<<width-1 && width < 12 {
+				width++
+			}
+		}
+		previous = code
+	}
+	return dst[:size], nil
+}
+
+// append the string of code to dst
+func appendString(dst []byte, code int, prefix *[lzwMaxCode]uint16, suffix *[lzwMaxCode]byte, length *[lzwMaxCode]int) []byte {
+
+	start := len(dst)
+	end := start + length[code]
+	for cap(dst) < end {
+		dst = append(dst[:cap(dst)], 0)
+	}
+	dst = dst[:end]
+	for idx := end - 1; idx >= start; idx-- {
+		dst[idx] = suffix[code]
+		code = int(prefix[code])
+	}
+	return dst
+}
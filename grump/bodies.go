@@ -0,0 +1,351 @@
+package grump
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/thomaspeugeot/tkv/bods"
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// RowReader reads the rows of a population raster, from the north to the south
+// (GridReader, geotiff.Raster and the landscan grids)
+type RowReader interface {
+	ReadRow(values []float64) error
+}
+
+// Population is the population count of the cells of a country
+type Population struct {
+	// nb of individuals per cell, row 0 is at the south (the orientation of Row2Lat)
+	Cells [][]float64
+
+	// cells that are not no-data, with the same orientation as Cells
+	Within [][]bool
+
+	Total float64
+}
+
+// ReadPopulation reads the rows of the raster of the country (NRows rows of NCols cells).
+// no-data cells (isNoData or NaN) have no population and are not within the country
+func ReadPopulation(country *Country, rows RowReader, isNoData func(v float64) bool) (*Population, error) {
+
+	var p Population
+	p.Cells = make([][]float64, country.NRows)
+	p.Within = make([][]bool, country.NRows)
+
+	// the raster is read from the north
+	for row := country.NRows - 1; row >= 0; row-- {
+		values := make([]float64, country.NCols)
+		if err := rows.ReadRow(values); err != nil {
+			return nil, err
+		}
+		p.Within[row] = make([]bool, country.NCols)
+		for col, nbIndividualsInCell := range values {
+			if isNoData(nbIndividualsInCell) || math.IsNaN(nbIndividualsInCell) {
+				values[col] = 0
+			} else {
+				p.Within[row][col] = true
+				p.Total += nbIndividualsInCell
+			}
+		}
+		p.Cells[row] = values
+		Trace.Printf("row %5d lat %2.3f total %f", row, country.Row2Lat(row), p.Total)
+	}
+	Info.Printf("population of %s read, total %f", country.Name, p.Total)
+	return &p, nil
+}
+
+// Mask returns the mask of the cells that are not no-data
+func (p *Population) Mask() *Mask {
+	nRows := len(p.Within)
+	nCols := 0
+	if nRows > 0 {
+		nCols = len(p.Within[0])
+	}
+	return NewRasterMask(nCols, nRows, func(row, col int) bool { return p.Within[row][col] })
+}
+
+// Keep keeps the population of the cells whose center is within the mask, the other cells
+// have no population and are not within the country
+func (p *Population) Keep(mask *Mask) {
+
+	p.Total = 0
+	for row := range p.Cells {
+		y := (float64(row) + 0.5) / float64(len(p.Cells))
+		for col := range p.Cells[row] {
+			x := (float64(col) + 0.5) / float64(len(p.Cells[row]))
+			if !mask.Contains(x, y) {
+				p.Cells[row][col] = 0
+				p.Within[row][col] = false
+			}
+			p.Total += p.Cells[row][col]
+		}
+	}
+}
+
+// Arrangements are the positions of n circles in a unit square, for n from 1 to Max()
+//
+// the arrangement is the optimal packing of the csq files or a fibonacci packing
+type Arrangements struct {
+	csq [][][2]float64 // csq[n][circle], nil for the fibonacci packing
+}
+
+// maximum nb of circles per cell of the arrangements
+const (
+	maxCirclesCSQ       = 750
+	maxCirclesFibonacci = 10000
+)
+
+// FibonacciArrangements returns the fibonacci packing of up to 10000 circles
+func FibonacciArrangements() Arrangements {
+	return Arrangements{}
+}
+
+// ReadArrangements reads the optimal packing of up to 750 circles from the csq_coords
+// directory of the tkv data (csq<n>.txt, one line per circle: id, x, y)
+func ReadArrangements(dirTKVData string) (Arrangements, error) {
+
+	a := Arrangements{csq: make([][][2]float64, maxCirclesCSQ+1)}
+	for nbCircles := 1; nbCircles <= maxCirclesCSQ; nbCircles++ {
+
+		a.csq[nbCircles] = make([][2]float64, nbCircles)
+
+		// open the reference file
+		circlePackingFilePath := fmt.Sprintf("%s/csq_coords/csq%d.txt", dirTKVData, nbCircles)
+		circlePackingFile, err := os.Open(filepath.Clean(circlePackingFilePath))
+		if err != nil {
+			return Arrangements{}, err
+		}
+
+		// one line per circle
+		scannerCircle := bufio.NewScanner(circlePackingFile)
+		scannerCircle.Split(bufio.ScanWords)
+		for circle := 0; circle < nbCircles; circle++ {
+			var id string
+			for _, v := range []interface{}{&id, &a.csq[nbCircles][circle][0], &a.csq[nbCircles][circle][1]} {
+				if !scannerCircle.Scan() {
+					circlePackingFile.Close()
+					return Arrangements{}, fmt.Errorf("%s: %d circles, want %d", circlePackingFilePath, circle, nbCircles)
+				}
+				fmt.Sscan(scannerCircle.Text(), v)
+			}
+		}
+		circlePackingFile.Close()
+	}
+	Info.Printf("reading circle packing files is over")
+	return a, nil
+}
+
+// Max returns the maximum nb of circles of the arrangements
+func (a Arrangements) Max() int {
+	if a.csq != nil {
+		return maxCirclesCSQ
+	}
+	return maxCirclesFibonacci
+}
+
+// Coord returns the position within the unit square of the circle of the arrangement of nbCircles
+func (a Arrangements) Coord(nbCircles, circle int) (x, y float64) {
+
+	// the csq coordinates are centered on the square
+	if a.csq != nil {
+		return 0.5 + a.csq[nbCircles][circle][0], 0.5 + a.csq[nbCircles][circle][1]
+	}
+
+	// coef is the spacing at the end and the beginning
+	// of each row
+	goldentRatio := 1.0 + math.Sqrt(5.0)
+	coef := math.Sqrt(float64(nbCircles)) / (math.Sqrt(float64(nbCircles)) + 1.0)
+
+	x = (float64(circle) + 0.5) / float64(nbCircles)
+	_, y = math.Modf(((float64(circle) + 0.5) * goldentRatio))
+
+	// shrink by coef at the center
+	x = 0.5 + (x-0.5)*coef
+	y = 0.5 + (y-0.5)*coef
+	return x, y
+}
+
+// BodiesConfig are the parameters of the generation of the bodies from the population
+type BodiesConfig struct {
+	TargetMaxBodies int
+	SampleRatio     float64 // ratio (in %) of the bodies that are kept
+	UrbanThreshold  float64 // nb of individuals above which the bodies of a cell get the urban flag
+	Arrangements    Arrangements
+}
+
+// BodiesStats are the statistics of the generation of the bodies
+type BodiesStats struct {
+	PopTotal                        float64 // population of the country
+	Cutoff                          float64 // population per body
+	CumulativePop                   float64 // population of the cells
+	PopInParselyPopulatedCells      float64 // population of the cells that got a body after the first pass
+	MissedPop                       float64 // population of the cells with population but without bodies
+	NotAccountedForPop              float64 // population of these cells that did not get a body
+	NbBodies                        int
+	NbCells                         int
+	NbCellsWithoutBodies            int
+	NbCellsWithPopButWithZeroBodies int
+}
+
+// String returns the statistics, one per line
+func (s BodiesStats) String() string {
+	return fmt.Sprintf("pop total\t\t\t%10.0f\n", s.PopTotal) +
+		fmt.Sprintf("pop cutoff per cell\t%10.0f\n", s.Cutoff) +
+		fmt.Sprintf("Total pop in graph cells\t%10.0f\n", s.PopInParselyPopulatedCells) +
+		fmt.Sprintf("cumulative pop\t\t\t%10.0f\n", s.CumulativePop) +
+		fmt.Sprintf("nb of bodies\t\t\t%10d\n", s.NbBodies) +
+		fmt.Sprintf("nb of cells \t\t\t%10d\n", s.NbCells) +
+		fmt.Sprintf("nb of cells with bodies\t\t%10d\n", s.NbCells-s.NbCellsWithoutBodies) +
+		fmt.Sprintf("nb of cells without bodies\t%10d\n", s.NbCellsWithoutBodies) +
+		fmt.Sprintf("nb of cells with pop w/o bodies\t%10d\n", s.NbCellsWithPopButWithZeroBodies) +
+		fmt.Sprintf("graph pop of cells\t\t\t%10.0f\n", s.MissedPop) +
+		fmt.Sprintf("missed pop of cells w/o bodies\t%10.0f\n", s.NotAccountedForPop)
+}
+
+// GenerateBodies places the bodies in the cells of the country, the number of bodies of a
+// cell is proportional to its population and the bodies are arranged within the cell. attributes[idx]
+// tells where bodies[idx] comes from. The sampling of the bodies is drawn from rng.
+func GenerateBodies(country *Country, population *Population, config BodiesConfig, rng *rand.Rand) (bodies []quadtree.Body, attributes []bods.Attributes, stats BodiesStats) {
+
+	cutoff := population.Total / float64(config.TargetMaxBodies)
+
+	maxCirclePerCell := config.Arrangements.Max()
+	colLngWidth := country.Spacing()
+
+	Info.Printf("Preparing the ouput")
+	cumulativePopTotal := 0.0
+	nbCellsWithZeroBodies := 0
+	nbCellsWithPopButWithZeroBodies := 0
+	missedPopulationTotal := 0.0
+
+	// 2D array to store wether the cell has no bodies but some pop
+	parselyPopulatedCellCoords := make([][]bool, country.NRows)
+
+	Info.Printf("Parsing the pop cells and generating bodies")
+	for row := 0; row < country.NRows; row++ {
+		lat := country.Row2Lat(row)
+
+		// allocate for col
+		parselyPopulatedCellCoords[row] = make([]bool, country.NCols)
+		for col := 0; col < country.NCols; col++ {
+			lng := float64(country.XllCorner) + (float64(col) * colLngWidth)
+
+			// compute relative coordinate of the cell
+			relX, relY := country.LatLng2XY(lat, lng)
+
+			// fetch count of the cell
+			nbIndividualsInCell := population.Cells[row][col]
+
+			// how many bodies ? it is maxBodies *( nbIndividualsInCell / country.PCount)
+			nbBodiesInCell := int(math.Floor(float64(config.TargetMaxBodies) * nbIndividualsInCell / population.Total))
+
+			massPerBody := cutoff
+
+			if nbBodiesInCell == 0 {
+				nbCellsWithZeroBodies++
+			}
+			if nbBodiesInCell == 0 && nbIndividualsInCell > 0 {
+				nbCellsWithPopButWithZeroBodies++
+				missedPopulationTotal += nbIndividualsInCell
+				parselyPopulatedCellCoords[row][col] = true
+			}
+			if nbBodiesInCell > maxCirclePerCell {
+				Error.Printf("nbBodiesInCell %d superior to maxCirclePerCell %d", nbBodiesInCell, maxCirclePerCell)
+
+				nbBodiesInCell = maxCirclePerCell
+				massPerBody = float64(nbIndividualsInCell) / float64(nbBodiesInCell)
+			}
+
+			// initiate the bodies in cell
+			for i := 0; i < nbBodiesInCell; i++ {
+				x, y := config.Arrangements.Coord(nbBodiesInCell, i)
+				var body quadtree.Body
+				body.X = relX + x/float64(country.NCols)
+				body.Y = relY + y/float64(country.NRows)
+				body.M = massPerBody
+
+				// sample bodies
+				sample := rng.Float64() * 100.0
+				if sample < config.SampleRatio {
+					bodies = append(bodies, body)
+					attributes = append(attributes, bods.Attributes{
+						Row:        int32(row),
+						Col:        int32(col),
+						Population: massPerBody,
+						Urban:      nbIndividualsInCell >= config.UrbanThreshold})
+				}
+			}
+			cumulativePopTotal += nbIndividualsInCell
+		}
+	}
+
+	var popInParselyPopulatedCells, notAccountedForPop float64
+
+	// since this is a memory hungry operation
+	// the following operation is split among set of rows
+	nbChunk := 10
+	for chunk := 0; chunk < nbChunk; chunk++ {
+
+		Info.Printf("%d/%d to %d/%d", chunk, nbChunk, chunk+1, nbChunk)
+		graphBodies, graphAttributes := AddBodiesOfParselyPopulatedCells(
+			chunk*(country.NRows/nbChunk),
+			(chunk+1)*(country.NRows/nbChunk),
+			country,
+			parselyPopulatedCellCoords,
+			population.Cells,
+			colLngWidth,
+			cutoff,
+			config.SampleRatio,
+			config.UrbanThreshold,
+			rng,
+			&popInParselyPopulatedCells,
+			&notAccountedForPop)
+		bodies = append(bodies, graphBodies...)
+		attributes = append(attributes, graphAttributes...)
+	}
+
+	stats = BodiesStats{
+		PopTotal:                        population.Total,
+		Cutoff:                          cutoff,
+		CumulativePop:                   cumulativePopTotal,
+		PopInParselyPopulatedCells:      popInParselyPopulatedCells,
+		MissedPop:                       missedPopulationTotal,
+		NotAccountedForPop:              notAccountedForPop,
+		NbBodies:                        len(bodies),
+		NbCells:                         country.NRows * country.NCols,
+		NbCellsWithoutBodies:            nbCellsWithZeroBodies,
+		NbCellsWithPopButWithZeroBodies: nbCellsWithPopButWithZeroBodies,
+	}
+	return bodies, attributes, stats
+}
+
+// BodiesNamePattern is the name of the body files (the pattern of barneshut.CountryBodiesNamePattern),
+// with the name of the country, the nb of bodies and the step
+const BodiesNamePattern = "conf-%s-%08d-%05d.bods"
+
+// WriteBodies numbers the bodies and writes the body file of the country in the current directory
+// (conf-<name>-<nb of bodies>-00000.bods)
+func (country *Country) WriteBodies(bodies []quadtree.Body, attributes []bods.Attributes) error {
+
+	// the ID of a body is its rank in the output, it identifies the body until the translation
+	for idx := range bodies {
+		bodies[idx].ID = uint32(idx + 1)
+	}
+
+	filename := fmt.Sprintf(BodiesNamePattern, country.Name, len(bodies), 0)
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := bods.NewEncoder(file).EncodeWithAttributes(country.Name, 0, bodies, attributes); err != nil {
+		file.Close()
+		return fmt.Errorf("writing the bodies of %s in %s: %w", country.Name, filename, err)
+	}
+	Info.Printf("bodies written in %s", filename)
+	return file.Close()
+}
@@ -0,0 +1,131 @@
+package grump
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	barneshut "github.com/thomaspeugeot/tkv/barnes-hut"
+	"github.com/thomaspeugeot/tkv/bods"
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+func TestGenerateBodies(t *testing.T) {
+
+	// 3 x 10 cells, the population is on the east and the north sides of the country
+	country := Country{Name: "tst", NCols: 3, NRows: 10, XllCorner: -72, YllCorner: 18}
+	p := Population{Cells: make([][]float64, country.NRows)}
+	for row := range p.Cells {
+		p.Cells[row] = []float64{0, 10, 100}
+		p.Total += 110
+	}
+	p.Cells[country.NRows-1][0] = 1000
+	p.Total += 1000
+
+	config := BodiesConfig{TargetMaxBodies: 1000, SampleRatio: 100, UrbanThreshold: 1000, Arrangements: FibonacciArrangements()}
+	bodies, attributes, stats := GenerateBodies(&country, &p, config, rand.New(rand.NewSource(1)))
+	if len(bodies) == 0 || len(bodies) != len(attributes) {
+		t.Fatalf("%d bodies, %d attributes", len(bodies), len(attributes))
+	}
+	if stats.NbBodies != len(bodies) || stats.PopTotal != p.Total || stats.NbCells != 30 || stats.NbCellsWithoutBodies != 9 {
+		t.Errorf("stats %#v", stats)
+	}
+
+	// the bodies are within their cell
+	nbUrban := 0
+	for idx, b := range bodies {
+		a := attributes[idx]
+		col := int(b.X * float64(country.NCols))
+		row := int(b.Y * float64(country.NRows))
+		if b.X <= 0 || b.X >= 1 || b.Y <= 0 || b.Y >= 1 || row != int(a.Row) || col != int(a.Col) {
+			t.Fatalf("body %d at %f %f, in cell %d %d, want cell %d %d", idx, b.X, b.Y, row, col, a.Row, a.Col)
+		}
+		if a.Urban {
+			nbUrban++
+		}
+	}
+	if nbUrban == 0 {
+		t.Errorf("no urban body")
+	}
+}
+
+// test that the cells whose population is too low for a body get the bodies of their connected set
+func TestGenerateBodiesOfParselyPopulatedCells(t *testing.T) {
+
+	// 3 x 100 cells, the cells of the west side have 1 individual, too few for a body (cutoff is 5.1)
+	country := Country{Name: "tst", NCols: 3, NRows: 100, XllCorner: -72, YllCorner: 18}
+	p := Population{Cells: make([][]float64, country.NRows)}
+	for row := range p.Cells {
+		p.Cells[row] = []float64{1, 0, 50}
+		p.Total += 51
+	}
+
+	config := BodiesConfig{TargetMaxBodies: 1000, SampleRatio: 100, UrbanThreshold: 1000, Arrangements: FibonacciArrangements()}
+	bodies, attributes, stats := GenerateBodies(&country, &p, config, rand.New(rand.NewSource(1)))
+	if len(bodies) != len(attributes) || stats.NbBodies != len(bodies) || stats.PopInParselyPopulatedCells != 100 {
+		t.Fatalf("%d bodies, %d attributes, stats %#v", len(bodies), len(attributes), stats)
+	}
+
+	// the rows are split in 10 chunks, the 10 cells of the west side of a chunk get one body
+	nbWest := 0
+	for idx, b := range bodies {
+		a := attributes[idx]
+		if a.Col != 0 {
+			continue
+		}
+		nbWest++
+		if b.M != stats.Cutoff || a.Population != stats.Cutoff || a.Urban || int(b.X*float64(country.NCols)) != 0 {
+			t.Errorf("body %d %#v, attributes %#v", idx, b, a)
+		}
+	}
+	if nbWest != 10 {
+		t.Errorf("got %d bodies on the west side, want 10", nbWest)
+	}
+}
+
+// test that the body file is written with the name and the content read by the simulation
+func TestWriteBodies(t *testing.T) {
+
+	if BodiesNamePattern != barneshut.CountryBodiesNamePattern {
+		t.Errorf("body file name %s, simulation reads %s", BodiesNamePattern, barneshut.CountryBodiesNamePattern)
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	country := Country{Name: "tst"}
+	var bodies []quadtree.Body
+	quadtree.InitBodiesUniform(&bodies, 10)
+	attributes := make([]bods.Attributes, len(bodies))
+	for idx := range attributes {
+		attributes[idx].Row = int32(idx)
+	}
+	if err := country.WriteBodies(bodies, attributes); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(fmt.Sprintf(barneshut.CountryBodiesNamePattern, "tst", len(bodies), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	header, read, readAttributes, err := bods.ReadBodiesWithAttributes(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Country != "tst" || header.Step != 0 || len(read) != len(bodies) || len(readAttributes) != len(bodies) {
+		t.Fatalf("header %#v, %d bodies, %d attributes", header, len(read), len(readAttributes))
+	}
+	for idx := range read {
+		if read[idx] != bodies[idx] || read[idx].ID != uint32(idx+1) || readAttributes[idx] != attributes[idx] {
+			t.Errorf("body %d got %#v %#v, want %#v %#v", idx, read[idx], readAttributes[idx], bodies[idx], attributes[idx])
+		}
+	}
+}
@@ -22,12 +22,47 @@ type Country struct {
 	Name                               string
 	NCols, NRows int
 	XllCorner, YllCorner float64
+
+	// side length in degrees of the cells (GrumpSpacing if not set)
+	CellSize float64 `json:",omitempty"`
+
+	// domain of the country within the unit square (nil if the bodies can go anywhere)
+	Mask *Mask `json:",omitempty"`
+}
+
+// CheckName checks that the name of a country, its ISO 3166 code or the key of a region (for instance
+// "idf" or "hti-ouest"), can be used in the names of the files: letters, digits, '_' and '-'
+func CheckName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty country name")
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return fmt.Errorf("country name %q, only letters, digits, '_' and '-' are allowed", name)
+		}
+	}
+	return nil
+}
+
+// Spacing returns the side length in degrees of the cells
+func (country *Country) Spacing() float64 {
+	if country.CellSize == 0 {
+		return GrumpSpacing
+	}
+	return country.CellSize
+}
+
+// SetGridHeader sets the size and the corner of the country from the header of its grid
+func (country *Country) SetGridHeader(h GridHeader) {
+	country.NCols, country.NRows = h.NCols, h.NRows
+	country.XllCorner, country.YllCorner = h.XllCorner, h.YllCorner
+	country.CellSize = h.CellSize
 }
 
 // Row2Lat converts from row index to lat
 func (country *Country) Row2Lat(row int) (lat float64) {
 	// lat := float64( country.YllCorner) + (float64( country.NRows - row)*rowLatWidth)
-	lat = float64(country.YllCorner) + float64(row)*GrumpSpacing
+	lat = float64(country.YllCorner) + float64(row)*country.Spacing()
 	return lat
 }
 
@@ -65,8 +100,8 @@ func (country *Country) Unserialize() {
 	Info.Printf("(Grump) Init Country orig lat %f lng %f size lat %f lng %f ",
 		float64(country.YllCorner),
 		float64(country.XllCorner),
-		float64(country.NRows)*GrumpSpacing,
-		float64(country.NCols)*GrumpSpacing)
+		float64(country.NRows)*country.Spacing(),
+		float64(country.NCols)*country.Spacing())
 
 	file.Close()
 }
@@ -75,8 +110,8 @@ func (country *Country) Unserialize() {
 func (country *Country) LatLng2XY(lat, lng float64) (x, y float64) {
 
 	// compute relative coordinates within the square
-	x = (lng - float64(country.XllCorner)) / (float64(country.NCols) * GrumpSpacing)
-	y = (lat - float64(country.YllCorner)) / (float64(country.NRows) * GrumpSpacing) // y is 0 at northest point and 1.0 at southest point
+	x = (lng - float64(country.XllCorner)) / (float64(country.NCols) * country.Spacing())
+	y = (lat - float64(country.YllCorner)) / (float64(country.NRows) * country.Spacing()) // y is 0 at northest point and 1.0 at southest point
 
 	return x, y
 }
@@ -84,8 +119,8 @@ func (country *Country) LatLng2XY(lat, lng float64) (x, y float64) {
 // XY2LatLng gives from lat/lng, the relative coordinate within the country
 func (country *Country) XY2LatLng(x, y float64) (lat, lng float64) {
 
-	lat = float64(country.YllCorner) + (y * float64(country.NRows) * GrumpSpacing)
-	lng = float64(country.XllCorner) + (x * float64(country.NCols) * GrumpSpacing)
+	lat = float64(country.YllCorner) + (y * float64(country.NRows) * country.Spacing())
+	lng = float64(country.XllCorner) + (x * float64(country.NCols) * country.Spacing())
 
 	return lat, lng
 }
@@ -0,0 +1,115 @@
+package grump
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// a GeoJSON object, a geometry, a feature or a feature collection
+type geoJSON struct {
+	Type        string
+	Properties  map[string]interface{}
+	Coordinates json.RawMessage
+	Geometry    *geoJSON
+	Features    []geoJSON
+	Geometries  []geoJSON
+}
+
+// ReadGeoJSON reads the rings of lng/lat points of the polygons of a GeoJSON object (a Polygon or a
+// MultiPolygon, a Feature, a FeatureCollection or a GeometryCollection of them). The holes are rings
+// as the outer rings, the masks use the even-odd rule.
+func ReadGeoJSON(r io.Reader) ([][][2]float64, error) {
+	return ReadGeoJSONWhere(r, "", "")
+}
+
+// ReadGeoJSONWhere reads the rings of the polygons of the features whose property is value (for
+// instance the "code" of a département in the file of all the départements), all the polygons if
+// property is empty
+func ReadGeoJSONWhere(r io.Reader, property, value string) ([][][2]float64, error) {
+
+	var object geoJSON
+	if err := json.NewDecoder(r).Decode(&object); err != nil {
+		return nil, fmt.Errorf("geojson: %w", err)
+	}
+	var rings [][][2]float64
+	if err := object.appendRings(&rings, property, value); err != nil {
+		return nil, err
+	}
+	if len(rings) == 0 && property != "" {
+		return nil, fmt.Errorf("geojson: no polygon of a feature whose %s is %s", property, value)
+	}
+	if len(rings) == 0 {
+		return nil, fmt.Errorf("geojson: no polygon in the %s", object.Type)
+	}
+	return rings, nil
+}
+
+func (object *geoJSON) appendRings(rings *[][][2]float64, property, value string) error {
+
+	// the geometries out of a feature have no properties
+	if property != "" && object.Type != "Feature" && object.Type != "FeatureCollection" {
+		return nil
+	}
+
+	switch object.Type {
+	case "Polygon":
+		var polygon [][][2]float64
+		if err := json.Unmarshal(object.Coordinates, &polygon); err != nil {
+			return fmt.Errorf("geojson: coordinates of a Polygon: %w", err)
+		}
+		*rings = append(*rings, polygon...)
+	case "MultiPolygon":
+		var polygons [][][][2]float64
+		if err := json.Unmarshal(object.Coordinates, &polygons); err != nil {
+			return fmt.Errorf("geojson: coordinates of a MultiPolygon: %w", err)
+		}
+		for _, polygon := range polygons {
+			*rings = append(*rings, polygon...)
+		}
+	case "Feature":
+		if property != "" {
+			// numbers are compared in their shortest form, 75 and not 75.000000
+			v, ok := object.Properties[property]
+			if !ok || v == nil || fmt.Sprint(v) != value {
+				return nil
+			}
+		}
+		if object.Geometry != nil {
+			return object.Geometry.appendRings(rings, "", "")
+		}
+	case "FeatureCollection":
+		for idx := range object.Features {
+			if err := object.Features[idx].appendRings(rings, property, value); err != nil {
+				return err
+			}
+		}
+	case "GeometryCollection":
+		for idx := range object.Geometries {
+			if err := object.Geometries[idx].appendRings(rings, property, value); err != nil {
+				return err
+			}
+		}
+	case "Point", "MultiPoint", "LineString", "MultiLineString":
+		// no area
+	default:
+		return fmt.Errorf("geojson: unknown type %q", object.Type)
+	}
+	return nil
+}
+
+// RingsBounds returns the bounding box of rings of lng/lat points, lat of the north and south
+// sides and lng of the west and east sides
+func RingsBounds(rings [][][2]float64) (north, south, west, east float64) {
+
+	north, south = math.Inf(-1), math.Inf(1)
+	west, east = math.Inf(1), math.Inf(-1)
+	for _, ring := range rings {
+		for _, point := range ring {
+			west, east = math.Min(west, point[0]), math.Max(east, point[0])
+			south, north = math.Min(south, point[1]), math.Max(north, point[1])
+		}
+	}
+	return north, south, west, east
+}
@@ -0,0 +1,129 @@
+package grump
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadGeoJSON(t *testing.T) {
+
+	square := `[[0,0],[2,0],[2,1],[0,1],[0,0]]`
+	hole := `[[0.5,0.25],[1,0.25],[1,0.75],[0.5,0.25]]`
+	far := `[[10,-5],[11,-5],[11,-4],[10,-5]]`
+
+	cases := []struct {
+		name, input string
+		nbRings     int
+	}{
+		{"polygon", `{"type":"Polygon","coordinates":[` + square + `,` + hole + `]}`, 2},
+		{"multipolygon", `{"type":"MultiPolygon","coordinates":[[` + square + `],[` + far + `]]}`, 2},
+		{"feature", `{"type":"Feature","properties":{"name":"a"},"geometry":{"type":"Polygon","coordinates":[` + square + `]}}`, 1},
+		{"collection", `{"type":"FeatureCollection","features":[
+			{"type":"Feature","geometry":{"type":"Point","coordinates":[1,1]}},
+			{"type":"Feature","geometry":{"type":"GeometryCollection","geometries":[{"type":"Polygon","coordinates":[` + far + `]}]}},
+			{"type":"Feature","geometry":{"type":"Polygon","coordinates":[` + square + `,` + hole + `]}}]}`, 3},
+	}
+	for _, c := range cases {
+		rings, err := ReadGeoJSON(strings.NewReader(c.input))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if len(rings) != c.nbRings {
+			t.Errorf("%s: %d rings, want %d", c.name, len(rings), c.nbRings)
+		}
+	}
+
+	for _, input := range []string{
+		`{"type":"Point","coordinates":[1,1]}`,
+		`{"type":"Polygon","coordinates":[[1,1]]}`,
+		`{"type":"Circle"}`,
+		`{"type":`,
+	} {
+		if _, err := ReadGeoJSON(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: no error", input)
+		}
+	}
+
+	rings, _ := ReadGeoJSON(strings.NewReader(`{"type":"MultiPolygon","coordinates":[[` + square + `],[` + far + `]]}`))
+	if north, south, west, east := RingsBounds(rings); north != 1 || south != -5 || west != 0 || east != 11 {
+		t.Errorf("bounds %f %f %f %f, want 1 -5 0 11", north, south, west, east)
+	}
+}
+
+func TestPopulationKeep(t *testing.T) {
+
+	// 4 x 2 cells of 1 degree from 0, 0, the polygon is the west half with a hole in the south west cell
+	country := Country{NCols: 4, NRows: 2, CellSize: 1}
+	rings, err := ReadGeoJSON(strings.NewReader(`{"type":"Polygon","coordinates":[
+		[[0,0],[2,0],[2,2],[0,2],[0,0]],
+		[[0.25,0.25],[0.75,0.25],[0.75,0.75],[0.25,0.75],[0.25,0.25]]]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Population{
+		Cells:  [][]float64{{1, 2, 3, 4}, {5, 6, 7, 8}},
+		Within: [][]bool{{true, true, true, true}, {true, true, true, false}},
+	}
+	p.Keep(country.PolygonMask(rings))
+
+	want := [][]float64{{0, 2, 0, 0}, {5, 6, 0, 0}}
+	for row := range want {
+		for col := range want[row] {
+			if p.Cells[row][col] != want[row][col] || p.Within[row][col] != (want[row][col] != 0) {
+				t.Errorf("cell %d %d: %f %t, want %f", row, col, p.Cells[row][col], p.Within[row][col], want[row][col])
+			}
+		}
+	}
+	if p.Total != 13 {
+		t.Errorf("total %f, want 13", p.Total)
+	}
+}
+
+func TestReadGeoJSONWhere(t *testing.T) {
+
+	input := `{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{"code":"75","name":"Paris"},"geometry":{"type":"Polygon","coordinates":[[[2,48],[3,48],[3,49],[2,48]]]}},
+		{"type":"Feature","properties":{"code":"92","name":"Hauts-de-Seine"},"geometry":{"type":"MultiPolygon","coordinates":[[[[1,48],[2,48],[2,49],[1,48]]],[[[0,0],[1,0],[1,1],[0,0]]]]}},
+		{"type":"Feature","properties":{"code":93},"geometry":{"type":"Polygon","coordinates":[[[4,48],[5,48],[5,49],[4,48]]]}},
+		{"type":"Feature","properties":null,"geometry":{"type":"Polygon","coordinates":[[[6,48],[7,48],[7,49],[6,48]]]}}]}`
+
+	cases := []struct {
+		property, value string
+		nbRings         int
+		west            float64
+	}{
+		{"code", "75", 1, 2},
+		{"name", "Hauts-de-Seine", 2, 0},
+		{"code", "93", 1, 4},
+		{"", "", 5, 0},
+	}
+	for _, c := range cases {
+		rings, err := ReadGeoJSONWhere(strings.NewReader(input), c.property, c.value)
+		if err != nil {
+			t.Errorf("%s %s: %v", c.property, c.value, err)
+			continue
+		}
+		if _, _, west, _ := RingsBounds(rings); len(rings) != c.nbRings || west != c.west {
+			t.Errorf("%s %s: %d rings west %f, want %d rings west %f", c.property, c.value, len(rings), west, c.nbRings, c.west)
+		}
+	}
+
+	if _, err := ReadGeoJSONWhere(strings.NewReader(input), "code", "13"); err == nil {
+		t.Errorf("no error for a missing feature")
+	}
+}
+
+func TestCheckName(t *testing.T) {
+	for _, name := range []string{"fra", "hti-ouest", "ile_de_france", "R75"} {
+		if err := CheckName(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "île", "a/b", "a b", "a.b"} {
+		if err := CheckName(name); err == nil {
+			t.Errorf("%q: no error", name)
+		}
+	}
+}
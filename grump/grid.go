@@ -0,0 +1,251 @@
+package grump
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// GridHeader is the header of an ESRI ASCII grid (the format of the GRUMP files)
+//
+//	ncols         1234
+//	nrows         567
+//	xllcorner     -5.5
+//	yllcorner     41.3
+//	cellsize      0.0083333333333
+//	NODATA_value  -2147483647
+//
+// keywords are case insensitive and can come in any order. xllcenter/yllcenter
+// can be used instead of xllcorner/yllcorner, NODATA_value is optional.
+type GridHeader struct {
+	NCols, NRows         int
+	XllCorner, YllCorner float64 // lower left corner of the south west cell (converted from xllcenter/yllcenter)
+	CellSize             float64
+
+	NoData    float64
+	HasNoData bool
+}
+
+// IsNoData tells wether v is the no-data value of the grid
+func (h *GridHeader) IsNoData(v float64) bool {
+	return h.HasNoData && v == h.NoData
+}
+
+// GridError is a malformed input in an ESRI ASCII grid
+type GridError struct {
+	Line int // line of the input, from 1
+	Msg  string
+}
+
+func (e *GridError) Error() string {
+	return fmt.Sprintf("grid line %d: %s", e.Line, e.Msg)
+}
+
+// GridReader streams the rows of an ESRI ASCII grid
+//
+// rows are read in the order of the file, that is from the north to the south:
+// the first row read is at the top of the grid, row NRows-1 of the country
+type GridReader struct {
+	Header GridHeader
+
+	r           *bufio.Reader
+	line        int    // line of the next byte of r
+	last        int    // line of the last token
+	pending     string // first token of the data, read while parsing the header
+	pendingLine int
+	row         int // nb of rows read
+}
+
+// NewGridReader parses and validates the header of the grid read from r
+func NewGridReader(r io.Reader) (*GridReader, error) {
+
+	g := GridReader{r: bufio.NewReaderSize(r, 1<<16), line: 1}
+
+	var xll, yll float64
+	var xCenter, yCenter bool
+	seen := make(map[string]int) // line of each keyword ("xll" and "yll" for the corners)
+
+	for {
+		token, line, err := g.token()
+		if err == io.EOF {
+			return nil, &GridError{g.last, "no data after the header"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		keyword := strings.ToLower(token)
+		switch keyword {
+		case "ncols", "nrows", "xllcorner", "xllcenter", "yllcorner", "yllcenter", "cellsize", "nodata_value":
+		default:
+			// first value of the grid
+			if _, errNumber := strconv.ParseFloat(token, 64); errNumber != nil {
+				return nil, &GridError{line, fmt.Sprintf("unknown keyword %q", token)}
+			}
+			g.pending, g.pendingLine = token, line
+			if err := validateHeader(seen, line); err != nil {
+				return nil, err
+			}
+			g.Header.XllCorner, g.Header.YllCorner = xll, yll
+			if xCenter {
+				g.Header.XllCorner -= g.Header.CellSize / 2.0
+			}
+			if yCenter {
+				g.Header.YllCorner -= g.Header.CellSize / 2.0
+			}
+			return &g, nil
+		}
+		key := keyword
+		if strings.HasSuffix(key, "corner") || strings.HasSuffix(key, "center") {
+			key = key[:3] // corner and center of an axis exclude each other
+		}
+		if previous, ok := seen[key]; ok {
+			return nil, &GridError{line, fmt.Sprintf("%s already set at line %d", token, previous)}
+		}
+		seen[key] = line
+
+		value, valueLine, err := g.token()
+		if err == io.EOF || (err == nil && valueLine != line) {
+			return nil, &GridError{line, fmt.Sprintf("missing value of %s", token)}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch keyword {
+		case "ncols", "nrows":
+			n, errInt := strconv.Atoi(value)
+			if errInt != nil || n <= 0 {
+				return nil, &GridError{line, fmt.Sprintf("%s got %q, want a positive integer", token, value)}
+			}
+			if keyword == "ncols" {
+				g.Header.NCols = n
+			} else {
+				g.Header.NRows = n
+			}
+			continue
+		}
+		f, errFloat := strconv.ParseFloat(value, 64)
+		if errFloat != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, &GridError{line, fmt.Sprintf("%s got %q, want a number", token, value)}
+		}
+		switch keyword {
+		case "xllcorner", "xllcenter":
+			xll, xCenter = f, keyword == "xllcenter"
+		case "yllcorner", "yllcenter":
+			yll, yCenter = f, keyword == "yllcenter"
+		case "cellsize":
+			if f <= 0 {
+				return nil, &GridError{line, fmt.Sprintf("cellsize got %q, want a positive number", value)}
+			}
+			g.Header.CellSize = f
+		case "nodata_value":
+			g.Header.NoData, g.Header.HasNoData = f, true
+		}
+	}
+}
+
+// check that the mandatory keywords are set, line is the line of the first value
+func validateHeader(seen map[string]int, line int) error {
+
+	for _, keyword := range []string{"ncols", "nrows", "xll", "yll", "cellsize"} {
+		if _, ok := seen[keyword]; !ok {
+			if len(keyword) == 3 {
+				keyword = keyword + "corner or " + keyword + "center"
+			}
+			return &GridError{line, fmt.Sprintf("missing %s in the header", keyword)}
+		}
+	}
+	return nil
+}
+
+// Row returns the nb of rows read so far
+func (g *GridReader) Row() int {
+	return g.row
+}
+
+// ReadRow reads the next row of the grid into values, that has to be of length NCols
+//
+// it returns io.EOF after the last row. After the last row, anything but white spaces
+// is an error.
+func (g *GridReader) ReadRow(values []float64) error {
+
+	if len(values) != g.Header.NCols {
+		return fmt.Errorf("grid row of %d values, want %d", len(values), g.Header.NCols)
+	}
+	if g.row == g.Header.NRows {
+		token, line, err := g.token()
+		if err == nil {
+			return &GridError{line, fmt.Sprintf("unexpected %q after the last row", token)}
+		}
+		return err
+	}
+
+	for col := range values {
+		token, line, err := g.token()
+		if err == io.EOF {
+			return &GridError{g.last, fmt.Sprintf("unexpected end of file, row %d has %d values, want %d", g.row, col, g.Header.NCols)}
+		}
+		if err != nil {
+			return err
+		}
+		v, errFloat := strconv.ParseFloat(token, 64)
+		if errFloat != nil || math.IsNaN(v) {
+			return &GridError{line, fmt.Sprintf("row %d col %d got %q, want a number", g.row, col, token)}
+		}
+		values[col] = v
+	}
+	g.row++
+	return nil
+}
+
+// next white space separated token and its line, io.EOF if there is none
+func (g *GridReader) token() (token string, line int, err error) {
+
+	if g.pending != "" {
+		token, g.pending = g.pending, ""
+		g.last = g.pendingLine
+		return token, g.last, nil
+	}
+
+	// skip the white spaces
+	var c byte
+	for {
+		c, err = g.r.ReadByte()
+		if err != nil {
+			return "", g.line, err
+		}
+		if c == '\n' {
+			g.line++
+		}
+		if !isSpace(c) {
+			break
+		}
+	}
+
+	line = g.line
+	g.last = line
+	var b strings.Builder
+	for {
+		b.WriteByte(c)
+		c, err = g.r.ReadByte()
+		if err == io.EOF {
+			return b.String(), line, nil
+		}
+		if err != nil {
+			return "", line, err
+		}
+		if isSpace(c) {
+			if c == '\n' {
+				g.line++
+			}
+			return b.String(), line, nil
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
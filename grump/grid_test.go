@@ -0,0 +1,174 @@
+package grump
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+// header and rows of a grid file of the testdata
+func readGrid(filename string) (*GridHeader, [][]float64, error) {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	g, err := NewGridReader(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rows [][]float64
+	for {
+		values := make([]float64, g.Header.NCols)
+		err := g.ReadRow(values)
+		if err == io.EOF {
+			return &g.Header, rows, nil
+		}
+		if err != nil {
+			return &g.Header, rows, err
+		}
+		rows = append(rows, values)
+	}
+}
+
+func TestGridReader(t *testing.T) {
+
+	cases := []struct {
+		filename  string
+		header    GridHeader
+		rows      [][]float64
+		errLine   int
+		errSubstr string
+	}{
+		{
+			filename: "grid.asc",
+			header:   GridHeader{NCols: 3, NRows: 2, XllCorner: -5.5, YllCorner: 41, CellSize: 0.5, NoData: -2147483647, HasNoData: true},
+			rows:     [][]float64{{1, 2.5, -2147483647}, {0, 4, 5}},
+		},
+		{
+			// keywords in any order and case, centers instead of corners, a row on two lines, CRLF
+			filename: "grid-reordered.asc",
+			header:   GridHeader{NCols: 3, NRows: 2, XllCorner: -5.5, YllCorner: 41, CellSize: 0.5},
+			rows:     [][]float64{{1, 2.5, 3}, {0, 4, 5}},
+		},
+		{filename: "bad-value.asc", errLine: 8, errSubstr: `row 1 col 1 got "x"`},
+		{filename: "short.asc", errLine: 7, errSubstr: "row 1 has 2 values, want 3"},
+		{filename: "long.asc", errLine: 8, errSubstr: `unexpected "7" after the last row`},
+		{filename: "two-corners.asc", errLine: 4, errSubstr: "xllcenter already set at line 3"},
+		{filename: "no-cellsize.asc", errLine: 5, errSubstr: "missing cellsize"},
+		{filename: "duplicate.asc", errLine: 3, errSubstr: "ncols already set at line 1"},
+		{filename: "missing-value.asc", errLine: 5, errSubstr: "missing value of cellsize"},
+		{filename: "negative-rows.asc", errLine: 2, errSubstr: "want a positive integer"},
+		{filename: "unknown-keyword.asc", errLine: 6, errSubstr: `unknown keyword "unit"`},
+	}
+	for _, c := range cases {
+		header, rows, err := readGrid("testdata/" + c.filename)
+		if c.errSubstr != "" {
+			var gridErr *GridError
+			if !errors.As(err, &gridErr) || gridErr.Line != c.errLine || !strings.Contains(err.Error(), c.errSubstr) {
+				t.Errorf("%s got error %v, want line %d: %s", c.filename, err, c.errLine, c.errSubstr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s got error %v", c.filename, err)
+			continue
+		}
+		if *header != c.header {
+			t.Errorf("%s header got %+v, want %+v", c.filename, *header, c.header)
+		}
+		if len(rows) != len(c.rows) {
+			t.Errorf("%s got %d rows, want %d", c.filename, len(rows), len(c.rows))
+			continue
+		}
+		for row := range rows {
+			for col := range rows[row] {
+				if rows[row][col] != c.rows[row][col] {
+					t.Errorf("%s row %d col %d got %f, want %f", c.filename, row, col, rows[row][col], c.rows[row][col])
+				}
+			}
+		}
+	}
+}
+
+func TestCountrySetGridHeader(t *testing.T) {
+
+	header, _, err := readGrid("testdata/grid.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var country Country
+	country.SetGridHeader(*header)
+	if !header.IsNoData(-2147483647) || header.IsNoData(0) {
+		t.Errorf("no-data value %f", header.NoData)
+	}
+
+	// north east corner of the grid
+	if x, y := country.LatLng2XY(42, -4); math.Abs(x-1) > 1e-12 || math.Abs(y-1) > 1e-12 {
+		t.Errorf("got %f %f", x, y)
+	}
+	if lat := country.Row2Lat(1); lat != 41.5 {
+		t.Errorf("lat of row 1 got %f", lat)
+	}
+}
+
+func TestClip(t *testing.T) {
+
+	// 4 x 3 cells of 1 degree from 10, 20 to 14, 23
+	h := GridHeader{NCols: 4, NRows: 3, XllCorner: 10, YllCorner: 20, CellSize: 1}
+	rows := "1 2 3 4\n5 6 7 8\n9 10 11 12\n"
+
+	tests := []struct {
+		north, south, west, east float64
+		row, col                 int
+		want                     [][]float64
+	}{
+		{23, 20, 10, 14, 0, 0, [][]float64{{1, 2, 3, 4}, {5, 6, 7, 8}, {9, 10, 11, 12}}},
+		{22.5, 21, 11.2, 13, 0, 1, [][]float64{{2, 3}, {6, 7}}},
+		{22, 20.5, 13, 20, 1, 3, [][]float64{{8}, {12}}},
+		{40, -40, -100, 100, 0, 0, [][]float64{{1, 2, 3, 4}, {5, 6, 7, 8}, {9, 10, 11, 12}}},
+	}
+	for _, test := range tests {
+		clipped, row, col, err := h.Clip(test.north, test.south, test.west, test.east)
+		if err != nil {
+			t.Errorf("%v: %v", test, err)
+			continue
+		}
+		if row != test.row || col != test.col || clipped.NRows != len(test.want) || clipped.NCols != len(test.want[0]) {
+			t.Errorf("%v: %d x %d cells from row %d col %d", test, clipped.NRows, clipped.NCols, row, col)
+			continue
+		}
+		if clipped.XllCorner != 10+float64(col) || clipped.YllCorner != 23-float64(row+clipped.NRows) {
+			t.Errorf("%v: corner %f %f", test, clipped.XllCorner, clipped.YllCorner)
+		}
+
+		grid, err := NewGridReader(strings.NewReader(fmt.Sprintf(
+			"ncols 4\nnrows 3\nxllcorner 10\nyllcorner 20\ncellsize 1\n%s", rows)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader := NewClipReader(grid, &h, row, col)
+		for _, want := range test.want {
+			values := make([]float64, clipped.NCols)
+			if err := reader.ReadRow(values); err != nil {
+				t.Fatalf("%v: %v", test, err)
+			}
+			for idx := range want {
+				if values[idx] != want[idx] {
+					t.Errorf("%v: row %v, want %v", test, values, want)
+					break
+				}
+			}
+		}
+	}
+
+	if _, _, _, err := h.Clip(30, 25, 10, 14); err == nil {
+		t.Errorf("no error for a box outside the grid")
+	}
+}
@@ -167,8 +167,9 @@ func BenchmarkReadGrumpNationalities(b *testing.B) {
 	// Count the words, the countries.
 	countriesDensities := make(map[int]int)
 
+	// the 17160 rows of the global GRUMP grids go from 85 to -58 (rows of 1/120 degree)
 	topLat := 85.0
-	bottomLat := -85.0
+	bottomLat := -58.0
 	westLong := -180.0
 	eastLong := 180.0
 
@@ -284,6 +285,7 @@ func BenchmarkReadGrumpNationalities(b *testing.B) {
 
 	// capture config
 	var r barneshut.Run
+	defer r.Close()
 	r.Init(&bodies)
 	r.SetCountry("fra")
 	r.CaptureConfig()
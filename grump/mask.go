@@ -0,0 +1,155 @@
+package grump
+
+import (
+	"sync"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// Mask is the domain of the country within the unit square of the simulation
+// (the bodies are kept within the domain and bounce on its outline)
+//
+// The mask is either a raster of NCols * NRows cells (usually the cells of the
+// GRUMP file that are not no-data) or a set of polygons in relative coordinates.
+// If both are set, a point has to be within both.
+type Mask struct {
+	// raster, one bit per cell, row major, row 0 is at the south (y = 0)
+	// the bit is set if the cell is within the country. Cells is base64 encoded in the coord file
+	NCols, NRows int    `json:",omitempty"`
+	Cells        []byte `json:",omitempty"`
+
+	// rings of the polygons in relative coordinates (even-odd rule, therefore
+	// a ring within another ring is a hole)
+	Polygons [][]quadtree.BodyXY `json:",omitempty"`
+
+	// bounding boxes of the rings, computed on first use
+	bboxOnce sync.Once
+	bboxes   []bbox
+}
+
+type bbox struct {
+	xMin, yMin, xMax, yMax float64
+}
+
+// NewRasterMask returns a mask of nCols * nRows cells. inside tells
+// wether the cell at row, col is within the country (row 0 is at the south)
+func NewRasterMask(nCols, nRows int, inside func(row, col int) bool) *Mask {
+
+	m := Mask{NCols: nCols, NRows: nRows}
+	m.Cells = make([]byte, (nCols*nRows+7)/8)
+	for row := 0; row < nRows; row++ {
+		for col := 0; col < nCols; col++ {
+			if inside(row, col) {
+				bit := row*nCols + col
+				m.Cells[bit/8] |= 1 << uint(bit%8)
+			}
+		}
+	}
+	return &m
+}
+
+// NewPolygonMask returns a mask from rings in relative coordinates
+func NewPolygonMask(rings [][]quadtree.BodyXY) *Mask {
+	return &Mask{Polygons: rings}
+}
+
+// PolygonMask returns a mask from rings of lng/lat points (the order of GeoJSON coordinates)
+func (country *Country) PolygonMask(rings [][][2]float64) *Mask {
+
+	relRings := make([][]quadtree.BodyXY, len(rings))
+	for i, ring := range rings {
+		relRings[i] = make([]quadtree.BodyXY, len(ring))
+		for j, point := range ring {
+			x, y := country.LatLng2XY(point[1], point[0])
+			relRings[i][j] = quadtree.BodyXY{X: x, Y: y}
+		}
+	}
+	return NewPolygonMask(relRings)
+}
+
+// Contains tells wether the point at relative coordinates x, y is within the mask
+func (m *Mask) Contains(x, y float64) bool {
+
+	if x < 0.0 || x >= 1.0 || y < 0.0 || y >= 1.0 {
+		return false
+	}
+	if m.Cells != nil && !m.rasterContains(x, y) {
+		return false
+	}
+	if m.Polygons != nil && !m.polygonsContain(x, y) {
+		return false
+	}
+	return true
+}
+
+func (m *Mask) rasterContains(x, y float64) bool {
+
+	col := int(x * float64(m.NCols))
+	row := int(y * float64(m.NRows))
+	if col >= m.NCols || row >= m.NRows {
+		return false
+	}
+	bit := row*m.NCols + col
+	return m.Cells[bit/8]&(1<<uint(bit%8)) != 0
+}
+
+// even-odd rule, a ray is cast toward the east
+func (m *Mask) polygonsContain(x, y float64) bool {
+
+	m.bboxOnce.Do(m.computeBBoxes)
+
+	inside := false
+	for i, ring := range m.Polygons {
+		b := m.bboxes[i]
+		if x < b.xMin || x > b.xMax || y < b.yMin || y > b.yMax {
+			continue
+		}
+		for j, k := 0, len(ring)-1; j < len(ring); k, j = j, j+1 {
+			a, c := ring[j], ring[k]
+			if (a.Y > y) != (c.Y > y) && x < a.X+(y-a.Y)*(c.X-a.X)/(c.Y-a.Y) {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+func (m *Mask) computeBBoxes() {
+
+	m.bboxes = make([]bbox, len(m.Polygons))
+	for i, ring := range m.Polygons {
+		if len(ring) == 0 {
+			continue
+		}
+		b := bbox{ring[0].X, ring[0].Y, ring[0].X, ring[0].Y}
+		for _, p := range ring {
+			if p.X < b.xMin {
+				b.xMin = p.X
+			}
+			if p.X > b.xMax {
+				b.xMax = p.X
+			}
+			if p.Y < b.yMin {
+				b.yMin = p.Y
+			}
+			if p.Y > b.yMax {
+				b.yMax = p.Y
+			}
+		}
+		m.bboxes[i] = b
+	}
+}
+
+// Ratio returns the ratio of the unit square that is within the mask (on a grid of size * size points)
+func (m *Mask) Ratio(size int) float64 {
+
+	nbInside := 0
+	for i := 0; i < size; i++ {
+		for j := 0; j < size; j++ {
+			if m.Contains((float64(i)+0.5)/float64(size), (float64(j)+0.5)/float64(size)) {
+				nbInside++
+			}
+		}
+	}
+	return float64(nbInside) / float64(size*size)
+}
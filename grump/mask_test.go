@@ -0,0 +1,94 @@
+package grump
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+func TestRasterMask(t *testing.T) {
+
+	// 4 cols * 2 rows, the south west cell is no-data
+	m := NewRasterMask(4, 2, func(row, col int) bool { return !(row == 0 && col == 0) })
+
+	cases := []struct {
+		x, y float64
+		want bool
+	}{
+		{0.1, 0.1, false},
+		{0.3, 0.1, true},
+		{0.1, 0.6, true},
+		{0.99, 0.99, true},
+		{1.0, 0.5, false},
+		{-0.1, 0.5, false},
+	}
+	for _, c := range cases {
+		if got := m.Contains(c.x, c.y); got != c.want {
+			t.Errorf("%f %f got %t, want %t", c.x, c.y, got, c.want)
+		}
+	}
+}
+
+func TestPolygonMask(t *testing.T) {
+
+	// a square with a square hole
+	outer := []quadtree.BodyXY{{X: 0.1, Y: 0.1}, {X: 0.9, Y: 0.1}, {X: 0.9, Y: 0.9}, {X: 0.1, Y: 0.9}}
+	hole := []quadtree.BodyXY{{X: 0.4, Y: 0.4}, {X: 0.6, Y: 0.4}, {X: 0.6, Y: 0.6}, {X: 0.4, Y: 0.6}}
+	m := NewPolygonMask([][]quadtree.BodyXY{outer, hole})
+
+	cases := []struct {
+		x, y float64
+		want bool
+	}{
+		{0.05, 0.5, false},
+		{0.2, 0.5, true},
+		{0.5, 0.5, false},
+		{0.7, 0.8, true},
+		{0.95, 0.95, false},
+	}
+	for _, c := range cases {
+		if got := m.Contains(c.x, c.y); got != c.want {
+			t.Errorf("%f %f got %t, want %t", c.x, c.y, got, c.want)
+		}
+	}
+	if ratio := m.Ratio(100); ratio < 0.59 || ratio > 0.61 {
+		t.Errorf("ratio got %f, want 0.60", ratio)
+	}
+}
+
+// test that the mask of a country goes through the coord file format
+func TestCountryMaskJSON(t *testing.T) {
+
+	country := Country{Name: "hti", NCols: 3, NRows: 2, XllCorner: -74.5, YllCorner: 18.0}
+	country.Mask = NewRasterMask(3, 2, func(row, col int) bool { return row == col })
+
+	jsonCountry, err := json.Marshal(&country)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read Country
+	if err := json.Unmarshal(jsonCountry, &read); err != nil {
+		t.Fatal(err)
+	}
+	for row := 0; row < 2; row++ {
+		for col := 0; col < 3; col++ {
+			x, y := (float64(col)+0.5)/3.0, (float64(row)+0.5)/2.0
+			if read.Mask.Contains(x, y) != (row == col) {
+				t.Errorf("cell %d %d got %t", row, col, read.Mask.Contains(x, y))
+			}
+		}
+	}
+
+	// a coord file without mask has no domain
+	var withoutMask Country
+	if err := json.Unmarshal([]byte(`{"Name":"hti","NCols":343,"NRows":249}`), &withoutMask); err != nil || withoutMask.Mask != nil {
+		t.Errorf("got mask %v, err %v", withoutMask.Mask, err)
+	}
+
+	// polygons are given in lng/lat
+	m := country.PolygonMask([][][2]float64{{{-74.5, 18.0}, {-74.5 + 3*GrumpSpacing, 18.0}, {-74.5, 18.0 + 2*GrumpSpacing}}})
+	if !m.Contains(0.2, 0.2) || m.Contains(0.8, 0.8) {
+		t.Errorf("polygon mask got %v", m.Polygons)
+	}
+}
@@ -4,11 +4,19 @@ import (
 	"fmt"
 	"math/rand"
 	"runtime"
+	"sort"
 
 	"github.com/gyuho/goraph"
+	"github.com/thomaspeugeot/tkv/bods"
 	"github.com/thomaspeugeot/tkv/quadtree"
 )
 
+// AddBodiesOfParselyPopulatedCells returns the bodies of the cells between startRow and endRow
+// whose population is too low for a body
+//
+// the connected sets of these cells get one body of mass cutoff each time their cumulated population
+// is above cutoff. The body is at the center of the cell where the cutoff is reached, attributes[idx]
+// tells where bodies[idx] comes from.
 func AddBodiesOfParselyPopulatedCells(
 	startRow, endRow int,
 	country *Country,
@@ -17,8 +25,9 @@ func AddBodiesOfParselyPopulatedCells(
 	colLngWidth float64,
 	cutoff float64,
 	sampleRatio float64,
-	bodies []quadtree.Body,
-	popInParselyPopulatedCells, notAccountedForPop *float64) {
+	urbanThreshold float64,
+	rng *rand.Rand,
+	popInParselyPopulatedCells, notAccountedForPop *float64) (bodies []quadtree.Body, attributes []bods.Attributes) {
 
 	Info.Printf("Construct the nodes of the graph of parsely populated cells")
 	graph := goraph.NewGraph()
@@ -72,6 +81,22 @@ func AddBodiesOfParselyPopulatedCells(
 	setOfSets := goraph.Tarjan(graph)
 	fmt.Printf("Graph number of connected graph\t%10d\n", len(setOfSets))
 
+	// Tarjan walks the nodes in the order of a map, the sets and their nodes are sorted
+	// so that the same input gives the same bodies
+	cellOf := func(id goraph.ID) (row, col int) {
+		fmt.Sscanf(id.String(), "%d-%d", &row, &col)
+		return row, col
+	}
+	before := func(a, b goraph.ID) bool {
+		rowA, colA := cellOf(a)
+		rowB, colB := cellOf(b)
+		return rowA < rowB || (rowA == rowB && colA < colB)
+	}
+	for _, set := range setOfSets {
+		sort.Slice(set, func(i, j int) bool { return before(set[i], set[j]) })
+	}
+	sort.Slice(setOfSets, func(i, j int) bool { return before(setOfSets[i][0], setOfSets[j][0]) })
+
 	// parse the connected set
 	// population that is not accounted for in the graph
 	for setId := 0; setId < len(setOfSets); setId++ {
@@ -106,9 +131,14 @@ func AddBodiesOfParselyPopulatedCells(
 				body.M = cutoff
 
 				// sample bodies
-				sample := rand.Float64() * 100.0
+				sample := rng.Float64() * 100.0
 				if sample < sampleRatio {
 					bodies = append(bodies, body)
+					attributes = append(attributes, bods.Attributes{
+						Row:        int32(row),
+						Col:        int32(col),
+						Population: cutoff,
+						Urban:      inputPopulationMatrix[row][col] >= urbanThreshold})
 				}
 			}
 
@@ -121,6 +151,7 @@ func AddBodiesOfParselyPopulatedCells(
 
 	graph = nil
 	runtime.GC()
+	return bodies, attributes
 }
 
 func PrintMemUsage() {
@@ -0,0 +1,101 @@
+package grump
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thomaspeugeot/tkv/geotiff"
+)
+
+// OpenRaster opens the population raster according to the extension of the file, an ESRI ASCII grid
+// (the GRUMP files) or a GeoTIFF (GPW v4, WorldPop, GHS-POP)
+func OpenRaster(file *os.File) (GridHeader, RowReader, error) {
+
+	switch strings.ToLower(filepath.Ext(file.Name())) {
+	case ".tif", ".tiff":
+		raster, err := geotiff.Open(file)
+		if err != nil {
+			return GridHeader{}, nil, err
+		}
+		if math.Abs(raster.PixelWidth-raster.PixelHeight) > 1e-9*raster.PixelWidth {
+			return GridHeader{}, nil, fmt.Errorf("pixels of %g x %g degrees, want square pixels", raster.PixelWidth, raster.PixelHeight)
+		}
+		header := GridHeader{
+			NCols:     raster.Width,
+			NRows:     raster.Height,
+			XllCorner: raster.West,
+			YllCorner: raster.North - float64(raster.Height)*raster.PixelHeight,
+			CellSize:  raster.PixelWidth,
+			NoData:    raster.NoData,
+			HasNoData: raster.HasNoData}
+		return header, raster, nil
+	default:
+		grid, err := NewGridReader(file)
+		if err != nil {
+			return GridHeader{}, nil, err
+		}
+		return grid.Header, grid, nil
+	}
+}
+
+// Clip returns the header of the cells of the grid that intersect the bounding box (lat of the north
+// and south sides, lng of the west and east sides) and the row (from the north) and the col of its
+// north west cell within the grid
+func (h *GridHeader) Clip(north, south, west, east float64) (clipped GridHeader, row, col int, err error) {
+
+	gridNorth := h.YllCorner + float64(h.NRows)*h.CellSize
+
+	// a small tolerance, the borders are often on the sides of the cells
+	const epsilon = 1e-6
+	clamp := func(v float64, max int) int {
+		return int(math.Max(0, math.Min(float64(max), v)))
+	}
+	row = clamp(math.Floor((gridNorth-north)/h.CellSize+epsilon), h.NRows)
+	lastRow := clamp(math.Ceil((gridNorth-south)/h.CellSize-epsilon), h.NRows)
+	col = clamp(math.Floor((west-h.XllCorner)/h.CellSize+epsilon), h.NCols)
+	lastCol := clamp(math.Ceil((east-h.XllCorner)/h.CellSize-epsilon), h.NCols)
+	if lastRow <= row || lastCol <= col {
+		return GridHeader{}, 0, 0, fmt.Errorf("the box %f %f %f %f is outside the grid", north, south, west, east)
+	}
+
+	clipped = *h
+	clipped.NRows, clipped.NCols = lastRow-row, lastCol-col
+	clipped.XllCorner = h.XllCorner + float64(col)*h.CellSize
+	clipped.YllCorner = gridNorth - float64(lastRow)*h.CellSize
+	return clipped, row, col, nil
+}
+
+// ClipReader reads the rows of the part of a grid given by Clip
+type ClipReader struct {
+	rows      RowReader
+	buf       []float64 // a row of the grid
+	row, col  int
+	nbSkipped int // nb of rows of the grid before the part that have been read
+}
+
+// NewClipReader returns a reader of the rows of the part of the grid of header h (read from rows)
+// whose north west cell is at row, col
+func NewClipReader(rows RowReader, h *GridHeader, row, col int) *ClipReader {
+	return &ClipReader{rows: rows, buf: make([]float64, h.NCols), row: row, col: col}
+}
+
+// ReadRow reads the next row of the part of the grid into values
+func (c *ClipReader) ReadRow(values []float64) error {
+
+	for ; c.nbSkipped < c.row; c.nbSkipped++ {
+		if err := c.rows.ReadRow(c.buf); err != nil {
+			return err
+		}
+	}
+	if c.col+len(values) > len(c.buf) {
+		return fmt.Errorf("clipped row of %d values from col %d, the grid has %d cols", len(values), c.col, len(c.buf))
+	}
+	if err := c.rows.ReadRow(c.buf); err != nil {
+		return err
+	}
+	copy(values, c.buf[c.col:])
+	return nil
+}
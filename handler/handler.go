@@ -34,6 +34,10 @@ type VillageCoordResponse struct {
 	X, Y                   float64
 	SourceBorderPoints     GeoJSONBorderCoordinates
 	TargetBorderPoints     GeoJSONBorderCoordinates
+
+	// population of the source and target territories, if the body files have attributes
+	SourceTerritory *translation.Territory `json:",omitempty"`
+	TargetTerritory *translation.Territory `json:",omitempty"`
 }
 
 // get village coordinates from lat/long
@@ -89,6 +93,14 @@ func GetTranslationResult(w http.ResponseWriter, req *http.Request) {
 		response.TargetBorderPoints[0][idx][1] = targetBorderPoints[idx].X // X is latitude
 	}
 
+	// add the population of the territories
+	if territory, ok := translation.GetTranslateCurrent().SourceTerritory(llc.Lat, llc.Lng); ok {
+		response.SourceTerritory = &territory
+	}
+	if territory, ok := translation.GetTranslateCurrent().TargetTerritory(xSpread, ySpread); ok {
+		response.TargetTerritory = &territory
+	}
+
 	VillageCoordResponsejson, _ := json.MarshalIndent(response, "", "	")
 	fmt.Fprintf(w, "%s", VillageCoordResponsejson)
 }
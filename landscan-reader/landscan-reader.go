@@ -0,0 +1,96 @@
+// Package landscanreader is the main package of the program that cuts a country out of a LandScan population grid
+// and generates the coord file and the config file of bodies of the country
+//
+// The country is the bounding box of its border in countryspecs, the bodies are placed in the cells as in
+// grump-reader
+//
+// usage landscan-reader -input=lspop2014 -border=Haiti -country=hti
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+
+	"github.com/thomaspeugeot/tkv/countryspecs"
+	"github.com/thomaspeugeot/tkv/grump"
+	"github.com/thomaspeugeot/tkv/landscan"
+)
+
+func main() {
+
+	// flag "country"
+	countryPtr := flag.String("country", "hti", "name of the country in the output files, the 3 small letter ISO 3166 code")
+
+	// flag "border"
+	borderPtr := flag.String("border", "Haiti", "name of the country in the borders of countryspecs")
+
+	// population grid
+	inputPtr := flag.String("input", "", "LandScan population grid, the directory of an ArcInfo grid (lspop2014), an ESRI ASCII grid (.asc) or a GeoTIFF (.tif)")
+
+	targetMaxBodiesPtr := flag.Int("targetMaxBodies", 100000, "target nb of bodies")
+	sampleRatioPtr := flag.Float64("sampleRatio", 100, "Ratio (in %) of output bodies, default is 100%")
+	dirTKVDataPtr := flag.String("tkvdata", "/Users/thomaspeugeot/the-mapping-data/", "directory containing input tkv data (for the optimal packing)")
+	fiboPtr := flag.Bool("fibo", true, "if true, uses fibonacci packing")
+	maskPtr := flag.Bool("mask", true, "if true, the no-data cells are stored in the coord file as the mask of the country")
+	seedPtr := flag.Int64("seed", 1, "seed of the random sampling of the bodies, the same seed gives the same bodies")
+	urbanThresholdPtr := flag.Float64("urbanThreshold", 1000, "nb of individuals above which a cell is urban (the bodies of the cell get the urban flag)")
+
+	flag.Parse()
+
+	if *inputPtr == "" {
+		log.Fatal("no LandScan grid, see -input")
+	}
+	border, ok := countryspecs.BorderByName(*borderPtr)
+	if !ok {
+		log.Fatalf("no border of %s in countryspecs", *borderPtr)
+	}
+
+	header, grid, closeGrid, err := landscan.Open(*inputPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	grump.Info.Printf("grid of %d x %d cells of %f degrees", header.NCols, header.NRows, header.CellSize)
+
+	// the cells of the bounding box of the country
+	country := grump.Country{Name: *countryPtr}
+	rows, err := landscan.Clip(header, grid, border, &country)
+	if err != nil {
+		log.Fatal(err)
+	}
+	grump.Info.Println("country struct content is ", country)
+
+	population, err := grump.ReadPopulation(&country, rows, header.IsNoData)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := closeGrid(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *maskPtr {
+		country.Mask = population.Mask()
+		grump.Info.Printf("ratio of the square within the country %f", country.Mask.Ratio(1000))
+	}
+	population.Within = nil
+	country.Serialize()
+
+	config := grump.BodiesConfig{
+		TargetMaxBodies: *targetMaxBodiesPtr,
+		SampleRatio:     *sampleRatioPtr,
+		UrbanThreshold:  *urbanThresholdPtr,
+		Arrangements:    grump.FibonacciArrangements()}
+	if !*fiboPtr {
+		if config.Arrangements, err = grump.ReadArrangements(*dirTKVDataPtr); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(*seedPtr))
+	bodies, attributes, stats := grump.GenerateBodies(&country, population, config, rng)
+	fmt.Print(stats)
+	if err := country.WriteBodies(bodies, attributes); err != nil {
+		log.Fatal(err)
+	}
+}
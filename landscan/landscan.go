@@ -1,2 +1,503 @@
-// PAckage shape provide function for processing a shape file from landscan into a body file for the sim server
+/*
+Package landscan provides functions for processing a population grid from LandScan into a body file
+for the sim server.
+
+LandScan is no shapefile, it is a GRID raster (see the working note): the population is the
+directory of an ArcInfo binary grid (lspop2014 in the Haiti sample) with the files
+
+	hdr.adf       the type of the cells and the size of the tiles and of the blocks
+	dblbnd.adf    the bounds of the grid
+	w001001x.adf  the index of the blocks of the tile (offset and size in 16 bit words)
+	w001001.adf   the blocks, compressed one by one
+
+The grids of the other tiles are w002001, w001000, z001001 ... The package also reads the LandScan
+releases in ESRI ASCII grid or GeoTIFF (through grump.OpenRaster).
+*/
 package landscan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+
+	"github.com/thomaspeugeot/tkv/countryspecs"
+	"github.com/thomaspeugeot/tkv/grump"
+)
+
+// ErrUnsupported is returned for the blocks that are not decoded (CCITT compressed blocks)
+var ErrUnsupported = errors.New("landscan: unsupported block type")
+
+// no-data values of the ArcInfo grids
+const (
+	intNoData   = -2147483647
+	floatNoData = -math.MaxFloat32
+)
+
+// Grid is an ArcInfo binary grid, read row by row from the north
+type Grid struct {
+	Width, Height int
+
+	// lng of the west side, lat of the north side, size of the cells in degrees
+	West, North           float64
+	CellWidth, CellHeight float64
+
+	// float cells (int cells otherwise)
+	Float bool
+
+	NoData float64
+
+	fsys       fs.FS
+	dir        string
+	compressed bool
+
+	// size of a tile in blocks and of a block in cells
+	blocksPerRow, blocksPerColumn int
+	blockWidth, blockHeight       int
+
+	tiles map[[2]int]*tile
+
+	// rows of the block row that is decoded
+	blockRow int
+	cells    []float64
+
+	row int // next row of ReadRow
+}
+
+// a tile of the grid, the pair of its w001001.adf and w001001x.adf files
+type tile struct {
+	offsets, sizes []int64 // offset and size in bytes of the blocks
+	data           io.ReaderAt
+	file           fs.File // nil if the tile is missing
+}
+
+// OpenGrid opens the ArcInfo grid of directory dir within fsys (for instance os.DirFS of the
+// directory of the grid and ".")
+func OpenGrid(fsys fs.FS, dir string) (*Grid, error) {
+
+	hdr, err := fs.ReadFile(fsys, path.Join(dir, "hdr.adf"))
+	if err != nil {
+		return nil, err
+	}
+	if len(hdr) < 308 || string(hdr[:7]) != "GRID1.2" {
+		return nil, fmt.Errorf("landscan: %s is not the header of an ArcInfo grid", path.Join(dir, "hdr.adf"))
+	}
+	g := Grid{fsys: fsys, dir: dir, tiles: make(map[[2]int]*tile), blockRow: -1}
+	switch cellType := readInt32(hdr[16:]); cellType {
+	case 1:
+		g.NoData = intNoData
+	case 2:
+		g.Float = true
+		g.NoData = floatNoData
+	default:
+		return nil, fmt.Errorf("landscan: unknown cell type %d", cellType)
+	}
+	g.compressed = readInt32(hdr[20:]) == 0
+	g.CellWidth = readFloat64(hdr[256:])
+	g.CellHeight = readFloat64(hdr[264:])
+	g.blocksPerRow = int(readInt32(hdr[288:]))
+	g.blocksPerColumn = int(readInt32(hdr[292:]))
+	g.blockWidth = int(readInt32(hdr[296:]))
+	g.blockHeight = int(readInt32(hdr[304:]))
+	if !(g.CellWidth > 0 && g.CellHeight > 0) || g.blocksPerRow <= 0 || g.blocksPerColumn <= 0 ||
+		g.blockWidth <= 0 || g.blockHeight <= 0 {
+		return nil, fmt.Errorf("landscan: invalid header of %s", dir)
+	}
+
+	bounds, err := fs.ReadFile(fsys, path.Join(dir, "dblbnd.adf"))
+	if err != nil {
+		return nil, err
+	}
+	if len(bounds) < 32 {
+		return nil, fmt.Errorf("landscan: %d bytes in the bounds of %s, want 32", len(bounds), dir)
+	}
+	west, south := readFloat64(bounds[0:]), readFloat64(bounds[8:])
+	east, north := readFloat64(bounds[16:]), readFloat64(bounds[24:])
+	g.West, g.North = west, north
+	g.Width = int(math.Floor((east-west)/g.CellWidth + 0.5))
+	g.Height = int(math.Floor((north-south)/g.CellHeight + 0.5))
+	if g.Width <= 0 || g.Height <= 0 {
+		return nil, fmt.Errorf("landscan: invalid bounds of %s", dir)
+	}
+	g.cells = make([]float64, g.blockHeight*g.Width)
+	return &g, nil
+}
+
+// Close closes the files of the tiles
+func (g *Grid) Close() error {
+	var err error
+	for _, t := range g.tiles {
+		if t.file != nil {
+			if errClose := t.file.Close(); err == nil {
+				err = errClose
+			}
+		}
+	}
+	g.tiles = make(map[[2]int]*tile)
+	return err
+}
+
+// IsNoData tells wether v is the no-data value of the grid
+func (g *Grid) IsNoData(v float64) bool {
+	return v == g.NoData
+}
+
+// Header returns the grid header of the grid, the cells must be square
+func (g *Grid) Header() (grump.GridHeader, error) {
+	if math.Abs(g.CellWidth-g.CellHeight) > 1e-9*g.CellWidth {
+		return grump.GridHeader{}, fmt.Errorf("landscan: cells of %g x %g degrees, want square cells", g.CellWidth, g.CellHeight)
+	}
+	return grump.GridHeader{
+		NCols:     g.Width,
+		NRows:     g.Height,
+		XllCorner: g.West,
+		YllCorner: g.North - float64(g.Height)*g.CellHeight,
+		CellSize:  g.CellWidth,
+		NoData:    g.NoData,
+		HasNoData: true}, nil
+}
+
+// ReadRow reads the next row of the grid into values, it returns io.EOF after the last row
+func (g *Grid) ReadRow(values []float64) error {
+	if g.row == g.Height {
+		return io.EOF
+	}
+	if err := g.ReadRowAt(g.row, values); err != nil {
+		return err
+	}
+	g.row++
+	return nil
+}
+
+// ReadRowAt reads the row (from the north) of the grid into values
+func (g *Grid) ReadRowAt(row int, values []float64) error {
+	if row < 0 || row >= g.Height {
+		return fmt.Errorf("landscan: row %d outside of the %d rows of the grid", row, g.Height)
+	}
+	if len(values) != g.Width {
+		return fmt.Errorf("landscan: row of %d values, the grid has %d cols", len(values), g.Width)
+	}
+	if blockRow := row / g.blockHeight; blockRow != g.blockRow {
+		if err := g.loadBlockRow(blockRow); err != nil {
+			return err
+		}
+	}
+	start := (row % g.blockHeight) * g.Width
+	copy(values, g.cells[start:start+g.Width])
+	return nil
+}
+
+// decode the blocks of a row of blocks of the grid
+func (g *Grid) loadBlockRow(blockRow int) error {
+
+	g.blockRow = -1
+	tileY, localRow := blockRow/g.blocksPerColumn, blockRow%g.blocksPerColumn
+	block := make([]float64, g.blockWidth*g.blockHeight)
+	nbBlocksAcross := (g.Width + g.blockWidth - 1) / g.blockWidth
+	for blockCol := 0; blockCol < nbBlocksAcross; blockCol++ {
+		t, err := g.tile(blockCol/g.blocksPerRow, tileY)
+		if err != nil {
+			return err
+		}
+		id := localRow*g.blocksPerRow + blockCol%g.blocksPerRow
+		if err := g.decodeBlock(t, id, block); err != nil {
+			return fmt.Errorf("landscan: block %d of row %d: %w", blockCol, blockRow, err)
+		}
+
+		// the blocks of the east side go beyond the grid
+		x0 := blockCol * g.blockWidth
+		width := g.blockWidth
+		if x0+width > g.Width {
+			width = g.Width - x0
+		}
+		for y := 0; y < g.blockHeight; y++ {
+			copy(g.cells[y*g.Width+x0:y*g.Width+x0+width], block[y*g.blockWidth:])
+		}
+	}
+	g.blockRow = blockRow
+	return nil
+}
+
+// tile returns the tile of the grid, the tiles are named as in GDAL
+func (g *Grid) tile(tileX, tileY int) (*tile, error) {
+
+	if t, ok := g.tiles[[2]int{tileX, tileY}]; ok {
+		return t, nil
+	}
+	var name string
+	switch tileY {
+	case 0:
+		name = fmt.Sprintf("w%03d001", tileX+1)
+	case 1:
+		name = fmt.Sprintf("w%03d000", tileX+1)
+	default:
+		name = fmt.Sprintf("z%03d%03d", tileX+1, tileY-1)
+	}
+
+	t := &tile{}
+	index, err := fs.ReadFile(g.fsys, path.Join(g.dir, name+"x.adf"))
+	if errors.Is(err, fs.ErrNotExist) {
+		// a missing tile has only no-data cells
+		g.tiles[[2]int{tileX, tileY}] = t
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(index) < 100 {
+		return nil, fmt.Errorf("landscan: %d bytes in the index %s", len(index), name+"x.adf")
+	}
+	for pos := 100; pos+8 <= len(index); pos += 8 {
+		t.offsets = append(t.offsets, 2*int64(readInt32(index[pos:])))
+		t.sizes = append(t.sizes, 2*int64(readInt32(index[pos+4:])))
+	}
+
+	file, err := g.fsys.Open(path.Join(g.dir, name+".adf"))
+	if err != nil {
+		return nil, err
+	}
+	t.file = file
+	if readerAt, ok := file.(io.ReaderAt); ok {
+		t.data = readerAt
+	} else {
+		// for instance the files of a zip archive
+		data, err := io.ReadAll(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		t.data = bytes.NewReader(data)
+	}
+	g.tiles[[2]int{tileX, tileY}] = t
+	return t, nil
+}
+
+// decode the block id of the tile into cells (blockWidth x blockHeight)
+func (g *Grid) decodeBlock(t *tile, id int, cells []float64) error {
+
+	if id >= len(t.sizes) || t.sizes[id] == 0 {
+		for idx := range cells {
+			cells[idx] = g.NoData
+		}
+		return nil
+	}
+
+	// the block starts with its size in 16 bit words
+	raw := make([]byte, 2+t.sizes[id])
+	if _, err := t.data.ReadAt(raw, t.offsets[id]); err != nil {
+		return err
+	}
+	if size := 2 * int64(binary.BigEndian.Uint16(raw)); size != t.sizes[id] {
+		return fmt.Errorf("size %d, the index says %d", size, t.sizes[id])
+	}
+	raw = raw[2:]
+
+	if g.Float {
+		if len(raw) < 4*len(cells) {
+			return fmt.Errorf("%d bytes for %d float cells", len(raw), len(cells))
+		}
+		for idx := range cells {
+			cells[idx] = float64(math.Float32frombits(binary.BigEndian.Uint32(raw[4*idx:])))
+		}
+		return nil
+	}
+	if !g.compressed {
+		if len(raw) < 4*len(cells) {
+			return fmt.Errorf("%d bytes for %d int cells", len(raw), len(cells))
+		}
+		for idx := range cells {
+			cells[idx] = float64(readInt32(raw[4*idx:]))
+		}
+		return nil
+	}
+	return decodeIntBlock(raw, cells)
+}
+
+// decodeIntBlock decodes a compressed block of int cells (the encodings are the ones of GDAL)
+//
+// the block is the type of the block, the size of the minimum, the minimum (signed, MSB first)
+// and the values, all the values but the no-data are relative to the minimum
+func decodeIntBlock(raw []byte, cells []float64) error {
+
+	if len(raw) < 2 || raw[1] > 4 || len(raw) < 2+int(raw[1]) {
+		return errors.New("truncated block header")
+	}
+	blockType, minSize := raw[0], int(raw[1])
+	min := 0
+	for idx := 0; idx < minSize; idx++ {
+		min = min<<8 | int(raw[2+idx])
+	}
+	if minSize > 0 && raw[2] > 127 {
+		min -= 1 << (8 * uint(minSize))
+	}
+	data := raw[2+minSize:]
+
+	// the sum wraps around as the int32 of ArcInfo (the minimum of blocks with no-data cells is
+	// the no-data value, the values of the other cells are above 2^31)
+	value := func(v int) float64 {
+		return float64(int32(v + min))
+	}
+
+	// the values of the raw blocks, nbBits per cell
+	rawValues := func(nbBits int) error {
+		if len(data)*8 < nbBits*len(cells) {
+			return fmt.Errorf("%d bytes for %d cells of %d bits", len(data), len(cells), nbBits)
+		}
+		for idx := range cells {
+			var v int
+			switch nbBits {
+			case 1:
+				v = int(data[idx/8]>>(7-uint(idx%8))) & 1
+			case 4:
+				v = int(data[idx/2]>>(4*uint(1-idx%2))) & 0xf
+			case 8:
+				v = int(data[idx])
+			case 16:
+				v = int(binary.BigEndian.Uint16(data[2*idx:]))
+			case 32:
+				v = int(readInt32(data[4*idx:]))
+			}
+			cells[idx] = value(v)
+		}
+		return nil
+	}
+
+	switch blockType {
+	case 0x00:
+		for idx := range cells {
+			cells[idx] = value(0)
+		}
+		return nil
+	case 0x01:
+		return rawValues(1)
+	case 0x04:
+		return rawValues(4)
+	case 0x08:
+		return rawValues(8)
+	case 0x10:
+		return rawValues(16)
+	case 0x20:
+		return rawValues(32)
+	case 0xFC, 0xF8, 0xF0, 0xE0:
+		// runs of a value, a count and the value on 1, 2 or 4 bytes
+		valueSize := map[byte]int{0xFC: 1, 0xF8: 1, 0xF0: 2, 0xE0: 4}[blockType]
+		idx := 0
+		for idx < len(cells) {
+			if len(data) < 1+valueSize {
+				return fmt.Errorf("truncated run at cell %d", idx)
+			}
+			count := int(data[0])
+			var v int
+			switch valueSize {
+			case 1:
+				v = int(data[1])
+			case 2:
+				v = int(binary.BigEndian.Uint16(data[1:]))
+			case 4:
+				v = int(readInt32(data[1:]))
+			}
+			data = data[1+valueSize:]
+			for ; count > 0 && idx < len(cells); count-- {
+				cells[idx] = value(v)
+				idx++
+			}
+		}
+		return nil
+	case 0xDF, 0xD7, 0xCF:
+		// a marker below 128 is followed by as many cells (the minimum, 8 bit or 16 bit literals),
+		// a marker from 128 is a run of 256 - marker no-data cells
+		literalSize := map[byte]int{0xDF: 0, 0xD7: 1, 0xCF: 2}[blockType]
+		idx := 0
+		for idx < len(cells) {
+			if len(data) < 1 {
+				return fmt.Errorf("truncated block at cell %d", idx)
+			}
+			marker := int(data[0])
+			data = data[1:]
+			if marker >= 128 {
+				for count := 256 - marker; count > 0 && idx < len(cells); count-- {
+					cells[idx] = intNoData
+					idx++
+				}
+				continue
+			}
+			if len(data) < marker*literalSize {
+				return fmt.Errorf("truncated literals at cell %d", idx)
+			}
+			for count := 0; count < marker && idx < len(cells); count++ {
+				v := 0
+				switch literalSize {
+				case 1:
+					v = int(data[count])
+				case 2:
+					v = int(binary.BigEndian.Uint16(data[2*count:]))
+				}
+				cells[idx] = value(v)
+				idx++
+			}
+			data = data[marker*literalSize:]
+		}
+		return nil
+	case 0xFF:
+		return ErrUnsupported
+	default:
+		return fmt.Errorf("unknown block type 0x%02x", blockType)
+	}
+}
+
+func readInt32(b []byte) int32 {
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func readFloat64(b []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(b))
+}
+
+// Open opens a LandScan population raster, the directory of an ArcInfo grid (lspop2014), an ESRI ASCII
+// grid or a GeoTIFF. closeRaster closes the files of the raster.
+func Open(name string) (header grump.GridHeader, rows grump.RowReader, closeRaster func() error, err error) {
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return grump.GridHeader{}, nil, nil, err
+	}
+	if info.IsDir() {
+		grid, err := OpenGrid(os.DirFS(name), ".")
+		if err != nil {
+			return grump.GridHeader{}, nil, nil, err
+		}
+		if header, err = grid.Header(); err != nil {
+			grid.Close()
+			return grump.GridHeader{}, nil, nil, err
+		}
+		return header, grid, grid.Close, nil
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return grump.GridHeader{}, nil, nil, err
+	}
+	if header, rows, err = grump.OpenRaster(file); err != nil {
+		file.Close()
+		return grump.GridHeader{}, nil, nil, err
+	}
+	return header, rows, file.Close, nil
+}
+
+// Clip returns the part of the raster within the bounding box of the border (enlarged by one
+// cell), the country gets the size and the corner of the part
+func Clip(header grump.GridHeader, rows grump.RowReader, border countryspecs.CountryBorder, country *grump.Country) (grump.RowReader, error) {
+
+	north, south, west, east := border.Bounds()
+	clipped, row, col, err := header.Clip(north, south, west, east)
+	if err != nil {
+		return nil, fmt.Errorf("landscan: %s: %w", border.Name, err)
+	}
+	country.SetGridHeader(clipped)
+	return grump.NewClipReader(rows, &header, row, col), nil
+}
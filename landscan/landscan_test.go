@@ -0,0 +1,174 @@
+package landscan
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"math"
+	"testing"
+
+	"github.com/thomaspeugeot/tkv/countryspecs"
+	"github.com/thomaspeugeot/tkv/grump"
+)
+
+// the population grid of the Haiti sample
+func openHaiti(t *testing.T) (*Grid, fs.FS) {
+	archive, err := zip.OpenReader("Haiti-2014.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { archive.Close() })
+	grid, err := OpenGrid(archive, "Population/lspop2014")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { grid.Close() })
+	return grid, archive
+}
+
+// the value attribute table of the grid, the nb of cells of each value
+func readVAT(t *testing.T, fsys fs.FS) map[int32]int32 {
+	vat, err := fs.ReadFile(fsys, "Population/lspop2014/vat.adf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[int32]int32)
+	for pos := 0; pos+8 <= len(vat); pos += 8 {
+		counts[readInt32(vat[pos:])] = readInt32(vat[pos+4:])
+	}
+	return counts
+}
+
+func TestGrid(t *testing.T) {
+
+	grid, archive := openHaiti(t)
+	if grid.Width != 343 || grid.Height != 249 || grid.Float {
+		t.Fatalf("grid of %d x %d cells (float %v), want 343 x 249 int cells", grid.Width, grid.Height, grid.Float)
+	}
+	if math.Abs(grid.West+74.483333) > 1e-5 || math.Abs(grid.North-20.1) > 1e-5 || math.Abs(grid.CellWidth-1.0/120.0) > 1e-9 {
+		t.Errorf("west %f north %f cell %f", grid.West, grid.North, grid.CellWidth)
+	}
+
+	// the decoded cells have the histogram of the value attribute table
+	counts := make(map[int32]int32)
+	total, nbCells := 0.0, 0
+	values := make([]float64, grid.Width)
+	for row := 0; ; row++ {
+		err := grid.ReadRow(values)
+		if err == io.EOF {
+			if row != grid.Height {
+				t.Fatalf("EOF at row %d", row)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range values {
+			if grid.IsNoData(v) {
+				continue
+			}
+			counts[int32(v)]++
+			total += v
+			nbCells++
+		}
+	}
+	if total != 9996731 || nbCells != 33786 {
+		t.Errorf("total %f in %d cells, want 9996731 in 33786 cells", total, nbCells)
+	}
+	vat := readVAT(t, archive)
+	if len(counts) != len(vat) {
+		t.Errorf("%d values, the attribute table has %d", len(counts), len(vat))
+	}
+	for v, count := range vat {
+		if counts[v] != count {
+			t.Errorf("%d cells of value %d, want %d", counts[v], v, count)
+		}
+	}
+
+	// the statistics of the grid
+	sta, err := fs.ReadFile(archive, "Population/lspop2014/sta.adf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	max := math.Float64frombits(binary.BigEndian.Uint64(sta[8:]))
+	if _, ok := counts[int32(max)]; !ok {
+		t.Errorf("no cell of the max value %f", max)
+	}
+}
+
+func TestClip(t *testing.T) {
+
+	grid, _ := openHaiti(t)
+	header, err := grid.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	border, ok := countryspecs.BorderByName("Haiti")
+	if !ok {
+		t.Fatal("no border of Haiti")
+	}
+	var country grump.Country
+	rows, err := Clip(header, grid, border, &country)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the part covers the box within the grid
+	north, south, west, east := border.Bounds()
+	north = math.Min(north, header.YllCorner+float64(header.NRows)*header.CellSize)
+	south = math.Max(south, header.YllCorner)
+	west = math.Max(west, header.XllCorner)
+	east = math.Min(east, header.XllCorner+float64(header.NCols)*header.CellSize)
+	const epsilon = 1e-6
+	if country.XllCorner > west+epsilon || country.XllCorner+float64(country.NCols)*country.CellSize < east-epsilon ||
+		country.YllCorner > south+epsilon || country.YllCorner+float64(country.NRows)*country.CellSize < north-epsilon ||
+		country.NCols > header.NCols || country.NRows > header.NRows {
+		t.Errorf("country %+v does not cover the box %f %f %f %f", country, north, south, west, east)
+	}
+
+	// the bounding box of Haiti holds the population of the sample
+	population, err := grump.ReadPopulation(&country, rows, header.IsNoData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if population.Total != 9996731 {
+		t.Errorf("population %f within the bounding box, want 9996731", population.Total)
+	}
+}
+
+func TestDecodeIntBlock(t *testing.T) {
+
+	cells := make([]float64, 6)
+	tests := []struct {
+		raw  []byte
+		want []float64
+	}{
+		{[]byte{0x00, 0x01, 0x07}, []float64{7, 7, 7, 7, 7, 7}},
+		{[]byte{0x08, 0x01, 0xfe, 1, 2, 3, 4, 5, 6}, []float64{-1, 0, 1, 2, 3, 4}},
+		{[]byte{0x04, 0x00, 0x12, 0x34, 0x56}, []float64{1, 2, 3, 4, 5, 6}},
+		{[]byte{0xF8, 0x01, 0x10, 2, 1, 4, 0}, []float64{17, 17, 16, 16, 16, 16}},
+		{[]byte{0xE0, 0x00, 6, 0, 1, 0, 0}, []float64{65536, 65536, 65536, 65536, 65536, 65536}},
+		{[]byte{0xDF, 0x00, 2, 0xfe, 2}, []float64{0, 0, intNoData, intNoData, 0, 0}},
+		{[]byte{0xD7, 0x01, 10, 0xfd, 3, 1, 2, 3}, []float64{intNoData, intNoData, intNoData, 11, 12, 13}},
+		{[]byte{0xCF, 0x00, 1, 1, 0, 0xfb}, []float64{256, intNoData, intNoData, intNoData, intNoData, intNoData}},
+	}
+	for _, test := range tests {
+		if err := decodeIntBlock(test.raw, cells); err != nil {
+			t.Errorf("block % x: %v", test.raw, err)
+			continue
+		}
+		for idx := range cells {
+			if cells[idx] != test.want[idx] {
+				t.Errorf("block % x: %v, want %v", test.raw, cells, test.want)
+				break
+			}
+		}
+	}
+
+	for _, raw := range [][]byte{{0x08, 0x00, 1, 2}, {0xDF, 0x00, 2}, {0xFF, 0x00}, {0x42, 0x00}, {0x08, 0x05}} {
+		if err := decodeIntBlock(raw, cells); err == nil {
+			t.Errorf("block % x: no error", raw)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package lloyd
+
+import (
+	"math"
+
+	barneshut "github.com/thomaspeugeot/tkv/barnes-hut"
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// nb of bodies per cell of the grid (on average)
+const bodiesPerCell = 2
+
+// a grid of cells over the square, each cell lists the bodies within it
+//
+// it is used to find the nearest bodies of a point. Bodies of cell c are
+// bodies[start[c]:start[c+1]] (counting sort of the bodies per cell)
+type grid struct {
+	size   int     // nb of cells per axis
+	start  []int32 // first body of each cell
+	bodies []int32 // index of the bodies, sorted per cell
+}
+
+// build the grid of bodies
+func newGrid(bodies []quadtree.Body) *grid {
+
+	var g grid
+	g.size = int(math.Ceil(math.Sqrt(float64(len(bodies)) / bodiesPerCell)))
+	if g.size < 1 {
+		g.size = 1
+	}
+	g.start = make([]int32, g.size*g.size+1)
+	g.bodies = make([]int32, len(bodies))
+
+	cells := make([]int32, len(bodies))
+	for idx, body := range bodies {
+		c := g.cell(body.X, body.Y)
+		cells[idx] = int32(c)
+		g.start[c+1]++
+	}
+	for c := 0; c < g.size*g.size; c++ {
+		g.start[c+1] += g.start[c]
+	}
+	next := append([]int32(nil), g.start[:g.size*g.size]...)
+	for idx, c := range cells {
+		g.bodies[next[c]] = int32(idx)
+		next[c]++
+	}
+	return &g
+}
+
+// index of the cell of x or y (bodies outside the square go to the border cells)
+func (g *grid) axis(x float64) int {
+	i := int(x * float64(g.size))
+	if i < 0 {
+		return 0
+	}
+	if i >= g.size {
+		return g.size - 1
+	}
+	return i
+}
+
+func (g *grid) cell(x, y float64) int {
+	return g.axis(y)*g.size + g.axis(x)
+}
+
+// call visit for the bodies of the cells at ring distance ring of the cell i, j
+// (ring 0 is the cell itself, ring 1 are the 8 cells around it, ...)
+func (g *grid) visitRing(i, j, ring int, visit func(idx int32)) {
+
+	for jj := j - ring; jj <= j+ring; jj++ {
+		if jj < 0 || jj >= g.size {
+			continue
+		}
+		for ii := i - ring; ii <= i+ring; ii++ {
+			if ii < 0 || ii >= g.size {
+				continue
+			}
+			// only the border of the ring
+			if ii != i-ring && ii != i+ring && jj != j-ring && jj != j+ring {
+				continue
+			}
+			c := jj*g.size + ii
+			for _, idx := range g.bodies[g.start[c]:g.start[c+1]] {
+				visit(idx)
+			}
+		}
+	}
+}
+
+// Neighbours returns the barneshut.NbOfNeighboursPerBody nearest neighbours of each body
+//
+// The stirring between two configurations of the same bodies is measured with
+// Neighbours(bodies).ComputeStirring(neighboursOrig), where neighboursOrig are the neighbours
+// of the first configuration. Neighbours are stored by their ID, bodies without ID are numbered.
+func Neighbours(bodies *[]quadtree.Body) *barneshut.NeighbourDico {
+
+	quadtree.SetMissingIDs(*bodies)
+	dico := barneshut.NewNeighbourDico(len(*bodies))
+	if len(*bodies) == 0 {
+		return dico
+	}
+	g := newGrid(*bodies)
+	cellSize := 1.0 / float64(g.size)
+	last := barneshut.NbOfNeighboursPerBody - 1
+
+	for idx := range *bodies {
+		body := &((*bodies)[idx])
+		i, j := g.axis(body.X), g.axis(body.Y)
+
+		for ring := 0; ring < g.size; ring++ {
+			g.visitRing(i, j, ring, func(n int32) {
+				if int(n) == idx {
+					return
+				}
+				neighbour := &((*bodies)[n])
+				dist := math.Hypot(neighbour.X-body.X, neighbour.Y-body.Y)
+				dico.Insert(idx, neighbour, dist)
+			})
+			// as long as the dico is not full, the distance of the last neighbour is 2.0
+			if (*dico)[idx][last].Distance <= float64(ring)*cellSize {
+				break
+			}
+		}
+	}
+	return dico
+}
+
+// return the Voronoi cell of the body at idx (within the unit square)
+//
+// the cell is clipped by the bisectors of the bodies ring after ring. After ring k, all bodies
+// within k cells of the body have been seen and the other bodies cannot clip the cell
+// if they are further than twice the radius of the cell. The cell is stored in c.
+func (g *grid) voronoiCell(bodies []quadtree.Body, idx int, c *clipper) polygon {
+
+	cellSize := 1.0 / float64(g.size)
+	body := bodies[idx].BodyXY
+	i, j := g.axis(body.X), g.axis(body.Y)
+
+	c.reset()
+	for ring := 0; ring < g.size; ring++ {
+		g.visitRing(i, j, ring, func(n int32) {
+			other := bodies[n].BodyXY
+			if int(n) == idx || other == body {
+				return
+			}
+			m := quadtree.BodyXY{X: (body.X + other.X) / 2.0, Y: (body.Y + other.Y) / 2.0}
+			d := quadtree.BodyXY{X: other.X - body.X, Y: other.Y - body.Y}
+			c.clip(m, d)
+		})
+		if 2.0*c.cell.radius(body.X, body.Y) <= float64(ring)*cellSize {
+			break
+		}
+	}
+	return c.cell
+}
@@ -0,0 +1,221 @@
+// Package lloyd spreads bodies with the Lloyd algorithm, an alternative to the N-body spreading of barneshut
+//
+// At each step, the square is tesselated into the Voronoi cells of the bodies and each body is moved
+// to the centroid of its cell. The iterations converge toward a centroidal Voronoi tesselation, where
+// all cells have about the same area: the density of bodies is uniform and, since a body moves only within
+// its own cell, bodies keep their neighbours.
+//
+// The Voronoi cell of a body is computed by clipping the square with the bisectors of the nearest bodies,
+// until the bodies that have not been seen are too far to clip the cell.
+//
+// Lloyd iterations are local, a body moves within its cell. When the density is very uneven (a few
+// cities with most of the bodies), many steps are needed to spread the bodies over the square. The relaxation
+// is then better used after a global spreading (Barnes-Hut for instance).
+package lloyd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+
+	barneshut "github.com/thomaspeugeot/tkv/barnes-hut"
+	"github.com/thomaspeugeot/tkv/bods"
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// nb of sample points per axis of the bounding box of a cell, used to compute
+// the centroid of the part of the cell that is within the domain
+const domainSamples = 8
+
+// a Run of the Lloyd algorithm on a set of bodies
+type Run struct {
+	country string
+	bodies  *[]quadtree.Body
+	step    int
+
+	// if not nil, the density of bodies is uniform within the domain only. The centroid of a cell
+	// is then the centroid of the part of the cell that is within the domain (it is estimated with sample points).
+	Domain barneshut.Domain
+
+	// the body is moved to position + OverRelaxation * (centroid - position). 1.0 (if 0) is the
+	// Lloyd algorithm, values between 1.0 and 2.0 speed up the convergence
+	OverRelaxation float64
+
+	NbRoutines int // nb of concurrent routines that compute the cells (nb of CPU if 0)
+
+	neighboursOrig *barneshut.NeighbourDico // neighbours at the start of the run
+
+	displacement float64 // mean displacement of the last step, relative to the mean distance between bodies
+
+	OutputDir string // output dir for the run
+}
+
+// Init prepares the run for the bodies of country
+//
+// bodies are moved in place
+func (r *Run) Init(country string, bodies *[]quadtree.Body) {
+	r.country = country
+	r.bodies = bodies
+	r.step = 0
+	r.displacement = 1.0
+	r.neighboursOrig = Neighbours(bodies)
+	if r.OutputDir == "" {
+		r.OutputDir = "."
+	}
+}
+
+func (r *Run) GetStep() int { return r.step }
+
+// Displacement returns the mean displacement of the bodies during the last step,
+// relative to the mean distance between bodies in a uniform layout
+func (r *Run) Displacement() float64 { return r.displacement }
+
+// Stirring returns the ratio of the original neighbours that are still neighbours
+// (see barneshut.NeighbourDico.ComputeStirring)
+func (r *Run) Stirring() float64 {
+	return Neighbours(r.bodies).ComputeStirring(r.neighboursOrig)
+}
+
+// OneStep moves each body to the centroid of its Voronoi cell
+//
+// bodies are shared among the concurrent routines, positions are updated once all the cells are computed
+func (r *Run) OneStep() {
+
+	bodies := *r.bodies
+	if len(bodies) == 0 {
+		return
+	}
+	g := newGrid(bodies)
+
+	omega := r.OverRelaxation
+	if omega == 0.0 {
+		omega = 1.0
+	}
+
+	nbRoutines := r.NbRoutines
+	if nbRoutines <= 0 {
+		nbRoutines = runtime.NumCPU()
+	}
+	positions := make([]quadtree.BodyXY, len(bodies))
+	displacements := make([]float64, nbRoutines)
+	var wg sync.WaitGroup
+	for routine := 0; routine < nbRoutines; routine++ {
+		wg.Add(1)
+		go func(routine int) {
+			defer wg.Done()
+			var c clipper
+			for idx := routine; idx < len(bodies); idx += nbRoutines {
+				body := bodies[idx].BodyXY
+				positions[idx] = body
+
+				cell := g.voronoiCell(bodies, idx, &c)
+				x, y, ok := r.centroid(cell)
+				if !ok {
+					continue
+				}
+				displacements[routine] += math.Hypot(x-body.X, y-body.Y)
+
+				// over relaxation, the body goes beyond the centroid
+				if omega != 1.0 {
+					xOver := body.X + omega*(x-body.X)
+					yOver := body.Y + omega*(y-body.Y)
+					if xOver >= 0.0 && xOver < 1.0 && yOver >= 0.0 && yOver < 1.0 &&
+						(r.Domain == nil || r.Domain.Contains(xOver, yOver)) {
+						x, y = xOver, yOver
+					}
+				}
+				positions[idx] = quadtree.BodyXY{X: x, Y: y}
+			}
+		}(routine)
+	}
+	wg.Wait()
+
+	displacement := 0.0
+	for routine := range displacements {
+		displacement += displacements[routine]
+	}
+	for idx := range bodies {
+		bodies[idx].BodyXY = positions[idx]
+	}
+	r.displacement = displacement / float64(len(bodies)) * math.Sqrt(float64(len(bodies)))
+
+	r.step++
+	Trace.Printf("step %d, displacement %f", r.step, r.displacement)
+}
+
+// return the centroid of the cell (within the domain if there is one)
+//
+// ok is false if no part of the cell is within the domain
+func (r *Run) centroid(cell polygon) (x, y float64, ok bool) {
+
+	if r.Domain == nil {
+		return cell.centroid()
+	}
+
+	xMin, yMin, xMax, yMax := cell.bbox()
+	nb := 0
+	for j := 0; j < domainSamples; j++ {
+		for i := 0; i < domainSamples; i++ {
+			xS := xMin + (xMax-xMin)*(float64(i)+0.5)/domainSamples
+			yS := yMin + (yMax-yMin)*(float64(j)+0.5)/domainSamples
+			if cell.contains(xS, yS) && r.Domain.Contains(xS, yS) {
+				x += xS
+				y += yS
+				nb++
+			}
+		}
+	}
+	if nb == 0 {
+		return 0.0, 0.0, false
+	}
+	return x / float64(nb), y / float64(nb), true
+}
+
+// RunRelaxation performs steps until the relative displacement (see Displacement) is below threshold
+// or until maxSteps steps have been performed (if maxSteps is above 0)
+//
+// return the nb of steps
+func (r *Run) RunRelaxation(threshold float64, maxSteps int) int {
+
+	for maxSteps <= 0 || r.step < maxSteps {
+		r.OneStep()
+		if r.displacement < threshold {
+			break
+		}
+	}
+	Info.Printf("Lloyd relaxation of %s completed at step %d, displacement %e", r.country, r.step, r.displacement)
+	return r.step
+}
+
+// CaptureConfig writes the bodies in the output dir, with the name
+// of the body files of barneshut (see barneshut.CountryBodiesNamePattern)
+//
+// return true if operation was successful
+func (r *Run) CaptureConfig() bool {
+
+	filename := fmt.Sprintf(r.OutputDir+"/"+barneshut.CountryBodiesNamePattern, r.country, len(*r.bodies), r.step)
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+		return false
+	}
+	err = r.WriteConfig(file)
+	file.Close()
+	if err != nil {
+		Error.Printf("CaptureConfig %s: %s", filename, err.Error())
+		return false
+	}
+	return true
+}
+
+// WriteConfig writes the bodies into out, with the format of the body files
+func (r *Run) WriteConfig(out io.Writer) error {
+	if barneshut.UseBinaryBodsFormat {
+		return bods.NewEncoder(out).Encode(r.country, r.step, *r.bodies)
+	}
+	return bods.WriteJSON(out, *r.bodies)
+}
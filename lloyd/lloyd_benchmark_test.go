@@ -0,0 +1,99 @@
+package lloyd
+
+import (
+	"archive/zip"
+	"context"
+	"testing"
+
+	barneshut "github.com/thomaspeugeot/tkv/barnes-hut"
+	"github.com/thomaspeugeot/tkv/bods"
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// compare the Lloyd relaxation with the Barnes-Hut spreading for haiti
+// (one body out of 20 of the original configuration)
+//
+// the stirring is measured with the nearest neighbours (see Neighbours) for both,
+// spread is the spread of the density tenciles with 20 bodies per village
+//
+// BenchmarkLloydVsBarnesHutHti/lloyd                 	 1	 62988225312 ns/op	124.1 spread	2000 steps	0.4075 stirring
+// BenchmarkLloydVsBarnesHutHti/barnesHut             	 1	117235842746 ns/op	58.96 spread	 535 steps	0.4606 stirring
+// BenchmarkLloydVsBarnesHutHti/barnesHutThenLloyd    	 1	132497064276 ns/op	53.08 spread	 635 steps	0.4520 stirring
+//
+// Lloyd alone does not converge within 2000 steps: bodies of the cities only move within their
+// cells and the spreading over the whole country is slow. After Barnes-Hut, 100 steps of Lloyd
+// make the density more uniform and keep the neighbours.
+func BenchmarkLloydVsBarnesHutHti(b *testing.B) {
+
+	archive, err := zip.OpenReader("../runtime_server/conf-hti-00190948-00000.bods.zip")
+	if err != nil {
+		b.Skip(err)
+	}
+	defer archive.Close()
+	file, err := archive.File[0].Open()
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, hti, err := bods.ReadBodies(file)
+	file.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var sample []quadtree.Body
+	for idx := 0; idx < len(hti); idx += 20 {
+		sample = append(sample, hti[idx])
+	}
+
+	b.Run("lloyd", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			bodies := append([]quadtree.Body(nil), sample...)
+			var r Run
+			r.OverRelaxation = 1.9
+			r.Init("hti", &bodies)
+			steps := r.RunRelaxation(0.001, 2000)
+
+			b.ReportMetric(float64(steps), "steps")
+			b.ReportMetric(r.Stirring(), "stirring")
+			b.ReportMetric(densitySpread(bodies), "spread")
+		}
+	})
+
+	for _, lloydSteps := range []int{0, 100} {
+		name := "barnesHut"
+		if lloydSteps > 0 {
+			name = "barnesHutThenLloyd"
+		}
+		b.Run(name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				bodies := append([]quadtree.Body(nil), sample...)
+				neighboursOrig := Neighbours(&bodies)
+
+				var r barneshut.Run
+				defer r.Close()
+				config := barneshut.NewRunConfig()
+				config.StopCriteria = barneshut.AnyOf{barneshut.EnergyDecrease{Threshold: 1e-4}, barneshut.MaxSteps{Steps: 2000}}
+				r.SetConfig(config)
+				r.OutputDir = b.TempDir()
+				r.CaptureGifStep = 0
+				r.Init(&bodies)
+				r.SetState(barneshut.RUNNING)
+				result, err := r.RunSimulation(context.Background())
+				if err != nil {
+					b.Fatal(err)
+				}
+				steps := result.Step
+
+				if lloydSteps > 0 {
+					var l Run
+					l.OverRelaxation = 1.9
+					l.Init("hti", &bodies)
+					steps += l.RunRelaxation(0.0, lloydSteps)
+				}
+
+				b.ReportMetric(float64(steps), "steps")
+				b.ReportMetric(Neighbours(&bodies).ComputeStirring(neighboursOrig), "stirring")
+				b.ReportMetric(densitySpread(bodies), "spread")
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package lloyd
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+
+	barneshut "github.com/thomaspeugeot/tkv/barnes-hut"
+	"github.com/thomaspeugeot/tkv/bods"
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// nb bodies of mass 1, the positions are seeded by nb
+func randomBodies(nb int) []quadtree.Body {
+	rng := rand.New(rand.NewSource(int64(nb)))
+	bodies := make([]quadtree.Body, nb)
+	for idx := range bodies {
+		bodies[idx].X = rng.Float64()
+		bodies[idx].Y = rng.Float64()
+		bodies[idx].M = 1.0
+	}
+	return bodies
+}
+
+// return the index of the body that is the nearest of x, y
+func nearestBody(bodies []quadtree.Body, x, y float64) int {
+	nearest, nearestDist := -1, math.MaxFloat64
+	for idx, body := range bodies {
+		if dist := math.Hypot(body.X-x, body.Y-y); dist < nearestDist {
+			nearest, nearestDist = idx, dist
+		}
+	}
+	return nearest
+}
+
+// test that the neighbours are the nearest bodies
+func TestNeighbours(t *testing.T) {
+
+	bodies := randomBodies(300)
+	dico := Neighbours(&bodies)
+
+	for idx, body := range bodies {
+		var distances []float64
+		for n, other := range bodies {
+			if n != idx {
+				distances = append(distances, math.Hypot(other.X-body.X, other.Y-body.Y))
+			}
+		}
+		sort.Float64s(distances)
+		for rank := 0; rank < barneshut.NbOfNeighboursPerBody; rank++ {
+			if got := (*dico)[idx][rank].Distance; got != distances[rank] {
+				t.Fatalf("body %d rank %d, distance got %f, want %f", idx, rank, got, distances[rank])
+			}
+		}
+	}
+	if stirring := dico.ComputeStirring(dico); stirring != 1.0 {
+		t.Errorf("stirring of the same neighbours got %f", stirring)
+	}
+}
+
+// spread of the density tenciles of the bodies (see barneshut.DensitySpread)
+// with about 20 bodies per village
+func densitySpread(bodies []quadtree.Body) float64 {
+	var r barneshut.Run
+	defer r.Close()
+	r.Init(&bodies)
+	r.SetNbVillagePerAxe(int(math.Sqrt(float64(len(bodies)) / 20.0)))
+	tenciles := r.ComputeDensityTencilePerTerritory()
+	return tenciles[9] - tenciles[0]
+}
+
+// test that the relaxation spreads the bodies and keeps their neighbours
+func TestRelaxation(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 500)
+	barneshut.SpreadOnCircle(&bodies)
+	spread := densitySpread(append([]quadtree.Body(nil), bodies...))
+
+	var r Run
+	r.OverRelaxation = 1.9
+	r.Init("tst", &bodies)
+	steps := r.RunRelaxation(0.002, 2000)
+
+	if r.Displacement() >= 0.002 {
+		t.Errorf("displacement got %f after %d steps", r.Displacement(), steps)
+	}
+	if got := densitySpread(append([]quadtree.Body(nil), bodies...)); got > spread/10.0 {
+		t.Errorf("density spread got %f, initial spread %f", got, spread)
+	}
+	if stirring := r.Stirring(); stirring < 0.3 {
+		t.Errorf("stirring got %f", stirring)
+	}
+}
+
+// test that the points of the voronoi cell of a body are nearer to this body than to the others
+func TestVoronoiCell(t *testing.T) {
+
+	bodies := randomBodies(300)
+	g := newGrid(bodies)
+
+	var c clipper
+	area := 0.0
+	for idx := range bodies {
+		cell := g.voronoiCell(bodies, idx, &c)
+		if !cell.contains(bodies[idx].X, bodies[idx].Y) {
+			t.Fatalf("body %d is not in its cell %v", idx, cell)
+		}
+		for _, v := range cell {
+			// move the vertex a little toward the body
+			x := v.X + 1e-9*(bodies[idx].X-v.X)
+			y := v.Y + 1e-9*(bodies[idx].Y-v.Y)
+			if nearest := nearestBody(bodies, x, y); nearest != idx {
+				t.Fatalf("vertex %v of the cell of %d is nearer to %d", v, idx, nearest)
+			}
+		}
+		for k := range cell {
+			cur, next := cell[k], cell[(k+1)%len(cell)]
+			area += (cur.X*next.Y - next.X*cur.Y) / 2.0
+		}
+	}
+	// cells are a partition of the square
+	if math.Abs(area-1.0) > 1e-9 {
+		t.Errorf("total area of the cells got %f", area)
+	}
+}
+
+func TestPolygon(t *testing.T) {
+
+	var c clipper
+	c.reset()
+	// keep x <= 0.5
+	c.clip(quadtree.BodyXY{X: 0.5, Y: 0.5}, quadtree.BodyXY{X: 1.0, Y: 0.0})
+	// keep y <= x
+	c.clip(quadtree.BodyXY{X: 0.0, Y: 0.0}, quadtree.BodyXY{X: -1.0, Y: 1.0})
+
+	x, y, ok := c.cell.centroid()
+	if !ok || math.Abs(x-1.0/3.0) > 1e-12 || math.Abs(y-1.0/6.0) > 1e-12 {
+		t.Errorf("centroid got %f %f %t, cell %v", x, y, ok, c.cell)
+	}
+	if !c.cell.contains(0.4, 0.1) || c.cell.contains(0.1, 0.4) || c.cell.contains(0.6, 0.1) {
+		t.Errorf("contains is wrong for the cell %v", c.cell)
+	}
+}
+
+// domain that is the left half of the square
+type leftHalf struct{}
+
+func (d leftHalf) Contains(x, y float64) bool { return x < 0.5 }
+
+// test that the bodies are kept within the domain
+func TestRelaxationDomain(t *testing.T) {
+
+	bodies := randomBodies(500)
+	for idx := range bodies {
+		bodies[idx].X *= 0.5
+	}
+
+	var r Run
+	r.Domain = leftHalf{}
+	r.Init("tst", &bodies)
+	r.RunRelaxation(0.0, 20)
+
+	maxX := 0.0
+	for _, body := range bodies {
+		maxX = math.Max(maxX, body.X)
+	}
+	// bodies near the border of the domain are at the middle of their cell
+	if maxX >= 0.5 || maxX < 0.45 {
+		t.Errorf("max x got %f", maxX)
+	}
+}
+
+func TestCaptureConfig(t *testing.T) {
+
+	bodies := randomBodies(100)
+
+	var r Run
+	r.OutputDir = t.TempDir()
+	r.Init("tst", &bodies)
+	r.RunRelaxation(0.0, 3)
+	if !r.CaptureConfig() {
+		t.Fatal("capture failed")
+	}
+
+	file, err := os.Open(r.OutputDir + "/" + fmt.Sprintf(barneshut.CountryBodiesNamePattern, "tst", 100, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	_, read, err := bods.ReadBodies(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(bodies) || read[42].BodyXY != bodies[42].BodyXY {
+		t.Errorf("got %d bodies, body 42 at %v, want %v", len(read), read[42].BodyXY, bodies[42].BodyXY)
+	}
+}
@@ -0,0 +1,92 @@
+package lloyd
+
+import (
+	"math"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// a convex polygon, vertices are counter clockwise
+type polygon []quadtree.BodyXY
+
+// the unit square
+var square = polygon{{X: 0.0, Y: 0.0}, {X: 1.0, Y: 0.0}, {X: 1.0, Y: 1.0}, {X: 0.0, Y: 1.0}}
+
+// clips a cell by half planes, the buffers are reused from one cell to the next
+type clipper struct {
+	cell, tmp polygon
+}
+
+// start a new cell that is the unit square
+func (c *clipper) reset() {
+	c.cell = append(c.cell[:0], square...)
+}
+
+// keep the part of the cell where (v - m).d <= 0 (Sutherland-Hodgman)
+func (c *clipper) clip(m, d quadtree.BodyXY) {
+
+	side := func(v quadtree.BodyXY) float64 { return (v.X-m.X)*d.X + (v.Y-m.Y)*d.Y }
+
+	c.tmp = c.tmp[:0]
+	for k := range c.cell {
+		cur, next := c.cell[k], c.cell[(k+1)%len(c.cell)]
+		sCur, sNext := side(cur), side(next)
+		if sCur <= 0.0 {
+			c.tmp = append(c.tmp, cur)
+		}
+		if (sCur < 0.0 && sNext > 0.0) || (sCur > 0.0 && sNext < 0.0) {
+			t := sCur / (sCur - sNext)
+			c.tmp = append(c.tmp, quadtree.BodyXY{X: cur.X + t*(next.X-cur.X), Y: cur.Y + t*(next.Y-cur.Y)})
+		}
+	}
+	c.cell, c.tmp = c.tmp, c.cell
+}
+
+// centroid of the polygon, ok is false if its area is 0
+func (p polygon) centroid() (x, y float64, ok bool) {
+
+	area := 0.0
+	for k := range p {
+		cur, next := p[k], p[(k+1)%len(p)]
+		cross := cur.X*next.Y - next.X*cur.Y
+		area += cross
+		x += (cur.X + next.X) * cross
+		y += (cur.Y + next.Y) * cross
+	}
+	if area == 0.0 {
+		return 0.0, 0.0, false
+	}
+	return x / (3.0 * area), y / (3.0 * area), true
+}
+
+func (p polygon) bbox() (xMin, yMin, xMax, yMax float64) {
+	xMin, yMin, xMax, yMax = math.MaxFloat64, math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64
+	for _, v := range p {
+		xMin, xMax = math.Min(xMin, v.X), math.Max(xMax, v.X)
+		yMin, yMax = math.Min(yMin, v.Y), math.Max(yMax, v.Y)
+	}
+	return
+}
+
+// true if x, y is within the polygon (which is convex)
+func (p polygon) contains(x, y float64) bool {
+	if len(p) < 3 {
+		return false
+	}
+	for k := range p {
+		cur, next := p[k], p[(k+1)%len(p)]
+		if (next.X-cur.X)*(y-cur.Y)-(next.Y-cur.Y)*(x-cur.X) < 0.0 {
+			return false
+		}
+	}
+	return true
+}
+
+// max distance between x, y and the vertices of the polygon
+func (p polygon) radius(x, y float64) float64 {
+	radius := 0.0
+	for _, v := range p {
+		radius = math.Max(radius, math.Hypot(v.X-x, v.Y-y))
+	}
+	return radius
+}
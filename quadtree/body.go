@@ -15,6 +15,10 @@ type Body struct {
 	BodyXY
 	M float64
 
+	// ID identifies the body along the pipeline (from grump-reader to the translation), it is
+	// kept when the bodies are moved or reordered. IDs start at 1, 0 means that the body has no ID
+	ID uint32
+
 	// coordinate in the quadtree
 	coord Coord
 
@@ -30,6 +34,9 @@ func (b *Body) Next() *Body { return b.next }
 
 func (b *Body) Coord() Coord { return b.coord }
 
+// Coord8 returns the coordinates of the node of the body at level 8
+func (b *Body) Coord8() Coord { return b.getCoord8() }
+
 // get Node coordinates at level 8
 func (b Body) getCoord8() Coord {
 	var c Coord
@@ -45,16 +52,52 @@ func (b Body) getCoord8() Coord {
 	return c
 }
 
+// get Node coordinates at level
+func (b Body) getCoord(level int) Coord {
+	if level == 8 {
+		return b.getCoord8()
+	}
+	nbNodes := float64(int(1) << uint(level))
+	return GetCoord(level, int(b.X*nbNodes), int(b.Y*nbNodes))
+}
+
+// SetMissingIDs numbers the bodies from 1 if none of them has an ID
+// (bodies created without ID or read from a body file written before the IDs)
+// return true if the bodies have been numbered
+func SetMissingIDs(bodies []Body) bool {
+	for idx := range bodies {
+		if bodies[idx].ID != 0 {
+			return false
+		}
+	}
+	for idx := range bodies {
+		bodies[idx].ID = uint32(idx + 1)
+	}
+	return len(bodies) > 0
+}
+
 // init a quadtree with random position
 func InitBodiesUniform(bodies *[]Body, nbBodies int) {
+	InitBodiesUniformWithRand(bodies, nbBodies, nil)
+}
+
+// same as InitBodiesUniform with positions and masses drawn from rng
+// (from the global source of math/rand if rng is nil)
+func InitBodiesUniformWithRand(bodies *[]Body, nbBodies int, rng *rand.Rand) {
+
+	uniform := rand.Float64
+	if rng != nil {
+		uniform = rng.Float64
+	}
 
 	// var q Quadtree
 	*bodies = make([]Body, nbBodies)
 
 	// init bodies
 	for idx := range *bodies {
-		(*bodies)[idx].X = rand.Float64()
-		(*bodies)[idx].Y = rand.Float64()
-		(*bodies)[idx].M = rand.Float64()
+		(*bodies)[idx].X = uniform()
+		(*bodies)[idx].Y = uniform()
+		(*bodies)[idx].M = uniform()
+		(*bodies)[idx].ID = uint32(idx + 1)
 	}
 }
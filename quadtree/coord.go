@@ -22,8 +22,8 @@ import (
 //
 // Coordinates of a node are coded as follow
 //
-//	byte 0 : 0x00 (unused)
-// 	byte 1 : level (root = 0, max depth = 7)
+//	byte 0 : X and Y coordinates extension for levels 9 to 12 (4 bits each)
+// 	byte 1 : level (root = 0, max depth = 12)
 // 	byte 2 : X coordinate
 // 	byte 3 : Y coordinate : coded on
 //
@@ -33,27 +33,42 @@ import (
 //	level 2: node coordinates are 0, 64 (0x40), 128 (0x80), 192 (0x84)
 //	...
 //	level 8: node coordinates are encoded on the full 8 bits, from 0 to 0xFF (255)
+//
+// Nodes below level 8 are only needed where bodies are crowded (see Quadtree.MaxLevel).
+// Their X coordinate is continued on the 4 high bits of byte 0 and their Y coordinate on the 4 low bits.
+// Therefore, byte 0 is null for nodes at level 8 and above, and the Coord of those nodes is their rank in the direct
+// access table
+//
+//	level 9: node coordinates are encoded on byte 2 and the highest bit of the half byte 0
+//	...
+//	level 12: node coordinates are encoded on byte 2 and the 4 bits of the half byte 0
 type Coord uint32
 
+// MaxDepth is the deepest level that can be encoded in a Coord
+const MaxDepth = 12
+
 // node level of a node coord c
-// is between 0 and 8 and coded on 2nd byte of the Coord c
-func (c Coord) Level() int { return int(c >> 16) }
+// is between 0 and MaxDepth and coded on 2nd byte of the Coord c
+func (c Coord) Level() int { return int((c >> 16) & 0xFF) }
 func (c *Coord) SetLevel(level int) {
 
-	*c = *c & 0x0000FFFF // reset level but bytes for x & y are preserved
+	*c = *c & 0xFF00FFFF // reset level but bytes for x & y are preserved
 	var pad uint32
 	pad = (uint32(level) << 16)
 	*c = *c | Coord(pad) // set level
 
 }
 
-// x coord
+// x coord (at the resolution of level 8)
 func (c Coord) X() int { return int((c & 0x0000FFFF) >> 8) }
 
+// x coord at the resolution of MaxDepth (from 0 to 4095)
+func (c Coord) fineX() int { return c.X()<<4 | int(c>>28) }
+
 // set X coordinate of node in Hexa from 0 to 255
 func (c *Coord) setXHexaLevel8(x int) {
 
-	*c = *c & 0x00FF00FF // reset x bytes
+	*c = *c & 0x0FFF00FF // reset x bytes
 
 	var pad uint32
 	pad = (uint32(x) << 8)
@@ -61,57 +76,89 @@ func (c *Coord) setXHexaLevel8(x int) {
 	*c = *c | Coord(pad)
 }
 
+// set X coordinate at the resolution of MaxDepth (from 0 to 4095)
+func (c *Coord) setFineX(x int) {
+	c.setXHexaLevel8(x >> 4)
+	*c = *c | Coord(uint32(x&0xF)<<28)
+}
+
 // set X coordinate in Hexa according to level
 // x is between 0 and 1<<(level-1)
 func (c *Coord) setXHexa(x int, level int) {
-	c.setXHexaLevel8(x << (8 - uint(level)))
+	if level <= 8 {
+		c.setXHexaLevel8(x << (8 - uint(level)))
+	} else {
+		c.setFineX(x << (MaxDepth - uint(level)))
+	}
 }
 
-// y coord
+// y coord (at the resolution of level 8)
 func (c Coord) Y() int { return int(c & 0x000000FF) }
+
+// y coord at the resolution of MaxDepth (from 0 to 4095)
+func (c Coord) fineY() int { return c.Y()<<4 | int((c>>24)&0xF) }
+
 func (c *Coord) setYHexaLevel8(y int) {
-	*c = *c & 0x00FFFF00 // reset y bytes
+	*c = *c & 0xF0FFFF00 // reset y bytes
 
 	var pad uint32
 	pad = uint32(y)
 	*c = *c | Coord(pad)
 }
 
+// set Y coordinate at the resolution of MaxDepth (from 0 to 4095)
+func (c *Coord) setFineY(y int) {
+	c.setYHexaLevel8(y >> 4)
+	*c = *c | Coord(uint32(y&0xF)<<24)
+}
+
 // set Y coordinate in Hexa according to level
 // y is between 0 and 1<<(level-1)
 func (c *Coord) setYHexa(y int, level int) {
-	c.setYHexaLevel8(y << (8 - uint(level)))
+	if level <= 8 {
+		c.setYHexaLevel8(y << (8 - uint(level)))
+	} else {
+		c.setFineY(y << (MaxDepth - uint(level)))
+	}
 }
 
 // check encoding of c
 func (c *Coord) checkIntegrity() bool {
 
-	//	byte 0 is null
-	if res := 0xFF000000 & (*c); res != 0x00 {
-		return false
-	}
-
-	// check level is below or equal to 8
-	if c.Level() > 8 {
+	// check level is below or equal to MaxDepth
+	if c.Level() > MaxDepth {
 		return false
 	}
 
 	// check x coord is encoded acoording to the level
+	// (bits below the resolution of the level are null, this includes byte 0 for levels 0 to 8)
 	if false {
-		fmt.Printf("y (0xFF >> uint( SetLevel(%d))) %08b\n", c.Level(), 0xFF>>uint(c.Level()))
+		fmt.Printf("y (0xFFF >> uint( SetLevel(%d))) %012b\n", c.Level(), 0xFFF>>uint(c.Level()))
 	}
-	if (0xFF>>uint(c.Level()))&c.X() != 0x00 {
+	if (0xFFF>>uint(c.Level()))&c.fineX() != 0x00 {
 		return false
 	}
 
 	// check y coord
-	if (0xFF>>uint(c.Level()))&c.Y() != 0x00 {
+	if (0xFFF>>uint(c.Level()))&c.fineY() != 0x00 {
 		return false
 	}
 
 	return true
 }
 
+// rank of the node along the Z-order (Morton) curve, at the resolution of level 8 (from 0 to 0xFFFF)
+// the bits of X and Y are interleaved, therefore the nodes below a node have contiguous ranks
+func (c Coord) Morton() int {
+	rank := 0
+	for bit := 7; bit >= 0; bit-- {
+		rank = rank<<2 | (c.X()>>uint(bit)&1)<<1 | c.Y()>>uint(bit)&1
+	}
+	return rank
+}
+
+// get the coord of node i, j at level
+// i and j are between 0 and 1<<(level-1)
 func GetCoord(level, i, j int) Coord {
 	var coord Coord
 	coord.SetLevel(level)
@@ -7,8 +7,8 @@ import (
 // a node is a body
 type Node struct {
 	// bodies of the node
-	//  at level 8, this is the list of bodies pertaining in the bounding box of the node
-	// for level 7 to 0, this is the list of the four bodies of the nodes at the level below (or +1)
+	//  for a leaf node, this is the list of bodies pertaining in the bounding box of the node
+	// for other nodes, this is the list of the four bodies of the nodes at the level below (or +1)
 
 	// Barycenter with mass of all the bodies of the node
 	// this body is linked with the bodies at his level in the node
@@ -16,11 +16,20 @@ type Node struct {
 	first    *Body // link to the bodies below
 	coord    Coord // the coordinate of the Node
 	nbBodies int   // number of bodies in the node
+
+	below *[4]Node // nodes at the level below for nodes at level 8 and deeper (NW, NE, SW, SE), nil for a leaf
 }
 
 // link to the first body of the bodies chain belonging to the node
 func (n *Node) First() *Body { return n.first }
 
+// a leaf node is a node whose first body is the first of the bodies located in the node
+// leaves are at level 8 or deeper if the area of the node is crowded
+func (n *Node) IsLeaf() bool { return n.coord.Level() >= 8 && n.below == nil }
+
+// number of bodies in the node (computed by ComputeNbBodiesPerNode)
+func (n *Node) NbBodies() int { return n.nbBodies }
+
 func (n *Node) Coord() Coord { return n.coord }
 
 // update COM of a node (reset the current COM before)
@@ -12,15 +12,22 @@ Each node holds sub nodes except the leaf nodes that contains all bodies that ar
 Bodies's X,Y coordinates are float64 between 0 & 1
 
 A quadtree is usualy a dynmic structure. In this package implementation, the architecture is static with a depth of nodes
-limited to 8 (256 * 256 cells at the level 8)
+of 8 (256 * 256 cells at the level 8).
+
+When bodies are crowded in some cells (for instance 1M bodies with a large part of them in Paris), leaves at level 8 hold
+thousands of bodies. Therefore, the depth can be increased up to MaxDepth with Quadtree.MaxLevel. Nodes at level 8 holding more than
+Quadtree.LeafCapacity bodies are then divided into nodes of the level below, and so on. Only those nodes are allocated, the
+depth of the quadtree adapts to the local density of bodies.
 */
 package quadtree
 
 import (
 	"fmt"
 	"math"
+	"runtime"
 	"sort"
-	"testing"
+	"strings"
+	"sync"
 )
 
 type QuadtreeGini [9][10]float64
@@ -31,8 +38,36 @@ type Quadtree struct {
 	Nodes         [1 << 20]Node
 	bodies        *[]Body      // pointer to the body slice
 	BodyCountGini QuadtreeGini // for each of the 9 levels, tencentile of bodies
+
+	// MaxLevel is the maximum depth of the quadtree, between 8 and MaxDepth (0 means 8)
+	MaxLevel int
+
+	// LeafCapacity is the number of bodies above which a node at level 8 or below is divided
+	// it is used only if MaxLevel is above 8 (0 means DefaultLeafCapacity)
+	LeafCapacity int
+
+	// NbRoutines is the number of concurrent routines that update the nodes (0 means the nb of CPU)
+	NbRoutines int
+
+	chunks []*chunkLists // lists of the bodies of the chunks, reused from one update to the next
+}
+
+// lists of the bodies of a chunk of the body slice, for each node at level 8
+// (the index of a node is its coord without the level)
+type chunkLists struct {
+	heads, tails [1 << 16]*Body
+	counts       [1 << 16]int
 }
 
+// max nb of chunks of the body slice during the update of the nodes
+const maxChunks = 16
+
+// Debug, if true, checks the integrity of the quadtree after each update (this is slow)
+var Debug bool
+
+// DefaultLeafCapacity is the default number of bodies above which a node is divided
+const DefaultLeafCapacity = 32
+
 var optim bool
 
 func init() {
@@ -63,8 +98,40 @@ func (q *Quadtree) UpdateNodesListsAndCOM() {
 	q.updateNodesList()
 	q.updateNodesCOM()
 
-	var t testing.T
-	q.CheckIntegrity(&t)
+	if Debug {
+		if err := q.Check(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// nb of routines of the update
+func (q *Quadtree) nbRoutines() int {
+	if q.NbRoutines <= 0 {
+		return runtime.NumCPU()
+	}
+	return q.NbRoutines
+}
+
+// call f on nbRoutines contiguous ranges of [0, n) concurrently
+func parallel(nbRoutines, n int, f func(start, end int)) {
+
+	if nbRoutines > n {
+		nbRoutines = n
+	}
+	if nbRoutines <= 1 {
+		f(0, n)
+		return
+	}
+	var wg sync.WaitGroup
+	for routine := 0; routine < nbRoutines; routine++ {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			f(start, end)
+		}(routine*n/nbRoutines, (routine+1)*n/nbRoutines)
+	}
+	wg.Wait()
 }
 
 // compute quadtree Nodes for levels from 0 to 7
@@ -87,12 +154,66 @@ func (q *Quadtree) updateNodesCOMAbove8() {
 	}
 }
 
+// max level of the quadtree
+func (q *Quadtree) maxLevel() int {
+	if q.MaxLevel < 8 {
+		return 8
+	}
+	if q.MaxLevel > MaxDepth {
+		return MaxDepth
+	}
+	return q.MaxLevel
+}
+
+func (q *Quadtree) leafCapacity() int {
+	if q.LeafCapacity <= 0 {
+		return DefaultLeafCapacity
+	}
+	return q.LeafCapacity
+}
+
+// Node returns the node at coord c
+// nodes from level 0 to 8 are always present, nodes below level 8 are
+// present only if the node above has been divided (nil otherwise)
+func (q *Quadtree) Node(c Coord) *Node {
+
+	level := c.Level()
+	if level <= 8 {
+		return &(q.Nodes[c])
+	}
+
+	// go down from the node at level 8
+	x, y := c.fineX(), c.fineY()
+	node := &(q.Nodes[GetCoord(8, x>>4, y>>4)])
+	for l := 9; l <= level; l++ {
+		if node.below == nil {
+			return nil
+		}
+		shift := uint(MaxDepth - l)
+		node = &(node.below[(x>>shift)&1+2*((y>>shift)&1)])
+	}
+	return node
+}
+
 // get nodes coords below
 func NodesBelow(c Coord) (coordNW, coordNE, coordSW, coordSE Coord) {
 
 	levelBelow := c.Level() + 1
 	i := c.X()
 	j := c.Y()
+
+	if levelBelow > 8 {
+		// the coordinates of the nodes are continued on byte 0
+		i := c.fineX() >> uint(MaxDepth-c.Level())
+		j := c.fineY() >> uint(MaxDepth-c.Level())
+
+		coordNW = GetCoord(levelBelow, 2*i, 2*j)
+		coordNE = GetCoord(levelBelow, 2*i+1, 2*j)
+		coordSW = GetCoord(levelBelow, 2*i, 2*j+1)
+		coordSE = GetCoord(levelBelow, 2*i+1, 2*j+1)
+
+		return coordNW, coordNE, coordSW, coordSE
+	}
 	shift := uint(8 - levelBelow)
 
 	// to go east at the level below, we flip to 1 the bit that is significant at that level
@@ -163,63 +284,151 @@ func (q *Quadtree) setupNodesLinks() {
 }
 
 // fill quadtree at level 8 with bodies
+//
+// bodies are chained in the order opposite to their index. If MaxLevel is above 8,
+// crowded nodes are then divided
+//
+// The body slice is cut into chunks that are chained concurrently, each chunk with its own lists.
+// Then, the nodes are shared among the routines and the lists of the chunks are joined, from the
+// last chunk to the first one: the order of the bodies is the same whatever the nb of routines.
 func (q *Quadtree) updateNodesList() {
 
 	Trace.Println("updateNodesList")
 
-	for idx := range *q.bodies {
+	bodies := *q.bodies
+	nbRoutines := q.nbRoutines()
 
-		b := &((*q.bodies)[idx])
+	// each chunk needs its lists (1.5 MB), there are not more than maxChunks chunks and small slices
+	// are not worth more than one chunk
+	nbChunks := nbRoutines
+	if nbChunks > maxChunks {
+		nbChunks = maxChunks
+	}
+	if nbChunks > 1+len(bodies)/(1<<14) {
+		nbChunks = 1 + len(bodies)/(1<<14)
+	}
+	for len(q.chunks) < nbChunks {
+		q.chunks = append(q.chunks, new(chunkLists))
+	}
 
-		// 1st phase, remove the body from its current double linked list
-		// link the next body to the previous one
-		if b.next != nil {
-			b.next.prev = b.prev
-		}
-		// link the previous body to the next one
-		if b.prev != nil {
-			b.prev.next = b.next
-		} else {
-			// if body prev is nil,
-			// it can be either the current first of a node or it has not been initialized
-			// if it is the current first of the node,
-			// the first of the node shall point to the next of the body
-			if (q.Nodes[b.coord]).first == b {
-				(q.Nodes[b.coord]).first = b.next
+	parallel(nbChunks, nbChunks, func(first, last int) {
+		for chunk := first; chunk < last; chunk++ {
+			lists := q.chunks[chunk]
+			for idx := chunk * len(bodies) / nbChunks; idx < (chunk+1)*len(bodies)/nbChunks; idx++ {
+
+				b := &(bodies[idx])
+				coord := b.getCoord8()
+				b.coord = coord
+
+				// put body as the first body of the list
+				k := int(coord & 0xFFFF)
+				b.prev = nil
+				b.next = lists.heads[k]
+				if lists.heads[k] != nil {
+					lists.heads[k].prev = b
+				} else {
+					lists.tails[k] = b
+				}
+				lists.heads[k] = b
+				lists.counts[k]++
 			}
 		}
+	})
+
+	parallel(nbRoutines, 1<<16, func(first, last int) {
+		for k := first; k < last; k++ {
+			node := &(q.Nodes[GetCoord(8, k>>8, k&0xFF)])
+			node.first = nil
+			node.below = nil
+			node.nbBodies = 0
+
+			// join the lists of the chunks (and reset them for the next update)
+			var tail *Body
+			for chunk := nbChunks - 1; chunk >= 0; chunk-- {
+				lists := q.chunks[chunk]
+				head := lists.heads[k]
+				if head == nil {
+					continue
+				}
+				if tail == nil {
+					node.first = head
+				} else {
+					tail.next = head
+					head.prev = tail
+				}
+				tail = lists.tails[k]
+				node.nbBodies += lists.counts[k]
+				lists.heads[k], lists.tails[k], lists.counts[k] = nil, nil, 0
+			}
 
-		// 2nd Phase
-		// put body as the first body of the node
-		// shift the first body if it is already there
-		// compute coord of body (this is direct access)
-		coord := b.getCoord8()
-		node := &(q.Nodes[coord])
-		initialFirstBody := node.first
-		if (initialFirstBody != nil) && (initialFirstBody != b) {
-			// double link body to the current node's first
-			b.next = initialFirstBody
-			initialFirstBody.prev = b
+			if q.maxLevel() > 8 {
+				q.divide(node)
+			}
 		}
+	})
+}
 
-		// body b is the new node's first
-		node.first = b
-		b.prev = nil
+// put body b as the first body of the node
+func (n *Node) push(b *Body) {
 
-		// setup new coord
-		b.coord = coord
+	b.prev = nil
+	b.next = n.first
+	if n.first != nil {
+		// double link body to the current node's first
+		n.first.prev = b
+	}
+	n.first = b
+	n.nbBodies++
+}
 
-		if b.next == b {
-			s := fmt.Sprintf("updateNodesList: Node linked to itself coord : idx %d, %s", idx, b.coord.String())
-			panic(s)
-		}
+// divide the leaf node n if it holds more than LeafCapacity bodies
+// (and if it is above MaxLevel)
+//
+// bodies of n are distributed among the four nodes below, which are then divided if necessary
+func (q *Quadtree) divide(n *Node) {
+
+	level := n.coord.Level()
+	if level >= q.maxLevel() || n.nbBodies <= q.leafCapacity() {
+		return
+	}
+
+	var below [4]Node
+	coordNW, coordNE, coordSW, coordSE := NodesBelow(n.coord)
+	below[0].coord = coordNW
+	below[1].coord = coordNE
+	below[2].coord = coordSW
+	below[3].coord = coordSE
+
+	// distribute the bodies
+	nbNodes := float64(int(1) << uint(level+1))
+	for b := n.first; b != nil; {
+		next := b.next
+		rank := int(b.X*nbNodes)&1 + 2*(int(b.Y*nbNodes)&1)
+		below[rank].push(b)
+		b.coord = below[rank].coord
+		b = next
+	}
+
+	// bodies of the nodes below are chained
+	n.below = &below
+	n.first = &(below[0].Body)
+	below[0].Body.next = &(below[1].Body)
+	below[1].Body.next = &(below[2].Body)
+	below[2].Body.next = &(below[3].Body)
+
+	for rank := range below {
+		q.divide(&below[rank])
 	}
 }
 
 // compute COM of quadtree from level 8 to level 0
+//
+// nodes of a level are computed concurrently, a level is computed when the level below is done
 func (q *Quadtree) updateNodesCOM() {
 
 	Trace.Println("updateNodesCOM")
+	nbRoutines := q.nbRoutines()
+
 	// compute is bottom up
 	for level := 8; level >= 0; level-- {
 
@@ -227,26 +436,75 @@ func (q *Quadtree) updateNodesCOM() {
 		nbNodesX := 1 << uint(level)
 		nbNodesY := 1 << uint(level)
 
-		// parse nodes of level
-		for i := 0; i < nbNodesX; i++ {
-			for j := 0; j < nbNodesY; j++ {
+		// below 1024 nodes, the routines are not worth their cost
+		nbRoutinesOfLevel := nbRoutines
+		if level < 5 {
+			nbRoutinesOfLevel = 1
+		}
 
-				coord := GetCoord(level, i, j)
-				node := &(q.Nodes[coord])
+		// parse nodes of level, routines share the columns
+		parallel(nbRoutinesOfLevel, nbNodesX, func(first, last int) {
+			for i := first; i < last; i++ {
+				for j := 0; j < nbNodesY; j++ {
 
-				// fmt.Println("updateNodesCOM ", q.Nodes[coord].coord.String())
-				// s := fmt.Sprintf("updateNodesCOM level %8d i %8d j %8d coord %s",
-				// level, i, j, node.coord.String())
-				// fmt.Println(s)
-				node.updateCOM()
+					coord := GetCoord(level, i, j)
+					node := &(q.Nodes[coord])
+
+					if node.below != nil {
+						node.updateCOMBelow()
+					}
+					node.updateCOM()
+				}
 			}
+		})
+	}
+}
+
+// compute COM of the nodes below a node at level 8 or deeper (bottom up)
+func (n *Node) updateCOMBelow() {
+	for rank := range n.below {
+		node := &(n.below[rank])
+		if node.below != nil {
+			node.updateCOMBelow()
 		}
+		node.updateCOM()
+	}
+}
+
+// ErrorReporter receives the errors found by CheckIntegrity (for instance a *testing.T)
+type ErrorReporter interface {
+	Errorf(format string, args ...interface{})
+}
+
+// collects the errors of CheckIntegrity
+type integrityErrors []string
+
+func (e *integrityErrors) Errorf(format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+// Check checks the integrity of the quadtree (see CheckIntegrity)
+//
+// return nil if the quadtree is consistent, an error with the problems otherwise
+func (q *Quadtree) Check() error {
+
+	var errs integrityErrors
+	q.CheckIntegrity(&errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) > 10 {
+		errs = append(errs[:10], fmt.Sprintf("and %d other errors", len(errs)-10))
 	}
+	return fmt.Errorf("quadtree integrity: %s", strings.Join(errs, ", "))
 }
 
 // check integrity of the quadtree by performing
 // all kinds of test
-func (q *Quadtree) CheckIntegrity(t *testing.T) {
+//
+// the positions of the bodies are checked against the nodes, therefore the check is meaningful only
+// right after Init or UpdateNodesListsAndCOM, before the bodies are moved
+func (q *Quadtree) CheckIntegrity(t ErrorReporter) {
 
 	Trace.Printf("CheckIntegrity")
 	nbBodies := 0
@@ -263,36 +521,15 @@ func (q *Quadtree) CheckIntegrity(t *testing.T) {
 			for j := 0; j < nbNodesY; j++ {
 
 				coord := GetCoord(level, i, j)
-				node := &(q.Nodes[coord])
 
 				// test that the node coord is correct
 				if q.Nodes[coord].coord != coord {
 					s := fmt.Sprintf("node coord = %s, want %s",
 						q.Nodes[coord].coord.String(), coord.String())
-					t.Errorf(s)
+					t.Errorf("%s", s)
 				}
 
-				// test that the node first body
-				// has a nil previous body
-				if node.first != nil && node.first.prev != nil {
-					s := fmt.Sprintf("node coord = %s, has first body with non nil prev",
-						q.Nodes[coord].coord.String())
-					t.Errorf(s)
-				}
-
-				// test for each body of the chain of bodies
-				// - that the next body previous body is the body
-				rank := 0
-				for b := node.first; b != nil; b = b.next {
-
-					if b.next != nil && b.next.prev != b {
-						s := fmt.Sprintf("node coord = %s, has %d nth body with next body not point to him for prev",
-							q.Nodes[coord].coord.String(), rank)
-						t.Errorf(s)
-					}
-					nbBodies++
-					rank++
-				}
+				nbBodies += q.checkLeavesIntegrity(t, &(q.Nodes[coord]))
 			}
 		}
 	}
@@ -303,6 +540,51 @@ func (q *Quadtree) CheckIntegrity(t *testing.T) {
 	}
 }
 
+// check integrity of the leaves at node or below node
+// return the number of bodies in the leaves
+func (q *Quadtree) checkLeavesIntegrity(t ErrorReporter, node *Node) (nbBodies int) {
+
+	if !node.IsLeaf() {
+		for rank := range node.below {
+			below := &(node.below[rank])
+			if q.Node(below.coord) != below {
+				t.Errorf("node coord = %s, is not found at its coord", below.coord.String())
+			}
+			nbBodies += q.checkLeavesIntegrity(t, below)
+		}
+		return nbBodies
+	}
+
+	// test that the node first body
+	// has a nil previous body
+	if node.first != nil && node.first.prev != nil {
+		s := fmt.Sprintf("node coord = %s, has first body with non nil prev",
+			node.coord.String())
+		t.Errorf("%s", s)
+	}
+
+	// test for each body of the chain of bodies
+	// - that the next body previous body is the body
+	// - that the body coord is the node coord
+	rank := 0
+	for b := node.first; b != nil; b = b.next {
+
+		if b.next != nil && b.next.prev != b {
+			s := fmt.Sprintf("node coord = %s, has %d nth body with next body not point to him for prev",
+				node.coord.String(), rank)
+			t.Errorf("%s", s)
+		}
+		if b.coord != node.coord || b.getCoord(node.coord.Level()) != node.coord {
+			s := fmt.Sprintf("node coord = %s, has %d nth body with coord %s",
+				node.coord.String(), rank, b.coord.String())
+			t.Errorf("%s", s)
+		}
+		nbBodies++
+		rank++
+	}
+	return nbBodies
+}
+
 // compute number of bodies per node
 // update the counting of bodies per node for all levels
 func (q *Quadtree) ComputeNbBodiesPerNode() {
@@ -372,15 +654,20 @@ func (q *Quadtree) ComputeQuadtreeGini() {
 }
 
 // consolidate the number of bodies attached to the node
-// at level 8, this is the number of bodies
-// above level 8, this is an aggregate of the number of bodies at the level below
+// for a leaf, this is the number of bodies
+// otherwise, this is an aggregate of the number of bodies at the level below
 func (q *Quadtree) updateBodiesNb(n *Node) {
 	n.nbBodies = 0
 
-	if n.coord.Level() == 8 {
+	if n.IsLeaf() {
 		for b := n.first; b != nil; b = b.next {
 			n.nbBodies++
 		}
+	} else if n.below != nil {
+		for rank := range n.below {
+			q.updateBodiesNb(&(n.below[rank]))
+			n.nbBodies += n.below[rank].nbBodies
+		}
 	} else {
 		coordNW, coordNE, coordSW, coordSE := NodesBelow(n.coord)
 
@@ -1,6 +1,8 @@
 package quadtree
 
 import (
+	"fmt"
+	"runtime"
 	"testing"
 )
 
@@ -52,6 +54,20 @@ func BenchmarkUpdateNodesList_10M(b *testing.B) {
 	}
 }
 
+func BenchmarkUpdateNodesListAdaptive_10M(b *testing.B) {
+	var q Quadtree
+	var bodies []Body
+
+	q.MaxLevel = MaxDepth
+	InitBodiesUniform(&bodies, 10000000)
+	q.Init(&bodies)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		q.updateNodesList()
+	}
+}
+
 func BenchmarkUpdateNodesCOM_10M(b *testing.B) {
 	var q Quadtree
 	var bodies []Body
@@ -74,3 +90,27 @@ func BenchmarkInitQuadtree(b *testing.B) {
 		InitBodiesUniform(&bodies, 1000000)
 	}
 }
+
+// update of the quadtree of 1M bodies with one routine and with one routine per CPU
+func BenchmarkUpdateNodesListsAndCOM_1M(b *testing.B) {
+
+	nbRoutinesList := []int{1}
+	if runtime.NumCPU() > 1 {
+		nbRoutinesList = append(nbRoutinesList, runtime.NumCPU())
+	}
+	for _, nbRoutines := range nbRoutinesList {
+		b.Run(fmt.Sprintf("routines=%d", nbRoutines), func(b *testing.B) {
+			var q Quadtree
+			var bodies []Body
+
+			q.NbRoutines = nbRoutines
+			InitBodiesUniform(&bodies, 1000000)
+			q.Init(&bodies)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				q.UpdateNodesListsAndCOM()
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package quadtree
 
 import (
 	"fmt"
+	"math"
+	"math/rand"
 	"testing"
 )
 
@@ -39,13 +41,17 @@ func TestCoordIntegrity(t *testing.T) {
 	}{
 		{0x00, true},
 		{0x000000FF, false}, // at level 0, no bits are allowed for x or y
-		{0x000a0001, false}, // level a is above 8
+		{0x000d0001, false}, // level d is above MaxDepth
 		{0x00070010, true},  // level 7 is OK
 		{0x00070001, false}, // the last bit shall be 0
 		{0x00070101, false}, // the last bit of x shall be 0
 		{0x00080001, true},  // the last bit can be 1
-		{0x000A0000, false},
-		{0x0A0A0000, false}, // byte 0 shall be null
+		{0x000D0000, false},
+		{0x0A0A0000, false}, // bits of byte 0 below the resolution of level a shall be null
+		{0x01080000, false}, // byte 0 shall be null at level 8
+		{0x400a0000, true},  // at level a, the first 2 bits of the x extension can be 1
+		{0x100a0000, false}, // at level a, the last 2 bits of the x extension shall be 0
+		{0x0F0C0000, true},  // at level c, all bits of the y extension can be 1
 	}
 	for rank, c := range cases {
 		got := c.in.checkIntegrity()
@@ -159,6 +165,27 @@ func TestNodesBelow(t *testing.T) {
 	// fmt.Printf("\nTestNodesBelow\n\nin %s\nnw %s\nne %s\nsw %s\nse %s", &coordNW, &n_coordNW, &coordNE, &coordSW, &coordSE)
 }
 
+// check that the ranks along the Z-order curve of the nodes at level 8 are a permutation
+// and that the 4 nodes below a node have contiguous ranks
+func TestMorton(t *testing.T) {
+
+	seen := make([]bool, 1<<16)
+	for i := 0; i < 256; i++ {
+		for j := 0; j < 256; j++ {
+			rank := GetCoord(8, i, j).Morton()
+			if seen[rank] {
+				t.Fatalf("rank %d of node %d %d already seen", rank, i, j)
+			}
+			seen[rank] = true
+		}
+	}
+
+	coordNW, coordNE, coordSW, coordSE := NodesBelow(GetCoord(7, 42, 17))
+	if coordNW.Morton()+1 != coordSW.Morton() || coordNW.Morton()+2 != coordNE.Morton() || coordNW.Morton()+3 != coordSE.Morton() {
+		t.Errorf("got ranks %d %d %d %d", coordNW.Morton(), coordSW.Morton(), coordNE.Morton(), coordSE.Morton())
+	}
+}
+
 func TestUpdateNodesCOM(t *testing.T) {
 
 	var q Quadtree
@@ -206,8 +233,8 @@ func TestGetCoord8(t *testing.T) {
 		in   Body
 		want Coord
 	}{
-		{Body{BodyXY{0.0, 0.0}, 0.0, 0x0, nil, nil}, 0x00080000},
-		{Body{BodyXY{0.0, 255.999}, 255.999, 0x0, nil, nil}, 0x0008FFFF},
+		{Body{BodyXY{0.0, 0.0}, 0.0, 0, 0x0, nil, nil}, 0x00080000},
+		{Body{BodyXY{0.0, 255.999}, 255.999, 0, 0x0, nil, nil}, 0x0008FFFF},
 	}
 	for _, c := range cases {
 		got := c.in.getCoord8()
@@ -219,6 +246,114 @@ func TestGetCoord8(t *testing.T) {
 	}
 }
 
+// check that bodies without ID are numbered by their rank from 1
+// and that they are left unchanged as soon as one body has an ID
+func TestSetMissingIDs(t *testing.T) {
+
+	bodies := make([]Body, 3)
+	if !SetMissingIDs(bodies) || bodies[0].ID != 1 || bodies[2].ID != 3 {
+		t.Errorf("got ids %d %d %d", bodies[0].ID, bodies[1].ID, bodies[2].ID)
+	}
+	bodies[0].ID = 7
+	if SetMissingIDs(bodies) || bodies[0].ID != 7 {
+		t.Errorf("existing ids should be kept, got %d", bodies[0].ID)
+	}
+}
+
+// check computation of nodes below, deeper than level 8
+func TestNodesBelowDeep(t *testing.T) {
+
+	cases := []struct {
+		in                     Coord
+		nw, ne, sw, se, wantIn Coord
+	}{
+		{GetCoord(7, 3, 5), GetCoord(8, 6, 10), GetCoord(8, 7, 10), GetCoord(8, 6, 11), GetCoord(8, 7, 11), 0},
+		{GetCoord(8, 255, 3), GetCoord(9, 510, 6), GetCoord(9, 511, 6), GetCoord(9, 510, 7), GetCoord(9, 511, 7), 0},
+		{GetCoord(11, 2047, 0), GetCoord(12, 4094, 0), GetCoord(12, 4095, 0), GetCoord(12, 4094, 1), GetCoord(12, 4095, 1), 0},
+	}
+	for _, c := range cases {
+		nw, ne, sw, se := NodesBelow(c.in)
+		if nw != c.nw || ne != c.ne || sw != c.sw || se != c.se {
+			t.Errorf("NodesBelow(%s) == %s %s %s %s, want %s %s %s %s", c.in.String(),
+				nw.String(), ne.String(), sw.String(), se.String(),
+				c.nw.String(), c.ne.String(), c.sw.String(), c.se.String())
+		}
+		for _, below := range []Coord{nw, ne, sw, se} {
+			if !below.checkIntegrity() {
+				t.Errorf("invalid coord below %s", below.String())
+			}
+		}
+	}
+}
+
+// test that crowded nodes are divided
+func TestAdaptiveDepth(t *testing.T) {
+
+	var q Quadtree
+	q.MaxLevel = 12
+	q.LeafCapacity = 10
+
+	// half of the bodies are crowded in a small area
+	var bodies []Body
+	InitBodiesUniform(&bodies, 100000)
+	for idx := 0; idx < len(bodies); idx += 2 {
+		bodies[idx].X = 0.5 + bodies[idx].X*0.001
+		bodies[idx].Y = 0.3 + bodies[idx].Y*0.001
+	}
+	q.Init(&bodies)
+	q.CheckIntegrity(t)
+
+	// the crowded area is in a node of level 12 or in a leaf that is not full
+	crowded := Body{BodyXY: BodyXY{0.5005, 0.3005}}
+	leaf := q.Node(crowded.getCoord(12))
+	if leaf == nil {
+		t.Fatalf("crowded area is not divided down to level 12")
+	}
+	if !leaf.IsLeaf() {
+		t.Errorf("node at level 12 is not a leaf")
+	}
+
+	// leaves above MaxLevel are not full
+	q.ComputeNbBodiesPerNode()
+	var checkLeaves func(n *Node)
+	checkLeaves = func(n *Node) {
+		if n.IsLeaf() {
+			if n.coord.Level() < 12 && n.nbBodies > q.LeafCapacity {
+				t.Errorf("leaf %s at level %d has %d bodies", n.coord.String(), n.coord.Level(), n.nbBodies)
+			}
+			return
+		}
+		for rank := range n.below {
+			checkLeaves(&n.below[rank])
+		}
+	}
+	for i := 0; i < 256; i++ {
+		for j := 0; j < 256; j++ {
+			checkLeaves(&q.Nodes[GetCoord(8, i, j)])
+		}
+	}
+	if q.Nodes[0].nbBodies != len(bodies) {
+		t.Errorf("root node has %d bodies, want %d", q.Nodes[0].nbBodies, len(bodies))
+	}
+
+	// the COM of the root is the COM of the bodies
+	var m, x float64
+	for _, b := range bodies {
+		m += b.M
+		x += b.X * b.M
+	}
+	if math.Abs(q.Nodes[0].M-m) > 1e-6*m || math.Abs(q.Nodes[0].X-x/m) > 1e-9 {
+		t.Errorf("root COM got M %f X %f, want M %f X %f", q.Nodes[0].M, q.Nodes[0].X, m, x/m)
+	}
+
+	// bodies move out of the crowded area
+	for idx := range bodies {
+		bodies[idx].X = float64(idx%1000) / 1000.0
+	}
+	q.UpdateNodesListsAndCOM()
+	q.CheckIntegrity(t)
+}
+
 func TestSetupNodesLinks(t *testing.T) {
 	var q Quadtree
 
@@ -241,3 +376,85 @@ func TestSetupNodesLinks(t *testing.T) {
 		t.Errorf("first body of node has no next")
 	}
 }
+
+// test that the update gives the same lists and COM whatever the nb of routines
+func TestUpdateConcurrent(t *testing.T) {
+
+	var bodies []Body
+	InitBodiesUniformWithRand(&bodies, 200000, rand.New(rand.NewSource(1)))
+	for idx := 0; idx < len(bodies); idx += 2 {
+		bodies[idx].X = 0.5 + bodies[idx].X*0.001
+		bodies[idx].Y = 0.3 + bodies[idx].Y*0.001
+	}
+	other := append([]Body(nil), bodies...)
+
+	var q, qOther Quadtree
+	q.MaxLevel, qOther.MaxLevel = 12, 12
+	q.NbRoutines, qOther.NbRoutines = 1, 7
+	q.Init(&bodies)
+	qOther.Init(&other)
+	qOther.CheckIntegrity(t)
+
+	var compare func(n, nOther *Node)
+	compare = func(n, nOther *Node) {
+		if n.Body.BodyXY != nOther.Body.BodyXY || n.M != nOther.M || n.nbBodies != nOther.nbBodies {
+			t.Fatalf("node %s, got COM %v and %v", n.coord.String(), n.Body.BodyXY, nOther.Body.BodyXY)
+		}
+		if (n.below == nil) != (nOther.below == nil) {
+			t.Fatalf("node %s is divided in one quadtree only", n.coord.String())
+		}
+		if n.below != nil {
+			for rank := range n.below {
+				compare(&n.below[rank], &nOther.below[rank])
+			}
+			return
+		}
+		b, bOther := n.first, nOther.first
+		for ; b != nil && bOther != nil; b, bOther = b.next, bOther.next {
+			if b.BodyXY != bOther.BodyXY {
+				t.Fatalf("node %s, got body %v and %v", n.coord.String(), b.BodyXY, bOther.BodyXY)
+			}
+		}
+		if b != nil || bOther != nil {
+			t.Fatalf("node %s, lists have different lengths", n.coord.String())
+		}
+	}
+	for level := 0; level <= 8; level++ {
+		for i := 0; i < 1<<uint(level); i++ {
+			for j := 0; j < 1<<uint(level); j++ {
+				coord := GetCoord(level, i, j)
+				if level < 8 {
+					if q.Nodes[coord].Body.BodyXY != qOther.Nodes[coord].Body.BodyXY {
+						t.Fatalf("node %s, got COM %v and %v", coord.String(), q.Nodes[coord].Body.BodyXY, qOther.Nodes[coord].Body.BodyXY)
+					}
+					continue
+				}
+				compare(&q.Nodes[coord], &qOther.Nodes[coord])
+			}
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+
+	var q Quadtree
+	var bodies []Body
+	InitBodiesUniformWithRand(&bodies, 1000, rand.New(rand.NewSource(1)))
+	q.Init(&bodies)
+	if err := q.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a body that is not in the node of its coord
+	bodies[10].coord ^= 1
+	if err := q.Check(); err == nil {
+		t.Errorf("corrupted quadtree is not detected")
+	}
+
+	Debug = true
+	defer func() { Debug = false }()
+	q.UpdateNodesListsAndCOM()
+	if err := q.Check(); err != nil {
+		t.Errorf("quadtree is corrupted after an update: %s", err.Error())
+	}
+}
@@ -4,8 +4,10 @@ Package main contains code for running the 10000 web server as a standalone serv
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/thomaspeugeot/tkv/handler"
 	"github.com/thomaspeugeot/tkv/server"
@@ -15,6 +17,25 @@ import (
 // go run runtime_server.go -targetCountryStep=43439
 func main() {
 
+	// regions or other countries than the ones of the translation package
+	territoriesPtr := flag.String("territories", "", "comma separated list of additional countries or regions <key>:<nb bodies>:<step>, for instance idf:100000:2000")
+
+	flag.Parse()
+
+	for _, territory := range strings.Split(*territoriesPtr, ",") {
+		if territory == "" {
+			continue
+		}
+		spec, err := translation.ParseCountrySpec(territory)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := translation.AddCountrySpec(spec); err != nil {
+			log.Fatal(err)
+		}
+		server.Info.Printf("territory %s with %d bodies at step %d", spec.Name, spec.NbBodies, spec.Step)
+	}
+
 	t := translation.GetTranslateCurrent()
 	server.Info.Printf("ended init of country %s", t.GetSourceCountryName())
 
@@ -4,6 +4,7 @@ Contains the main package for the simulation server. Also contains all handlers
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -12,6 +13,8 @@ import (
 	"net/http"
 
 	"github.com/thomaspeugeot/tkv/barnes-hut"
+	"github.com/thomaspeugeot/tkv/grump"
+	"github.com/thomaspeugeot/tkv/quadtree"
 	"github.com/thomaspeugeot/tkv/server"
 	"github.com/thomaspeugeot/tkv/translation"
 )
@@ -26,13 +29,38 @@ var r *barneshut.Run
 func main() {
 
 	// flags  for source country
-	sourceCountryPtr := flag.String("sourceCountry", "fra", "iso 3166 sourceCountry code")
+	sourceCountryPtr := flag.String("sourceCountry", "fra", "iso 3166 sourceCountry code, or the key of a region generated by grump-reader -region")
 	sourceCountryNbBodiesPtr := flag.String("sourceCountryNbBodies", "934136", "nb of bodies")
 	sourceCountryStepPtr := flag.String("sourceCountryStep", "0", "simulation step for the spread bodies for source country")
 
 	cutoffPtr := flag.String("cutoff", "2", "cutoff code distance")
 
+	kernelPtr := flag.String("kernel", "inverseSquare", fmt.Sprintf("repulsion kernel, one of %v", barneshut.KernelNames))
+	kernelParamPtr := flag.Float64("kernelParam", 0.0, "parameter of the kernel (screening length of yukawa, softening length of plummer, radius of smoothCutoff)")
+
+	integratorPtr := flag.String("integrator", "euler", fmt.Sprintf("integrator that moves the bodies, one of %v", barneshut.IntegratorNames))
+	minimizePtr := flag.Bool("minimize", false, "if true, the energy is minimised with lbfgs (only the final configuration is meaningful), this overrides -integrator")
+
 	shutdownCriteriaPtr := flag.String("shutdownCriteria", "0.00001", "If energy decreases ratio is below this threshold during a simulation step, simulation shutdowns")
+	shutdownStepsPtr := flag.Int("shutdownSteps", 0, "nb of steps over which the energy decrease ratio is averaged for the shutdown criteria (0 is the default of the integrator, 10 for lbfgs and 1 for the others)")
+
+	boundaryPtr := flag.String("boundary", barneshut.MIRROR, fmt.Sprintf("boundary mode, one of %v", barneshut.BoundaryModes))
+
+	dualTreePtr := flag.Bool("dualTree", false, "if true, the repulsion is computed with the dual tree algorithm instead of Barnes-Hut")
+
+	maskPtr := flag.Bool("mask", false, "if true, bodies are kept within the mask of the country stored in conf-xxx.coord")
+
+	quadtreeMaxLevelPtr := flag.Int("quadtreeMaxLevel", 8, fmt.Sprintf("max depth of the quadtree (from 8 to %d), crowded nodes are divided down to this level", quadtree.MaxDepth))
+	leafCapacityPtr := flag.Int("leafCapacity", quadtree.DefaultLeafCapacity, "number of bodies above which a node of the quadtree is divided (if quadtreeMaxLevel is above 8)")
+
+	reorderStepPtr := flag.Int("reorderStep", 0, "steps between reorderings of the bodies along the Z-order curve of the quadtree, for cache locality (0 means never)")
+
+	debugPtr := flag.Bool("debug", false, "if true, the integrity of the quadtree is checked at each step (slow)")
+
+	maxStepsPtr := flag.Int("maxSteps", 0, "if above 0, simulation stops after this number of steps")
+	maxDurationPtr := flag.Duration("maxDuration", 0, "if above 0, simulation stops after this duration (for instance 2h30m)")
+	densitySpreadPtr := flag.Float64("densitySpread", 0, "if above 0, simulation stops when the spread between the highest and the lowest density tenciles is below this value (in percentage of the average density)")
+	minStirringPtr := flag.Float64("minStirring", 0, "if above 0, simulation stops when the ratio of original neighbours that are still neighbours is below this value")
 
 	portPtr := flag.String("port", "8000", "listening port")
 
@@ -40,8 +68,15 @@ func main() {
 
 	captureGifStep := flag.Int("stepsBetweenGifs", 40, "steps between gif")
 
+	checkpointStep := flag.Int("stepsBetweenCheckpoints", 0, "steps between automatic checkpoints of the full simulation state (0 means no checkpoint)")
+
+	resumePtr := flag.String("resume", "", "checkpoint file (conf-xxx-xxxxxxxx-xxxxx.chkp) to resume the simulation from, the integrator, kernel, boundary, stop criteria, quadtree and reordering parameters of the checkpoint replace the ones of the flags, -dualTree and -mask have to be the ones of the checkpoint")
+
 	flag.Parse()
 
+	// parameters of the run
+	config := barneshut.NewRunConfig()
+
 	// init sourceCountry from flags
 	var sourceCountry translation.CountryWithBodies
 	sourceCountry.Name = *sourceCountryPtr
@@ -60,22 +95,108 @@ func main() {
 		}
 	}
 	{
-		_, errScan := fmt.Sscanf(*cutoffPtr, "%f", &barneshut.CutoffDistance)
+		_, errScan := fmt.Sscanf(*cutoffPtr, "%f", &config.CutoffDistance)
 		if errScan != nil {
 			log.Fatal(errScan)
 			return
 		}
 	}
-	server.Info.Printf("CutoffDistance %f", barneshut.CutoffDistance)
+	server.Info.Printf("CutoffDistance %f", config.CutoffDistance)
+
+	{
+		kernel, err := barneshut.KernelFromName(*kernelPtr, *kernelParamPtr)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		config.Kernel = kernel
+	}
+	server.Info.Printf("Kernel %s", config.Kernel.Name())
 
 	{
-		_, errScan := fmt.Sscanf(*shutdownCriteriaPtr, "%f", &barneshut.ShutdownCriteria)
+		integrator, err := barneshut.IntegratorFromName(*integratorPtr)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		config.Integrator = integrator
+	}
+	if *minimizePtr {
+		config.Integrator = barneshut.LBFGS{}
+	}
+	server.Info.Printf("Integrator %s", config.Integrator.Name())
+
+	// the steps of lbfgs are irregular, the decrease ratio is averaged over more steps
+	shutdownSteps := *shutdownStepsPtr
+	if shutdownSteps < 0 {
+		log.Fatalf("-shutdownSteps %d is below 0", shutdownSteps)
+		return
+	}
+	if shutdownSteps == 0 {
+		shutdownSteps = 1
+		if _, ok := config.Integrator.(barneshut.LBFGS); ok {
+			shutdownSteps = 10
+		}
+	}
+	server.Info.Printf("Shutdown criteria averaged over %d steps", shutdownSteps)
+
+	config.BoundaryMode = barneshut.BoundaryModeType(*boundaryPtr)
+	{
+		valid := false
+		for _, mode := range barneshut.BoundaryModes {
+			valid = valid || mode == config.BoundaryMode
+		}
+		if !valid {
+			log.Fatalf("unknown boundary mode %s, possible modes are %v", *boundaryPtr, barneshut.BoundaryModes)
+			return
+		}
+	}
+	server.Info.Printf("Boundary mode %s", config.BoundaryMode)
+
+	if *maskPtr {
+		var country grump.Country
+		country.Name = sourceCountry.Name
+		country.Unserialize()
+		if country.Mask == nil {
+			log.Fatalf("no mask in the coord file of %s, run grump-reader with -mask", country.Name)
+			return
+		}
+		config.Domain = country.Mask
+		server.Info.Printf("Bodies are kept within the mask of %s (ratio of the square %f)", country.Name, country.Mask.Ratio(1000))
+	}
+
+	barneshut.UseDualTree = *dualTreePtr
+
+	quadtree.Debug = *debugPtr
+	config.QuadtreeMaxLevel = *quadtreeMaxLevelPtr
+	config.QuadtreeLeafCapacity = *leafCapacityPtr
+	config.ReorderStep = *reorderStepPtr
+
+	{
+		_, errScan := fmt.Sscanf(*shutdownCriteriaPtr, "%f", &config.ShutdownCriteria)
 		if errScan != nil {
 			log.Fatal(errScan)
 			return
 		}
 	}
-	server.Info.Printf("Studown Criteria %f", barneshut.ShutdownCriteria)
+	server.Info.Printf("Studown Criteria %f", config.ShutdownCriteria)
+
+	// the first criterion that fires stops the simulation
+	config.StopCriteria = barneshut.AnyOf{barneshut.EnergyDecrease{Threshold: config.ShutdownCriteria, Steps: shutdownSteps}}
+	if *maxStepsPtr > 0 {
+		config.StopCriteria = append(config.StopCriteria, barneshut.MaxSteps{Steps: *maxStepsPtr})
+	}
+	if *maxDurationPtr > 0 {
+		config.StopCriteria = append(config.StopCriteria, barneshut.WallClock{Budget: *maxDurationPtr})
+	}
+	if *densitySpreadPtr > 0 {
+		config.StopCriteria = append(config.StopCriteria, barneshut.DensitySpread{Target: *densitySpreadPtr})
+	}
+	if *minStirringPtr > 0 {
+		config.StopCriteria = append(config.StopCriteria, barneshut.Stirring{Threshold: *minStirringPtr})
+	}
+	server.Info.Printf("Stop criteria %s", config.StopCriteria.Name())
+
 	port := 8000
 	{
 		_, errScan := fmt.Sscanf(*portPtr, "%d", &port)
@@ -85,14 +206,21 @@ func main() {
 		}
 	}
 	server.Info.Printf("will listen on port %d", port)
-	r = barneshut.NewRun()
+	r = barneshut.NewRunWithConfig(config)
 
 	r.CaptureGifStep = *captureGifStep
+	r.CheckpointStep = *checkpointStep
 
-	// load configuration files.
-	filename := fmt.Sprintf(barneshut.CountryBodiesNamePattern, sourceCountry.Name, sourceCountry.NbBodies, sourceCountry.Step)
-	server.Info.Printf("filename for init %s", filename)
-	r.LoadConfig(filename)
+	if *resumePtr != "" {
+		// resume from the full simulation state
+		server.Info.Printf("resuming from checkpoint %s", *resumePtr)
+		r.LoadCheckpoint(*resumePtr)
+	} else {
+		// load configuration files.
+		filename := fmt.Sprintf(barneshut.CountryBodiesNamePattern, sourceCountry.Name, sourceCountry.NbBodies, sourceCountry.Step)
+		server.Info.Printf("filename for init %s", filename)
+		r.LoadConfig(filename)
+	}
 
 	if !*startPtr {
 		r.SetState(barneshut.STOPPED)
@@ -100,7 +228,15 @@ func main() {
 		r.SetState(barneshut.RUNNING)
 	}
 
-	go r.RunSimulation()
+	// the server stays up after the end of the simulation in order to serve the final configuration
+	go func() {
+		result, err := r.RunSimulation(context.Background())
+		if err != nil {
+			server.Error.Printf("simulation ended at step %d: %s", result.Step, err.Error())
+			return
+		}
+		server.Info.Printf("simulation completed at step %d (%s), final configuration in %s (also available at /finalConfig)", result.Step, result.StopCriterion, result.Filename)
+	}()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/status", status)
@@ -111,6 +247,8 @@ func main() {
 	mux.HandleFunc("/pause", pause)
 	mux.HandleFunc("/oneStep", oneStep)
 	mux.HandleFunc("/captureConfig", captureConfig)
+	mux.HandleFunc("/captureCheckpoint", captureCheckpoint)
+	mux.HandleFunc("/finalConfig", finalConfig)
 
 	mux.HandleFunc("/render", render)
 	mux.HandleFunc("/renderSVG", renderSVG)
@@ -126,6 +264,7 @@ func main() {
 	mux.HandleFunc("/minDistanceCoord", minDistanceCoord)
 	mux.HandleFunc("/nbVillagesPerAxe", nbVillagesPerAxe)
 	mux.HandleFunc("/nbRoutines", nbRoutines)
+	mux.HandleFunc("/workerStats", workerStats)
 	mux.HandleFunc("/fieldGridNb", fieldGridNb)
 	mux.HandleFunc("/updateRatioBorderBodies", updateRatioBorderBodies)
 	mux.HandleFunc("/toggleRenderChoice", toggleRenderChoice)
@@ -143,13 +282,16 @@ func status(w http.ResponseWriter, req *http.Request) {
 
 	fmt.Fprintf(w, "%s Dt Adjust %s\n%s",
 		r.State(),
-		barneshut.DtAdjustMode,
+		r.Config().DtAdjustMode,
 		r.Status())
 }
 
 func play(w http.ResponseWriter, req *http.Request) {
 
-	r.SetState(barneshut.RUNNING)
+	// a completed simulation cannot be restarted
+	if r.State() != barneshut.COMPLETED {
+		r.SetState(barneshut.RUNNING)
+	}
 	fmt.Fprintf(w, "Run status %s\n", r.State())
 }
 
@@ -159,13 +301,19 @@ func toggleManualAuto(w http.ResponseWriter, req *http.Request)     { r.ToggleMa
 
 func pause(w http.ResponseWriter, req *http.Request) {
 
-	r.SetState(barneshut.STOPPED)
+	if r.State() != barneshut.COMPLETED {
+		r.SetState(barneshut.STOPPED)
+	}
 	fmt.Fprintf(w, "Run status %s\n", r.State())
 }
 
 func oneStep(w http.ResponseWriter, req *http.Request) {
 	if r.State() == barneshut.STOPPED {
-		r.OneStep()
+		if err := r.OneStep(); err != nil {
+			server.Error.Printf("oneStep %s", err.Error())
+			http.Error(w, fmt.Sprintf("Run status %s, step failed: %s", r.State(), err.Error()), http.StatusInternalServerError)
+			return
+		}
 	}
 	fmt.Fprintf(w, "Run status %s\n", r.State())
 }
@@ -174,6 +322,31 @@ func captureConfig(w http.ResponseWriter, req *http.Request) {
 	r.CaptureConfig()
 }
 
+// checkpoint the full simulation state (works only if the simulation is paused)
+func captureCheckpoint(w http.ResponseWriter, req *http.Request) {
+	if r.State() == barneshut.STOPPED {
+		r.CaptureCheckpoint()
+	}
+}
+
+// send the final configuration (works only once the simulation is completed)
+func finalConfig(w http.ResponseWriter, req *http.Request) {
+	select {
+	case <-r.Done():
+	default:
+		http.Error(w, "simulation is not completed", http.StatusNotFound)
+		return
+	}
+	if _, err := r.Result(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := r.WriteConfig(w); err != nil {
+		server.Error.Printf("finalConfig %s", err.Error())
+	}
+}
+
 func render(w http.ResponseWriter, req *http.Request)    { r.RenderGif(w, true) }
 func renderSVG(w http.ResponseWriter, req *http.Request) { r.RenderSVG(w) }
 
@@ -213,7 +386,7 @@ func dt(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		log.Println("error decoding ", err)
 	} else {
-		barneshut.DtRequest = dtRequest
+		r.SetDtRequest(dtRequest)
 	}
 }
 
@@ -225,7 +398,7 @@ func theta(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		log.Println("error decoding ", err)
 	} else {
-		barneshut.BN_THETA_Request = thetaRequest
+		r.SetThetaRequest(thetaRequest)
 	}
 }
 
@@ -237,7 +410,7 @@ func nbVillagesPerAxe(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		log.Println("error decoding ", err)
 	} else {
-		barneshut.SetNbVillagePerAxe(nbVillagesPerAxe)
+		r.SetNbVillagePerAxe(nbVillagesPerAxe)
 	}
 }
 
@@ -249,10 +422,17 @@ func nbRoutines(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		log.Println("error decoding ", err)
 	} else {
-		barneshut.SetNbRoutines(nbRoutines)
+		r.SetNbRoutines(nbRoutines)
 	}
 }
 
+// load of the workers of the force computation at the last step (the busy durations are in nanoseconds)
+func workerStats(w http.ResponseWriter, req *http.Request) {
+
+	stats, _ := json.MarshalIndent(r.WorkerStats(), "", "	")
+	fmt.Fprintf(w, "%s", stats)
+}
+
 func fieldGridNb(w http.ResponseWriter, req *http.Request) {
 
 	decoder := json.NewDecoder(req.Body)
@@ -273,7 +453,7 @@ func updateRatioBorderBodies(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		log.Println("error decoding ", err)
 	} else {
-		barneshut.SetRatioBorderBodies(ratioBorderBodies)
+		r.SetRatioBorderBodies(ratioBorderBodies)
 	}
 }
 
@@ -2,7 +2,6 @@ package translation
 
 import (
 	"archive/zip"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -10,6 +9,7 @@ import (
 	"os"
 
 	"github.com/thomaspeugeot/tkv/barnes-hut"
+	"github.com/thomaspeugeot/tkv/bods"
 	"github.com/thomaspeugeot/tkv/grump"
 	"github.com/thomaspeugeot/tkv/quadtree"
 )
@@ -21,6 +21,8 @@ type CountryWithBodies struct {
 
 	bodiesOrig     *[]quadtree.BodyXY // original bodies position in the quatree
 	bodiesSpread   *[]quadtree.BodyXY // bodies position in the quatree after the spread simulation
+	bodiesID       []uint32           // ID of the bodies, they are the same in the original and in the spread configuration
+	attributes     []bods.Attributes  // where the bodies come from (nil if the body files have no attributes)
 	VilCoordinates [][]int
 	Step           int // step when the simulation stopped
 }
@@ -59,6 +61,8 @@ func (country *CountryWithBodies) Init() {
 	Info.Printf("Init after Unserialize name %s", country.Name)
 	Info.Printf("Init after Unserialize step %d", country.Step)
 
+	country.bodiesID = nil
+	country.attributes = nil
 	country.LoadConfig(true)  // load config at the end  of the simulation
 	country.LoadConfig(false) // load config at the start of the simulation
 
@@ -94,7 +98,13 @@ func (country *CountryWithBodies) LoadConfig(isOriginal bool) bool {
 	Info.Printf("LoadConfig (orig = true/final = false) %t file %s for country %s at step %d", isOriginal, filename, country.Name, step)
 
 	// check if file is missing.
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
+	if _, err := os.Stat(filename); err == nil {
+		bodsFileReader, bodsFileReaderErr = os.Open(filename)
+		if bodsFileReaderErr != nil {
+			log.Fatal(bodsFileReaderErr)
+			return false
+		}
+	} else if os.IsNotExist(err) {
 		Info.Printf("File %s is missing, trying to find the zip file", filename)
 
 		zipFilename := filename + ".zip"
@@ -123,22 +133,38 @@ func (country *CountryWithBodies) LoadConfig(isOriginal bool) bool {
 			defer bodsFileReader.Close()
 
 		}
+	} else {
+		log.Fatal(err)
+		return false
 	}
 
-	jsonParser := json.NewDecoder(bodsFileReader)
+	// the body file is either in JSON or in the binary format
+	_, bodiesRead, attributes, err := bods.ReadBodiesWithAttributes(bodsFileReader)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("parsing config file %s", err.Error()))
+	}
 
-	bodies := (make([]quadtree.BodyXY, 0))
+	bodies := make([]quadtree.BodyXY, len(bodiesRead))
+	ids := make([]uint32, len(bodiesRead))
+	for idx := range bodiesRead {
+		bodies[idx] = bodiesRead[idx].BodyXY
+		ids[idx] = bodiesRead[idx].ID
+	}
+
+	// the twin of a body is the body at the same index in the other configuration
+	if country.bodiesID == nil {
+		country.bodiesID = ids
+	} else if err := checkIDs(country.bodiesID, ids); err != nil {
+		log.Fatal(fmt.Sprintf("config file %s does not match the other configuration of %s: %s", filename, country.Name, err.Error()))
+	}
+	if attributes != nil {
+		country.attributes = attributes
+	}
 	if isOriginal {
 		country.bodiesOrig = &bodies
-		if err := jsonParser.Decode(country.bodiesOrig); err != nil {
-			log.Fatal(fmt.Sprintf("parsing config file %s", err.Error()))
-		}
 		Info.Printf("nb item parsed in file for orig %d\n", len(*country.bodiesOrig))
 	} else {
 		country.bodiesSpread = &bodies
-		if err := jsonParser.Decode(country.bodiesSpread); err != nil {
-			log.Fatal(fmt.Sprintf("parsing config file %s", err.Error()))
-		}
 		Info.Printf("nb item parsed in file for spread %d\n", len(*country.bodiesSpread))
 	}
 
@@ -149,6 +175,21 @@ func (country *CountryWithBodies) LoadConfig(isOriginal bool) bool {
 	return true
 }
 
+// check that the bodies of two configurations have the same IDs in the same order
+func checkIDs(want, got []uint32) error {
+
+	if len(got) != len(want) {
+		return fmt.Errorf("%d bodies instead of %d", len(got), len(want))
+	}
+	for idx := range got {
+		if got[idx] != want[idx] {
+			return fmt.Errorf("body %d has the ID %d instead of %d (the configurations are not from the same run or have been reordered)",
+				idx, got[idx], want[idx])
+		}
+	}
+	return nil
+}
+
 // compute villages barycenters
 func (country *CountryWithBodies) ComputeBaryCenters() {
 	Info.Printf("ComputeBaryCenters begins for country %s", country.Name)
@@ -265,6 +306,45 @@ func (country *CountryWithBodies) XYtoTerritoryBodies(x, y float64) PointList {
 	return points
 }
 
+// a Territory sums the attributes of the bodies of a village (see bods.Attributes)
+type Territory struct {
+	NbBodies   int
+	Population float64    // nb of people represented by the bodies
+	NbUrban    int        // nb of bodies from urban cells
+	Cells      [][2]int32 // GRUMP cells (row, col) the bodies come from, each cell once
+}
+
+// get the territory of the village of x, y spread coordinates
+// return false if the body files have no attributes
+func (country *CountryWithBodies) XYtoTerritory(x, y float64) (territory Territory, ok bool) {
+
+	if country.attributes == nil {
+		return territory, false
+	}
+
+	xMinVillage := float64(int(x*numberOfVillagePerAxe)) / numberOfVillagePerAxe
+	xMaxVillage := float64(int(x*numberOfVillagePerAxe+1.0)) / numberOfVillagePerAxe
+	yMinVillage := float64(int(y*numberOfVillagePerAxe)) / numberOfVillagePerAxe
+	yMaxVillage := float64(int(y*numberOfVillagePerAxe+1.0)) / numberOfVillagePerAxe
+
+	cells := make(map[[2]int32]bool)
+	for index, b := range *country.bodiesSpread {
+		if (xMinVillage <= b.X) && (b.X < xMaxVillage) && (yMinVillage <= b.Y) && (b.Y < yMaxVillage) {
+			a := country.attributes[index]
+			territory.NbBodies++
+			territory.Population += a.Population
+			if a.Urban {
+				territory.NbUrban++
+			}
+			if cell := [2]int32{a.Row, a.Col}; !cells[cell] {
+				cells[cell] = true
+				territory.Cells = append(territory.Cells, cell)
+			}
+		}
+	}
+	return territory, true
+}
+
 // given x, y of a point, return the border in the country
 func (country *CountryWithBodies) LatLngToTerritoryBorder(lat, lng float64) PointList {
 
@@ -3,9 +3,13 @@ Package translation provides functions for managing a translation between two co
 */
 package translation
 
-/* import (
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/thomaspeugeot/tkv/grump"
-) */
+)
 
 // Singloton pointing to the current translation
 // the singloton can be autocally initiated if it is nil
@@ -30,6 +34,46 @@ var countrySpecs = []CountrySpec{
 	// CountrySpec{Name: "rus", NbBodies: 509497, Step: 3386},
 }
 
+// AddCountrySpec adds a country, or a region by its key, to the countries of the translation
+// (a spec of the same name is replaced). The countries are loaded by the first GetTranslateCurrent,
+// the specs have to be added before.
+func AddCountrySpec(spec CountrySpec) error {
+
+	if mapOfCountries != nil {
+		return fmt.Errorf("cannot add %s, the countries of the translation are loaded", spec.Name)
+	}
+	if err := grump.CheckName(spec.Name); err != nil {
+		return err
+	}
+	for idx := range countrySpecs {
+		if countrySpecs[idx].Name == spec.Name {
+			countrySpecs[idx] = spec
+			return nil
+		}
+	}
+	countrySpecs = append(countrySpecs, spec)
+	return nil
+}
+
+// ParseCountrySpec parses the spec of a country <name>:<nb bodies>:<step> (for instance idf:100000:2000),
+// the name is an ISO 3166 code or a region key
+func ParseCountrySpec(s string) (CountrySpec, error) {
+
+	fields := strings.Split(s, ":")
+	if len(fields) != 3 {
+		return CountrySpec{}, fmt.Errorf("country spec %q, want <name>:<nb bodies>:<step>", s)
+	}
+	nbBodies, errNbBodies := strconv.Atoi(fields[1])
+	step, errStep := strconv.Atoi(fields[2])
+	if errNbBodies != nil || errStep != nil || nbBodies <= 0 || step < 0 {
+		return CountrySpec{}, fmt.Errorf("country spec %q, want <name>:<nb bodies>:<step>", s)
+	}
+	if err := grump.CheckName(fields[0]); err != nil {
+		return CountrySpec{}, err
+	}
+	return CountrySpec{Name: fields[0], NbBodies: nbBodies, Step: step}, nil
+}
+
 // Singloton pattern to init the current translation
 func GetTranslateCurrent() *Translation {
 
@@ -103,6 +147,21 @@ func (t *Translation) TargetBorder(x, y float64) PointList {
 	return t.targetCountry.XYtoTerritoryBodies(x, y)
 }
 
+// from a coordinate in source country, get the territory in the source country
+// (false if the body files of the source country have no attributes)
+func (t *Translation) SourceTerritory(lat, lng float64) (Territory, bool) {
+
+	_, _, _, xSpread, ySpread, _ := t.sourceCountry.ClosestBodyInOriginalPosition(lat, lng)
+	return t.sourceCountry.XYtoTerritory(xSpread, ySpread)
+}
+
+// from a spread coordinate, get the territory in the target country
+// (false if the body files of the target country have no attributes)
+func (t *Translation) TargetTerritory(x, y float64) (Territory, bool) {
+
+	return t.targetCountry.XYtoTerritory(x, y)
+}
+
 func (t *Translation) SourceBorder(lat, lng float64) PointList {
 
 	Info.Printf("Source Border for lat %f lng %f", lat, lng)
@@ -3,10 +3,30 @@ package translation
 import (
 	"math"
 	"testing"
+
+	"github.com/thomaspeugeot/tkv/bods"
+	"github.com/thomaspeugeot/tkv/quadtree"
 )
 
 var epsilon float64 = 0.0000001
 
+func TestCheckIDs(t *testing.T) {
+
+	cases := []struct {
+		got   []uint32
+		valid bool
+	}{
+		{[]uint32{1, 2, 3}, true},
+		{[]uint32{1, 3, 2}, false},
+		{[]uint32{1, 2}, false},
+	}
+	for _, c := range cases {
+		if err := checkIDs([]uint32{1, 2, 3}, c.got); (err == nil) != c.valid {
+			t.Errorf("ids %v, got %v", c.got, err)
+		}
+	}
+}
+
 // testing the translation lat lng to XY function of country
 func TestFRALatLng2XY(t *testing.T) {
 
@@ -76,3 +96,56 @@ func TestBallBodiesCount(t *testing.T) {
 	}
 
 }
+
+// test that the attributes of the bodies of a village are summed
+func TestXYtoTerritory(t *testing.T) {
+
+	var country CountryWithBodies
+	spread := []quadtree.BodyXY{{X: 0.505, Y: 0.505}, {X: 0.506, Y: 0.507}, {X: 0.508, Y: 0.501}, {X: 0.2, Y: 0.2}}
+	country.bodiesSpread = &spread
+	if _, ok := country.XYtoTerritory(0.505, 0.505); ok {
+		t.Errorf("a country without attributes should have no territory")
+	}
+
+	country.attributes = []bods.Attributes{
+		{Row: 3, Col: 4, Population: 100.0, Urban: true},
+		{Row: 3, Col: 4, Population: 100.0, Urban: true},
+		{Row: 3, Col: 5, Population: 50.0},
+		{Row: 9, Col: 9, Population: 10.0},
+	}
+	territory, ok := country.XYtoTerritory(0.505, 0.505)
+	if !ok || territory.NbBodies != 3 || territory.Population != 250.0 || territory.NbUrban != 2 ||
+		len(territory.Cells) != 2 || territory.Cells[1] != [2]int32{3, 5} {
+		t.Errorf("got %#v", territory)
+	}
+}
+
+func TestCountrySpec(t *testing.T) {
+
+	spec, err := ParseCountrySpec("hti-ouest:20000:1500")
+	if err != nil || spec != (CountrySpec{Name: "hti-ouest", NbBodies: 20000, Step: 1500}) {
+		t.Errorf("spec %v %v", spec, err)
+	}
+	for _, s := range []string{"idf", "idf:100", "idf:x:2", "idf:100:-1", "i/f:100:2", ":100:2"} {
+		if _, err := ParseCountrySpec(s); err == nil {
+			t.Errorf("%s: no error", s)
+		}
+	}
+
+	if mapOfCountries != nil {
+		t.Skip("the countries are loaded")
+	}
+	saved := countrySpecs
+	defer func() { countrySpecs = saved }()
+	countrySpecs = append([]CountrySpec(nil), saved...)
+
+	if err := AddCountrySpec(CountrySpec{Name: "idf", NbBodies: 100, Step: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddCountrySpec(CountrySpec{Name: "idf", NbBodies: 200, Step: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if len(countrySpecs) != len(saved)+1 || countrySpecs[len(saved)].NbBodies != 200 {
+		t.Errorf("specs %v", countrySpecs)
+	}
+}
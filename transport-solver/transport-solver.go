@@ -0,0 +1,90 @@
+/*
+Computes the spread configuration of a country with optimal transport instead of the simulation.
+
+The original configuration conf-xxx-xxxxxxxx-00000.bods (or its zip) is read in the current directory
+and the targets are written as conf-xxx-xxxxxxxx-sssss.bods where sssss is the nb of Newton iterations
+(at least 1, the original configuration is not overwritten).
+This step is the one to put in the CountrySpec of translation.
+*/
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	barneshut "github.com/thomaspeugeot/tkv/barnes-hut"
+	"github.com/thomaspeugeot/tkv/bods"
+	"github.com/thomaspeugeot/tkv/transport"
+)
+
+// to map haiti
+// go run transport-solver.go -sourceCountry=hti -sourceCountryNbBodies=190948
+func main() {
+
+	sourceCountryPtr := flag.String("sourceCountry", "fra", "iso 3166 sourceCountry code")
+	sourceCountryNbBodiesPtr := flag.Int("sourceCountryNbBodies", 934136, "nb of bodies")
+
+	tolerancePtr := flag.Float64("tolerance", 1e-3, "max error of the area of a cell, relative to its target area")
+	maxIterationsPtr := flag.Int("maxIterations", 100, "max nb of Newton iterations")
+
+	outputDirPtr := flag.String("outputDir", ".", "directory of the spread configuration")
+
+	flag.Parse()
+
+	filename := fmt.Sprintf(barneshut.CountryBodiesNamePattern, *sourceCountryPtr, *sourceCountryNbBodiesPtr, 0)
+	log.Printf("reading original configuration %s", filename)
+
+	var reader io.ReadCloser
+	if file, err := os.Open(filename); err == nil {
+		reader = file
+	} else if os.IsNotExist(err) {
+		archive, err := zip.OpenReader(filename + ".zip")
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		defer archive.Close()
+		if len(archive.File) == 0 {
+			log.Fatalf("no file in %s.zip", filename)
+			return
+		}
+		if reader, err = archive.File[0].Open(); err != nil {
+			log.Fatal(err)
+			return
+		}
+	} else {
+		log.Fatal(err)
+		return
+	}
+	_, bodies, attributes, err := bods.ReadBodiesWithAttributes(reader)
+	reader.Close()
+	if err != nil {
+		log.Fatal(fmt.Sprintf("parsing config file %s", err.Error()))
+		return
+	}
+	log.Printf("nb of bodies %d", len(bodies))
+
+	s, err := transport.NewSolver(bodies)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	s.Attributes = attributes
+	s.Tolerance = *tolerancePtr
+	s.MaxIterations = *maxIterationsPtr
+	if err := s.Solve(); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	output, ok := s.CaptureConfig(*outputDirPtr, *sourceCountryPtr)
+	if !ok {
+		log.Fatalf("cannot write %s", output)
+		return
+	}
+	log.Printf("spread configuration in %s, max error on the areas %e, step for translation %d", output, s.MaxError(), s.Step())
+}
@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"math"
+	"sort"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// max nb of bodies in a leaf of the kd tree
+const leafSize = 8
+
+// a node of the kd tree of the bodies
+//
+// the bodies of the node are index[first:last]. maxWeight is the max of the weights of the bodies
+// of the node, it is updated when the weights change (see updateWeights)
+type kdNode struct {
+	xMin, yMin, xMax, yMax float64
+	first, last            int
+	left, right            int // index of the nodes below (-1 for a leaf)
+	maxWeight              float64
+}
+
+type kdTree struct {
+	nodes []kdNode
+	index []int // index of the bodies, sorted by node
+}
+
+// build the kd tree of the bodies (bodies are not moved)
+func newKdTree(bodies []quadtree.Body) *kdTree {
+
+	var t kdTree
+	t.index = make([]int, len(bodies))
+	for idx := range t.index {
+		t.index[idx] = idx
+	}
+	if len(bodies) > 0 {
+		t.build(bodies, 0, len(bodies))
+	}
+	return &t
+}
+
+// build the node of the bodies index[first:last], return its index
+func (t *kdTree) build(bodies []quadtree.Body, first, last int) int {
+
+	n := kdNode{xMin: math.MaxFloat64, yMin: math.MaxFloat64, xMax: -math.MaxFloat64, yMax: -math.MaxFloat64,
+		first: first, last: last, left: -1, right: -1}
+	for _, idx := range t.index[first:last] {
+		n.xMin, n.xMax = math.Min(n.xMin, bodies[idx].X), math.Max(n.xMax, bodies[idx].X)
+		n.yMin, n.yMax = math.Min(n.yMin, bodies[idx].Y), math.Max(n.yMax, bodies[idx].Y)
+	}
+	node := len(t.nodes)
+	t.nodes = append(t.nodes, n)
+	if last-first <= leafSize {
+		return node
+	}
+
+	// split along the largest side at the median
+	sub := t.index[first:last]
+	if n.xMax-n.xMin >= n.yMax-n.yMin {
+		sort.Slice(sub, func(i, j int) bool { return bodies[sub[i]].X < bodies[sub[j]].X })
+	} else {
+		sort.Slice(sub, func(i, j int) bool { return bodies[sub[i]].Y < bodies[sub[j]].Y })
+	}
+	middle := (first + last) / 2
+	left := t.build(bodies, first, middle)
+	right := t.build(bodies, middle, last)
+	t.nodes[node].left, t.nodes[node].right = left, right
+	return node
+}
+
+// update the max weight of the nodes
+//
+// nodes below a node have a higher index, therefore nodes are updated from the last one
+func (t *kdTree) updateWeights(weights []float64) {
+
+	for node := len(t.nodes) - 1; node >= 0; node-- {
+		n := &t.nodes[node]
+		if n.left == -1 {
+			n.maxWeight = -math.MaxFloat64
+			for _, idx := range t.index[n.first:n.last] {
+				n.maxWeight = math.Max(n.maxWeight, weights[idx])
+			}
+			continue
+		}
+		n.maxWeight = math.Max(t.nodes[n.left].maxWeight, t.nodes[n.right].maxWeight)
+	}
+}
+
+// squared distance between x, y and the bounding box of the node
+func (n *kdNode) dist2(x, y float64) float64 {
+	dX := math.Max(0.0, math.Max(n.xMin-x, x-n.xMax))
+	dY := math.Max(0.0, math.Max(n.yMin-y, y-n.yMax))
+	return dX*dX + dY*dY
+}
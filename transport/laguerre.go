@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"math"
+
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// a vertex of a cell, edge is the body whose cell is on the other side of the edge
+// that goes from the vertex to the next vertex (-1 for the border of the square)
+type vertex struct {
+	quadtree.BodyXY
+	edge int
+}
+
+// a convex cell, vertices are counter clockwise
+type cell []vertex
+
+// the unit square
+var square = cell{
+	{quadtree.BodyXY{X: 0.0, Y: 0.0}, -1},
+	{quadtree.BodyXY{X: 1.0, Y: 0.0}, -1},
+	{quadtree.BodyXY{X: 1.0, Y: 1.0}, -1},
+	{quadtree.BodyXY{X: 0.0, Y: 1.0}, -1}}
+
+// clips a cell, the buffers are reused from one cell to the next
+type clipper struct {
+	cell, tmp cell
+	stack     []int
+}
+
+// remove the part of the cell where side is positive, side is an affine function.
+// the new edge is labelled with edge (Sutherland-Hodgman)
+func (c *clipper) clip(side func(v quadtree.BodyXY) float64, edge int) {
+
+	c.tmp = c.tmp[:0]
+	for k := range c.cell {
+		cur, next := c.cell[k], c.cell[(k+1)%len(c.cell)]
+		sCur, sNext := side(cur.BodyXY), side(next.BodyXY)
+		if sCur <= 0.0 {
+			if sCur == 0.0 && sNext > 0.0 {
+				// the cell goes out at the vertex
+				cur.edge = edge
+			}
+			c.tmp = append(c.tmp, cur)
+		}
+		if (sCur < 0.0 && sNext > 0.0) || (sCur > 0.0 && sNext < 0.0) {
+			t := sCur / (sCur - sNext)
+			v := vertex{quadtree.BodyXY{X: cur.X + t*(next.X-cur.X), Y: cur.Y + t*(next.Y-cur.Y)}, cur.edge}
+			if sCur < 0.0 {
+				// the cell goes out, the edge from the new vertex is the new edge
+				v.edge = edge
+			}
+			c.tmp = append(c.tmp, v)
+		}
+	}
+	c.cell, c.tmp = c.tmp, c.cell
+}
+
+// area and centroid of the cell
+func (p cell) areaCentroid() (area, x, y float64) {
+
+	for k := range p {
+		cur, next := p[k], p[(k+1)%len(p)]
+		cross := cur.X*next.Y - next.X*cur.Y
+		area += cross
+		x += (cur.X + next.X) * cross
+		y += (cur.Y + next.Y) * cross
+	}
+	if area == 0.0 {
+		return 0.0, 0.0, 0.0
+	}
+	return area / 2.0, x / (3.0 * area), y / (3.0 * area)
+}
+
+// compute the Laguerre cell of the body at idx (within the unit square), that is the points p where
+// |p - body|^2 - weight of the body is lower than for all other bodies
+//
+// The kd tree is walked and a node is skipped when none of its bodies can clip the current cell:
+// for each vertex v of the cell, the distance between v and the node minus the max weight of the node
+// is above |v - body|^2 - weight of the body. The cell is stored in c.
+func (t *kdTree) laguerreCell(bodies []quadtree.Body, weights []float64, idx int, c *clipper) cell {
+
+	body := bodies[idx].BodyXY
+	weight := weights[idx]
+	cost := func(v quadtree.BodyXY, b quadtree.BodyXY, w float64) float64 {
+		dX, dY := v.X-b.X, v.Y-b.Y
+		return dX*dX + dY*dY - w
+	}
+
+	c.cell = append(c.cell[:0], square...)
+	c.stack = append(c.stack[:0], 0)
+	for len(c.stack) > 0 {
+		node := &t.nodes[c.stack[len(c.stack)-1]]
+		c.stack = c.stack[:len(c.stack)-1]
+
+		canClip := false
+		for _, v := range c.cell {
+			if node.dist2(v.X, v.Y)-node.maxWeight < cost(v.BodyXY, body, weight) {
+				canClip = true
+				break
+			}
+		}
+		if !canClip {
+			continue
+		}
+
+		if node.left != -1 {
+			// the nearest node is walked first, it is more likely to clip the cell
+			var xC, yC float64
+			for _, v := range c.cell {
+				xC += v.X / float64(len(c.cell))
+				yC += v.Y / float64(len(c.cell))
+			}
+			left, right := node.left, node.right
+			if t.nodes[left].dist2(xC, yC) < t.nodes[right].dist2(xC, yC) {
+				left, right = right, left
+			}
+			c.stack = append(c.stack, left, right)
+			continue
+		}
+
+		for _, j := range t.index[node.first:node.last] {
+			if j == idx {
+				continue
+			}
+			other, otherWeight := bodies[j].BodyXY, weights[j]
+			c.clip(func(v quadtree.BodyXY) float64 {
+				return cost(v, body, weight) - cost(v, other, otherWeight)
+			}, j)
+			if len(c.cell) == 0 {
+				return c.cell
+			}
+		}
+	}
+	return c.cell
+}
+
+// length of the edge from the vertex k of the cell
+func (p cell) edgeLength(k int) float64 {
+	next := p[(k+1)%len(p)]
+	return math.Hypot(next.X-p[k].X, next.Y-p[k].Y)
+}
@@ -0,0 +1,367 @@
+// Package transport maps the bodies onto the uniform square with semi-discrete optimal transport
+//
+// It is an alternative to the spreading simulation of barneshut. Each body i of mass m_i is given a weight w_i
+// and the Laguerre cell of the points p of the square where |p - body i|^2 - w_i is the lowest.
+// The weights are computed (with the damped Newton method of Kitagawa, Mérigot and Thibert)
+// so that the area of each cell is m_i / (sum of the masses): the bodies are then uniformly spread.
+// The target position of a body is the centroid of its cell.
+//
+// The mapping minimises the mean squared displacement of the population, therefore it keeps the neighbourhoods
+// as much as possible. There is no randomness, the same bodies give the same targets.
+//
+// The targets are written as a body file (see barneshut.CountryBodiesNamePattern) whose step is the nb of
+// Newton iterations (see Solver.Step). It can be used by translation.CountryWithBodies as the spread configuration.
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+
+	barneshut "github.com/thomaspeugeot/tkv/barnes-hut"
+	"github.com/thomaspeugeot/tkv/bods"
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// ErrNoConvergence is returned when the areas of the cells cannot be made closer to their targets
+var ErrNoConvergence = errors.New("optimal transport does not converge")
+
+// a Solver computes the optimal transport of bodies onto the unit square
+type Solver struct {
+	Tolerance     float64 // max error of the area of a cell, relative to its target area (1e-3 if 0)
+	MaxIterations int     // max nb of Newton iterations (100 if 0)
+	NbRoutines    int     // nb of concurrent routines that compute the cells (nb of CPU if 0)
+
+	// optional attributes of the bodies, they are written with the targets (see bods.Attributes)
+	Attributes []bods.Attributes
+
+	bodies      []quadtree.Body
+	targetAreas []float64
+	weights     []float64
+	tree        *kdTree
+
+	iterations int
+	maxError   float64 // max relative error of the areas of the cells
+	targets    []quadtree.BodyXY
+}
+
+// an element of a row of the jacobian of the areas of the cells
+type jacobianEntry struct {
+	j     int
+	value float64
+}
+
+// the cells for a set of weights
+type cells struct {
+	areas     []float64
+	centroids []quadtree.BodyXY
+	jacobian  [][]jacobianEntry // derivative of the area of the cell i according to the weight of j (j != i)
+}
+
+// NewSolver returns a solver for bodies
+//
+// bodies have to be within the unit square, have a positive mass and be at distinct positions
+func NewSolver(bodies []quadtree.Body) (*Solver, error) {
+
+	s := Solver{bodies: bodies}
+	if len(bodies) == 0 {
+		return nil, errors.New("no bodies")
+	}
+
+	totalMass := 0.0
+	for idx, body := range bodies {
+		if body.X < 0.0 || body.X > 1.0 || body.Y < 0.0 || body.Y > 1.0 {
+			return nil, fmt.Errorf("body %d at x %f y %f is outside the square", idx, body.X, body.Y)
+		}
+		if body.M <= 0.0 {
+			return nil, fmt.Errorf("body %d has a mass of %f", idx, body.M)
+		}
+		totalMass += body.M
+	}
+
+	// two bodies at the same position would have the same cell
+	sorted := make([]int, len(bodies))
+	for idx := range sorted {
+		sorted[idx] = idx
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := bodies[sorted[i]], bodies[sorted[j]]
+		return a.X < b.X || (a.X == b.X && a.Y < b.Y)
+	})
+	for k := 1; k < len(sorted); k++ {
+		if bodies[sorted[k]].BodyXY == bodies[sorted[k-1]].BodyXY {
+			return nil, fmt.Errorf("%w: bodies %d and %d", barneshut.ErrZeroDistance, sorted[k-1], sorted[k])
+		}
+	}
+
+	s.targetAreas = make([]float64, len(bodies))
+	for idx, body := range bodies {
+		s.targetAreas[idx] = body.M / totalMass
+	}
+	s.weights = make([]float64, len(bodies))
+	s.tree = newKdTree(bodies)
+	return &s, nil
+}
+
+func (s *Solver) Iterations() int { return s.iterations }
+
+// Step returns the step of the body file of the targets, the nb of iterations
+//
+// it is at least 1, the step 0 is the original configuration that must not be overwritten
+func (s *Solver) Step() int {
+	if s.iterations < 1 {
+		return 1
+	}
+	return s.iterations
+}
+
+// MaxError returns the max error of the areas of the cells, relative to their target area
+func (s *Solver) MaxError() float64 { return s.maxError }
+
+// compute the cells of the bodies with weights
+func (s *Solver) computeCells(weights []float64, withJacobian bool) cells {
+
+	var c cells
+	c.areas = make([]float64, len(s.bodies))
+	c.centroids = make([]quadtree.BodyXY, len(s.bodies))
+	if withJacobian {
+		c.jacobian = make([][]jacobianEntry, len(s.bodies))
+	}
+	s.tree.updateWeights(weights)
+
+	nbRoutines := s.NbRoutines
+	if nbRoutines <= 0 {
+		nbRoutines = runtime.NumCPU()
+	}
+	var wg sync.WaitGroup
+	for routine := 0; routine < nbRoutines; routine++ {
+		wg.Add(1)
+		go func(routine int) {
+			defer wg.Done()
+			var clip clipper
+			for idx := routine; idx < len(s.bodies); idx += nbRoutines {
+				cell := s.tree.laguerreCell(s.bodies, weights, idx, &clip)
+				area, x, y := cell.areaCentroid()
+				c.areas[idx] = area
+				c.centroids[idx] = quadtree.BodyXY{X: x, Y: y}
+				if !withJacobian {
+					continue
+				}
+
+				// moving the border with j changes the area by the length of the border
+				// divided by twice the distance between the bodies
+				for k, v := range cell {
+					if v.edge < 0 {
+						continue
+					}
+					body, other := s.bodies[idx], s.bodies[v.edge]
+					dist := math.Hypot(other.X-body.X, other.Y-body.Y)
+					c.jacobian[idx] = append(c.jacobian[idx], jacobianEntry{v.edge, cell.edgeLength(k) / (2.0 * dist)})
+				}
+			}
+		}(routine)
+	}
+	wg.Wait()
+	return c
+}
+
+// max error of the areas, relative to the target areas, and norm of the error
+func (s *Solver) areaError(areas []float64) (maxError, norm float64) {
+	for idx, area := range areas {
+		maxError = math.Max(maxError, math.Abs(area-s.targetAreas[idx])/s.targetAreas[idx])
+		norm += (area - s.targetAreas[idx]) * (area - s.targetAreas[idx])
+	}
+	return maxError, math.Sqrt(norm)
+}
+
+// Solve computes the weights of the bodies
+//
+// At each iteration, the Newton direction is damped until all cells keep an area above
+// half of the smallest area (target or initial) and the error on the areas decreases.
+func (s *Solver) Solve() error {
+
+	tolerance := s.Tolerance
+	if tolerance == 0.0 {
+		tolerance = 1e-3
+	}
+	maxIterations := s.MaxIterations
+	if maxIterations == 0 {
+		maxIterations = 100
+	}
+
+	c := s.computeCells(s.weights, true)
+	minArea := math.MaxFloat64
+	for idx := range c.areas {
+		minArea = math.Min(minArea, math.Min(c.areas[idx], s.targetAreas[idx]))
+	}
+	minArea /= 2.0
+
+	maxError, norm := s.areaError(c.areas)
+	for s.iterations = 0; s.iterations < maxIterations && maxError > tolerance; s.iterations++ {
+
+		rhs := make([]float64, len(s.bodies))
+		for idx := range rhs {
+			rhs[idx] = s.targetAreas[idx] - c.areas[idx]
+		}
+		direction := conjugateGradient(c.jacobian, rhs)
+
+		weights := make([]float64, len(s.weights))
+		accepted := false
+		for tau := 1.0; tau > 1e-10; tau /= 2.0 {
+			for idx := range weights {
+				weights[idx] = s.weights[idx] + tau*direction[idx]
+			}
+			next := s.computeCells(weights, true)
+			nextMaxError, nextNorm := s.areaError(next.areas)
+
+			smallest := math.MaxFloat64
+			for _, area := range next.areas {
+				smallest = math.Min(smallest, area)
+			}
+			if smallest >= minArea && nextNorm <= (1.0-tau/2.0)*norm {
+				s.weights, c, maxError, norm = weights, next, nextMaxError, nextNorm
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			s.maxError = maxError
+			return fmt.Errorf("%w: max error on the areas %e after %d iterations", ErrNoConvergence, maxError, s.iterations)
+		}
+		Info.Printf("iteration %d, max error on the areas %e", s.iterations, maxError)
+	}
+
+	s.maxError = maxError
+	s.targets = c.centroids
+	if maxError > tolerance {
+		return fmt.Errorf("%w: max error on the areas %e after %d iterations", ErrNoConvergence, maxError, s.iterations)
+	}
+	return nil
+}
+
+// solve J x = b, where J is the jacobian of the areas (preconditioned conjugate gradient)
+//
+// the diagonal of J is minus the sum of the rows, J is then a graph laplacian. It is singular (the areas
+// do not change if all weights change by the same value), the sum of b is 0 and x is chosen with a zero mean.
+func conjugateGradient(jacobian [][]jacobianEntry, b []float64) []float64 {
+
+	n := len(b)
+	diagonal := make([]float64, n)
+	for i := range jacobian {
+		for _, e := range jacobian[i] {
+			diagonal[i] += e.value
+		}
+		if diagonal[i] == 0.0 {
+			diagonal[i] = 1.0
+		}
+	}
+	multiply := func(x, res []float64) {
+		for i := range jacobian {
+			res[i] = 0.0
+			for _, e := range jacobian[i] {
+				res[i] += e.value * (x[i] - x[e.j])
+			}
+		}
+	}
+
+	// the sum of b is 0 up to rounding errors, that would make CG diverge
+	x := make([]float64, n)
+	r := append([]float64(nil), b...)
+	mean := 0.0
+	for i := range r {
+		mean += r[i] / float64(n)
+	}
+	for i := range r {
+		r[i] -= mean
+	}
+	z := make([]float64, n)
+	for i := range z {
+		z[i] = r[i] / diagonal[i]
+	}
+	p := append([]float64(nil), z...)
+	jp := make([]float64, n)
+
+	rz := dot(r, z)
+	bNorm := math.Sqrt(dot(r, r))
+	for iteration := 0; iteration < n+100 && math.Sqrt(dot(r, r)) > 1e-8*bNorm; iteration++ {
+		multiply(p, jp)
+		pjp := dot(p, jp)
+		if pjp <= 0.0 {
+			break
+		}
+		alpha := rz / pjp
+		for i := range x {
+			x[i] += alpha * p[i]
+			r[i] -= alpha * jp[i]
+			z[i] = r[i] / diagonal[i]
+		}
+		rzNext := dot(r, z)
+		for i := range p {
+			p[i] = z[i] + rzNext/rz*p[i]
+		}
+		rz = rzNext
+	}
+
+	mean = 0.0
+	for i := range x {
+		mean += x[i] / float64(n)
+	}
+	for i := range x {
+		x[i] -= mean
+	}
+	return x
+}
+
+func dot(u, v []float64) float64 {
+	res := 0.0
+	for k := range u {
+		res += u[k] * v[k]
+	}
+	return res
+}
+
+// Targets returns the bodies at the centroid of their cell (nil if Solve has not been called)
+func (s *Solver) Targets() []quadtree.Body {
+	if s.targets == nil {
+		return nil
+	}
+	targets := append([]quadtree.Body(nil), s.bodies...)
+	for idx := range targets {
+		targets[idx].BodyXY = s.targets[idx]
+	}
+	return targets
+}
+
+// WriteConfig writes the targets into out, with the format of the body files
+func (s *Solver) WriteConfig(out io.Writer, country string) error {
+	if barneshut.UseBinaryBodsFormat {
+		return bods.NewEncoder(out).EncodeWithAttributes(country, s.Step(), s.Targets(), s.Attributes)
+	}
+	return bods.WriteJSON(out, s.Targets())
+}
+
+// CaptureConfig writes the targets in outputDir, the step of the body file is s.Step()
+//
+// return the name of the file and true if operation was successful
+func (s *Solver) CaptureConfig(outputDir, country string) (string, bool) {
+
+	filename := fmt.Sprintf(outputDir+"/"+barneshut.CountryBodiesNamePattern, country, len(s.bodies), s.Step())
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+		return filename, false
+	}
+	err = s.WriteConfig(file, country)
+	file.Close()
+	if err != nil {
+		Error.Printf("CaptureConfig %s: %s", filename, err.Error())
+		return filename, false
+	}
+	return filename, true
+}
@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"archive/zip"
+	"testing"
+
+	"github.com/thomaspeugeot/tkv/bods"
+	"github.com/thomaspeugeot/tkv/lloyd"
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// optimal transport of haiti (one body out of 20 of the original configuration)
+//
+// the stirring is measured with the nearest neighbours of lloyd.Neighbours, it can be compared
+// with BenchmarkLloydVsBarnesHutHti
+//
+// BenchmarkSolveHti 	       1	136598369979 ns/op	        16.00 iterations	         0.5342 stirring
+//
+// the mapping keeps more neighbours than Barnes-Hut (0.46) in a comparable time
+func BenchmarkSolveHti(b *testing.B) {
+
+	archive, err := zip.OpenReader("../runtime_server/conf-hti-00190948-00000.bods.zip")
+	if err != nil {
+		b.Skip(err)
+	}
+	defer archive.Close()
+	file, err := archive.File[0].Open()
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, hti, err := bods.ReadBodies(file)
+	file.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var sample []quadtree.Body
+	for idx := 0; idx < len(hti); idx += 20 {
+		sample = append(sample, hti[idx])
+	}
+
+	for n := 0; n < b.N; n++ {
+		// neighbours are compared by their address, bodies are moved in place
+		bodies := append([]quadtree.Body(nil), sample...)
+		neighboursOrig := lloyd.Neighbours(&bodies)
+
+		s, err := NewSolver(bodies)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := s.Solve(); err != nil {
+			b.Fatal(err)
+		}
+		copy(bodies, s.Targets())
+		b.ReportMetric(float64(s.Iterations()), "iterations")
+		b.ReportMetric(lloyd.Neighbours(&bodies).ComputeStirring(neighboursOrig), "stirring")
+	}
+}
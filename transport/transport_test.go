@@ -0,0 +1,229 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+
+	barneshut "github.com/thomaspeugeot/tkv/barnes-hut"
+	"github.com/thomaspeugeot/tkv/bods"
+	"github.com/thomaspeugeot/tkv/quadtree"
+)
+
+// nb bodies of mass 1, the positions are seeded by nb
+func randomBodies(nb int) []quadtree.Body {
+	rng := rand.New(rand.NewSource(int64(nb)))
+	bodies := make([]quadtree.Body, nb)
+	for idx := range bodies {
+		bodies[idx].X = rng.Float64()
+		bodies[idx].Y = rng.Float64()
+		bodies[idx].M = 1.0
+	}
+	return bodies
+}
+
+// test that the cells are a partition of the square and that the points of a cell
+// have the lowest cost for the body of the cell
+func TestLaguerreCells(t *testing.T) {
+
+	bodies := randomBodies(300)
+	s, err := NewSolver(bodies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	weights := make([]float64, len(bodies))
+	for idx := range weights {
+		weights[idx] = 0.01 * rng.Float64()
+	}
+	s.tree.updateWeights(weights)
+
+	cost := func(v quadtree.BodyXY, idx int) float64 {
+		dX, dY := v.X-bodies[idx].X, v.Y-bodies[idx].Y
+		return dX*dX + dY*dY - weights[idx]
+	}
+
+	var c clipper
+	total := 0.0
+	for idx := range bodies {
+		cell := s.tree.laguerreCell(bodies, weights, idx, &c)
+		area, x, y := cell.areaCentroid()
+		total += area
+		for _, v := range append(cell, vertex{quadtree.BodyXY{X: x, Y: y}, -1}) {
+			if len(cell) == 0 {
+				break
+			}
+			for j := range bodies {
+				if cost(v.BodyXY, j) < cost(v.BodyXY, idx)-1e-12 {
+					t.Fatalf("point %v of the cell of %d has a lower cost for %d", v.BodyXY, idx, j)
+				}
+			}
+		}
+	}
+	if math.Abs(total-1.0) > 1e-9 {
+		t.Errorf("total area of the cells got %f", total)
+	}
+}
+
+// test the jacobian of the areas against finite differences
+func TestJacobian(t *testing.T) {
+
+	bodies := randomBodies(100)
+	s, _ := NewSolver(bodies)
+	c := s.computeCells(s.weights, true)
+
+	// central differences
+	idx := 42
+	h := 1e-7
+	weights := make([]float64, len(bodies))
+	weights[idx] = h
+	next := s.computeCells(weights, false)
+	weights[idx] = -h
+	previous := s.computeCells(weights, false)
+
+	// derivative of the area of idx according to its own weight
+	diagonal := 0.0
+	for _, e := range c.jacobian[idx] {
+		diagonal += e.value
+		// derivative of the area of the neighbour j according to the weight of idx
+		if got := (next.areas[e.j] - previous.areas[e.j]) / (2.0 * h); math.Abs(got+e.value) > 1e-4*e.value {
+			t.Errorf("neighbour %d, derivative got %e, want %e", e.j, got, -e.value)
+		}
+	}
+	if got := (next.areas[idx] - previous.areas[idx]) / (2.0 * h); math.Abs(got-diagonal) > 1e-4*diagonal {
+		t.Errorf("derivative got %e, want %e", got, diagonal)
+	}
+}
+
+// test that uniform bodies do not move
+func TestSolveUniform(t *testing.T) {
+
+	var bodies []quadtree.Body
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			bodies = append(bodies, quadtree.Body{BodyXY: quadtree.BodyXY{X: (float64(i) + 0.5) / 10.0, Y: (float64(j) + 0.5) / 10.0}, M: 1.0})
+		}
+	}
+	s, _ := NewSolver(bodies)
+	if err := s.Solve(); err != nil {
+		t.Fatal(err)
+	}
+	targets := s.Targets()
+	for idx := range bodies {
+		if math.Hypot(targets[idx].X-bodies[idx].X, targets[idx].Y-bodies[idx].Y) > 1e-12 {
+			t.Fatalf("body %d at %v moved to %v", idx, bodies[idx].BodyXY, targets[idx].BodyXY)
+		}
+	}
+	if s.Iterations() != 0 {
+		t.Errorf("got %d iterations", s.Iterations())
+	}
+}
+
+// test that concentrated bodies are spread, with areas of the cells proportional to the mass
+// and that the mapping is deterministic
+func TestSolve(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 500)
+	barneshut.SpreadOnCircleWithRand(&bodies, rand.New(rand.NewSource(1)))
+	for idx := range bodies {
+		bodies[idx].M = 1.0 + float64(idx%3)
+	}
+
+	var targets [2][]quadtree.Body
+	for n := range targets {
+		s, err := NewSolver(bodies)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Solve(); err != nil {
+			t.Fatal(err)
+		}
+		if s.MaxError() > 1e-3 {
+			t.Errorf("max error got %e", s.MaxError())
+		}
+		targets[n] = s.Targets()
+	}
+
+	// density of the mass per village of a 5 * 5 grid
+	var villages [5][5]float64
+	for _, body := range targets[0] {
+		villages[int(body.X*5.0)][int(body.Y*5.0)] += body.M
+	}
+	mean := 2.0 * float64(len(bodies)) / 25.0
+	for x := range villages {
+		for y := range villages[x] {
+			if math.Abs(villages[x][y]-mean) > 0.25*mean {
+				t.Errorf("village %d %d has a mass of %f, mean %f", x, y, villages[x][y], mean)
+			}
+		}
+	}
+
+	for idx := range targets[0] {
+		if targets[0][idx] != targets[1][idx] {
+			t.Fatalf("body %d, got %v and %v", idx, targets[0][idx].BodyXY, targets[1][idx].BodyXY)
+		}
+	}
+}
+
+func TestNewSolverErrors(t *testing.T) {
+
+	bodies := randomBodies(10)
+	bodies[7].BodyXY = bodies[3].BodyXY
+	if _, err := NewSolver(bodies); !errors.Is(err, barneshut.ErrZeroDistance) {
+		t.Errorf("got %v, want %v", err, barneshut.ErrZeroDistance)
+	}
+
+	bodies = randomBodies(10)
+	bodies[2].X = 1.5
+	if _, err := NewSolver(bodies); err == nil {
+		t.Errorf("a body outside the square should be refused")
+	}
+}
+
+func TestCaptureConfig(t *testing.T) {
+
+	bodies := make([]quadtree.Body, 100)
+	barneshut.SpreadOnCircleWithRand(&bodies, rand.New(rand.NewSource(1)))
+	s, _ := NewSolver(bodies)
+	if err := s.Solve(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	filename, ok := s.CaptureConfig(dir, "tst")
+	if !ok || filename != dir+"/"+fmt.Sprintf(barneshut.CountryBodiesNamePattern, "tst", 100, s.Step()) {
+		t.Fatalf("got %s %t", filename, ok)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	header, read, err := bods.ReadBodies(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Step != s.Step() || len(read) != 100 || read[42].BodyXY != s.Targets()[42].BodyXY {
+		t.Errorf("got step %d, %d bodies, body 42 at %v", header.Step, len(read), read[42].BodyXY)
+	}
+}
+
+// test that the targets of bodies that are already spread do not overwrite the original configuration
+func TestCaptureConfigWithoutIteration(t *testing.T) {
+
+	bodies := randomBodies(100)
+	s, _ := NewSolver(bodies)
+	s.Tolerance = math.MaxFloat64
+	if err := s.Solve(); err != nil || s.Iterations() != 0 {
+		t.Fatalf("got %d iterations, %v", s.Iterations(), err)
+	}
+
+	dir := t.TempDir()
+	filename, ok := s.CaptureConfig(dir, "tst")
+	if !ok || filename != dir+"/"+fmt.Sprintf(barneshut.CountryBodiesNamePattern, "tst", 100, 1) {
+		t.Errorf("got %s %t", filename, ok)
+	}
+}